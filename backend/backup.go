@@ -24,7 +24,10 @@ type BackupConfig struct {
 	Credentials         BackupCredentials            `json:"credentials"`
 	InterfaceMetadata   map[string]InterfaceMetadata `json:"interface_metadata"`
 	DHCPConfig          interface{}                  `json:"dhcp_config"`
-	FirewallRules       []string                     `json:"firewall_rules"`
+	UserFirewallRules   []UserFirewallRule           `json:"user_firewall_rules"`
+	FirewallZones       *ZoneStore                   `json:"firewall_zones,omitempty"`
+	FirewallSets        []FirewallSet                `json:"firewall_sets"`
+	IPv6                *IPv6Config                  `json:"ipv6,omitempty"`
 	PortForwardingRules []PortForwardingRule         `json:"port_forwarding"`
 }
 
@@ -48,7 +51,7 @@ func createBackup() ([]byte, error) {
 	}
 
 	snapshot := BackupSnapshot{
-		Version:   "0.12",
+		Version:   "0.13",
 		Timestamp: time.Now(),
 		Hostname:  hostname,
 		Config:    BackupConfig{},
@@ -79,11 +82,13 @@ func createBackup() ([]byte, error) {
 		}
 	}
 
-	// Firewall rules (basic snapshot - just store rule descriptions)
-	snapshot.Config.FirewallRules = []string{
-		"# Firewall rules snapshot",
-		"# Note: Firewall rules should be manually reviewed after restore",
-	}
+	// Firewall rules and zones (the persisted inputs the ruleset is generated from)
+	snapshot.Config.UserFirewallRules = GetUserFirewallRules()
+	zones := GetZoneStore()
+	snapshot.Config.FirewallZones = &zones
+	snapshot.Config.FirewallSets = GetFirewallSets()
+	ipv6 := GetIPv6Config()
+	snapshot.Config.IPv6 = &ipv6
 
 	// Port forwarding rules
 	loadPortForwardingRules()
@@ -192,6 +197,60 @@ func restoreBackup(data []byte) error {
 		}
 	}
 
+	// Restore firewall zones and user rules (absent in pre-0.13 backups)
+	if snapshot.Config.FirewallZones != nil {
+		if err := validateZoneStore(*snapshot.Config.FirewallZones); err != nil {
+			log.Printf("WARNING: Skipping invalid firewall zones in backup: %v", err)
+		} else {
+			zoneStoreLock.Lock()
+			zoneStore = *snapshot.Config.FirewallZones
+			zoneStoreLock.Unlock()
+
+			if err := saveZoneConfig(); err != nil {
+				log.Printf("WARNING: Failed to restore firewall zones: %v", err)
+			}
+		}
+	}
+
+	if snapshot.Config.IPv6 != nil && validateIPv6Config(*snapshot.Config.IPv6) == nil {
+		ipv6ConfigLock.Lock()
+		ipv6Config = *snapshot.Config.IPv6
+		ipv6ConfigLock.Unlock()
+
+		if err := saveIPv6Config(); err != nil {
+			log.Printf("WARNING: Failed to restore IPv6 config: %v", err)
+		}
+	}
+
+	if snapshot.Config.FirewallSets != nil {
+		if err := validateFirewallSets(snapshot.Config.FirewallSets); err != nil {
+			log.Printf("WARNING: Skipping invalid firewall sets in backup: %v", err)
+		} else {
+			fwSetStoreLock.Lock()
+			fwSetStore.Sets = snapshot.Config.FirewallSets
+			fwSetStoreLock.Unlock()
+
+			if err := saveFirewallSets(); err != nil {
+				log.Printf("WARNING: Failed to restore firewall sets: %v", err)
+			}
+		}
+	}
+
+	// Rules are checked against the zones and sets now in effect
+	if snapshot.Config.UserFirewallRules != nil {
+		if err := validateUserFirewallRules(snapshot.Config.UserFirewallRules, GetZoneStore(), GetFirewallSets()); err != nil {
+			log.Printf("WARNING: Skipping invalid firewall rules in backup: %v", err)
+		} else {
+			fwRuleStoreLock.Lock()
+			fwRuleStore.Rules = snapshot.Config.UserFirewallRules
+			fwRuleStoreLock.Unlock()
+
+			if err := saveUserFirewallRules(); err != nil {
+				log.Printf("WARNING: Failed to restore firewall rules: %v", err)
+			}
+		}
+	}
+
 	log.Printf("System restored from backup (timestamp: %s)", snapshot.Timestamp.Format(time.RFC3339))
 
 	return nil
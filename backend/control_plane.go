@@ -70,7 +70,7 @@ func injectControlPlaneProtection(ruleset string) string {
 				currentLine := strings.TrimSpace(lines[j])
 
 				// Found the injection point - after invalid drop and ICMP accepts
-				if strings.Contains(currentLine, "ip6 nexthdr icmpv6 accept") {
+				if strings.Contains(currentLine, controlPlaneMarker) {
 					// Inject control plane rules here
 					if !injected {
 						// Write the next few lines until we hit the ICMP line
@@ -114,9 +114,9 @@ func injectControlPlaneProtection(ruleset string) string {
 
 // A better implementation that's more robust:
 func injectControlPlaneProtectionV2(ruleset string) string {
-	// Strategy: Find "ip6 nexthdr icmpv6 accept" and inject our rules right after it
+	// Strategy: Find the last base ICMPv6 rule of the input chain and inject our rules right after it
 
-	marker := "ip6 nexthdr icmpv6 accept"
+	marker := controlPlaneMarker
 	if !strings.Contains(ruleset, marker) {
 		fmt.Println("[CONTROL_PLANE] WARNING: Could not find injection point in ruleset")
 		return ruleset
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -139,33 +144,214 @@ func getARPTable() ([]ARPEntry, error) {
 	return entries, nil
 }
 
-// regenerateDnsmasqDHCPConfig generates the dnsmasq DHCP configuration file
-func regenerateDnsmasqDHCPConfig(store *DHCPConfigStore) error {
+// IPv6 modes map to dnsmasq dhcp-range mode keywords; "stateful" is a plain address range
+var dhcpv6Modes = map[string]bool{
+	"ra-only":      true,
+	"slaac":        true,
+	"ra-stateless": true,
+	"ra-names":     true,
+	"stateful":     true,
+}
+
+var (
+	dhcpLeaseTimeRegex = regexp.MustCompile(`^([0-9]+[smhdw]?|infinite)$`)
+	dnsDomainRegex     = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+)
+
+// validateDHCPv6Config checks the IPv6 part of an interface DHCP config
+func validateDHCPv6Config(conf DHCPConfig) error {
+	if conf.IPv6Mode == "" {
+		return nil
+	}
+	if !dhcpv6Modes[conf.IPv6Mode] {
+		return fmt.Errorf("ipv6Mode must be one of ra-only, slaac, ra-stateless, ra-names, stateful")
+	}
+
+	switch conf.IPv6PrefixSource {
+	case "", "static":
+		ip, prefix, err := net.ParseCIDR(conf.IPv6Prefix)
+		if err != nil || ip.To4() != nil {
+			return fmt.Errorf("ipv6Prefix must be an IPv6 CIDR")
+		}
+		if ones, _ := prefix.Mask.Size(); ones != 64 {
+			return fmt.Errorf("ipv6Prefix must be a /64 (SLAAC and RA require it)")
+		}
+	case "delegated":
+		if conf.IPv6Prefix != "" {
+			return fmt.Errorf("ipv6Prefix must be empty when the prefix is delegated")
+		}
+	default:
+		return fmt.Errorf("ipv6PrefixSource must be static or delegated")
+	}
+
+	hasRange := conf.IPv6StartSuffix != "" || conf.IPv6EndSuffix != ""
+	if conf.IPv6Mode == "stateful" && !hasRange {
+		return fmt.Errorf("stateful DHCPv6 requires ipv6StartSuffix and ipv6EndSuffix")
+	}
+	if hasRange {
+		if conf.IPv6Mode != "stateful" && conf.IPv6Mode != "slaac" {
+			return fmt.Errorf("an address range is only used in stateful or slaac mode")
+		}
+		start, err1 := parseIPv6Suffix(conf.IPv6StartSuffix)
+		end, err2 := parseIPv6Suffix(conf.IPv6EndSuffix)
+		if err1 != nil {
+			return err1
+		}
+		if err2 != nil {
+			return err2
+		}
+		if bytes.Compare(start, end) > 0 {
+			return fmt.Errorf("ipv6StartSuffix must not be greater than ipv6EndSuffix")
+		}
+	}
+
+	if conf.IPv6LeaseTime != "" && !dhcpLeaseTimeRegex.MatchString(conf.IPv6LeaseTime) {
+		return fmt.Errorf("invalid ipv6LeaseTime %q", conf.IPv6LeaseTime)
+	}
+	for _, dns := range conf.RDNSS {
+		if !isIPv6Address(dns) {
+			return fmt.Errorf("RDNSS server %q is not an IPv6 address", dns)
+		}
+	}
+	for _, domain := range conf.DNSSL {
+		if len(domain) > 253 || !dnsDomainRegex.MatchString(domain) {
+			return fmt.Errorf("invalid DNSSL domain %q", domain)
+		}
+	}
+	return nil
+}
+
+// parseIPv6Suffix parses the interface-identifier part of a DHCPv6 range (e.g. ::100)
+func parseIPv6Suffix(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil || !strings.Contains(s, ":") {
+		return nil, fmt.Errorf("invalid IPv6 range suffix %q", s)
+	}
+	ip = ip.To16()
+	for _, b := range ip[:8] {
+		if b != 0 {
+			return nil, fmt.Errorf("IPv6 range suffix %q must only set the lower 64 bits", s)
+		}
+	}
+	return ip, nil
+}
+
+// ipv6PrefixAddr combines a /64 prefix with a host suffix
+func ipv6PrefixAddr(prefix *net.IPNet, suffix net.IP) net.IP {
+	addr := make(net.IP, net.IPv6len)
+	copy(addr, prefix.IP.To16())
+	copy(addr[8:], suffix.To16()[8:])
+	return addr
+}
+
+// writeDHCPv6Config renders the RA / DHCPv6 lines for one interface. A static
+// prefix is written out explicitly; a delegated prefix uses dnsmasq's
+// constructor so the range follows whatever /64 is assigned to the interface.
+func writeDHCPv6Config(b *strings.Builder, ifaceName string, conf DHCPConfig) {
+	if conf.IPv6Mode == "" {
+		return
+	}
+
+	leaseTime := conf.IPv6LeaseTime
+	if leaseTime == "" {
+		leaseTime = conf.LeaseTime
+	}
+	if leaseTime == "" {
+		leaseTime = "12h"
+	}
+
+	// dhcp-range address part: "<start>[,<end>]" plus constructor for delegated prefixes
+	var addrs []string
+	start, _ := parseIPv6Suffix(conf.IPv6StartSuffix)
+	end, _ := parseIPv6Suffix(conf.IPv6EndSuffix)
+	if conf.IPv6PrefixSource == "delegated" {
+		if start != nil && end != nil {
+			addrs = []string{start.String(), end.String()}
+		} else {
+			addrs = []string{"::"}
+		}
+		addrs = append(addrs, "constructor:"+ifaceName)
+	} else {
+		_, prefix, err := net.ParseCIDR(conf.IPv6Prefix)
+		if err != nil {
+			return
+		}
+		if start != nil && end != nil {
+			addrs = []string{ipv6PrefixAddr(prefix, start).String(), ipv6PrefixAddr(prefix, end).String()}
+		} else {
+			addrs = []string{prefix.IP.String()}
+		}
+	}
+
+	parts := append([]string{ifaceName}, addrs...)
+	if conf.IPv6Mode != "stateful" {
+		parts = append(parts, conf.IPv6Mode)
+	}
+	parts = append(parts, "64", leaseTime)
+	b.WriteString(fmt.Sprintf("dhcp-range=%s\n", strings.Join(parts, ",")))
+
+	// RDNSS / DNSSL are sent in both router advertisements and DHCPv6 replies
+	if len(conf.RDNSS) > 0 {
+		servers := make([]string, len(conf.RDNSS))
+		for i, s := range conf.RDNSS {
+			servers[i] = "[" + s + "]"
+		}
+		b.WriteString(fmt.Sprintf("dhcp-option=%s,option6:dns-server,%s\n", ifaceName, strings.Join(servers, ",")))
+	}
+	if len(conf.DNSSL) > 0 {
+		b.WriteString(fmt.Sprintf("dhcp-option=%s,option6:domain-search,%s\n", ifaceName, strings.Join(conf.DNSSL, ",")))
+	}
+}
+
+// renderDnsmasqDHCPConfig builds the dnsmasq DHCP configuration from the store
+func renderDnsmasqDHCPConfig(store *DHCPConfigStore) string {
 	var config strings.Builder
 
 	config.WriteString("# Auto-generated by SoftRouter - DO NOT EDIT MANUALLY\n")
 	config.WriteString("# Edit via Web UI: Interfaces > Configure DHCP\n\n")
 
+	ifaceNames := make([]string, 0, len(store.Configs))
+	raEnabled := false
 	for ifaceName, dhcpConf := range store.Configs {
+		ifaceNames = append(ifaceNames, ifaceName)
+		if dhcpConf.Enabled && dhcpConf.IPv6Mode != "" {
+			raEnabled = true
+		}
+	}
+	sort.Strings(ifaceNames)
+
+	if raEnabled {
+		config.WriteString("# IPv6 router advertisements\n")
+		config.WriteString("enable-ra\n\n")
+	}
+
+	for _, ifaceName := range ifaceNames {
+		dhcpConf := store.Configs[ifaceName]
 		if !dhcpConf.Enabled {
 			continue
 		}
 
 		config.WriteString(fmt.Sprintf("# DHCP for %s\n", ifaceName))
 		config.WriteString(fmt.Sprintf("interface=%s\n", ifaceName))
-		config.WriteString(fmt.Sprintf("dhcp-range=%s,%s,%s,%s\n",
-			ifaceName, dhcpConf.StartIP, dhcpConf.EndIP, dhcpConf.LeaseTime))
 
-		// Gateway (option 3)
-		if dhcpConf.Gateway != "" {
-			config.WriteString(fmt.Sprintf("dhcp-option=%s,3,%s\n", ifaceName, dhcpConf.Gateway))
-		}
+		// An IPv6-only interface has no IPv4 range
+		if dhcpConf.StartIP != "" {
+			config.WriteString(fmt.Sprintf("dhcp-range=%s,%s,%s,%s\n",
+				ifaceName, dhcpConf.StartIP, dhcpConf.EndIP, dhcpConf.LeaseTime))
 
-		// DNS servers (option 6)
-		if len(dhcpConf.DNSServers) > 0 {
-			config.WriteString(fmt.Sprintf("dhcp-option=%s,6,%s\n", ifaceName, strings.Join(dhcpConf.DNSServers, ",")))
+			// Gateway (option 3)
+			if dhcpConf.Gateway != "" {
+				config.WriteString(fmt.Sprintf("dhcp-option=%s,3,%s\n", ifaceName, dhcpConf.Gateway))
+			}
+
+			// DNS servers (option 6)
+			if len(dhcpConf.DNSServers) > 0 {
+				config.WriteString(fmt.Sprintf("dhcp-option=%s,6,%s\n", ifaceName, strings.Join(dhcpConf.DNSServers, ",")))
+			}
 		}
 
+		writeDHCPv6Config(&config, ifaceName, dhcpConf)
+
 		config.WriteString("\n")
 	}
 
@@ -178,6 +364,11 @@ func regenerateDnsmasqDHCPConfig(store *DHCPConfigStore) error {
 		}
 	}
 
+	return config.String()
+}
+
+// regenerateDnsmasqDHCPConfig generates the dnsmasq DHCP configuration file
+func regenerateDnsmasqDHCPConfig(store *DHCPConfigStore) error {
 	// Ensure directory exists
 	if err := os.MkdirAll("/etc/dnsmasq.d", 0755); err != nil {
 		log.Printf("ERROR: Failed to create /etc/dnsmasq.d directory: %v", err)
@@ -185,7 +376,7 @@ func regenerateDnsmasqDHCPConfig(store *DHCPConfigStore) error {
 	}
 
 	// Write the configuration file
-	err := os.WriteFile(dnsmasqDHCPPath, []byte(config.String()), 0644)
+	err := os.WriteFile(dnsmasqDHCPPath, []byte(renderDnsmasqDHCPConfig(store)), 0644)
 	if err != nil {
 		return err
 	}
@@ -212,40 +403,51 @@ func parseDHCPLeases() ([]DHCPLease, error) {
 	}
 	defer file.Close() //nolint:errcheck
 
+	return parseDHCPLeasesFrom(file)
+}
+
+// parseDHCPLeasesFrom parses dnsmasq lease lines. IPv4 leases come first; a
+// "duid <server-duid>" line starts the DHCPv6 section, where the MAC column is
+// replaced by the IAID and the last column is the client DUID.
+func parseDHCPLeasesFrom(r io.Reader) ([]DHCPLease, error) {
 	var leases []DHCPLease
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
+	inIPv6 := false
 
 	for scanner.Scan() {
 		line := scanner.Text()
 		fields := strings.Fields(line)
 
+		if len(fields) == 2 && fields[0] == "duid" {
+			inIPv6 = true
+			continue
+		}
 		if len(fields) < 5 {
 			continue
 		}
 
-		// Format: <expiry> <mac> <ip> <hostname> <client-id>
-		expiryTimestamp := fields[0]
+		// Format: <expiry> <mac|iaid> <ip> <hostname> <client-id|duid>
 		expiryTime := time.Unix(0, 0) // Default
-		if ts, err := time.Parse("1136239445", expiryTimestamp); err == nil {
-			expiryTime = ts
+		if tsInt, err := strconv.ParseInt(fields[0], 10, 64); err != nil {
+			log.Printf("WARNING: Failed to parse DHCP lease timestamp: %v", err)
 		} else {
-			// Try int parse if timestamp is robust
-			var tsInt int64
-			if _, err := fmt.Sscanf(expiryTimestamp, "%d", &tsInt); err != nil {
-				log.Printf("WARNING: Failed to parse DHCP lease timestamp: %v", err)
-			} else {
-				expiryTime = time.Unix(tsInt, 0)
-			}
+			expiryTime = time.Unix(tsInt, 0)
 		}
 
 		lease := DHCPLease{
 			Expires:   expiryTime.Format("2006-01-02 15:04:05"),
-			MAC:       fields[1],
 			IP:        fields[2],
 			Hostname:  fields[3],
 			Interface: "", // dnsmasq doesn't track interface in leases file
 		}
 
+		if inIPv6 || isIPv6Address(fields[2]) {
+			lease.IAID = fields[1]
+			lease.DUID = fields[4]
+		} else {
+			lease.MAC = fields[1]
+		}
+
 		leases = append(leases, lease)
 	}
 
@@ -283,6 +485,10 @@ func getNetworkClients(w http.ResponseWriter, r *http.Request) {
 
 	// Add DHCP Leases (Update IP/Expires if exists, else create)
 	for _, lease := range dhcpLeases {
+		// DHCPv6 leases are keyed by DUID and carry no MAC
+		if lease.MAC == "" {
+			continue
+		}
 		entry, exists := clientMap[lease.MAC]
 		if !exists {
 			entry = ARPEntry{
@@ -437,9 +643,15 @@ func setDHCPConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate IP range if enabled
+	// Validate IP range if enabled (IPv6-only interfaces may omit the IPv4 range)
 	if req.Config.Enabled {
-		if err := validateIPRange(req.InterfaceName, req.Config.StartIP, req.Config.EndIP, req.Config.Gateway); err != nil {
+		if req.Config.StartIP != "" || req.Config.IPv6Mode == "" {
+			if err := validateIPRange(req.InterfaceName, req.Config.StartIP, req.Config.EndIP, req.Config.Gateway); err != nil {
+				http.Error(w, fmt.Sprintf("Validation error: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := validateDHCPv6Config(req.Config); err != nil {
 			http.Error(w, fmt.Sprintf("Validation error: %v", err), http.StatusBadRequest)
 			return
 		}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderDnsmasqDHCPv6(t *testing.T) {
+	store := &DHCPConfigStore{
+		Configs: map[string]DHCPConfig{
+			"eth1": {
+				Enabled: true, StartIP: "192.168.1.100", EndIP: "192.168.1.200", LeaseTime: "12h", Gateway: "192.168.1.1",
+				IPv6Mode: "stateful", IPv6Prefix: "2001:db8:1::/64", IPv6StartSuffix: "::100", IPv6EndSuffix: "::1ff",
+				RDNSS: []string{"2001:db8:1::1"}, DNSSL: []string{"lan", "example.com"},
+			},
+			"eth2": {Enabled: true, IPv6Mode: "ra-stateless", IPv6PrefixSource: "delegated", IPv6LeaseTime: "1h"},
+			"eth3": {Enabled: true, StartIP: "10.0.3.10", EndIP: "10.0.3.50", LeaseTime: "24h"},
+		},
+	}
+
+	config := renderDnsmasqDHCPConfig(store)
+
+	expected := []string{
+		"enable-ra\n",
+		"dhcp-range=eth1,192.168.1.100,192.168.1.200,12h\n",
+		"dhcp-range=eth1,2001:db8:1::100,2001:db8:1::1ff,64,12h\n",
+		"dhcp-option=eth1,option6:dns-server,[2001:db8:1::1]\n",
+		"dhcp-option=eth1,option6:domain-search,lan,example.com\n",
+		"dhcp-range=eth2,::,constructor:eth2,ra-stateless,64,1h\n",
+		"dhcp-range=eth3,10.0.3.10,10.0.3.50,24h\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(config, s) {
+			t.Errorf("Expected config to contain %q.\nGenerated:\n%s", s, config)
+		}
+	}
+
+	if strings.Contains(config, "dhcp-range=eth2,,") {
+		t.Error("IPv6-only interface must not get an IPv4 range")
+	}
+	if strings.Index(config, "interface=eth1") > strings.Index(config, "interface=eth2") {
+		t.Error("Expected interfaces in sorted order")
+	}
+}
+
+func TestValidateDHCPv6Config(t *testing.T) {
+	valid := DHCPConfig{IPv6Mode: "slaac", IPv6Prefix: "2001:db8:1::/64", RDNSS: []string{"2001:db8:1::1"}}
+	if err := validateDHCPv6Config(valid); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*DHCPConfig)
+	}{
+		{"unknown mode", func(c *DHCPConfig) { c.IPv6Mode = "dhcp" }},
+		{"prefix not /64", func(c *DHCPConfig) { c.IPv6Prefix = "2001:db8::/56" }},
+		{"ipv4 prefix", func(c *DHCPConfig) { c.IPv6Prefix = "10.0.0.0/24" }},
+		{"delegated with prefix", func(c *DHCPConfig) { c.IPv6PrefixSource = "delegated" }},
+		{"stateful without range", func(c *DHCPConfig) { c.IPv6Mode = "stateful" }},
+		{"range on ra-only", func(c *DHCPConfig) { c.IPv6Mode = "ra-only"; c.IPv6StartSuffix = "::1"; c.IPv6EndSuffix = "::2" }},
+		{"suffix sets prefix bits", func(c *DHCPConfig) { c.IPv6StartSuffix = "2001::1"; c.IPv6EndSuffix = "::2" }},
+		{"inverted range", func(c *DHCPConfig) { c.IPv6StartSuffix = "::200"; c.IPv6EndSuffix = "::100" }},
+		{"ipv4 rdnss", func(c *DHCPConfig) { c.RDNSS = []string{"1.1.1.1"} }},
+		{"bad dnssl", func(c *DHCPConfig) { c.DNSSL = []string{"lan\nenable-tftp"} }},
+		{"bad lease time", func(c *DHCPConfig) { c.IPv6LeaseTime = "12h,ra-names" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := valid
+			tt.mutate(&conf)
+			if err := validateDHCPv6Config(conf); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestParseDHCPLeasesIPv6(t *testing.T) {
+	data := `1760000000 aa:bb:cc:dd:ee:ff 192.168.1.150 laptop 01:aa:bb:cc:dd:ee:ff
+duid 00:01:00:01:2c:3d:4e:5f:00:11:22:33:44:55
+1760003600 305419896 2001:db8:1::123 phone 00:04:8d:3c:1a:2b:3c:4d:5e:6f:70:81:92:a3:b4:c5:d6:e7
+`
+	leases, err := parseDHCPLeasesFrom(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("parseDHCPLeasesFrom failed: %v", err)
+	}
+	if len(leases) != 2 {
+		t.Fatalf("Expected 2 leases, got %d", len(leases))
+	}
+
+	if leases[0].MAC != "aa:bb:cc:dd:ee:ff" || leases[0].DUID != "" {
+		t.Errorf("Unexpected IPv4 lease: %+v", leases[0])
+	}
+	v6 := leases[1]
+	if v6.IP != "2001:db8:1::123" || v6.MAC != "" || v6.IAID != "305419896" || !strings.HasPrefix(v6.DUID, "00:04:8d") || v6.Hostname != "phone" {
+		t.Errorf("Unexpected IPv6 lease: %+v", v6)
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PDClientConfig requests a delegated prefix (DHCPv6-PD) on a WAN interface and
+// hands out /64s from it to LAN interfaces
+type PDClientConfig struct {
+	Interface      string            `json:"interface"`       // WAN interface running the client
+	Enabled        bool              `json:"enabled"`         //
+	PrefixHint     int               `json:"prefix_hint"`     // Requested prefix length (e.g. 56), 0 = let the ISP decide
+	RequestAddress bool              `json:"request_address"` // Also request a WAN address (IA_NA)
+	LANs           []PDLANAssignment `json:"lans"`
+}
+
+// PDLANAssignment maps a LAN interface to a /64 subnet of the delegated prefix
+type PDLANAssignment struct {
+	Interface string `json:"interface"`
+	SubnetID  int    `json:"subnet_id"` // Index of the /64 inside the delegated prefix
+}
+
+// PDStore holds all prefix delegation clients
+type PDStore struct {
+	Clients []PDClientConfig `json:"clients"`
+}
+
+// DelegatedPrefix is the current prefix parsed from a client's lease file
+type DelegatedPrefix struct {
+	Interface     string    `json:"interface"`
+	Prefix        string    `json:"prefix"` // e.g. 2001:db8:1200::/56
+	PreferredLife int64     `json:"preferred_life"`
+	ValidLife     int64     `json:"valid_life"`
+	Obtained      time.Time `json:"obtained"`
+	Expires       time.Time `json:"expires"`
+	DNSServers    []string  `json:"dns_servers,omitempty"`
+}
+
+// PDStatus reports the delegated prefix and LAN addresses of one client
+type PDStatus struct {
+	Interface   string            `json:"interface"`
+	Prefix      *DelegatedPrefix  `json:"prefix"`
+	Assignments map[string]string `json:"assignments"` // LAN interface -> assigned address
+}
+
+var (
+	pdStore      = PDStore{Clients: []PDClientConfig{}}
+	pdStoreLock  sync.RWMutex
+	pdConfigPath = "/etc/softrouter/dhcpv6_pd.json"
+
+	pdUnitDir  = "/etc/systemd/system"
+	pdLeaseDir = "/var/lib/softrouter"
+
+	pdState     = map[string]DelegatedPrefix{} // WAN interface -> current prefix
+	pdAssigned  = map[string]string{}          // LAN interface -> address assigned from a delegated prefix
+	pdStateLock sync.Mutex
+	pdTicker    *time.Ticker
+)
+
+func initPrefixDelegation() {
+	loadPDConfig()
+	applyPDClients(nil)
+	startPDMonitor()
+}
+
+func loadPDConfig() {
+	pdStoreLock.Lock()
+	defer pdStoreLock.Unlock()
+
+	data, err := os.ReadFile(pdConfigPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error loading DHCPv6-PD config: %v\n", err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &pdStore); err != nil {
+		fmt.Printf("Error parsing DHCPv6-PD config: %v\n", err)
+		pdStore = PDStore{Clients: []PDClientConfig{}}
+	}
+}
+
+func savePDConfig() error {
+	pdStoreLock.RLock()
+	data, err := json.MarshalIndent(pdStore, "", "  ")
+	pdStoreLock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pdConfigPath, data, 0644)
+}
+
+// GetPDConfig returns a copy of the prefix delegation configuration
+func GetPDConfig() PDStore {
+	pdStoreLock.RLock()
+	defer pdStoreLock.RUnlock()
+
+	store := PDStore{Clients: make([]PDClientConfig, len(pdStore.Clients))}
+	for i, c := range pdStore.Clients {
+		c.LANs = append([]PDLANAssignment(nil), c.LANs...)
+		store.Clients[i] = c
+	}
+	return store
+}
+
+func validatePDConfig(store PDStore) error {
+	wans := make(map[string]bool)
+	lans := make(map[string]bool)
+
+	for _, c := range store.Clients {
+		if !isValidInterfaceName(c.Interface) {
+			return fmt.Errorf("invalid WAN interface name %q", c.Interface)
+		}
+		if wans[c.Interface] {
+			return fmt.Errorf("duplicate prefix delegation client on %s", c.Interface)
+		}
+		wans[c.Interface] = true
+
+		if c.PrefixHint != 0 && (c.PrefixHint < 48 || c.PrefixHint > 64) {
+			return fmt.Errorf("%s: prefix_hint must be between 48 and 64", c.Interface)
+		}
+
+		subnets := make(map[int]bool)
+		for _, lan := range c.LANs {
+			if !isValidInterfaceName(lan.Interface) || lan.Interface == c.Interface {
+				return fmt.Errorf("%s: invalid LAN interface %q", c.Interface, lan.Interface)
+			}
+			if lans[lan.Interface] {
+				return fmt.Errorf("LAN interface %s is assigned more than once", lan.Interface)
+			}
+			lans[lan.Interface] = true
+
+			if lan.SubnetID < 0 || (c.PrefixHint != 0 && lan.SubnetID >= 1<<(64-c.PrefixHint)) {
+				return fmt.Errorf("%s: subnet_id %d does not fit in a /%d", lan.Interface, lan.SubnetID, c.PrefixHint)
+			}
+			if subnets[lan.SubnetID] {
+				return fmt.Errorf("%s: subnet_id %d is used twice", c.Interface, lan.SubnetID)
+			}
+			subnets[lan.SubnetID] = true
+		}
+	}
+
+	for wan := range wans {
+		if lans[wan] {
+			return fmt.Errorf("%s cannot be both a WAN and a LAN interface", wan)
+		}
+	}
+	return nil
+}
+
+// splitDelegatedPrefix returns the subnetID-th /64 of a delegated prefix
+func splitDelegatedPrefix(prefix string, subnetID int) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil || ip.To4() != nil {
+		return nil, fmt.Errorf("invalid delegated prefix %q", prefix)
+	}
+	ones, _ := ipNet.Mask.Size()
+	if ones > 64 {
+		return nil, fmt.Errorf("delegated prefix %s is longer than /64", prefix)
+	}
+	if subnetID < 0 || uint64(subnetID) >= uint64(1)<<(64-ones) {
+		return nil, fmt.Errorf("subnet %d does not fit in %s", subnetID, prefix)
+	}
+
+	// Place the subnet ID in the bits between the prefix length and /64
+	base := new(big.Int).SetBytes(ipNet.IP.To16())
+	offset := new(big.Int).Lsh(big.NewInt(int64(subnetID)), 64)
+	sum := new(big.Int).Add(base, offset).Bytes()
+
+	subnet := make(net.IP, net.IPv6len)
+	copy(subnet[net.IPv6len-len(sum):], sum)
+	return &net.IPNet{IP: subnet, Mask: net.CIDRMask(64, 128)}, nil
+}
+
+// desiredLANAddresses computes the router address (::1/64) for every LAN
+// interface whose WAN currently holds a delegated prefix
+func desiredLANAddresses(store PDStore, prefixes map[string]DelegatedPrefix) map[string]string {
+	wanted := make(map[string]string)
+	for _, c := range store.Clients {
+		p, ok := prefixes[c.Interface]
+		if !c.Enabled || !ok {
+			continue
+		}
+		for _, lan := range c.LANs {
+			subnet, err := splitDelegatedPrefix(p.Prefix, lan.SubnetID)
+			if err != nil {
+				fmt.Printf("WARNING: DHCPv6-PD on %s: %v\n", c.Interface, err)
+				continue
+			}
+			addr := make(net.IP, net.IPv6len)
+			copy(addr, subnet.IP)
+			addr[15] = 1
+			wanted[lan.Interface] = addr.String() + "/64"
+		}
+	}
+	return wanted
+}
+
+// parseDHCPv6PDLease returns the prefix from the most recent lease6 block of a
+// dhclient lease file, or nil if there is none or it has expired:
+//
+//	lease6 {
+//	  interface "eth0";
+//	  ia-pd 1a:2b:3c:4d {
+//	    iaprefix 2001:db8:1200::/56 {
+//	      starts 1700000000;
+//	      preferred-life 3600;
+//	      max-life 7200;
+//	    }
+//	  }
+//	  option dhcp6.name-servers 2001:db8::53;
+//	}
+func parseDHCPv6PDLease(r io.Reader, now time.Time) (*DelegatedPrefix, error) {
+	var latest, current *DelegatedPrefix
+	depth, prefixDepth := 0, 0
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ";")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "lease6" && strings.HasSuffix(line, "{"):
+			current = &DelegatedPrefix{}
+			depth = 1
+			continue
+		case current == nil:
+			continue
+		case fields[0] == "iaprefix" && len(fields) >= 2:
+			current.Prefix = fields[1]
+			prefixDepth = depth + 1
+		case fields[0] == "interface" && len(fields) == 2:
+			current.Interface = strings.Trim(fields[1], `"`)
+		case fields[0] == "option" && len(fields) >= 3 && fields[1] == "dhcp6.name-servers":
+			current.DNSServers = strings.Split(strings.Join(fields[2:], ""), ",")
+		case depth == prefixDepth && len(fields) == 2:
+			n, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				break
+			}
+			switch fields[0] {
+			case "starts":
+				current.Obtained = time.Unix(n, 0)
+			case "preferred-life":
+				current.PreferredLife = n
+			case "max-life":
+				current.ValidLife = n
+			}
+		}
+
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+		if depth < prefixDepth {
+			prefixDepth = 0
+		}
+		if depth == 0 {
+			if current.Prefix != "" {
+				latest = current
+			}
+			current = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if latest == nil {
+		return nil, nil
+	}
+	latest.Expires = latest.Obtained.Add(time.Duration(latest.ValidLife) * time.Second)
+	if !latest.Expires.After(now) {
+		return nil, nil
+	}
+	return latest, nil
+}
+
+func pdUnitName(iface string) string {
+	return "softrouter-dhcp6pd-" + iface + ".service"
+}
+
+func pdLeaseFile(iface string) string {
+	return fmt.Sprintf("%s/dhcp6pd-%s.leases", pdLeaseDir, iface)
+}
+
+// renderPDUnit builds the systemd unit running dhclient in prefix delegation mode
+func renderPDUnit(c PDClientConfig) string {
+	args := []string{"/sbin/dhclient", "-6", "-P"}
+	if c.RequestAddress {
+		args = append(args, "-N")
+	}
+	if c.PrefixHint != 0 {
+		args = append(args, "--prefix-len-hint", strconv.Itoa(c.PrefixHint))
+	}
+	args = append(args, "-d",
+		"-lf", pdLeaseFile(c.Interface),
+		"-pf", fmt.Sprintf("/run/softrouter-dhcp6pd-%s.pid", c.Interface),
+		c.Interface)
+
+	var b strings.Builder
+	b.WriteString("# Auto-generated by SoftRouter - DO NOT EDIT MANUALLY\n")
+	b.WriteString("[Unit]\n")
+	b.WriteString(fmt.Sprintf("Description=SoftRouter DHCPv6 prefix delegation on %s\n", c.Interface))
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("Wants=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString(fmt.Sprintf("ExecStart=%s\n", strings.Join(args, " ")))
+	b.WriteString("Restart=always\n")
+	b.WriteString("RestartSec=10\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// applyPDClients writes and (re)starts the client units, and stops the units of
+// clients that were removed or disabled since previous
+func applyPDClients(previous *PDStore) {
+	store := GetPDConfig()
+
+	if err := os.MkdirAll(pdLeaseDir, 0755); err != nil {
+		fmt.Printf("WARNING: Failed to create %s: %v\n", pdLeaseDir, err)
+	}
+
+	active := make(map[string]bool)
+	for _, c := range store.Clients {
+		if !c.Enabled {
+			continue
+		}
+		active[c.Interface] = true
+
+		unitPath := pdUnitDir + "/" + pdUnitName(c.Interface)
+		unit := renderPDUnit(c)
+		if existing, err := os.ReadFile(unitPath); err == nil && string(existing) == unit {
+			continue // Unchanged and already running
+		}
+		if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+			fmt.Printf("WARNING: Failed to write %s: %v\n", unitPath, err)
+			continue
+		}
+		if err := runPrivileged("systemctl", "daemon-reload"); err != nil {
+			fmt.Printf("WARNING: systemctl daemon-reload failed: %v\n", err)
+		}
+		if err := runPrivileged("systemctl", "enable", pdUnitName(c.Interface)); err != nil {
+			fmt.Printf("WARNING: Failed to enable DHCPv6-PD on %s: %v\n", c.Interface, err)
+		}
+		if err := runPrivileged("systemctl", "restart", pdUnitName(c.Interface)); err != nil {
+			fmt.Printf("WARNING: Failed to start DHCPv6-PD on %s: %v\n", c.Interface, err)
+		}
+	}
+
+	if previous == nil {
+		return
+	}
+	for _, c := range previous.Clients {
+		if active[c.Interface] {
+			continue
+		}
+		if err := runPrivileged("systemctl", "disable", "--now", pdUnitName(c.Interface)); err != nil {
+			fmt.Printf("WARNING: Failed to stop DHCPv6-PD on %s: %v\n", c.Interface, err)
+		}
+		if err := os.Remove(pdUnitDir + "/" + pdUnitName(c.Interface)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("WARNING: Failed to remove DHCPv6-PD unit for %s: %v\n", c.Interface, err)
+		}
+	}
+}
+
+// startPDMonitor polls the lease files for prefix changes
+func startPDMonitor() {
+	pdTicker = time.NewTicker(30 * time.Second)
+	go func() {
+		refreshDelegatedPrefixes()
+		for range pdTicker.C {
+			refreshDelegatedPrefixes()
+		}
+	}()
+}
+
+// refreshDelegatedPrefixes reads the current prefixes, moves LAN addresses to
+// the new /64s and re-renders RA and firewall config when anything changed
+func refreshDelegatedPrefixes() {
+	store := GetPDConfig()
+
+	prefixes := make(map[string]DelegatedPrefix)
+	for _, c := range store.Clients {
+		if !c.Enabled {
+			continue
+		}
+		f, err := os.Open(pdLeaseFile(c.Interface))
+		if err != nil {
+			continue // No lease yet
+		}
+		p, err := parseDHCPv6PDLease(f, time.Now())
+		f.Close() //nolint:errcheck
+		if err != nil {
+			fmt.Printf("WARNING: Failed to parse DHCPv6-PD lease for %s: %v\n", c.Interface, err)
+			continue
+		}
+		if p != nil {
+			p.Interface = c.Interface
+			prefixes[c.Interface] = *p
+		}
+	}
+
+	pdStateLock.Lock()
+	defer pdStateLock.Unlock()
+
+	changed := false
+	for wan, p := range prefixes {
+		if old, ok := pdState[wan]; !ok || old.Prefix != p.Prefix {
+			fmt.Printf("DHCPv6-PD: %s delegated prefix is now %s\n", wan, p.Prefix)
+			changed = true
+		}
+	}
+	for wan, old := range pdState {
+		if _, ok := prefixes[wan]; !ok {
+			fmt.Printf("DHCPv6-PD: %s lost delegated prefix %s\n", wan, old.Prefix)
+			changed = true
+		}
+	}
+	pdState = prefixes
+
+	wanted := desiredLANAddresses(store, prefixes)
+	for lan, addr := range pdAssigned {
+		if wanted[lan] == addr {
+			continue
+		}
+		if err := configureInterfaceAddress("del", addr, lan); err != nil {
+			fmt.Printf("WARNING: Failed to remove %s from %s: %v\n", addr, lan, err)
+		}
+		delete(pdAssigned, lan)
+		changed = true
+	}
+	for lan, addr := range wanted {
+		if pdAssigned[lan] == addr {
+			continue
+		}
+		if err := configureInterfaceAddress("replace", addr, lan); err != nil {
+			fmt.Printf("WARNING: Failed to assign %s to %s: %v\n", addr, lan, err)
+			continue
+		}
+		pdAssigned[lan] = addr
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+
+	// Router advertisements for delegated prefixes follow the LAN address (constructor:)
+	if dhcpStore, err := loadDHCPConfig(); err == nil && usesDelegatedPrefix(dhcpStore) {
+		if err := regenerateDnsmasqDHCPConfig(dhcpStore); err != nil {
+			fmt.Printf("WARNING: Failed to update router advertisements: %v\n", err)
+		}
+	}
+	// The ruleset matches interfaces, not the delegated prefix, so it is left
+	// alone: an unattended apply would arm the confirm watchdog and roll back
+}
+
+func usesDelegatedPrefix(store *DHCPConfigStore) bool {
+	for _, conf := range store.Configs {
+		if conf.Enabled && conf.IPv6Mode != "" && conf.IPv6PrefixSource == "delegated" {
+			return true
+		}
+	}
+	return false
+}
+
+// getPDStatus returns the current prefix and LAN addresses for each client
+func getPDStatus() []PDStatus {
+	store := GetPDConfig()
+
+	pdStateLock.Lock()
+	defer pdStateLock.Unlock()
+
+	status := make([]PDStatus, 0, len(store.Clients))
+	for _, c := range store.Clients {
+		s := PDStatus{Interface: c.Interface, Assignments: make(map[string]string)}
+		if p, ok := pdState[c.Interface]; ok {
+			s.Prefix = &p
+		}
+		for _, lan := range c.LANs {
+			if addr, ok := pdAssigned[lan.Interface]; ok {
+				s.Assignments[lan.Interface] = addr
+			}
+		}
+		status = append(status, s)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Interface < status[j].Interface })
+	return status
+}
+
+// --- Handlers ---
+
+func getPDHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]interface{}{
+		"config": GetPDConfig(),
+		"status": getPDStatus(),
+	})
+}
+
+func updatePDHandler(w http.ResponseWriter, r *http.Request) {
+	var req PDStore
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if req.Clients == nil {
+		req.Clients = []PDClientConfig{}
+	}
+
+	if err := validatePDConfig(req); err != nil {
+		respondWithError(w, ErrNetworkInvalidIP, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	previous := GetPDConfig()
+	pdStoreLock.Lock()
+	pdStore = req
+	pdStoreLock.Unlock()
+
+	reqJSON, _ := json.Marshal(req)
+	if err := savePDConfig(); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "network.ipv6.pd.update", "dhcpv6_pd", string(reqJSON), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save DHCPv6-PD configuration", err)
+		return
+	}
+	logAuditEvent(getUsernameFromToken(r), "network.ipv6.pd.update", "dhcpv6_pd", string(reqJSON), getClientIP(r), true)
+
+	go func() {
+		applyPDClients(&previous)
+		refreshDelegatedPrefixes()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, req)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitDelegatedPrefix(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		subnetID int
+		expected string
+		valid    bool
+	}{
+		{"2001:db8:1200::/56", 0, "2001:db8:1200::/64", true},
+		{"2001:db8:1200::/56", 1, "2001:db8:1200:1::/64", true},
+		{"2001:db8:1200::/56", 255, "2001:db8:1200:ff::/64", true},
+		{"2001:db8:1200::/56", 256, "", false},
+		{"2001:db8:1234:5600::/60", 10, "2001:db8:1234:560a::/64", true},
+		{"2001:db8:1:2::/64", 1, "", false},
+		{"10.0.0.0/8", 0, "", false},
+	}
+
+	for _, tt := range tests {
+		subnet, err := splitDelegatedPrefix(tt.prefix, tt.subnetID)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s #%d: expected valid=%v, got %v", tt.prefix, tt.subnetID, tt.valid, err)
+			continue
+		}
+		if tt.valid && subnet.String() != tt.expected {
+			t.Errorf("%s #%d: expected %s, got %s", tt.prefix, tt.subnetID, tt.expected, subnet)
+		}
+	}
+}
+
+func TestParseDHCPv6PDLease(t *testing.T) {
+	data := `default-duid "\000\001\000\001\054\075\116\137";
+lease6 {
+  interface "eth0";
+  ia-pd 1a:2b:3c:4d {
+    starts 1700000000;
+    renew 1800;
+    iaprefix 2001:db8:1200::/56 {
+      starts 1700000000;
+      preferred-life 3600;
+      max-life 7200;
+    }
+  }
+}
+lease6 {
+  interface "eth0";
+  ia-pd 1a:2b:3c:4d {
+    starts 1700003600;
+    iaprefix 2001:db8:3400::/56 {
+      starts 1700003600;
+      preferred-life 3600;
+      max-life 7200;
+    }
+  }
+  option dhcp6.name-servers 2001:db8::53,2001:db8::54;
+}
+`
+	now := time.Unix(1700004000, 0)
+	p, err := parseDHCPv6PDLease(strings.NewReader(data), now)
+	if err != nil {
+		t.Fatalf("parseDHCPv6PDLease failed: %v", err)
+	}
+	if p == nil {
+		t.Fatal("Expected a delegated prefix, got nil")
+	}
+	if p.Prefix != "2001:db8:3400::/56" || p.ValidLife != 7200 || p.PreferredLife != 3600 || p.Interface != "eth0" {
+		t.Errorf("Unexpected prefix: %+v", p)
+	}
+	if len(p.DNSServers) != 2 || p.DNSServers[1] != "2001:db8::54" {
+		t.Errorf("Unexpected DNS servers: %v", p.DNSServers)
+	}
+
+	// Past max-life the prefix must no longer be used
+	if p, _ := parseDHCPv6PDLease(strings.NewReader(data), time.Unix(1700011000, 0)); p != nil {
+		t.Errorf("Expected expired lease to yield nil, got %+v", p)
+	}
+}
+
+func TestDesiredLANAddresses(t *testing.T) {
+	store := PDStore{Clients: []PDClientConfig{
+		{Interface: "eth0", Enabled: true, PrefixHint: 56, LANs: []PDLANAssignment{{"eth1", 0}, {"eth1.20", 32}}},
+		{Interface: "eth5", Enabled: true, LANs: []PDLANAssignment{{"eth6", 1}}},
+	}}
+	if err := validatePDConfig(store); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	wanted := desiredLANAddresses(store, map[string]DelegatedPrefix{"eth0": {Prefix: "2001:db8:1200::/56"}})
+	if wanted["eth1"] != "2001:db8:1200::1/64" || wanted["eth1.20"] != "2001:db8:1200:20::1/64" {
+		t.Errorf("Unexpected LAN addresses: %v", wanted)
+	}
+	if _, ok := wanted["eth6"]; ok {
+		t.Error("LANs of a WAN without a prefix must not get an address")
+	}
+
+	store.Clients[1].LANs = append(store.Clients[1].LANs, PDLANAssignment{"eth1", 2})
+	if err := validatePDConfig(store); err == nil {
+		t.Error("Expected error for LAN assigned by two clients")
+	}
+}
+
+func TestRenderPDUnit(t *testing.T) {
+	unit := renderPDUnit(PDClientConfig{Interface: "eth0", PrefixHint: 56, RequestAddress: true})
+	expected := "ExecStart=/sbin/dhclient -6 -P -N --prefix-len-hint 56 -d -lf /var/lib/softrouter/dhcp6pd-eth0.leases -pf /run/softrouter-dhcp6pd-eth0.pid eth0\n"
+	if !strings.Contains(unit, expected) {
+		t.Errorf("Expected unit to contain %q.\nGenerated:\n%s", expected, unit)
+	}
+}
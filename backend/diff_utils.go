@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Line-based unified diff (Myers algorithm) used to preview firewall changes
+
+const (
+	diffContextLines = 3
+	diffMaxEdits     = 2000 // Beyond this the inputs are reported as fully replaced
+)
+
+type diffOp struct {
+	Kind byte // ' ' (equal), '-' (only in a), '+' (only in b)
+	Text string
+}
+
+// diffLines returns the shortest edit script turning a into b
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	maxD := n + m
+	if maxD > diffMaxEdits {
+		maxD = diffMaxEdits
+	}
+
+	// v[k+off] is the furthest x reached on diagonal k; trace keeps a copy per step
+	off := maxD + 1
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+
+	found := -1
+	for d := 0; d <= maxD && found < 0; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				found = d
+				break
+			}
+		}
+	}
+
+	if found < 0 {
+		// Too different to diff cheaply: delete everything, insert everything
+		ops := make([]diffOp, 0, n+m)
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// Walk the trace backwards to recover the edit script
+	var rev []diffOp
+	x, y := n, m
+	for d := found; d >= 0; d-- {
+		vd := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && vd[off+k-1] < vd[off+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := vd[off+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			rev = append(rev, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				rev = append(rev, diffOp{'+', b[prevY]})
+			} else {
+				rev = append(rev, diffOp{'-', a[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	ops := make([]diffOp, len(rev))
+	for i := range rev {
+		ops[i] = rev[len(rev)-1-i]
+	}
+	return ops
+}
+
+// unifiedDiff renders the differences between a and b in `diff -u` format.
+// Returns "" when the inputs are identical.
+func unifiedDiff(aName, bName string, a, b []string) string {
+	ops := diffLines(a, b)
+
+	// Line numbers (0-based) in a and b before each op
+	aPos := make([]int, len(ops)+1)
+	bPos := make([]int, len(ops)+1)
+	changed := false
+	for i, op := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if op.Kind != '+' {
+			aPos[i+1]++
+		}
+		if op.Kind != '-' {
+			bPos[i+1]++
+		}
+		if op.Kind != ' ' {
+			changed = true
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", aName, bName))
+
+	i := 0
+	for i < len(ops) {
+		if ops[i].Kind == ' ' {
+			i++
+			continue
+		}
+
+		// Hunk starts with up to diffContextLines of leading context
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend while the next change is within 2*context equal lines
+		end := i
+		for end < len(ops) {
+			if ops[end].Kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].Kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContextLines {
+				end += min(diffContextLines, run-end)
+				break
+			}
+			end = run
+		}
+
+		aCount := aPos[end] - aPos[start]
+		bCount := bPos[end] - bPos[start]
+		out.WriteString(fmt.Sprintf("@@ -%s +%s @@\n",
+			diffRange(aPos[start], aCount), diffRange(bPos[start], bCount)))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.Kind)
+			out.WriteString(op.Text)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+	return out.String()
+}
+
+func diffRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+var (
+	rulesetCounterRegex = regexp.MustCompile(`counter packets [0-9]+ bytes [0-9]+`)
+	rulesetExpiresRegex = regexp.MustCompile(`\s+expires\s+[0-9a-z.]+`)
+)
+
+// normalizeRulesetLines makes rulesets printed by `nft list ruleset` comparable:
+// comments, blank lines, the leading flush and live counter values are dropped,
+// element lists are joined onto one line without the elements the kernel added
+// at runtime (those with an expiry), and indentation is rebuilt from brace
+// depth. It does not undo nft's own rewriting of the generated text, so both
+// sides must come from the same printer: nft (see canonicalRuleset) or the
+// generator.
+func normalizeRulesetLines(ruleset string) []string {
+	var lines []string
+	depth := 0
+	raw := strings.Split(ruleset, "\n")
+	for i := 0; i < len(raw); i++ {
+		line := strings.TrimSpace(raw[i])
+		if line == "" || strings.HasPrefix(line, "#") || line == "flush ruleset" {
+			continue
+		}
+		if strings.HasPrefix(line, "elements = {") {
+			for !strings.HasSuffix(line, "}") && i+1 < len(raw) {
+				i++
+				line += " " + strings.TrimSpace(raw[i])
+			}
+			if line = normalizeSetElements(line); line == "" {
+				continue
+			}
+		}
+		line = rulesetCounterRegex.ReplaceAllString(line, "counter")
+
+		if strings.HasPrefix(line, "}") && depth > 0 {
+			depth--
+		}
+		lines = append(lines, strings.Repeat("  ", depth)+line)
+		if strings.HasSuffix(line, "{") {
+			depth++
+		}
+	}
+	return lines
+}
+
+// normalizeSetElements rewrites an `elements = { ... }` line, dropping dynamic
+// elements. It returns "" when no static element is left.
+func normalizeSetElements(line string) string {
+	body := strings.TrimSuffix(strings.TrimPrefix(line, "elements = {"), "}")
+	var elements []string
+	for _, e := range strings.Split(body, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" || rulesetExpiresRegex.MatchString(e) {
+			continue
+		}
+		elements = append(elements, e)
+	}
+	if len(elements) == 0 {
+		return ""
+	}
+	return "elements = { " + strings.Join(elements, ", ") + " }"
+}
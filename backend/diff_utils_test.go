@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []string{"table inet softrouter {", "  chain input {", "    iif lo accept", "    tcp dport 22 accept", "  }", "}"}
+	b := []string{"table inet softrouter {", "  chain input {", "    iif lo accept", "    tcp dport 2222 accept", "  }", "}"}
+
+	expected := `--- live
++++ generated
+@@ -1,6 +1,6 @@
+ table inet softrouter {
+   chain input {
+     iif lo accept
+-    tcp dport 22 accept
++    tcp dport 2222 accept
+   }
+ }
+`
+	if got := unifiedDiff("live", "generated", a, b); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+
+	if got := unifiedDiff("a", "b", a, a); got != "" {
+		t.Errorf("Expected no diff for identical input, got:\n%s", got)
+	}
+}
+
+func TestUnifiedDiffSeparateHunks(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 20; i++ {
+		a = append(a, string(rune('a'+i)))
+	}
+	b = append(b, a...)
+	b[1] = "X"
+	b = append(b[:15], b[16:]...) // delete "p"
+
+	got := unifiedDiff("a", "b", a, b)
+	if strings.Count(got, "@@ ") != 2 {
+		t.Fatalf("Expected two hunks, got:\n%s", got)
+	}
+	if !strings.Contains(got, "@@ -1,5 +1,5 @@\n a\n-b\n+X\n c\n") {
+		t.Errorf("Unexpected first hunk:\n%s", got)
+	}
+	if !strings.Contains(got, "@@ -13,7 +13,6 @@\n m\n n\n o\n-p\n q\n") {
+		t.Errorf("Unexpected second hunk:\n%s", got)
+	}
+}
+
+// Listings as printed by `nft -s list ruleset`: the live ruleset after some
+// uptime, and the same ruleset freshly loaded into the preview namespace
+const (
+	testLiveListing = `table inet softrouter {
+	set vpn_policy_1 {
+		type ipv4_addr
+		size 65535
+		flags dynamic,timeout
+		timeout 1h
+		elements = { 142.250.74.46 timeout 1h expires 52m31s680ms, 142.250.74.78 timeout 1h expires 58m2s12ms }
+	}
+
+	set blocklist {
+		type ipv4_addr
+		flags interval
+		auto-merge
+		elements = { 10.66.0.0/16, 192.0.2.10,
+			     198.51.100.0/24 }
+	}
+
+	chain input {
+		type filter hook input priority filter; policy drop;
+		iif "lo" accept
+		ct state established,related accept
+		ct state invalid drop
+		tcp dport 22 ct state new limit rate 10/minute burst 20 packets accept comment "SSH rate limit"
+		ip saddr @blocklist drop
+		limit rate 5/minute burst 10 packets log prefix "[INPUT DROP] "
+	}
+
+	chain forward {
+		type filter hook forward priority filter; policy drop;
+		ip saddr 10.0.0.5 meta l4proto icmp reject with icmpx admin-prohibited comment "no ping"
+		counter packets 1234 bytes 567890 accept
+	}
+}
+`
+	testCandidateListing = `table inet softrouter {
+	set vpn_policy_1 {
+		type ipv4_addr
+		size 65535
+		flags dynamic,timeout
+		timeout 1h
+	}
+
+	set blocklist {
+		type ipv4_addr
+		flags interval
+		auto-merge
+		elements = { 10.66.0.0/16, 192.0.2.10, 198.51.100.0/24 }
+	}
+
+	chain input {
+		type filter hook input priority filter; policy drop;
+		iif "lo" accept
+		ct state established,related accept
+		ct state invalid drop
+		tcp dport 22 ct state new limit rate 10/minute burst 20 packets accept comment "SSH rate limit"
+		ip saddr @blocklist drop
+		limit rate 5/minute burst 10 packets log prefix "[INPUT DROP] "
+	}
+
+	chain forward {
+		type filter hook forward priority filter; policy drop;
+		ip saddr 10.0.0.5 meta l4proto icmp reject with icmpx admin-prohibited comment "no ping"
+		counter packets 0 bytes 0 accept
+	}
+}
+`
+)
+
+func TestNormalizeRulesetLines(t *testing.T) {
+	live, candidate := normalizeRulesetLines(testLiveListing), normalizeRulesetLines(testCandidateListing)
+	if diff := unifiedDiff("live", "generated", live, candidate); diff != "" {
+		t.Errorf("Expected listings of the same ruleset to match, got:\n%s", diff)
+	}
+
+	// A real change still shows
+	changed := strings.Replace(testCandidateListing, "192.0.2.10, ", "", 1)
+	diff := unifiedDiff("live", "generated", live, normalizeRulesetLines(changed))
+	if !strings.Contains(diff, "-    elements = { 10.66.0.0/16, 192.0.2.10, 198.51.100.0/24 }\n+    elements = { 10.66.0.0/16, 198.51.100.0/24 }\n") {
+		t.Errorf("Expected the removed element in the diff, got:\n%s", diff)
+	}
+
+	// Generated text only matches generated text, which is what the
+	// known-good snapshot diff compares
+	generated := "flush ruleset\n\ntable inet softrouter {\n  chain input {\n    # comment\n    counter accept\n  }\n}\n"
+	if diff := unifiedDiff("good", "generated", normalizeRulesetLines(generated), normalizeRulesetLines(generated+"# trailer\n")); diff != "" {
+		t.Errorf("Expected generated rulesets to match, got:\n%s", diff)
+	}
+}
+
+func TestCanonicalRulesetCommandsAllowed(t *testing.T) {
+	for _, args := range [][]string{
+		{"netns", "add", previewNetns},
+		{"netns", "exec", previewNetns, "nft", "-f", "/tmp/softrouter-preview-1.nft"},
+		{"netns", "exec", previewNetns, "nft", "-s", "list", "ruleset"},
+		{"netns", "delete", previewNetns},
+	} {
+		if err := validateCommand("ip", args); err != nil {
+			t.Errorf("Expected ip %v to be allowed, got %v", args, err)
+		}
+	}
+}
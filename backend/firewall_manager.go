@@ -34,61 +34,10 @@ func (fm *FirewallManager) ApplyFirewallRules() error {
 
 	fmt.Println("Regenerating NFTables Ruleset (Atomic Mode)...")
 
-	// 1. Load Context
-	metaStore, err := loadInterfaceMetadata()
-	if err != nil {
-		fmt.Printf("Warning: Failed to load interface metadata: %v\n", err)
-		metaStore = &InterfaceMetadataStore{Metadata: make(map[string]InterfaceMetadata)}
-	}
-
-	configLock.RLock()
-	cfg := config
-	configLock.RUnlock()
-
-	pfRules := GetPortForwardingRules()
-
-	// 2. Determine Interface Groups
-	wanInterfaces := []string{}
-	lanInterfaces := []string{}
-
-	hasExplicitWan := false
-	for _, m := range metaStore.Metadata {
-		if strings.EqualFold(m.Label, "WAN") {
-			hasExplicitWan = true
-			break
-		}
-	}
-
-	for iface, meta := range metaStore.Metadata {
-		if strings.EqualFold(meta.Label, "WAN") {
-			wanInterfaces = append(wanInterfaces, iface)
-		} else if strings.EqualFold(meta.Label, "LAN") {
-			lanInterfaces = append(lanInterfaces, iface)
-		}
-	}
-
-	// Fallback: Auto-detect WAN
-	if !hasExplicitWan {
-		defWan, err := getDefaultGatewayInterface()
-		if err == nil && defWan != "" {
-			fmt.Printf("Auto-detected WAN interface: %s\n", defWan)
-			wanInterfaces = append(wanInterfaces, defWan)
-		}
-	}
-
-	// 3. Self-Check: Validate configuration
-	if len(wanInterfaces) == 0 {
-		return fmt.Errorf("CRITICAL: No WAN interfaces defined. Refusing to apply firewall rules")
-	}
-
-	if len(lanInterfaces) == 0 {
-		fmt.Println("WARNING: No LAN interfaces labeled. Management access may be limited to localhost only")
-	}
-
-	// 4. Generate complete ruleset as text
-	ruleset, err := fm.generateFullRuleset(wanInterfaces, lanInterfaces, cfg, pfRules)
+	// 1-4. Load context, resolve zones and generate complete ruleset as text
+	ruleset, err := fm.buildRuleset()
 	if err != nil {
-		return fmt.Errorf("Failed to generate ruleset: %v", err)
+		return err
 	}
 
 	// 5. Snapshot current ruleset for rollback
@@ -174,10 +123,114 @@ func (fm *FirewallManager) ApplyFirewallRules() error {
 	return nil
 }
 
+// RulesetInputs carries everything that is compiled into the nftables ruleset
+type RulesetInputs struct {
+	Zones        []FirewallZone // Effective zones (explicit members + labeled interfaces)
+	Policies     []ZonePolicy
+	Config       Config
+	PortForwards []PortForwardingRule
+	UserRules    []UserFirewallRule // Operator rules, evaluated before zone policies
+	Sets         []FirewallSet      // Named sets referenced as "@name"
+	VPNPolicies  []VPNPolicy        // Rendered as fwmarks and kill switch drops
+	VPNProfiles  []VPNProfile       // Select the mark of each policy
+	IPv6         IPv6Config
+	WANs         []WANInterface // Multi-WAN interfaces with routing tables (connmark stickiness)
+	Steering     []WANSteeringRule
+	PPPoE        []PPPoEConnection            // Session MTUs for inbound MSS clamping
+	Metadata     map[string]InterfaceMetadata // Per-interface MTU and MSS clamping overrides
+	WireGuard    []WGInterface                // Listen ports opened on the input chain
+	OpenVPN      *OpenVPNServerConfig         // Set up OpenVPN server, its port is opened on the input chain
+}
+
+// collectRulesetInputs loads the persisted state the generator depends on
+func collectRulesetInputs() (RulesetInputs, error) {
+	metaStore, err := loadInterfaceMetadata()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load interface metadata: %v\n", err)
+		metaStore = &InterfaceMetadataStore{Metadata: make(map[string]InterfaceMetadata)}
+	}
+
+	configLock.RLock()
+	cfg := config
+	configLock.RUnlock()
+
+	store := GetZoneStore()
+	wireGuard := GetWGInterfaces()
+	openVPN := openVPNServerRulesetInput()
+	zones := addOpenVPNServerZone(addWGInterfaceZones(resolveZones(store, metaStore.Metadata), wireGuard), openVPN)
+
+	// Fallback: Auto-detect WAN when no interface was placed in the WAN zone
+	if len(zoneInterfaces(zones, zoneWAN)) == 0 {
+		defWan, err := getDefaultGatewayInterface()
+		if err == nil && defWan != "" {
+			fmt.Printf("Auto-detected WAN interface: %s\n", defWan)
+			for i := range zones {
+				if zones[i].Name == zoneWAN {
+					zones[i].Interfaces = append(zones[i].Interfaces, defWan)
+				}
+			}
+		}
+	}
+
+	// Self-Check: Validate configuration
+	if len(zoneInterfaces(zones, zoneWAN)) == 0 {
+		return RulesetInputs{}, fmt.Errorf("CRITICAL: No WAN interfaces defined. Refusing to apply firewall rules")
+	}
+
+	if len(zoneInterfaces(zones, zoneLAN)) == 0 {
+		fmt.Println("WARNING: No LAN interfaces labeled. Management access may be limited to localhost only")
+	}
+
+	vpnPolicies, err := loadVPNPolicies()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load VPN policies: %v\n", err)
+	}
+
+	return RulesetInputs{
+		Zones:        zones,
+		Policies:     store.Policies,
+		Config:       cfg,
+		PortForwards: GetPortForwardingRules(),
+		UserRules:    GetUserFirewallRules(),
+		Sets:         GetFirewallSets(),
+		VPNPolicies:  vpnPolicies,
+		VPNProfiles:  GetVPNProfiles(),
+		IPv6:         GetIPv6Config(),
+		WANs:         GetWANStore().Interfaces,
+		Steering:     GetWANSteeringRules(),
+		PPPoE:        GetPPPoEConnections(),
+		Metadata:     metaStore.Metadata,
+		WireGuard:    wireGuard,
+		OpenVPN:      openVPN,
+	}, nil
+}
+
+// buildRuleset collects the current state and renders the full ruleset text
+func (fm *FirewallManager) buildRuleset() (string, error) {
+	in, err := collectRulesetInputs()
+	if err != nil {
+		return "", err
+	}
+
+	ruleset, err := fm.generateFullRuleset(in)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate ruleset: %v", err)
+	}
+	return ruleset, nil
+}
+
 // generateFullRuleset creates a complete nftables configuration as text
-func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []string, cfg Config, pfRules []PortForwardingRule) (string, error) {
+func (fm *FirewallManager) generateFullRuleset(in RulesetInputs) (string, error) {
 	var b strings.Builder
 
+	cfg := in.Config
+	pfRules := in.PortForwards
+	wanInterfaces := zoneInterfaces(in.Zones, zoneWAN)
+
+	// WebUI is reachable from the trusted zones
+	lanInterfaces := append([]string(nil), zoneInterfaces(in.Zones, zoneLAN)...)
+	lanInterfaces = append(lanInterfaces, zoneInterfaces(in.Zones, "management")...)
+
 	// Control plane protection will be injected later
 
 	// Flush all existing rules
@@ -186,6 +239,16 @@ func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []st
 	// ===== INET FILTER TABLE =====
 	b.WriteString("table inet softrouter {\n")
 
+	// Named sets and per-zone chains are declared before anything references them
+	writeNamedSets(&b, in.Sets)
+	inputDispatch, forwardDispatch := writeZoneChains(&b, in.Zones, in.Policies)
+	writeUserRuleChains(&b, in.UserRules, in.Zones, in.Sets)
+	writeWANMarkChains(&b, in.WANs)
+	writeWANSteering(&b, in.Steering)
+	writeVPNPolicyMarks(&b, in.VPNPolicies, in.VPNProfiles)
+	writeVPNKillSwitch(&b, in.VPNPolicies, in.VPNProfiles)
+	writeMSSClampChain(&b, in)
+
 	// INPUT Chain - DEFAULT DROP
 	b.WriteString("  chain input {\n")
 	b.WriteString("    type filter hook input priority filter; policy drop;\n\n")
@@ -201,7 +264,16 @@ func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []st
 
 	// Accept ICMP
 	b.WriteString("    ip protocol icmp accept\n")
-	b.WriteString("    ip6 nexthdr icmpv6 accept\n")
+
+	// ICMPv6 to the router per RFC 4890 section 4.4
+	b.WriteString(fmt.Sprintf("    icmpv6 type { %s } accept comment \"ICMPv6 errors\"\n", icmpv6TransitErrors))
+	b.WriteString("    icmpv6 type echo-request limit rate 10/second burst 20 packets accept comment \"ICMPv6 echo\"\n")
+	b.WriteString("    icmpv6 type echo-reply accept\n")
+	b.WriteString("    icmpv6 type { nd-router-solicit, nd-router-advert, nd-neighbor-solicit, nd-neighbor-advert, ind-neighbor-solicit, ind-neighbor-advert } ip6 hoplimit 255 accept comment \"ICMPv6 neighbor discovery\"\n")
+	b.WriteString("    icmpv6 type { mld-listener-query, mld-listener-report, mld-listener-done, mld2-listener-report } ip6 saddr fe80::/10 accept " + controlPlaneMarker + "\n")
+
+	// DHCPv6 client replies on WAN (prefix delegation / address assignment)
+	b.WriteString("    ip6 saddr fe80::/10 udp sport 547 udp dport 546 accept comment \"DHCPv6 client\"\n")
 
 	// Accept SSH (port 22) - prevent lockout
 	b.WriteString("    tcp dport 22 accept comment \"SSH access\"\n")
@@ -210,16 +282,29 @@ func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []st
 	b.WriteString("    udp dport 53 accept comment \"DNS\"\n")
 	b.WriteString("    tcp dport 53 accept comment \"DNS\"\n")
 
-	// Accept all from LAN interfaces
-	for _, lan := range lanInterfaces {
-		b.WriteString(fmt.Sprintf("    iifname \"%s\" accept comment \"LAN trust\"\n", lan))
+	// WireGuard handshakes (road warriors and site-to-site peers dial in)
+	for _, wg := range in.WireGuard {
+		if wg.Enabled && wg.ListenPort > 0 && wg.Role != wgRoleProvider {
+			b.WriteString(fmt.Sprintf("    udp dport %d accept comment \"WireGuard %s\"\n", wg.ListenPort, wg.Name))
+		}
 	}
+	if in.OpenVPN != nil {
+		b.WriteString(fmt.Sprintf("    %s dport %d accept comment \"OpenVPN server\"\n", in.OpenVPN.Protocol, in.OpenVPN.Port))
+	}
+
+	// User-defined rules take precedence over zone input policies
+	b.WriteString("    jump user_input\n")
 
 	// Accept DNAT'd connections from WAN (for WebUI access)
 	for _, wan := range wanInterfaces {
 		b.WriteString(fmt.Sprintf("    iifname \"%s\" ct status dnat accept comment \"WAN DNAT\"\n", wan))
 	}
 
+	// Zone input policies (LAN trust, Guest/IoT restricted to DNS/DHCP, ...)
+	for _, line := range inputDispatch {
+		b.WriteString(line)
+	}
+
 	// Log dropped packets (rate-limited for debugging)
 	b.WriteString("    limit rate 5/minute burst 10 packets log prefix \"[INPUT DROP] \"\n")
 
@@ -231,21 +316,57 @@ func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []st
 	b.WriteString("  chain forward {\n")
 	b.WriteString("    type filter hook forward priority filter; policy drop;\n\n")
 
+	// MSS clamping must see the SYN-ACK too, so it precedes the established accept
+	b.WriteString("    tcp flags syn jump mss_clamp\n")
+
 	// Accept established/related
 	b.WriteString("    ct state established,related accept\n")
 
-	// Allow LAN -> WAN
-	for _, lan := range lanInterfaces {
-		for _, wan := range wanInterfaces {
-			b.WriteString(fmt.Sprintf("    iifname \"%s\" oifname \"%s\" accept comment \"LAN to WAN\"\n", lan, wan))
+	// ICMPv6 that must not be dropped in transit (RFC 4890 section 4.3.1)
+	if in.IPv6.Forwarding {
+		b.WriteString(fmt.Sprintf("    icmpv6 type { %s } accept comment \"ICMPv6 errors (RFC 4890)\"\n", icmpv6TransitErrors))
+		b.WriteString("    icmpv6 type { echo-request, echo-reply } limit rate 100/second accept comment \"ICMPv6 echo (RFC 4890)\"\n")
+	} else {
+		b.WriteString("    meta nfproto ipv6 drop comment \"IPv6 forwarding disabled\"\n")
+	}
+
+	// User-defined rules can restrict forwarded and inter-zone traffic
+	b.WriteString("    jump user_forward\n")
+
+	// Port forwards restricted to a source address set drop everyone else
+	for _, pf := range pfRules {
+		if !pf.Enabled || pf.SourceSet == "" || len(wanInterfaces) == 0 || isIPv6Address(pf.InternalIP) {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("    iifname %s ct status dnat ip daddr %s %s dport %d ip saddr != @%s drop comment \"Port forward %d: source restricted\"\n",
+			nftIfaceSet(wanInterfaces), pf.InternalIP, pf.nftProtocol(), pf.InternalPort, pf.SourceSet, pf.ExternalPort))
+	}
+
+	// IPv6 pinholes: port forwards to global IPv6 addresses are routed, not NATed
+	if in.IPv6.Forwarding && len(wanInterfaces) > 0 {
+		for _, pf := range pfRules {
+			if !pf.Enabled || !isIPv6Address(pf.InternalIP) {
+				continue
+			}
+			match := ""
+			if pf.SourceSet != "" {
+				match = fmt.Sprintf(" ip6 saddr @%s", pf.SourceSet)
+			}
+			b.WriteString(fmt.Sprintf("    iifname %s%s ip6 daddr %s %s dport %d accept comment \"IPv6 pinhole: %s\"\n",
+				nftIfaceSet(wanInterfaces), match, pf.InternalIP, pf.nftProtocol(), pf.InternalPort, pf.Description))
 		}
 	}
 
-	// Allow port forwarding (WAN -> LAN via DNAT) - INTERFACE SCOPED
+	// Allow port forwarding (WAN -> LAN/DMZ via DNAT) - INTERFACE SCOPED
 	for _, wan := range wanInterfaces {
 		b.WriteString(fmt.Sprintf("    iifname \"%s\" ct status dnat accept comment \"Port forwarding\"\n", wan))
 	}
 
+	// Inter-zone policies (LAN -> WAN, Guest -> WAN but not LAN, ...)
+	for _, line := range forwardDispatch {
+		b.WriteString(line)
+	}
+
 	// Log dropped packets (rate-limited for debugging)
 	b.WriteString("    limit rate 5/minute burst 10 packets log prefix \"[FORWARD DROP] \"\n")
 
@@ -253,9 +374,7 @@ func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []st
 	b.WriteString("}\n\n")
 
 	// ===== IP NAT TABLE =====
-	// Note: IPv6 NAT is intentionally not implemented as it's typically not needed
-	// for IPv6 deployments which use direct routing. If IPv6 NAT is required in
-	// the future, a separate 'table ip6 nat' section would be added here.
+	// IPv6 is routed by default; NAT66/NPTv6 is rendered in 'table ip6 nat' below when enabled
 	b.WriteString("table ip nat {\n")
 
 	// PREROUTING Chain
@@ -275,13 +394,10 @@ func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []st
 
 	// Port Forwarding Rules
 	for _, rule := range pfRules {
-		if !rule.Enabled {
+		if !rule.Enabled || isIPv6Address(rule.InternalIP) {
 			continue
 		}
-		proto := rule.Protocol
-		if proto == "" {
-			proto = "tcp"
-		}
+		proto := rule.nftProtocol()
 		dnatTarget := fmt.Sprintf("%s:%d", rule.InternalIP, rule.InternalPort)
 
 		for _, wan := range wanInterfaces {
@@ -320,9 +436,14 @@ func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []st
 	b.WriteString("  chain postrouting {\n")
 	b.WriteString("    type nat hook postrouting priority srcnat; policy accept;\n\n")
 
-	// Masquerade LAN -> WAN
-	for _, wan := range wanInterfaces {
-		b.WriteString(fmt.Sprintf("    oifname \"%s\" masquerade comment \"NAT\"\n", wan))
+	// Masquerade traffic leaving through NAT zones (WAN by default)
+	for _, z := range in.Zones {
+		if !z.Masquerade {
+			continue
+		}
+		for _, iface := range z.Interfaces {
+			b.WriteString(fmt.Sprintf("    oifname \"%s\" masquerade comment \"NAT\"\n", iface))
+		}
 	}
 
 	// Hairpin NAT
@@ -341,6 +462,9 @@ func (fm *FirewallManager) generateFullRuleset(wanInterfaces, lanInterfaces []st
 	b.WriteString("  }\n")
 	b.WriteString("}\n")
 
+	// ===== IP6 NAT TABLE (optional NAT66 / NPTv6) =====
+	writeIPv6NATTable(&b, in.IPv6, wanInterfaces)
+
 	// Inject control plane protection into the ruleset
 	ruleset := b.String()
 	ruleset = injectControlPlaneProtectionV2(ruleset)
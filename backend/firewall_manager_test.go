@@ -0,0 +1,186 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testRulesetInputs() RulesetInputs {
+	store := defaultZoneStore()
+	meta := map[string]InterfaceMetadata{
+		"eth0":    {InterfaceName: "eth0", Label: "WAN"},
+		"eth1":    {InterfaceName: "eth1", Label: "LAN"},
+		"eth1.20": {InterfaceName: "eth1.20", Label: "Guest"},
+		"eth2":    {InterfaceName: "eth2", Label: "DMZ"},
+	}
+
+	return RulesetInputs{
+		Zones:    resolveZones(store, meta),
+		Policies: store.Policies,
+		Config:   Config{ProtectedSubnet: "10.0.0.0/24"},
+		IPv6:     IPv6Config{Forwarding: true, NATMode: "none"},
+		PortForwards: []PortForwardingRule{
+			{ID: "1", Description: "Web", Protocol: "tcp", ExternalPort: 443, InternalIP: "10.0.2.10", InternalPort: 443, Enabled: true},
+		},
+	}
+}
+
+func TestResolveZonesFromLabels(t *testing.T) {
+	in := testRulesetInputs()
+
+	if got := zoneInterfaces(in.Zones, "guest"); len(got) != 1 || got[0] != "eth1.20" {
+		t.Errorf("Expected guest zone to contain eth1.20, got %v", got)
+	}
+	if got := zoneInterfaces(in.Zones, "dmz"); len(got) != 1 || got[0] != "eth2" {
+		t.Errorf("Expected dmz zone to contain eth2, got %v", got)
+	}
+}
+
+func TestResolveZonesExplicitMembershipWins(t *testing.T) {
+	store := defaultZoneStore()
+	for i := range store.Zones {
+		if store.Zones[i].Name == "iot" {
+			store.Zones[i].Interfaces = []string{"eth3"}
+		}
+	}
+
+	// eth3 is labeled LAN but explicitly placed in the IoT zone
+	zones := resolveZones(store, map[string]InterfaceMetadata{"eth3": {Label: "LAN"}})
+
+	if got := zoneInterfaces(zones, zoneLAN); len(got) != 0 {
+		t.Errorf("Expected LAN zone to be empty, got %v", got)
+	}
+	if got := zoneInterfaces(zones, "iot"); len(got) != 1 || got[0] != "eth3" {
+		t.Errorf("Expected iot zone to contain eth3, got %v", got)
+	}
+}
+
+func TestGenerateFullRulesetZones(t *testing.T) {
+	ruleset, err := firewallManager.generateFullRuleset(testRulesetInputs())
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	expectedSubstrings := []string{
+		"chain forward_guest {",
+		"oifname \"eth0\" counter accept comment \"guest->wan\"",
+		"oifname \"eth1\" counter reject with icmpx type admin-prohibited comment \"guest->lan\"",
+		"iifname \"eth1.20\" jump forward_guest",
+		"iifname \"eth1.20\" jump input_guest",
+		"udp dport 53 accept comment \"guest: DNS\"",
+		"chain forward_lan {",
+		"oifname \"eth2\" counter accept comment \"lan->dmz\"",
+		"iifname \"eth0\" ct status dnat accept comment \"Port forwarding\"",
+		"oifname \"eth0\" masquerade comment \"NAT\"",
+		"iifname \"eth0\" tcp dport 443 dnat to 10.0.2.10:443",
+	}
+
+	for _, s := range expectedSubstrings {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain '%s', but it didn't.\nGenerated Ruleset:\n%s", s, ruleset)
+		}
+	}
+
+	// Zone chains must be declared before the base chains jump to them
+	if strings.Index(ruleset, "chain forward_guest {") > strings.Index(ruleset, "chain forward {") {
+		t.Error("Zone chains should be declared before the forward chain")
+	}
+
+	// Guest must not be masqueraded or trusted on input
+	if strings.Contains(ruleset, "oifname \"eth1.20\" masquerade") {
+		t.Error("Did not expect masquerade on the guest zone")
+	}
+}
+
+func TestValidateZoneStore(t *testing.T) {
+	if err := validateZoneStore(defaultZoneStore()); err != nil {
+		t.Errorf("Default zone store should be valid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*ZoneStore)
+	}{
+		{"bad zone name", func(s *ZoneStore) { s.Zones[1].Name = "LAN; drop" }},
+		{"unknown policy zone", func(s *ZoneStore) { s.Policies[0].To = "nowhere" }},
+		{"bad action", func(s *ZoneStore) { s.Policies[0].Action = "masquerade" }},
+		{"bad ports", func(s *ZoneStore) { s.Zones[3].Services[0].Ports = "53; flush ruleset" }},
+		{"interface in two zones", func(s *ZoneStore) {
+			s.Zones[0].Interfaces = []string{"eth0"}
+			s.Zones[1].Interfaces = []string{"eth0"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := defaultZoneStore()
+			tt.mutate(&store)
+			if err := validateZoneStore(store); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestGenerateFullRulesetDefaultProtocol(t *testing.T) {
+	in := testRulesetInputs()
+	in.Sets = append(in.Sets, FirewallSet{Name: "office", Type: "ipv4_addr", Elements: []string{"198.51.100.0/24"}})
+	in.PortForwards = append(in.PortForwards,
+		PortForwardingRule{ID: "2", Description: "SSH", ExternalPort: 2222, InternalIP: "192.168.1.20", InternalPort: 22, SourceSet: "office", Enabled: true},
+		PortForwardingRule{ID: "3", Description: "NAS", ExternalPort: 8443, InternalIP: "2001:db8:1::10", InternalPort: 8443, Enabled: true},
+	)
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	// Rules saved without a protocol are forwarded as tcp everywhere
+	expectedSubstrings := []string{
+		"ip daddr 192.168.1.20 tcp dport 22 ip saddr != @office drop",
+		"ip6 daddr 2001:db8:1::10 tcp dport 8443 accept comment \"IPv6 pinhole: NAS\"",
+		"tcp dport 2222 dnat to 192.168.1.20:22",
+	}
+	for _, s := range expectedSubstrings {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain '%s'.\nGenerated Ruleset:\n%s", s, ruleset)
+		}
+	}
+}
+
+func TestGenerateFullRulesetIPv6(t *testing.T) {
+	in := testRulesetInputs()
+	in.PortForwards = append(in.PortForwards, PortForwardingRule{
+		ID: "2", Description: "NAS", Protocol: "tcp", ExternalPort: 8443, InternalIP: "2001:db8:1::10", InternalPort: 8443, Enabled: true,
+	})
+	in.IPv6.NATMode = "nptv6"
+	in.IPv6.NPTv6 = []NPTv6Mapping{{Internal: "fd00:1::/64", External: "2001:db8:1::/64"}}
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	expectedSubstrings := []string{
+		"icmpv6 type { destination-unreachable, packet-too-big, time-exceeded, parameter-problem } accept comment \"ICMPv6 errors (RFC 4890)\"",
+		"ip6 hoplimit 255 accept comment \"ICMPv6 neighbor discovery\"",
+		"iifname \"eth0\" ip6 daddr 2001:db8:1::10 tcp dport 8443 accept comment \"IPv6 pinhole: NAS\"",
+		"table ip6 nat {",
+		"oifname \"eth0\" ip6 saddr fd00:1::/64 snat ip6 prefix to 2001:db8:1::/64",
+		"iifname \"eth0\" ip6 daddr 2001:db8:1::/64 dnat ip6 prefix to fd00:1::/64",
+		"# === CONTROL PLANE PROTECTION ===",
+	}
+	for _, s := range expectedSubstrings {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain '%s'.\nGenerated Ruleset:\n%s", s, ruleset)
+		}
+	}
+
+	// IPv6 targets must never end up in the IPv4 DNAT table
+	if strings.Contains(ruleset, "dnat to 2001:db8:1::10") {
+		t.Error("IPv6 port forward must be a pinhole, not an IPv4 DNAT")
+	}
+	if strings.Contains(ruleset, "ip6 nexthdr icmpv6 accept") {
+		t.Error("Expected ICMPv6 to be filtered by type instead of blanket accept")
+	}
+}
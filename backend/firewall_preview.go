@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// FirewallPreview describes what ApplyFirewallRules would do, without applying it
+type FirewallPreview struct {
+	Ruleset          string `json:"ruleset"`           // Generated ruleset text
+	Valid            bool   `json:"valid"`             // Passed `nft -c -f`
+	ValidationOutput string `json:"validation_output"` // nft output when validation failed
+	DiffLive         string `json:"diff_live"`         // Unified diff of the nft listings of live and generated
+	LiveError        string `json:"live_error,omitempty"`
+	DiffKnownGood    string `json:"diff_known_good"` // Unified diff against firewall.good.nft
+	KnownGoodError   string `json:"known_good_error,omitempty"`
+	Changed          bool   `json:"changed"` // Generated ruleset differs from the live one
+}
+
+// previewNetns is a scratch network namespace the generated ruleset is loaded
+// into, so nft prints it the same way it prints the live one
+const previewNetns = "softrouter-preview"
+
+// canonicalRuleset loads a ruleset file into the scratch namespace and lists
+// it back. nft rewrites what it loads (reject and icmp syntax, set ordering,
+// limit bursts), so only listings can be compared with the live ruleset.
+func canonicalRuleset(path string) (string, error) {
+	runPrivileged("ip", "netns", "delete", previewNetns) // Left over from an interrupted preview
+	if err := runPrivileged("ip", "netns", "add", previewNetns); err != nil {
+		return "", err
+	}
+	defer runPrivileged("ip", "netns", "delete", previewNetns)
+
+	if output, err := runPrivilegedCombinedOutput("ip", "netns", "exec", previewNetns, "nft", "-f", path); err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	output, err := runPrivilegedOutput("ip", "netns", "exec", previewNetns, "nft", "-s", "list", "ruleset")
+	return string(output), err
+}
+
+// PreviewFirewallRules generates the ruleset, validates it and diffs it against
+// the live ruleset and the last known-good snapshot
+func (fm *FirewallManager) PreviewFirewallRules() (*FirewallPreview, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	ruleset, err := fm.buildRuleset()
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &FirewallPreview{Ruleset: ruleset}
+
+	// Validate syntax (dry-run) exactly like ApplyFirewallRules does
+	tmpfile, err := os.CreateTemp("", "softrouter-preview-*.nft")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(ruleset); err != nil {
+		tmpfile.Close()
+		return nil, fmt.Errorf("Failed to write ruleset: %v", err)
+	}
+	tmpfile.Close()
+
+	if output, err := runPrivilegedCombinedOutput("nft", "-c", "-f", tmpfile.Name()); err != nil {
+		preview.ValidationOutput = strings.TrimSpace(fmt.Sprintf("%v\n%s", err, string(output)))
+	} else {
+		preview.Valid = true
+	}
+
+	generated := normalizeRulesetLines(ruleset)
+
+	// Live ruleset, compared with the generated one as nft prints it
+	if !preview.Valid {
+		preview.LiveError = "generated ruleset is invalid"
+	} else if listed, err := canonicalRuleset(tmpfile.Name()); err != nil {
+		preview.LiveError = fmt.Sprintf("Failed to list generated ruleset: %v", err)
+	} else if live, err := runPrivilegedOutput("nft", "-s", "list", "ruleset"); err != nil {
+		preview.LiveError = fmt.Sprintf("Failed to read live ruleset: %v", err)
+	} else {
+		preview.DiffLive = unifiedDiff("live", "generated", normalizeRulesetLines(string(live)), normalizeRulesetLines(listed))
+		preview.Changed = preview.DiffLive != ""
+	}
+
+	// Known-good snapshot (written by the last successful apply)
+	if good, err := os.ReadFile(knownGoodSnapshotPath); err != nil {
+		if os.IsNotExist(err) {
+			preview.KnownGoodError = "no known-good snapshot exists yet"
+		} else {
+			preview.KnownGoodError = fmt.Sprintf("Failed to read known-good snapshot: %v", err)
+		}
+	} else {
+		preview.DiffKnownGood = unifiedDiff(knownGoodSnapshotPath, "generated", normalizeRulesetLines(string(good)), generated)
+	}
+
+	return preview, nil
+}
+
+// previewFirewallRules handles POST /api/firewall/preview
+func previewFirewallRules(w http.ResponseWriter, r *http.Request) {
+	preview, err := firewallManager.PreviewFirewallRules()
+	if err != nil {
+		respondFirewallError(w, ErrFirewallInvalidRule, "Failed to generate firewall ruleset", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, preview)
+}
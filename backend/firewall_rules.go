@@ -0,0 +1,505 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// UserFirewallRule is an operator-defined rule that is compiled into every
+// regenerated ruleset, so it survives ApplyFirewallRules (unlike live `nft add rule`)
+type UserFirewallRule struct {
+	ID          string `json:"id"`
+	Chain       string `json:"chain"`        // "input" (to the router) or "forward" (through it)
+	SourceZone  string `json:"source_zone"`  // Optional zone name, e.g. "guest"
+	DestZone    string `json:"dest_zone"`    // Optional zone name (forward only)
+	SourceCIDR  string `json:"source_cidr"`  // Optional IP/CIDR (IPv4 or IPv6) or "@set"
+	DestCIDR    string `json:"dest_cidr"`    // Optional IP/CIDR (IPv4 or IPv6) or "@set"
+	Protocol    string `json:"protocol"`     // tcp, udp, tcp_udp, icmp, icmpv6, any
+	SourcePorts string `json:"source_ports"` // e.g., "1024-65535" or "@set"
+	DestPorts   string `json:"dest_ports"`   // e.g., "80,443" or "@set"
+	Action      string `json:"action"`       // accept, drop, reject
+	Log         bool   `json:"log"`
+	Counter     bool   `json:"counter"`
+	Comment     string `json:"comment"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// UserFirewallRuleStore keeps rules in evaluation order
+type UserFirewallRuleStore struct {
+	Rules []UserFirewallRule `json:"rules"`
+}
+
+var (
+	fwRuleStore      UserFirewallRuleStore
+	fwRuleStoreLock  sync.RWMutex
+	fwRuleConfigPath = "/etc/softrouter/firewall_rules.json"
+)
+
+func initUserFirewallRules() {
+	loadUserFirewallRules()
+}
+
+func loadUserFirewallRules() {
+	fwRuleStoreLock.Lock()
+	defer fwRuleStoreLock.Unlock()
+
+	data, err := os.ReadFile(fwRuleConfigPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fwRuleStore.Rules = []UserFirewallRule{}
+			return
+		}
+		fmt.Printf("Error loading firewall rules: %v\n", err)
+		return
+	}
+
+	if err := json.Unmarshal(data, &fwRuleStore); err != nil {
+		fmt.Printf("Error parsing firewall rules: %v\n", err)
+		fwRuleStore.Rules = []UserFirewallRule{}
+	}
+}
+
+func saveUserFirewallRules() error {
+	fwRuleStoreLock.RLock()
+	data, err := json.MarshalIndent(fwRuleStore, "", "  ")
+	fwRuleStoreLock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fwRuleConfigPath, data, 0644)
+}
+
+// GetUserFirewallRules returns a copy of the ordered rule list
+func GetUserFirewallRules() []UserFirewallRule {
+	fwRuleStoreLock.RLock()
+	defer fwRuleStoreLock.RUnlock()
+	rules := make([]UserFirewallRule, len(fwRuleStore.Rules))
+	copy(rules, fwRuleStore.Rules)
+	return rules
+}
+
+// validatePortSpec accepts "80", "80,443" and "8000-8100" style port lists
+func validatePortSpec(ports string) error {
+	if !portSpecRegex.MatchString(ports) {
+		return fmt.Errorf("invalid port list '%s'", ports)
+	}
+	for _, part := range strings.Split(ports, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		for _, p := range bounds {
+			n, _ := strconv.Atoi(p)
+			if n < 1 || n > 65535 {
+				return fmt.Errorf("port %s out of range", p)
+			}
+		}
+		if len(bounds) == 2 {
+			lo, _ := strconv.Atoi(bounds[0])
+			hi, _ := strconv.Atoi(bounds[1])
+			if lo > hi {
+				return fmt.Errorf("invalid port range '%s'", part)
+			}
+		}
+	}
+	return nil
+}
+
+// cidrFamily returns "ip" or "ip6" for an address or prefix, "" if invalid
+func cidrFamily(cidr string) string {
+	ip := net.ParseIP(cidr)
+	if ip == nil {
+		parsed, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return ""
+		}
+		ip = parsed
+	}
+	if ip.To4() != nil {
+		return "ip"
+	}
+	return "ip6"
+}
+
+// addrMatchFamily returns "ip" or "ip6" for an address, prefix or address set reference
+func addrMatchFamily(value string, sets []FirewallSet) string {
+	if isSetRef(value) {
+		return setAddrFamily(sets, value)
+	}
+	return cidrFamily(value)
+}
+
+// validateRuleAddr validates an address field, which may reference an address set
+func validateRuleAddr(value, what string, sets []FirewallSet) (string, error) {
+	if isSetRef(value) {
+		if err := validateSetReference(sets, value, setTypeIPv4, setTypeIPv6); err != nil {
+			return "", err
+		}
+		return setAddrFamily(sets, value), nil
+	}
+	family := cidrFamily(value)
+	if family == "" {
+		return "", fmt.Errorf("invalid %s address '%s'", what, value)
+	}
+	return family, nil
+}
+
+// validateUserFirewallRules checks a stored rule list, as found in a backup.
+// IDs end up in log prefixes and comments, so they must be UUIDs.
+func validateUserFirewallRules(rules []UserFirewallRule, zones ZoneStore, sets []FirewallSet) error {
+	for i, rule := range rules {
+		if _, err := uuid.Parse(rule.ID); err != nil {
+			return fmt.Errorf("rule %d: invalid id '%s'", i+1, rule.ID)
+		}
+		if err := validateUserFirewallRule(rule, zones, sets); err != nil {
+			return fmt.Errorf("rule %s: %v", rule.ID, err)
+		}
+	}
+	return nil
+}
+
+// validateUserFirewallRule checks a rule against the known zones and sets
+func validateUserFirewallRule(rule UserFirewallRule, zones ZoneStore, sets []FirewallSet) error {
+	if rule.Chain != "input" && rule.Chain != "forward" {
+		return fmt.Errorf("chain must be 'input' or 'forward'")
+	}
+	if !isValidZoneAction(rule.Action) {
+		return fmt.Errorf("action must be accept, drop or reject")
+	}
+
+	known := make(map[string]bool)
+	for _, z := range zones.Zones {
+		known[z.Name] = true
+	}
+	if rule.SourceZone != "" && !known[rule.SourceZone] {
+		return fmt.Errorf("unknown source zone '%s'", rule.SourceZone)
+	}
+	if rule.DestZone != "" {
+		if rule.Chain == "input" {
+			return fmt.Errorf("destination zone is only valid for forward rules")
+		}
+		if !known[rule.DestZone] {
+			return fmt.Errorf("unknown destination zone '%s'", rule.DestZone)
+		}
+	}
+
+	var err error
+	srcFamily, dstFamily := "", ""
+	if rule.SourceCIDR != "" {
+		if srcFamily, err = validateRuleAddr(rule.SourceCIDR, "source", sets); err != nil {
+			return err
+		}
+	}
+	if rule.DestCIDR != "" {
+		if dstFamily, err = validateRuleAddr(rule.DestCIDR, "destination", sets); err != nil {
+			return err
+		}
+	}
+	if srcFamily != "" && dstFamily != "" && srcFamily != dstFamily {
+		return fmt.Errorf("source and destination address families differ")
+	}
+
+	switch rule.Protocol {
+	case "tcp", "udp", "tcp_udp":
+		for _, ports := range []string{rule.SourcePorts, rule.DestPorts} {
+			if ports == "" {
+				continue
+			}
+			if isSetRef(ports) {
+				if err := validateSetReference(sets, ports, setTypeService); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := validatePortSpec(ports); err != nil {
+				return err
+			}
+		}
+	case "icmp", "icmpv6", "any", "":
+		if rule.SourcePorts != "" || rule.DestPorts != "" {
+			return fmt.Errorf("ports require protocol tcp, udp or tcp_udp")
+		}
+	default:
+		return fmt.Errorf("unsupported protocol '%s'", rule.Protocol)
+	}
+
+	if strings.ContainsAny(rule.Comment, "\"\\\n\r") || len(rule.Comment) > 100 {
+		return fmt.Errorf("comment must be under 100 characters without quotes or backslashes")
+	}
+
+	return nil
+}
+
+// renderUserFirewallRule compiles a rule into a single nft statement.
+// Returns "" if the rule references a zone that currently has no interfaces.
+func renderUserFirewallRule(rule UserFirewallRule, zones []FirewallZone, sets []FirewallSet) string {
+	var parts []string
+
+	if rule.SourceZone != "" {
+		ifaces := zoneInterfaces(zones, rule.SourceZone)
+		if len(ifaces) == 0 {
+			return ""
+		}
+		parts = append(parts, "iifname", nftIfaceSet(ifaces))
+	}
+	if rule.DestZone != "" {
+		ifaces := zoneInterfaces(zones, rule.DestZone)
+		if len(ifaces) == 0 {
+			return ""
+		}
+		parts = append(parts, "oifname", nftIfaceSet(ifaces))
+	}
+	if rule.SourceCIDR != "" {
+		parts = append(parts, addrMatchFamily(rule.SourceCIDR, sets), "saddr", rule.SourceCIDR)
+	}
+	if rule.DestCIDR != "" {
+		parts = append(parts, addrMatchFamily(rule.DestCIDR, sets), "daddr", rule.DestCIDR)
+	}
+
+	switch rule.Protocol {
+	case "tcp", "udp":
+		if rule.SourcePorts == "" && rule.DestPorts == "" {
+			parts = append(parts, "meta", "l4proto", rule.Protocol)
+		}
+		if rule.SourcePorts != "" {
+			parts = append(parts, rule.Protocol, "sport", nftPortSet(rule.SourcePorts))
+		}
+		if rule.DestPorts != "" {
+			parts = append(parts, rule.Protocol, "dport", nftPortSet(rule.DestPorts))
+		}
+	case "tcp_udp":
+		parts = append(parts, "meta", "l4proto", "{ tcp, udp }")
+		if rule.SourcePorts != "" {
+			parts = append(parts, "th", "sport", nftPortSet(rule.SourcePorts))
+		}
+		if rule.DestPorts != "" {
+			parts = append(parts, "th", "dport", nftPortSet(rule.DestPorts))
+		}
+	case "icmp":
+		parts = append(parts, "meta", "l4proto", "icmp")
+	case "icmpv6":
+		parts = append(parts, "meta", "l4proto", "ipv6-icmp")
+	}
+
+	if rule.Counter {
+		parts = append(parts, "counter")
+	}
+	if rule.Log {
+		parts = append(parts, "log", "prefix", fmt.Sprintf("\"[FW %s] \"", shortRuleID(rule.ID)))
+	}
+	parts = append(parts, nftAction(rule.Action))
+
+	comment := rule.Comment
+	if comment == "" {
+		comment = "user rule " + shortRuleID(rule.ID)
+	}
+	parts = append(parts, "comment", fmt.Sprintf("\"%s\"", comment))
+
+	return strings.Join(parts, " ")
+}
+
+func shortRuleID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// writeUserRuleChains renders the user_input and user_forward chains.
+// The chains are always declared so the base chains can jump to them unconditionally.
+func writeUserRuleChains(b *strings.Builder, rules []UserFirewallRule, zones []FirewallZone, sets []FirewallSet) {
+	for _, chain := range []string{"input", "forward"} {
+		b.WriteString(fmt.Sprintf("  chain user_%s {\n", chain))
+		for _, rule := range rules {
+			if !rule.Enabled || rule.Chain != chain {
+				continue
+			}
+			if stmt := renderUserFirewallRule(rule, zones, sets); stmt != "" {
+				b.WriteString("    " + stmt + "\n")
+			}
+		}
+		b.WriteString("  }\n\n")
+	}
+}
+
+// --- Handlers ---
+
+func listUserFirewallRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, GetUserFirewallRules())
+}
+
+// applyUserFirewallRuleChange persists the store, regenerates the ruleset and audits the change
+func applyUserFirewallRuleChange(w http.ResponseWriter, r *http.Request, action, resource, details string) bool {
+	if err := saveUserFirewallRules(); err != nil {
+		logAuditEvent(getUsernameFromToken(r), action, resource,
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save firewall rules", err)
+		return false
+	}
+
+	if err := firewallManager.ApplyFirewallRules(); err != nil {
+		logAuditEvent(getUsernameFromToken(r), action, resource,
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondFirewallError(w, ErrFirewallAddFailed, "Rule saved but the firewall could not be applied", err)
+		return false
+	}
+
+	logAuditEvent(getUsernameFromToken(r), action, resource, details, getClientIP(r), true)
+	return true
+}
+
+func createUserFirewallRule(w http.ResponseWriter, r *http.Request) {
+	var rule UserFirewallRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	if err := validateUserFirewallRule(rule, GetZoneStore(), GetFirewallSets()); err != nil {
+		respondWithError(w, ErrFirewallInvalidRule, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	rule.ID = uuid.New().String()
+
+	fwRuleStoreLock.Lock()
+	fwRuleStore.Rules = append(fwRuleStore.Rules, rule)
+	fwRuleStoreLock.Unlock()
+
+	ruleJSON, _ := json.Marshal(rule)
+	if !applyUserFirewallRuleChange(w, r, "firewall.rule.create", rule.ID, string(ruleJSON)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, rule)
+}
+
+func updateUserFirewallRule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		respondInvalidRequest(w, "ID required")
+		return
+	}
+
+	var rule UserFirewallRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	if err := validateUserFirewallRule(rule, GetZoneStore(), GetFirewallSets()); err != nil {
+		respondWithError(w, ErrFirewallInvalidRule, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	rule.ID = id
+
+	fwRuleStoreLock.Lock()
+	found := false
+	for i := range fwRuleStore.Rules {
+		if fwRuleStore.Rules[i].ID == id {
+			fwRuleStore.Rules[i] = rule
+			found = true
+			break
+		}
+	}
+	fwRuleStoreLock.Unlock()
+
+	if !found {
+		respondWithError(w, ErrGenericNotFound, "Rule not found", http.StatusNotFound, nil)
+		return
+	}
+
+	ruleJSON, _ := json.Marshal(rule)
+	if !applyUserFirewallRuleChange(w, r, "firewall.rule.update", id, string(ruleJSON)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, rule)
+}
+
+func deleteUserFirewallRule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		respondInvalidRequest(w, "ID required")
+		return
+	}
+
+	fwRuleStoreLock.Lock()
+	newRules := []UserFirewallRule{}
+	found := false
+	for _, rule := range fwRuleStore.Rules {
+		if rule.ID == id {
+			found = true
+			continue
+		}
+		newRules = append(newRules, rule)
+	}
+	fwRuleStore.Rules = newRules
+	fwRuleStoreLock.Unlock()
+
+	if !found {
+		respondWithError(w, ErrGenericNotFound, "Rule not found", http.StatusNotFound, nil)
+		return
+	}
+
+	if !applyUserFirewallRuleChange(w, r, "firewall.rule.delete", id, fmt.Sprintf("{\"id\":\"%s\"}", id)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
+
+// reorderUserFirewallRules takes the complete list of rule IDs in the new evaluation order
+func reorderUserFirewallRules(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	fwRuleStoreLock.Lock()
+	byID := make(map[string]UserFirewallRule, len(fwRuleStore.Rules))
+	for _, rule := range fwRuleStore.Rules {
+		byID[rule.ID] = rule
+	}
+
+	if len(req.IDs) != len(byID) {
+		fwRuleStoreLock.Unlock()
+		respondInvalidRequest(w, "Reorder must list every rule exactly once")
+		return
+	}
+
+	reordered := make([]UserFirewallRule, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		rule, ok := byID[id]
+		if !ok {
+			fwRuleStoreLock.Unlock()
+			respondInvalidRequest(w, "Reorder must list every rule exactly once")
+			return
+		}
+		delete(byID, id)
+		reordered = append(reordered, rule)
+	}
+	fwRuleStore.Rules = reordered
+	fwRuleStoreLock.Unlock()
+
+	idsJSON, _ := json.Marshal(req.IDs)
+	if !applyUserFirewallRuleChange(w, r, "firewall.rule.reorder", "rules", string(idsJSON)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, GetUserFirewallRules())
+}
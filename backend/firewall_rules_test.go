@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderUserFirewallRule(t *testing.T) {
+	zones := testRulesetInputs().Zones
+
+	tests := []struct {
+		name     string
+		rule     UserFirewallRule
+		expected string
+	}{
+		{
+			name:     "forward between zones with ports",
+			rule:     UserFirewallRule{ID: "abcdef0123", Chain: "forward", SourceZone: "guest", DestZone: "dmz", Protocol: "tcp", DestPorts: "80,443", Action: "accept", Comment: "guest web"},
+			expected: `iifname "eth1.20" oifname "eth2" tcp dport { 80, 443 } accept comment "guest web"`,
+		},
+		{
+			name:     "ipv6 source with counter and log",
+			rule:     UserFirewallRule{ID: "abcdef0123", Chain: "input", SourceCIDR: "2001:db8::/32", Protocol: "tcp_udp", DestPorts: "5000-5010", Action: "drop", Counter: true, Log: true},
+			expected: `ip6 saddr 2001:db8::/32 meta l4proto { tcp, udp } th dport 5000-5010 counter log prefix "[FW abcdef01] " drop comment "user rule abcdef01"`,
+		},
+		{
+			name:     "reject icmp from host",
+			rule:     UserFirewallRule{ID: "1", Chain: "forward", SourceCIDR: "10.0.0.5", Protocol: "icmp", Action: "reject", Comment: "no ping"},
+			expected: `ip saddr 10.0.0.5 meta l4proto icmp reject with icmpx type admin-prohibited comment "no ping"`,
+		},
+		{
+			name:     "empty zone is skipped",
+			rule:     UserFirewallRule{ID: "1", Chain: "forward", SourceZone: "iot", Action: "accept"},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderUserFirewallRule(tt.rule, zones, nil); got != tt.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateUserFirewallRule(t *testing.T) {
+	zones := defaultZoneStore()
+	valid := UserFirewallRule{Chain: "forward", SourceZone: "lan", DestZone: "wan", Protocol: "tcp", DestPorts: "25", Action: "drop"}
+	if err := validateUserFirewallRule(valid, zones, nil); err != nil {
+		t.Errorf("Expected valid rule, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*UserFirewallRule)
+	}{
+		{"bad chain", func(r *UserFirewallRule) { r.Chain = "output" }},
+		{"unknown zone", func(r *UserFirewallRule) { r.SourceZone = "nowhere" }},
+		{"dest zone on input", func(r *UserFirewallRule) { r.Chain = "input" }},
+		{"port out of range", func(r *UserFirewallRule) { r.DestPorts = "70000" }},
+		{"inverted range", func(r *UserFirewallRule) { r.DestPorts = "200-100" }},
+		{"ports without protocol", func(r *UserFirewallRule) { r.Protocol = "any" }},
+		{"mixed families", func(r *UserFirewallRule) { r.SourceCIDR = "10.0.0.0/8"; r.DestCIDR = "2001:db8::1" }},
+		{"injection in comment", func(r *UserFirewallRule) { r.Comment = "x\"; flush ruleset" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.mutate(&rule)
+			if err := validateUserFirewallRule(rule, zones, nil); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestValidateUserFirewallRules(t *testing.T) {
+	zones := defaultZoneStore()
+	valid := UserFirewallRule{ID: "4f9c6c52-3f0e-4f43-9a57-0c1f0b7b6d21", Chain: "forward", SourceZone: "lan", DestZone: "wan", Action: "drop"}
+	if err := validateUserFirewallRules([]UserFirewallRule{valid}, zones, nil); err != nil {
+		t.Errorf("Expected valid rules, got %v", err)
+	}
+
+	badID := valid
+	badID.ID = "x] \" drop; flush ruleset"
+	badZone := valid
+	badZone.SourceZone = "nowhere"
+	for _, rules := range [][]UserFirewallRule{{valid, badID}, {badZone, valid}} {
+		if err := validateUserFirewallRules(rules, zones, nil); err == nil {
+			t.Errorf("Expected %+v to be rejected", rules)
+		}
+	}
+}
+
+func TestGenerateFullRulesetUserRules(t *testing.T) {
+	in := testRulesetInputs()
+	in.UserRules = []UserFirewallRule{
+		{ID: "1", Chain: "forward", SourceZone: "lan", DestZone: "wan", Protocol: "tcp", DestPorts: "25", Action: "drop", Comment: "block smtp", Enabled: true},
+		{ID: "2", Chain: "forward", SourceCIDR: "10.0.0.9", Action: "drop", Comment: "disabled", Enabled: false},
+	}
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	if !strings.Contains(ruleset, `iifname "eth1" oifname "eth0" tcp dport 25 drop comment "block smtp"`) {
+		t.Errorf("Expected user rule in ruleset:\n%s", ruleset)
+	}
+	if strings.Contains(ruleset, `comment "disabled"`) {
+		t.Error("Disabled rules must not be rendered")
+	}
+
+	// User rules must be evaluated before zone dispatch
+	jump := strings.Index(ruleset, "jump user_forward")
+	dispatch := strings.Index(ruleset, "jump forward_lan")
+	if jump < 0 || dispatch < 0 || jump > dispatch {
+		t.Error("Expected user_forward jump before zone dispatch")
+	}
+}
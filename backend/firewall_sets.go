@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FirewallSet is a named address or port group rendered as an nft set in the
+// softrouter table. Rules, port forwards and VPN policies reference it as "@name".
+type FirewallSet struct {
+	Name     string   `json:"name"`     // e.g. "blocklist"
+	Type     string   `json:"type"`     // ipv4_addr, ipv6_addr, inet_service
+	Interval bool     `json:"interval"` // Allow CIDRs and ranges as elements
+	Timeout  string   `json:"timeout"`  // Optional, e.g. "1h": allows timed elements; persisted ones never expire
+	Elements []string `json:"elements"`
+	Comment  string   `json:"comment"`
+}
+
+// FirewallSetStore holds all named sets
+type FirewallSetStore struct {
+	Sets []FirewallSet `json:"sets"`
+}
+
+const (
+	setTypeIPv4    = "ipv4_addr"
+	setTypeIPv6    = "ipv6_addr"
+	setTypeService = "inet_service"
+)
+
+var (
+	fwSetStore      FirewallSetStore
+	fwSetStoreLock  sync.RWMutex
+	fwSetConfigPath = "/etc/softrouter/firewall_sets.json"
+
+	setNameRegex    = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+	setTimeoutRegex = regexp.MustCompile(`^([0-9]{1,5}[dhms]){1,4}$`)
+)
+
+func initFirewallSets() {
+	loadFirewallSets()
+}
+
+func loadFirewallSets() {
+	fwSetStoreLock.Lock()
+	defer fwSetStoreLock.Unlock()
+
+	data, err := os.ReadFile(fwSetConfigPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fwSetStore.Sets = []FirewallSet{}
+			return
+		}
+		fmt.Printf("Error loading firewall sets: %v\n", err)
+		return
+	}
+
+	if err := json.Unmarshal(data, &fwSetStore); err != nil {
+		fmt.Printf("Error parsing firewall sets: %v\n", err)
+		fwSetStore.Sets = []FirewallSet{}
+	}
+}
+
+func saveFirewallSets() error {
+	fwSetStoreLock.RLock()
+	data, err := json.MarshalIndent(fwSetStore, "", "  ")
+	fwSetStoreLock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fwSetConfigPath, data, 0644)
+}
+
+// GetFirewallSets returns a deep copy of all sets
+func GetFirewallSets() []FirewallSet {
+	fwSetStoreLock.RLock()
+	defer fwSetStoreLock.RUnlock()
+
+	sets := make([]FirewallSet, len(fwSetStore.Sets))
+	for i, s := range fwSetStore.Sets {
+		sets[i] = s
+		sets[i].Elements = append([]string(nil), s.Elements...)
+	}
+	return sets
+}
+
+func findFirewallSet(sets []FirewallSet, name string) *FirewallSet {
+	for i := range sets {
+		if sets[i].Name == name {
+			return &sets[i]
+		}
+	}
+	return nil
+}
+
+// isSetRef reports whether a rule value refers to a named set ("@name")
+func isSetRef(value string) bool {
+	return strings.HasPrefix(value, "@")
+}
+
+// validateSetReference checks that "@name" (or "name") exists and has one of the given types
+func validateSetReference(sets []FirewallSet, ref string, types ...string) error {
+	name := strings.TrimPrefix(ref, "@")
+	set := findFirewallSet(sets, name)
+	if set == nil {
+		return fmt.Errorf("unknown set '%s'", name)
+	}
+	for _, t := range types {
+		if set.Type == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("set '%s' has type %s, expected %s", name, set.Type, strings.Join(types, " or "))
+}
+
+// setAddrFamily returns the nft address family keyword ("ip"/"ip6") for an address set reference
+func setAddrFamily(sets []FirewallSet, ref string) string {
+	if set := findFirewallSet(sets, strings.TrimPrefix(ref, "@")); set != nil && set.Type == setTypeIPv6 {
+		return "ip6"
+	}
+	return "ip"
+}
+
+// validateSetElement checks one element against the set type
+func validateSetElement(set FirewallSet, elem string) error {
+	switch set.Type {
+	case setTypeService:
+		lo, hi, isRange := strings.Cut(elem, "-")
+		if isRange && !set.Interval {
+			return fmt.Errorf("port range '%s' requires an interval set", elem)
+		}
+		if !isRange {
+			hi = lo
+		}
+		a, err1 := strconv.Atoi(lo)
+		b, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || a < 0 || b > 65535 || a > b {
+			return fmt.Errorf("invalid port '%s'", elem)
+		}
+		return nil
+
+	case setTypeIPv4, setTypeIPv6:
+		wantV4 := set.Type == setTypeIPv4
+		var addrs []net.IP
+
+		if lo, hi, isRange := strings.Cut(elem, "-"); isRange {
+			addrs = []net.IP{net.ParseIP(lo), net.ParseIP(hi)}
+			if !set.Interval {
+				return fmt.Errorf("address range '%s' requires an interval set", elem)
+			}
+		} else if strings.Contains(elem, "/") {
+			ip, _, err := net.ParseCIDR(elem)
+			if err != nil {
+				return fmt.Errorf("invalid prefix '%s'", elem)
+			}
+			if !set.Interval {
+				return fmt.Errorf("prefix '%s' requires an interval set", elem)
+			}
+			addrs = []net.IP{ip}
+		} else {
+			addrs = []net.IP{net.ParseIP(elem)}
+		}
+
+		for _, ip := range addrs {
+			if ip == nil || (ip.To4() != nil) != wantV4 {
+				return fmt.Errorf("invalid %s element '%s'", set.Type, elem)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported set type '%s'", set.Type)
+}
+
+// validateFirewallSet checks a set definition and all its elements
+func validateFirewallSet(set FirewallSet) error {
+	if !setNameRegex.MatchString(set.Name) {
+		return fmt.Errorf("invalid set name '%s'", set.Name)
+	}
+	if strings.HasPrefix(set.Name, "steer_") {
+		return fmt.Errorf("set names starting with 'steer_' are reserved for WAN steering")
+	}
+	if set.Type != setTypeIPv4 && set.Type != setTypeIPv6 && set.Type != setTypeService {
+		return fmt.Errorf("set type must be ipv4_addr, ipv6_addr or inet_service")
+	}
+	if set.Timeout != "" && !setTimeoutRegex.MatchString(set.Timeout) {
+		return fmt.Errorf("invalid timeout '%s' (e.g. 30m, 1h, 7d)", set.Timeout)
+	}
+	if strings.ContainsAny(set.Comment, "\"\\\n\r") {
+		return fmt.Errorf("comment must not contain quotes or backslashes")
+	}
+	for _, elem := range set.Elements {
+		if err := validateSetElement(set, elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateFirewallSets checks a stored set list, as found in a backup
+func validateFirewallSets(sets []FirewallSet) error {
+	seen := make(map[string]bool, len(sets))
+	for _, set := range sets {
+		if err := validateFirewallSet(set); err != nil {
+			return err
+		}
+		if seen[set.Name] {
+			return fmt.Errorf("duplicate set name '%s'", set.Name)
+		}
+		seen[set.Name] = true
+	}
+	return nil
+}
+
+// nftSetFlags renders the flags line for a set, "" if it has none
+func nftSetFlags(set FirewallSet) string {
+	var flags []string
+	if set.Interval {
+		flags = append(flags, "interval")
+	}
+	if set.Timeout != "" {
+		flags = append(flags, "timeout")
+	}
+	return strings.Join(flags, ",")
+}
+
+// writeNamedSets renders set declarations; they must precede the chains using them
+func writeNamedSets(b *strings.Builder, sets []FirewallSet) {
+	for _, set := range sets {
+		b.WriteString(fmt.Sprintf("  set %s {\n", set.Name))
+		b.WriteString(fmt.Sprintf("    type %s\n", set.Type))
+		if flags := nftSetFlags(set); flags != "" {
+			b.WriteString(fmt.Sprintf("    flags %s\n", flags))
+		}
+		if set.Interval {
+			// Overlapping prefixes are merged instead of failing the whole ruleset
+			b.WriteString("    auto-merge\n")
+		}
+		if set.Comment != "" {
+			b.WriteString(fmt.Sprintf("    comment \"%s\"\n", set.Comment))
+		}
+		if len(set.Elements) > 0 {
+			b.WriteString(fmt.Sprintf("    elements = { %s }\n", strings.Join(set.Elements, ", ")))
+		}
+		b.WriteString("  }\n\n")
+	}
+}
+
+// setReferences lists what refers to a set, so in-use sets are not deleted
+func setReferences(name string) []string {
+	ref := "@" + name
+	var refs []string
+
+	for _, rule := range GetUserFirewallRules() {
+		if rule.SourceCIDR == ref || rule.DestCIDR == ref || rule.SourcePorts == ref || rule.DestPorts == ref {
+			refs = append(refs, "firewall rule "+shortRuleID(rule.ID))
+		}
+	}
+	for _, pf := range GetPortForwardingRules() {
+		if pf.SourceSet == name {
+			refs = append(refs, "port forward "+pf.Description)
+		}
+	}
+	if policies, err := loadVPNPolicies(); err == nil {
+		for _, p := range policies {
+			if p.SourceSet == name || p.DestCIDR == ref || p.DestPorts == ref {
+				refs = append(refs, "VPN policy "+p.Description)
+			}
+		}
+	}
+	return refs
+}
+
+// updateSetElementsLive adds and removes elements in the running set as one nft
+// transaction, without regenerating the ruleset
+func updateSetElementsLive(set FirewallSet, add, remove []string, timeout string) error {
+	var b strings.Builder
+	if len(add) > 0 {
+		elems := add
+		if timeout != "" {
+			elems = make([]string, len(add))
+			for i, e := range add {
+				elems[i] = e + " timeout " + timeout
+			}
+		}
+		b.WriteString(fmt.Sprintf("add element inet softrouter %s { %s }\n", set.Name, strings.Join(elems, ", ")))
+	}
+	if len(remove) > 0 {
+		b.WriteString(fmt.Sprintf("delete element inet softrouter %s { %s }\n", set.Name, strings.Join(remove, ", ")))
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+
+	tmpfile, err := os.CreateTemp("", "softrouter-set-*.nft")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(b.String()); err != nil {
+		tmpfile.Close()
+		return fmt.Errorf("failed to write set update: %v", err)
+	}
+	tmpfile.Close()
+
+	if output, err := runPrivilegedCombinedOutput("nft", "-f", tmpfile.Name()); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// --- Handlers ---
+
+func listFirewallSets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, GetFirewallSets())
+}
+
+// saveFirewallSet creates or replaces a set definition. Structural changes need a
+// full regeneration; use the elements endpoint for membership changes.
+func saveFirewallSet(w http.ResponseWriter, r *http.Request) {
+	var set FirewallSet
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if set.Elements == nil {
+		set.Elements = []string{}
+	}
+
+	if err := validateFirewallSet(set); err != nil {
+		respondWithError(w, ErrFirewallInvalidRule, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	fwSetStoreLock.Lock()
+	if existing := findFirewallSet(fwSetStore.Sets, set.Name); existing != nil {
+		// Changing the type would silently break every rule that references the set
+		if existing.Type != set.Type && len(setReferences(set.Name)) > 0 {
+			fwSetStoreLock.Unlock()
+			respondWithError(w, ErrFirewallInvalidRule, "Cannot change the type of a set that is in use", http.StatusConflict, nil)
+			return
+		}
+		*existing = set
+	} else {
+		fwSetStore.Sets = append(fwSetStore.Sets, set)
+	}
+	fwSetStoreLock.Unlock()
+
+	setJSON, _ := json.Marshal(set)
+	if err := saveFirewallSets(); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "firewall.set.save", set.Name, string(setJSON), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save firewall sets", err)
+		return
+	}
+	logAuditEvent(getUsernameFromToken(r), "firewall.set.save", set.Name, string(setJSON), getClientIP(r), true)
+
+	go firewallManager.ApplyFirewallRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, set)
+}
+
+func deleteFirewallSet(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		respondInvalidRequest(w, "Name required")
+		return
+	}
+
+	if refs := setReferences(name); len(refs) > 0 {
+		respondWithError(w, ErrFirewallInvalidRule,
+			fmt.Sprintf("Set is still referenced by: %s", strings.Join(refs, ", ")), http.StatusConflict, nil)
+		return
+	}
+
+	fwSetStoreLock.Lock()
+	newSets := []FirewallSet{}
+	found := false
+	for _, s := range fwSetStore.Sets {
+		if s.Name == name {
+			found = true
+			continue
+		}
+		newSets = append(newSets, s)
+	}
+	fwSetStore.Sets = newSets
+	fwSetStoreLock.Unlock()
+
+	if !found {
+		respondWithError(w, ErrGenericNotFound, "Set not found", http.StatusNotFound, nil)
+		return
+	}
+
+	if err := saveFirewallSets(); err != nil {
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save firewall sets", err)
+		return
+	}
+	logAuditEvent(getUsernameFromToken(r), "firewall.set.delete", name, fmt.Sprintf("{\"name\":\"%s\"}", name), getClientIP(r), true)
+
+	go firewallManager.ApplyFirewallRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
+
+// SetElementsUpdate adds/removes members of a set. Elements added with a timeout
+// expire in the kernel and are not persisted.
+type SetElementsUpdate struct {
+	Name    string   `json:"name"`
+	Add     []string `json:"add"`
+	Remove  []string `json:"remove"`
+	Timeout string   `json:"timeout"`
+}
+
+// liveSetElements lists the elements of the running set, timed ones included
+func liveSetElements(name string) ([]string, error) {
+	output, err := runPrivilegedOutput("nft", "list", "set", "inet", "softrouter", name)
+	if err != nil {
+		return nil, err
+	}
+	return parseSetElements(string(output)), nil
+}
+
+// parseSetElements reads the element list of an `nft list set` output, which
+// nft wraps over several lines. Timeouts and expiries are dropped.
+func parseSetElements(listing string) []string {
+	_, body, ok := strings.Cut(listing, "elements = {")
+	if !ok {
+		return nil
+	}
+	body, _, _ = strings.Cut(body, "}")
+	var elements []string
+	for _, e := range strings.Split(body, ",") {
+		if fields := strings.Fields(e); len(fields) > 0 {
+			elements = append(elements, fields[0])
+		}
+	}
+	return elements
+}
+
+// planSetElementsUpdate returns the elements to add and remove live, skipping
+// those already present/absent in the running set so the nft transaction
+// cannot fail on duplicates, and the membership to save. live is the kernel's
+// view including timed elements, which are never persisted. Removals always persist, timed
+// additions expire on their own and are not saved.
+func planSetElementsUpdate(current, live []string, req SetElementsUpdate) (add, remove, elements []string, persist bool) {
+	persisted := make(map[string]bool, len(current))
+	for _, e := range current {
+		persisted[e] = true
+	}
+	present := make(map[string]bool, len(live))
+	for _, e := range live {
+		present[e] = true
+	}
+
+	elements = []string{}
+	for _, e := range req.Add {
+		if !present[e] {
+			add = append(add, e)
+			present[e] = true
+		}
+		if req.Timeout == "" && !persisted[e] {
+			elements = append(elements, e)
+			persisted[e] = true
+			persist = true
+		}
+	}
+	for _, e := range req.Remove {
+		if present[e] {
+			remove = append(remove, e)
+			delete(present, e)
+		}
+		if persisted[e] {
+			delete(persisted, e)
+			persist = true
+		}
+	}
+
+	var kept []string
+	for _, e := range current {
+		if persisted[e] {
+			kept = append(kept, e)
+		}
+	}
+	for _, e := range elements {
+		if persisted[e] {
+			kept = append(kept, e)
+		}
+	}
+	if kept == nil {
+		kept = []string{}
+	}
+	return add, remove, kept, persist
+}
+
+func updateFirewallSetElements(w http.ResponseWriter, r *http.Request) {
+	var req SetElementsUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	fwSetStoreLock.Lock()
+	defer fwSetStoreLock.Unlock()
+
+	set := findFirewallSet(fwSetStore.Sets, req.Name)
+	if set == nil {
+		respondWithError(w, ErrGenericNotFound, "Set not found", http.StatusNotFound, nil)
+		return
+	}
+
+	if req.Timeout != "" {
+		if !setTimeoutRegex.MatchString(req.Timeout) {
+			respondInvalidRequest(w, "Invalid timeout")
+			return
+		}
+		if set.Timeout == "" {
+			respondInvalidRequest(w, "Set does not support element timeouts")
+			return
+		}
+	}
+	for _, elem := range append(append([]string(nil), req.Add...), req.Remove...) {
+		if err := validateSetElement(*set, elem); err != nil {
+			respondWithError(w, ErrFirewallInvalidRule, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	// Timed elements only exist in the kernel, so removals need its view
+	live := set.Elements
+	if set.Timeout != "" && len(req.Remove) > 0 {
+		var err error
+		if live, err = liveSetElements(set.Name); err != nil {
+			respondFirewallError(w, ErrFirewallAddFailed, "Failed to read set", err)
+			return
+		}
+	}
+	add, remove, elements, persist := planSetElementsUpdate(set.Elements, live, req)
+	details, _ := json.Marshal(req)
+	if err := updateSetElementsLive(*set, add, remove, req.Timeout); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "firewall.set.elements", set.Name, string(details), getClientIP(r), false)
+		respondFirewallError(w, ErrFirewallAddFailed, "Failed to update set", err)
+		return
+	}
+
+	// Persist permanent membership so the next regeneration keeps it
+	if persist {
+		set.Elements = elements
+
+		data, err := json.MarshalIndent(fwSetStore, "", "  ")
+		if err == nil {
+			err = os.WriteFile(fwSetConfigPath, data, 0644)
+		}
+		if err != nil {
+			respondSystemError(w, ErrSystemConfigSave, "Set updated live but could not be saved", err)
+			return
+		}
+	}
+
+	logAuditEvent(getUsernameFromToken(r), "firewall.set.elements", set.Name, string(details), getClientIP(r), true)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, *set)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSetElement(t *testing.T) {
+	v4 := FirewallSet{Name: "blocklist", Type: setTypeIPv4, Interval: true}
+	v6 := FirewallSet{Name: "v6hosts", Type: setTypeIPv6}
+	ports := FirewallSet{Name: "web", Type: setTypeService}
+
+	tests := []struct {
+		set   FirewallSet
+		elem  string
+		valid bool
+	}{
+		{v4, "10.0.0.1", true},
+		{v4, "10.0.0.0/8", true},
+		{v4, "10.0.0.10-10.0.0.20", true},
+		{v4, "2001:db8::1", false},
+		{v4, "10.0.0.1; flush ruleset", false},
+		{v6, "2001:db8::1", true},
+		{v6, "2001:db8::/32", false}, // Prefix needs an interval set
+		{ports, "443", true},
+		{ports, "8000-8100", false}, // Range needs an interval set
+		{ports, "70000", false},
+	}
+
+	for _, tt := range tests {
+		err := validateSetElement(tt.set, tt.elem)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s %q: expected valid=%v, got %v", tt.set.Name, tt.elem, tt.valid, err)
+		}
+	}
+}
+
+func TestPlanSetElementsUpdate(t *testing.T) {
+	current := []string{"10.0.0.1", "10.0.0.2"}
+
+	add, remove, elements, persist := planSetElementsUpdate(current, current, SetElementsUpdate{
+		Add: []string{"10.0.0.2", "10.0.0.3"}, Remove: []string{"10.0.0.1", "10.0.0.9"}})
+	if strings.Join(add, ",") != "10.0.0.3" || strings.Join(remove, ",") != "10.0.0.1" {
+		t.Errorf("Expected only actual changes, got add %v remove %v", add, remove)
+	}
+	if !persist || strings.Join(elements, ",") != "10.0.0.2,10.0.0.3" {
+		t.Errorf("Expected permanent membership to be saved, got %v (persist %v)", elements, persist)
+	}
+
+	// Timed additions are not saved, removals in the same request are
+	add, remove, elements, persist = planSetElementsUpdate(current, current, SetElementsUpdate{
+		Add: []string{"10.0.0.3"}, Remove: []string{"10.0.0.1"}, Timeout: "1h"})
+	if len(add) != 1 || len(remove) != 1 {
+		t.Errorf("Expected the live update to add and remove, got add %v remove %v", add, remove)
+	}
+	if !persist || strings.Join(elements, ",") != "10.0.0.2" {
+		t.Errorf("Expected the removal to be saved without the timed addition, got %v (persist %v)", elements, persist)
+	}
+
+	if _, _, _, persist = planSetElementsUpdate(current, current, SetElementsUpdate{Add: []string{"10.0.0.3"}, Timeout: "1h"}); persist {
+		t.Error("Expected timed additions alone not to be saved")
+	}
+
+	// Timed elements only exist live and can be removed before they expire
+	live := []string{"10.0.0.1", "10.0.0.2", "10.0.0.7"}
+	_, remove, elements, persist = planSetElementsUpdate(current, live, SetElementsUpdate{Remove: []string{"10.0.0.7"}})
+	if strings.Join(remove, ",") != "10.0.0.7" || persist || strings.Join(elements, ",") != "10.0.0.1,10.0.0.2" {
+		t.Errorf("Expected the timed element to be deleted live only, got remove %v elements %v (persist %v)", remove, elements, persist)
+	}
+}
+
+func TestValidateFirewallSets(t *testing.T) {
+	valid := FirewallSet{Name: "blocklist", Type: setTypeIPv4, Elements: []string{"192.0.2.1"}}
+	if err := validateFirewallSets([]FirewallSet{valid, {Name: "web", Type: setTypeService}}); err != nil {
+		t.Errorf("Expected valid sets, got %v", err)
+	}
+
+	injected := valid
+	injected.Elements = []string{"192.0.2.1 } ; flush ruleset ; add element inet softrouter blocklist { 192.0.2.2"}
+	badName := valid
+	badName.Name = "x { type ipv4_addr; }"
+	for _, sets := range [][]FirewallSet{{injected}, {badName}, {valid, valid}} {
+		if err := validateFirewallSets(sets); err == nil {
+			t.Errorf("Expected %+v to be rejected", sets)
+		}
+	}
+}
+
+func TestParseSetElements(t *testing.T) {
+	listing := "table inet softrouter {\n\tset blocklist {\n\t\ttype ipv4_addr\n\t\tflags interval,timeout\n\t\tauto-merge\n" +
+		"\t\telements = { 192.0.2.0/24, 198.51.100.7 timeout 1h expires 52m31s680ms,\n\t\t\t     203.0.113.1-203.0.113.9 }\n\t}\n}\n"
+	if got := strings.Join(parseSetElements(listing), ","); got != "192.0.2.0/24,198.51.100.7,203.0.113.1-203.0.113.9" {
+		t.Errorf("Unexpected elements %s", got)
+	}
+	if got := parseSetElements("table inet softrouter {\n\tset blocklist {\n\t\ttype ipv4_addr\n\t}\n}\n"); got != nil {
+		t.Errorf("Expected no elements, got %v", got)
+	}
+}
+
+func TestGenerateFullRulesetSets(t *testing.T) {
+	in := testRulesetInputs()
+	in.Sets = []FirewallSet{
+		{Name: "blocklist", Type: setTypeIPv4, Interval: true, Timeout: "1h", Elements: []string{"192.0.2.0/24"}},
+		{Name: "admins", Type: setTypeIPv6, Elements: []string{"2001:db8::10"}},
+	}
+	in.UserRules = []UserFirewallRule{
+		{ID: "1", Chain: "forward", SourceCIDR: "@blocklist", Action: "drop", Comment: "blocklist", Enabled: true},
+		{ID: "2", Chain: "input", SourceCIDR: "@admins", Protocol: "tcp", DestPorts: "22", Action: "accept", Comment: "admins", Enabled: true},
+	}
+	in.PortForwards[0].SourceSet = "blocklist"
+	in.VPNPolicies = []VPNPolicy{{SourceSet: "blocklist", Description: "vpn hosts"}}
+	in.VPNProfiles = []VPNProfile{{Name: "pia", Type: vpnProfileOpenVPN, Device: "tun1", Table: 100, MarkID: 1}}
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	expectedSubstrings := []string{
+		"  set blocklist {\n    type ipv4_addr\n    flags interval,timeout\n    auto-merge\n    elements = { 192.0.2.0/24 }\n  }",
+		"ip saddr @blocklist drop comment \"blocklist\"",
+		"ip6 saddr @admins tcp dport 22 accept comment \"admins\"",
+		"ip daddr 10.0.2.10 tcp dport 443 ip saddr != @blocklist drop",
+		"ip saddr @blocklist meta mark set meta mark & 0xd0ffffff | 0x01000000",
+	}
+	for _, s := range expectedSubstrings {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain '%s'.\nGenerated Ruleset:\n%s", s, ruleset)
+		}
+	}
+
+	if strings.Index(ruleset, "set blocklist {") > strings.Index(ruleset, "chain user_forward {") {
+		t.Error("Sets must be declared before the chains that reference them")
+	}
+}
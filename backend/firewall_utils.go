@@ -25,6 +25,9 @@ func enableIPForwarding() {
 	} else {
 		fmt.Println("IP Forwarding enabled.")
 	}
+
+	// IPv6 forwarding follows the IPv6 configuration (see ipv6_utils.go)
+	applyIPv6Sysctls()
 }
 
 // setupNAT is deprecated - all NAT logic moved to FirewallManager
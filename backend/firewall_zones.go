@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FirewallZone groups interfaces (physical ports or VLANs) that share a trust level.
+// Interfaces may be listed explicitly or picked up from the interface label of the
+// same name (e.g. an interface labeled "Guest" joins the "guest" zone).
+type FirewallZone struct {
+	Name        string        `json:"name"`        // e.g., "lan", "guest" - used in chain names
+	Description string        `json:"description"` // User-provided description
+	Interfaces  []string      `json:"interfaces"`  // e.g., ["eth1", "eth1.20"]
+	Input       string        `json:"input"`       // Traffic to the router itself: accept, drop, reject
+	Services    []ZoneService `json:"services"`    // Exceptions to Input (e.g., DNS/DHCP for guests)
+	Masquerade  bool          `json:"masquerade"`  // Source NAT traffic leaving through this zone
+}
+
+// ZoneService is a per-service exception used by zones and inter-zone policies
+type ZoneService struct {
+	Name     string `json:"name"`     // e.g., "DNS", "HTTPS"
+	Protocol string `json:"protocol"` // tcp, udp
+	Ports    string `json:"ports"`    // e.g., "53", "80,443", "8000-8100"
+	Action   string `json:"action"`   // accept, drop, reject
+}
+
+// ZonePolicy decides what happens to traffic forwarded from one zone to another
+type ZonePolicy struct {
+	From       string        `json:"from"`
+	To         string        `json:"to"`
+	Action     string        `json:"action"`     // accept, drop, reject
+	Exceptions []ZoneService `json:"exceptions"` // Evaluated before Action
+	Enabled    bool          `json:"enabled"`
+	Comment    string        `json:"comment"`
+}
+
+// ZoneStore is the persisted zone/policy model
+type ZoneStore struct {
+	Zones    []FirewallZone `json:"zones"`
+	Policies []ZonePolicy   `json:"policies"`
+}
+
+const (
+	zoneWAN = "wan"
+	zoneLAN = "lan"
+)
+
+var (
+	zoneStore      ZoneStore
+	zoneStoreLock  sync.RWMutex
+	zoneConfigPath = "/etc/softrouter/firewall_zones.json"
+
+	zoneNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{0,15}$`)
+	portSpecRegex = regexp.MustCompile(`^[0-9]{1,5}(-[0-9]{1,5})?(,[0-9]{1,5}(-[0-9]{1,5})?)*$`)
+)
+
+// defaultZoneStore mirrors the interface labels offered by the UI.
+// Guest and IoT can reach the internet and the router's DNS/DHCP, but not LAN.
+func defaultZoneStore() ZoneStore {
+	basicServices := []ZoneService{
+		{Name: "DNS", Protocol: "udp", Ports: "53", Action: "accept"},
+		{Name: "DNS", Protocol: "tcp", Ports: "53", Action: "accept"},
+		{Name: "DHCP", Protocol: "udp", Ports: "67", Action: "accept"},
+		{Name: "DHCPv6", Protocol: "udp", Ports: "547", Action: "accept"},
+	}
+
+	return ZoneStore{
+		Zones: []FirewallZone{
+			{Name: zoneWAN, Description: "Internet uplinks", Input: "drop", Masquerade: true},
+			{Name: zoneLAN, Description: "Trusted local network", Input: "accept"},
+			{Name: "management", Description: "Router administration", Input: "accept"},
+			{Name: "dmz", Description: "Publicly reachable servers", Input: "drop", Services: basicServices},
+			{Name: "guest", Description: "Guest network (internet only)", Input: "drop", Services: basicServices},
+			{Name: "iot", Description: "IoT devices (internet only)", Input: "drop", Services: basicServices},
+			{Name: "trunk", Description: "Untagged traffic on VLAN trunks", Input: "drop"},
+		},
+		Policies: []ZonePolicy{
+			{From: zoneLAN, To: zoneWAN, Action: "accept", Enabled: true, Comment: "LAN to WAN"},
+			{From: zoneLAN, To: "dmz", Action: "accept", Enabled: true, Comment: "LAN to DMZ"},
+			{From: zoneLAN, To: "iot", Action: "accept", Enabled: true, Comment: "LAN manages IoT"},
+			{From: "management", To: zoneWAN, Action: "accept", Enabled: true},
+			{From: "management", To: zoneLAN, Action: "accept", Enabled: true},
+			{From: "management", To: "dmz", Action: "accept", Enabled: true},
+			{From: "dmz", To: zoneWAN, Action: "accept", Enabled: true, Comment: "DMZ to WAN"},
+			{From: "guest", To: zoneWAN, Action: "accept", Enabled: true, Comment: "Guest to WAN"},
+			{From: "guest", To: zoneLAN, Action: "reject", Enabled: true, Comment: "Guest isolation"},
+			{From: "iot", To: zoneWAN, Action: "accept", Enabled: true, Comment: "IoT to WAN"},
+			{From: "iot", To: zoneLAN, Action: "reject", Enabled: true, Comment: "IoT isolation"},
+			{From: zoneWAN, To: "dmz", Action: "drop", Enabled: true, Comment: "Add exceptions to expose DMZ services"},
+		},
+	}
+}
+
+func initFirewallZones() {
+	loadZoneConfig()
+}
+
+func loadZoneConfig() {
+	zoneStoreLock.Lock()
+	defer zoneStoreLock.Unlock()
+
+	data, err := os.ReadFile(zoneConfigPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			zoneStore = defaultZoneStore()
+			return
+		}
+		fmt.Printf("Error loading firewall zones: %v\n", err)
+		zoneStore = defaultZoneStore()
+		return
+	}
+
+	if err := json.Unmarshal(data, &zoneStore); err != nil {
+		fmt.Printf("Error parsing firewall zones: %v. Using defaults.\n", err)
+		zoneStore = defaultZoneStore()
+	}
+}
+
+func saveZoneConfig() error {
+	zoneStoreLock.RLock()
+	data, err := json.MarshalIndent(zoneStore, "", "  ")
+	zoneStoreLock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(zoneConfigPath, data, 0644)
+}
+
+// GetZoneStore returns a deep copy of the zone model
+func GetZoneStore() ZoneStore {
+	zoneStoreLock.RLock()
+	defer zoneStoreLock.RUnlock()
+
+	out := ZoneStore{
+		Zones:    make([]FirewallZone, len(zoneStore.Zones)),
+		Policies: make([]ZonePolicy, len(zoneStore.Policies)),
+	}
+	for i, z := range zoneStore.Zones {
+		z.Interfaces = append([]string(nil), z.Interfaces...)
+		z.Services = append([]ZoneService(nil), z.Services...)
+		out.Zones[i] = z
+	}
+	for i, p := range zoneStore.Policies {
+		p.Exceptions = append([]ZoneService(nil), p.Exceptions...)
+		out.Policies[i] = p
+	}
+	return out
+}
+
+// resolveZones merges explicit zone membership with interface labels.
+// An interface listed explicitly in a zone wins over its label.
+func resolveZones(store ZoneStore, meta map[string]InterfaceMetadata) []FirewallZone {
+	zones := make([]FirewallZone, len(store.Zones))
+	assigned := make(map[string]bool)
+	index := make(map[string]int)
+
+	for i, z := range store.Zones {
+		z.Interfaces = append([]string(nil), z.Interfaces...)
+		zones[i] = z
+		index[z.Name] = i
+		for _, iface := range z.Interfaces {
+			assigned[iface] = true
+		}
+	}
+
+	// Sort for a stable ruleset (map iteration order is random)
+	names := make([]string, 0, len(meta))
+	for iface := range meta {
+		names = append(names, iface)
+	}
+	sort.Strings(names)
+
+	for _, iface := range names {
+		if assigned[iface] {
+			continue
+		}
+		label := strings.ToLower(meta[iface].Label)
+		if i, ok := index[label]; ok {
+			zones[i].Interfaces = append(zones[i].Interfaces, iface)
+			assigned[iface] = true
+		}
+	}
+
+	return zones
+}
+
+// zoneInterfaces returns the members of the named zone
+func zoneInterfaces(zones []FirewallZone, name string) []string {
+	for _, z := range zones {
+		if z.Name == name {
+			return z.Interfaces
+		}
+	}
+	return nil
+}
+
+// validateZoneStore checks names, references and actions before persisting
+func validateZoneStore(store ZoneStore) error {
+	seenZones := make(map[string]bool)
+	seenIfaces := make(map[string]string)
+
+	for _, z := range store.Zones {
+		if !zoneNameRegex.MatchString(z.Name) {
+			return fmt.Errorf("invalid zone name '%s' (lowercase letters, digits and _ only)", z.Name)
+		}
+		if seenZones[z.Name] {
+			return fmt.Errorf("duplicate zone '%s'", z.Name)
+		}
+		seenZones[z.Name] = true
+
+		if !isValidZoneAction(z.Input) {
+			return fmt.Errorf("zone %s: invalid input action '%s'", z.Name, z.Input)
+		}
+		for _, iface := range z.Interfaces {
+			if !isValidInterfaceName(iface) {
+				return fmt.Errorf("zone %s: invalid interface name '%s'", z.Name, iface)
+			}
+			if other, ok := seenIfaces[iface]; ok {
+				return fmt.Errorf("interface %s is in both %s and %s", iface, other, z.Name)
+			}
+			seenIfaces[iface] = z.Name
+		}
+		for _, svc := range z.Services {
+			if err := validateZoneService(svc); err != nil {
+				return fmt.Errorf("zone %s: %v", z.Name, err)
+			}
+		}
+	}
+
+	if !seenZones[zoneWAN] {
+		return fmt.Errorf("a '%s' zone is required", zoneWAN)
+	}
+
+	for _, p := range store.Policies {
+		if !seenZones[p.From] || !seenZones[p.To] {
+			return fmt.Errorf("policy %s -> %s references an unknown zone", p.From, p.To)
+		}
+		if p.From == p.To {
+			return fmt.Errorf("policy %s -> %s: source and destination must differ", p.From, p.To)
+		}
+		if !isValidZoneAction(p.Action) {
+			return fmt.Errorf("policy %s -> %s: invalid action '%s'", p.From, p.To, p.Action)
+		}
+		for _, exc := range p.Exceptions {
+			if err := validateZoneService(exc); err != nil {
+				return fmt.Errorf("policy %s -> %s: %v", p.From, p.To, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateZoneService(svc ZoneService) error {
+	if svc.Protocol != "tcp" && svc.Protocol != "udp" {
+		return fmt.Errorf("service '%s': protocol must be tcp or udp", svc.Name)
+	}
+	if err := validatePortSpec(svc.Ports); err != nil {
+		return fmt.Errorf("service '%s': %v", svc.Name, err)
+	}
+	if !isValidZoneAction(svc.Action) {
+		return fmt.Errorf("service '%s': invalid action '%s'", svc.Name, svc.Action)
+	}
+	if strings.ContainsAny(svc.Name, "\"\\\n") {
+		return fmt.Errorf("service name contains invalid characters")
+	}
+	return nil
+}
+
+func isValidZoneAction(action string) bool {
+	return action == "accept" || action == "drop" || action == "reject"
+}
+
+// --- Ruleset rendering helpers ---
+
+// nftAction maps a zone action to an nft verdict statement
+func nftAction(action string) string {
+	if action == "reject" {
+		return "reject with icmpx type admin-prohibited"
+	}
+	return action
+}
+
+// nftIfaceSet renders one or more interface names as an nft match value
+func nftIfaceSet(ifaces []string) string {
+	if len(ifaces) == 1 {
+		return fmt.Sprintf("\"%s\"", ifaces[0])
+	}
+	quoted := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		quoted[i] = fmt.Sprintf("\"%s\"", iface)
+	}
+	return "{ " + strings.Join(quoted, ", ") + " }"
+}
+
+// nftPortSet renders "80,443" as "{ 80, 443 }" and leaves single ports/ranges alone
+func nftPortSet(ports string) string {
+	parts := strings.Split(ports, ",")
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "{ " + strings.Join(parts, ", ") + " }"
+}
+
+// writeZoneChains renders per-zone input and forward chains plus the dispatch rules
+// that the main input/forward chains jump through. Returns the dispatch lines.
+func writeZoneChains(b *strings.Builder, zones []FirewallZone, policies []ZonePolicy) (inputDispatch, forwardDispatch []string) {
+	for _, z := range zones {
+		if len(z.Interfaces) == 0 {
+			continue
+		}
+		members := nftIfaceSet(z.Interfaces)
+
+		// Input: traffic addressed to the router from this zone
+		b.WriteString(fmt.Sprintf("  chain input_%s {\n", z.Name))
+		for _, svc := range z.Services {
+			b.WriteString(fmt.Sprintf("    %s dport %s %s comment \"%s: %s\"\n",
+				svc.Protocol, nftPortSet(svc.Ports), nftAction(svc.Action), z.Name, svc.Name))
+		}
+		if z.Input != "accept" {
+			b.WriteString(fmt.Sprintf("    limit rate 5/minute burst 10 packets log prefix \"[INPUT DROP %s] \"\n", z.Name))
+		}
+		b.WriteString(fmt.Sprintf("    counter %s comment \"%s input policy\"\n", nftAction(z.Input), z.Name))
+		b.WriteString("  }\n\n")
+		inputDispatch = append(inputDispatch,
+			fmt.Sprintf("    iifname %s jump input_%s comment \"zone %s\"\n", members, z.Name, z.Name))
+
+		// Forward: traffic from this zone to other zones
+		b.WriteString(fmt.Sprintf("  chain forward_%s {\n", z.Name))
+		for _, p := range policies {
+			if !p.Enabled || p.From != z.Name {
+				continue
+			}
+			dest := zoneInterfaces(zones, p.To)
+			if len(dest) == 0 {
+				continue
+			}
+			destSet := nftIfaceSet(dest)
+			for _, exc := range p.Exceptions {
+				b.WriteString(fmt.Sprintf("    oifname %s %s dport %s counter %s comment \"%s->%s: %s\"\n",
+					destSet, exc.Protocol, nftPortSet(exc.Ports), nftAction(exc.Action), p.From, p.To, exc.Name))
+			}
+			// Counters let the Firewall page show how much traffic each policy matches
+			b.WriteString(fmt.Sprintf("    oifname %s counter %s comment \"%s->%s\"\n",
+				destSet, nftAction(p.Action), p.From, p.To))
+		}
+		b.WriteString("  }\n\n")
+		forwardDispatch = append(forwardDispatch,
+			fmt.Sprintf("    iifname %s jump forward_%s comment \"zone %s\"\n", members, z.Name, z.Name))
+	}
+	return inputDispatch, forwardDispatch
+}
+
+// --- Handlers ---
+
+func getFirewallZones(w http.ResponseWriter, r *http.Request) {
+	store := GetZoneStore()
+
+	// Show effective membership (explicit + label-derived) alongside the stored model
+	metaStore, err := loadInterfaceMetadata()
+	if err == nil {
+		store.Zones = resolveZones(store, metaStore.Metadata)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, store)
+}
+
+func updateFirewallZones(w http.ResponseWriter, r *http.Request) {
+	var req ZoneStore
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	if err := validateZoneStore(req); err != nil {
+		respondWithError(w, ErrFirewallInvalidRule, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	zoneStoreLock.Lock()
+	zoneStore = req
+	zoneStoreLock.Unlock()
+
+	reqJSON, _ := json.Marshal(req)
+	if err := saveZoneConfig(); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "firewall.zones.update", "zones",
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save firewall zones", err)
+		return
+	}
+
+	logAuditEvent(getUsernameFromToken(r), "firewall.zones.update", "zones",
+		string(reqJSON), getClientIP(r), true)
+
+	// Recompile the ruleset with the new zone model
+	go firewallManager.ApplyFirewallRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "updated"})
+}
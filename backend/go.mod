@@ -5,6 +5,6 @@ go 1.24.0
 toolchain go1.24.12
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	golang.org/x/crypto v0.47.0 // indirect
+	github.com/google/uuid v1.6.0
+	golang.org/x/crypto v0.47.0
 )
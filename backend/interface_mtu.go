@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	minInterfaceMTU = 576
+	maxInterfaceMTU = 9216
+)
+
+// mssClampPrefixes are the tunnel and PPP interfaces clamped automatically.
+// They are matched by name so interfaces created after the ruleset was applied
+// (wg-quick, OpenVPN, pppd) are covered.
+var mssClampPrefixes = []string{"wg", "tun", "ppp"}
+
+func isTunnelInterface(name string) bool {
+	for _, prefix := range mssClampPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mssClampTarget is an interface whose link MTU is known, so TCP handshakes
+// arriving through it can be clamped too
+type mssClampTarget struct {
+	Interface string
+	MTU       int
+}
+
+// mssClampPlan splits the clamping configuration into the egress matches
+// (wildcards included), the interfaces opted out and the inbound targets
+func mssClampPlan(in RulesetInputs) (egress, disabled []string, inbound []mssClampTarget) {
+	for _, prefix := range mssClampPrefixes {
+		egress = append(egress, prefix+"*")
+	}
+
+	names := make([]string, 0, len(in.Metadata))
+	for name := range in.Metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	off := make(map[string]bool)
+	mtus := make(map[string]int)
+	for _, name := range names {
+		meta := in.Metadata[name]
+		switch meta.MSSClamp {
+		case "off":
+			off[name] = true
+			disabled = append(disabled, name)
+			continue
+		case "on":
+			if !isTunnelInterface(name) {
+				egress = append(egress, name)
+			}
+		}
+		if meta.MTU > 0 && (meta.MSSClamp == "on" || isTunnelInterface(name)) {
+			mtus[name] = meta.MTU
+		}
+	}
+
+	// pppd sets the session's MTU, it wins over the metadata
+	for _, c := range in.PPPoE {
+		if c.Enabled {
+			mtus[pppInterfaceName(c.Unit)] = c.mtu()
+		}
+	}
+
+	for name, mtu := range mtus {
+		if !off[name] {
+			inbound = append(inbound, mssClampTarget{Interface: name, MTU: mtu})
+		}
+	}
+	sort.Slice(inbound, func(i, j int) bool { return inbound[i].Interface < inbound[j].Interface })
+	return egress, disabled, inbound
+}
+
+// writeMSSClampChain renders the chain the forward chain jumps to for SYNs.
+// Handshakes leaving through a tunnel are clamped to the route MTU; those
+// arriving through an interface with a known MTU to that MTU.
+func writeMSSClampChain(b *strings.Builder, in RulesetInputs) {
+	egress, disabled, inbound := mssClampPlan(in)
+
+	b.WriteString("  chain mss_clamp {\n")
+	for _, t := range inbound {
+		// IPv4 and TCP headers take 40 bytes, IPv6 and TCP 60
+		for _, family := range []struct {
+			proto  string
+			header int
+		}{{"ipv4", 40}, {"ipv6", 60}} {
+			mss := t.MTU - family.header
+			b.WriteString(fmt.Sprintf("    iifname \"%s\" meta nfproto %s tcp option maxseg size %d-65535 tcp option maxseg size set %d comment \"MSS clamp %s inbound\"\n",
+				t.Interface, family.proto, mss+1, mss, t.Interface))
+		}
+	}
+	for _, name := range disabled {
+		b.WriteString(fmt.Sprintf("    oifname \"%s\" return comment \"MSS clamping disabled\"\n", name))
+	}
+	for _, name := range egress {
+		b.WriteString(fmt.Sprintf("    oifname \"%s\" tcp option maxseg size set rt mtu comment \"MSS clamp %s\"\n", name, name))
+	}
+	b.WriteString("  }\n\n")
+}
+
+// setLinkMTU changes an interface's MTU when it differs
+func setLinkMTU(name string, mtu int) error {
+	if iface, err := net.InterfaceByName(name); err == nil && iface.MTU == mtu {
+		return nil
+	}
+	if out, err := runPrivilegedCombinedOutput("ip", "link", "set", "dev", name, "mtu", strconv.Itoa(mtu)); err != nil {
+		return fmt.Errorf("%v (%s)", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// applyInterfaceMTUs sets the persisted MTUs on the interfaces present now
+func applyInterfaceMTUs() {
+	store, err := loadInterfaceMetadata()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load interface metadata: %v\n", err)
+		return
+	}
+	for name, meta := range store.Metadata {
+		if meta.MTU == 0 {
+			continue
+		}
+		if _, err := net.InterfaceByName(name); err != nil {
+			continue // Created later (tunnel, VLAN), its owner sets the MTU
+		}
+		if err := setLinkMTU(name, meta.MTU); err != nil {
+			fmt.Printf("Warning: Failed to set MTU %d on %s: %v\n", meta.MTU, name, err)
+		}
+	}
+}
+
+// SetInterfaceMTURequest updates the MTU settings of an interface
+type SetInterfaceMTURequest struct {
+	InterfaceName string `json:"interfaceName"`
+	MTU           int    `json:"mtu"`       // 0 = leave the link MTU alone
+	MSSClamp      string `json:"mss_clamp"` // "", "on", "off"
+}
+
+func setInterfaceMTU(w http.ResponseWriter, r *http.Request) {
+	var req SetInterfaceMTURequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if !isValidInterfaceName(req.InterfaceName) {
+		respondWithError(w, ErrInterfaceNotFound, "Invalid interface name", http.StatusBadRequest, nil)
+		return
+	}
+	if req.MTU != 0 && (req.MTU < minInterfaceMTU || req.MTU > maxInterfaceMTU) {
+		respondInvalidRequest(w, fmt.Sprintf("MTU must be between %d and %d", minInterfaceMTU, maxInterfaceMTU))
+		return
+	}
+	if req.MSSClamp != "" && req.MSSClamp != "on" && req.MSSClamp != "off" {
+		respondInvalidRequest(w, "mss_clamp must be empty, on or off")
+		return
+	}
+
+	// Apply first so an MTU the driver rejects is not persisted
+	if req.MTU != 0 {
+		if _, err := net.InterfaceByName(req.InterfaceName); err == nil {
+			if err := setLinkMTU(req.InterfaceName, req.MTU); err != nil {
+				logAuditEvent(getUsernameFromToken(r), "interface.mtu", req.InterfaceName,
+					fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+				respondWithError(w, ErrInterfaceConfigFailed, "Failed to set MTU", http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+
+	store, err := loadInterfaceMetadata()
+	if err != nil {
+		respondSystemError(w, ErrSystemConfigLoad, "Failed to load interface metadata", err)
+		return
+	}
+	meta := store.Metadata[req.InterfaceName]
+	meta.InterfaceName = req.InterfaceName
+	meta.MTU = req.MTU
+	meta.MSSClamp = req.MSSClamp
+	store.Metadata[req.InterfaceName] = meta
+
+	if err := saveInterfaceMetadata(store); err != nil {
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save interface metadata", err)
+		return
+	}
+
+	details, _ := json.Marshal(req)
+	logAuditEvent(getUsernameFromToken(r), "interface.mtu", req.InterfaceName, string(details), getClientIP(r), true)
+
+	// Clamping rules depend on the MTU settings
+	go firewallManager.ApplyFirewallRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, meta)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateFullRulesetMSSClamping(t *testing.T) {
+	in := testRulesetInputs()
+	in.Metadata = map[string]InterfaceMetadata{
+		"wg0":     {InterfaceName: "wg0", MTU: 1420},
+		"tun1":    {InterfaceName: "tun1", MSSClamp: "off"},
+		"gre1":    {InterfaceName: "gre1", MSSClamp: "on", MTU: 1476},
+		"eth1":    {InterfaceName: "eth1", Label: "LAN", MTU: 9000}, // Not a tunnel: no clamping
+		"eth1.20": {InterfaceName: "eth1.20", Label: "Guest"},
+	}
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	chainStart := strings.Index(ruleset, "  chain mss_clamp {\n")
+	if chainStart < 0 {
+		t.Fatalf("Expected an mss_clamp chain.\nGenerated Ruleset:\n%s", ruleset)
+	}
+	chain := ruleset[chainStart : chainStart+strings.Index(ruleset[chainStart:], "  }\n")]
+
+	expected := []string{
+		"    iifname \"gre1\" meta nfproto ipv4 tcp option maxseg size 1437-65535 tcp option maxseg size set 1436 comment \"MSS clamp gre1 inbound\"\n" +
+			"    iifname \"gre1\" meta nfproto ipv6 tcp option maxseg size 1417-65535 tcp option maxseg size set 1416 comment \"MSS clamp gre1 inbound\"\n" +
+			"    iifname \"wg0\" meta nfproto ipv4 tcp option maxseg size 1381-65535 tcp option maxseg size set 1380 comment \"MSS clamp wg0 inbound\"\n",
+		"    oifname \"tun1\" return comment \"MSS clamping disabled\"\n" +
+			"    oifname \"wg*\" tcp option maxseg size set rt mtu comment \"MSS clamp wg*\"\n" +
+			"    oifname \"tun*\" tcp option maxseg size set rt mtu comment \"MSS clamp tun*\"\n" +
+			"    oifname \"ppp*\" tcp option maxseg size set rt mtu comment \"MSS clamp ppp*\"\n" +
+			"    oifname \"gre1\" tcp option maxseg size set rt mtu comment \"MSS clamp gre1\"\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(chain, s) {
+			t.Errorf("Expected mss_clamp chain to contain:\n%s\nGot:\n%s", s, chain)
+		}
+	}
+	if strings.Contains(chain, "eth1") {
+		t.Errorf("LAN interfaces must not be clamped:\n%s", chain)
+	}
+
+	forward := ruleset[strings.Index(ruleset, "chain forward {"):]
+	jump := strings.Index(forward, "tcp flags syn jump mss_clamp\n")
+	if jump < 0 || jump > strings.Index(forward, "ct state established,related accept") {
+		t.Error("The MSS clamping jump must precede the established accept")
+	}
+}
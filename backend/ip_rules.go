@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// IPRule is a policy routing rule. Each subsystem owns a priority range and
+// syncIPRules reconciles that range against the kernel, so re-applying is
+// idempotent and stale rules are removed.
+type IPRule struct {
+	Priority int
+	From     string // Address or CIDR, "" = all
+	FwMark   string // "0x100/0xff00" or "0x100"
+	Table    string // Table number or name
+	Suppress bool   // suppress_prefixlength 0 (use the table for everything but default routes)
+}
+
+// ipRuleJSON is one entry of `ip -j rule show`
+type ipRuleJSON struct {
+	Priority int    `json:"priority"`
+	Src      string `json:"src"`
+	SrcLen   *int   `json:"srclen"`
+	FwMark   string `json:"fwmark"`
+	FwMask   string `json:"fwmask"`
+	Table    string `json:"table"`
+	Suppress *int   `json:"suppress_prefixlen"`
+}
+
+// normalize makes rules built here comparable with rules parsed from the kernel
+func (r IPRule) normalize() IPRule {
+	if r.From == "all" {
+		r.From = ""
+	}
+	if _, ipNet, err := net.ParseCIDR(r.From); err == nil {
+		ones, bits := ipNet.Mask.Size()
+		if ones == bits {
+			r.From = ipNet.IP.String()
+		} else {
+			r.From = ipNet.String()
+		}
+	}
+
+	if r.FwMark != "" {
+		mark, mask, _ := strings.Cut(r.FwMark, "/")
+		m, err1 := strconv.ParseUint(mark, 0, 32)
+		k, err2 := strconv.ParseUint(mask, 0, 32)
+		switch {
+		case err1 != nil:
+		case mask == "" || (err2 == nil && k == 0xffffffff):
+			r.FwMark = fmt.Sprintf("0x%x", m)
+		case err2 == nil:
+			r.FwMark = fmt.Sprintf("0x%x/0x%x", m, k)
+		}
+	}
+	return r
+}
+
+// args renders the selector and action for `ip rule add/del`
+func (r IPRule) args() []string {
+	args := []string{"priority", strconv.Itoa(r.Priority)}
+	if r.From != "" {
+		args = append(args, "from", r.From)
+	}
+	if r.FwMark != "" {
+		args = append(args, "fwmark", r.FwMark)
+	}
+	args = append(args, "lookup", r.Table)
+	if r.Suppress {
+		args = append(args, "suppress_prefixlength", "0")
+	}
+	return args
+}
+
+func (r IPRule) String() string {
+	return strings.Join(r.args(), " ")
+}
+
+// parseIPRules parses `ip -j rule show` output
+func parseIPRules(data []byte) ([]IPRule, error) {
+	var raw []ipRuleJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	rules := make([]IPRule, 0, len(raw))
+	for _, j := range raw {
+		r := IPRule{Priority: j.Priority, From: j.Src, Table: j.Table}
+		if j.SrcLen != nil && r.From != "all" {
+			r.From = fmt.Sprintf("%s/%d", j.Src, *j.SrcLen)
+		}
+		if j.FwMark != "" {
+			r.FwMark = j.FwMark
+			if j.FwMask != "" {
+				r.FwMark += "/" + j.FwMask
+			}
+		}
+		r.Suppress = j.Suppress != nil && *j.Suppress == 0
+		rules = append(rules, r.normalize())
+	}
+	return rules, nil
+}
+
+// diffIPRules returns the rules to delete and to add so that the rules with a
+// priority in [minPrio, maxPrio] match desired
+func diffIPRules(current, desired []IPRule, minPrio, maxPrio int) (del, add []IPRule) {
+	want := make(map[string]bool)
+	for _, r := range desired {
+		want[r.normalize().String()] = true
+	}
+
+	have := make(map[string]bool)
+	for _, r := range current {
+		if r.Priority < minPrio || r.Priority > maxPrio {
+			continue
+		}
+		key := r.String()
+		if want[key] && !have[key] {
+			have[key] = true
+			continue
+		}
+		del = append(del, r) // Stale or duplicate
+	}
+
+	for _, r := range desired {
+		r = r.normalize()
+		if !have[r.String()] {
+			have[r.String()] = true
+			add = append(add, r)
+		}
+	}
+	return del, add
+}
+
+// syncIPRules reconciles the kernel's rules in a priority range. family is "-4" or "-6".
+func syncIPRules(family string, desired []IPRule, minPrio, maxPrio int) error {
+	out, err := runPrivilegedOutput("ip", family, "-j", "rule", "show")
+	if err != nil {
+		return err
+	}
+	current, err := parseIPRules(out)
+	if err != nil {
+		return fmt.Errorf("failed to parse ip rules: %w", err)
+	}
+
+	del, add := diffIPRules(current, desired, minPrio, maxPrio)
+	var firstErr error
+	for _, r := range del {
+		if out, err := runPrivilegedCombinedOutput("ip", append([]string{family, "rule", "del"}, r.args()...)...); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("ip rule del %s: %w (%s)", r, err, strings.TrimSpace(string(out)))
+		}
+	}
+	for _, r := range add {
+		if out, err := runPrivilegedCombinedOutput("ip", append([]string{family, "rule", "add"}, r.args()...)...); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("ip rule add %s: %w (%s)", r, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return firstErr
+}
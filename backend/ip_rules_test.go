@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseIPRules(t *testing.T) {
+	data := []byte(`[{"priority":0,"src":"all","table":"local"},{"priority":4990,"src":"all","table":"main","suppress_prefixlen":0},{"priority":5001,"src":"all","fwmark":"0x100","fwmask":"0xff00","table":"201"},{"priority":5301,"src":"192.0.2.10","table":"201"},{"priority":5302,"src":"198.51.100.0","srclen":24,"table":"202"},{"priority":32766,"src":"all","table":"main"}]`)
+
+	rules, err := parseIPRules(data)
+	if err != nil {
+		t.Fatalf("parseIPRules failed: %v", err)
+	}
+
+	expected := []string{
+		"priority 0 lookup local",
+		"priority 4990 lookup main suppress_prefixlength 0",
+		"priority 5001 fwmark 0x100/0xff00 lookup 201",
+		"priority 5301 from 192.0.2.10 lookup 201",
+		"priority 5302 from 198.51.100.0/24 lookup 202",
+		"priority 32766 lookup main",
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %d", len(expected), len(rules))
+	}
+	for i, r := range rules {
+		if r.String() != expected[i] {
+			t.Errorf("Rule %d: expected %q, got %q", i, expected[i], r.String())
+		}
+	}
+}
+
+func TestDiffIPRules(t *testing.T) {
+	current := []IPRule{
+		{Priority: 0, Table: "local"},
+		{Priority: 5001, FwMark: "0x100/0xff00", Table: "201"},
+		{Priority: 5001, FwMark: "0x100/0xff00", Table: "201"}, // Duplicate from an older run
+		{Priority: 5302, From: "198.51.100.7", Table: "202"},   // Stale address
+		{Priority: 32766, Table: "main"},
+	}
+	desired := []IPRule{
+		{Priority: 5001, FwMark: "0x00000100/0x0000ff00", Table: "201"},
+		{Priority: 5302, From: "198.51.100.9/32", Table: "202"},
+	}
+
+	del, add := diffIPRules(current, desired, 4990, 5599)
+	if len(del) != 2 || del[0].Priority != 5001 || del[1].From != "198.51.100.7" {
+		t.Errorf("Unexpected deletions: %v", del)
+	}
+	if len(add) != 1 || add[0].String() != "priority 5302 from 198.51.100.9 lookup 202" {
+		t.Errorf("Unexpected additions: %v", add)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// IPv6Config controls IPv6 forwarding and optional IPv6 NAT
+type IPv6Config struct {
+	Forwarding bool           `json:"forwarding"` // Route IPv6 between zones
+	NATMode    string         `json:"nat_mode"`   // none, masquerade (NAT66), nptv6
+	NPTv6      []NPTv6Mapping `json:"nptv6"`      // Prefix translations for nat_mode "nptv6"
+}
+
+// NPTv6Mapping translates an internal prefix to an external prefix of the same length
+type NPTv6Mapping struct {
+	Internal string `json:"internal"` // e.g. fd00:1::/64
+	External string `json:"external"` // e.g. 2001:db8:1::/64
+}
+
+var (
+	ipv6Config     = IPv6Config{NATMode: "none", NPTv6: []NPTv6Mapping{}} // Forwarding is opt-in
+	ipv6ConfigLock sync.RWMutex
+	ipv6ConfigPath = "/etc/softrouter/ipv6.json"
+	ipv6Configured bool // A config was saved; until then the kernel's IPv6 sysctls are left alone
+)
+
+// ICMPv6 types that must not be dropped in transit (RFC 4890 section 4.3.1)
+const icmpv6TransitErrors = "destination-unreachable, packet-too-big, time-exceeded, parameter-problem"
+
+// Marker on the last base ICMP rule of the input chain; control plane rules are injected after it
+const controlPlaneMarker = `comment "ICMPv6 MLD (RFC 4890)"`
+
+// initIPv6 loads the IPv6 configuration and applies the forwarding sysctls
+func initIPv6() {
+	loadIPv6Config()
+	applyIPv6Sysctls()
+}
+
+func loadIPv6Config() {
+	ipv6ConfigLock.Lock()
+	defer ipv6ConfigLock.Unlock()
+
+	data, err := os.ReadFile(ipv6ConfigPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error loading IPv6 config: %v\n", err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &ipv6Config); err != nil {
+		fmt.Printf("Error parsing IPv6 config: %v\n", err)
+		return
+	}
+	ipv6Configured = true
+}
+
+func saveIPv6Config() error {
+	ipv6ConfigLock.RLock()
+	data, err := json.MarshalIndent(ipv6Config, "", "  ")
+	ipv6ConfigLock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ipv6ConfigPath, data, 0644)
+}
+
+// GetIPv6Config returns a copy of the current IPv6 configuration
+func GetIPv6Config() IPv6Config {
+	ipv6ConfigLock.RLock()
+	defer ipv6ConfigLock.RUnlock()
+	cfg := ipv6Config
+	cfg.NPTv6 = append([]NPTv6Mapping(nil), ipv6Config.NPTv6...)
+	return cfg
+}
+
+// applyIPv6Sysctls sets net.ipv6.conf.all.forwarding. With forwarding on, the kernel
+// ignores router advertisements unless accept_ra=2, so WAN interfaces get that
+// to keep their SLAAC address and default route. Installs that never saved an
+// IPv6 config keep whatever the system set.
+func applyIPv6Sysctls() {
+	ipv6ConfigLock.RLock()
+	configured := ipv6Configured
+	ipv6ConfigLock.RUnlock()
+	if !configured {
+		return
+	}
+	cfg := GetIPv6Config()
+
+	forwarding := "0"
+	if cfg.Forwarding {
+		forwarding = "1"
+	}
+	if err := runPrivileged("sysctl", "-w", "net.ipv6.conf.all.forwarding="+forwarding); err != nil {
+		fmt.Printf("WARNING: Failed to set IPv6 forwarding: %v\n", err)
+	}
+
+	if !cfg.Forwarding {
+		return
+	}
+
+	meta, err := loadInterfaceMetadata()
+	if err != nil {
+		return
+	}
+	for _, wan := range zoneInterfaces(resolveZones(GetZoneStore(), meta.Metadata), zoneWAN) {
+		if err := runPrivileged("sysctl", "-w", fmt.Sprintf("net.ipv6.conf.%s.accept_ra=2", wan)); err != nil {
+			fmt.Printf("WARNING: Failed to set accept_ra on %s: %v\n", wan, err)
+		}
+	}
+}
+
+func validateIPv6Config(cfg IPv6Config) error {
+	switch cfg.NATMode {
+	case "none", "masquerade":
+	case "nptv6":
+		if len(cfg.NPTv6) == 0 {
+			return fmt.Errorf("nptv6 requires at least one prefix mapping")
+		}
+	default:
+		return fmt.Errorf("nat_mode must be none, masquerade or nptv6")
+	}
+
+	for _, m := range cfg.NPTv6 {
+		in, inNet, err1 := net.ParseCIDR(m.Internal)
+		ex, exNet, err2 := net.ParseCIDR(m.External)
+		if err1 != nil || err2 != nil || in.To4() != nil || ex.To4() != nil {
+			return fmt.Errorf("NPTv6 mapping %s -> %s: prefixes must be IPv6 CIDRs", m.Internal, m.External)
+		}
+		inLen, _ := inNet.Mask.Size()
+		exLen, _ := exNet.Mask.Size()
+		if inLen != exLen {
+			return fmt.Errorf("NPTv6 mapping %s -> %s: prefix lengths must match", m.Internal, m.External)
+		}
+	}
+	return nil
+}
+
+// isIPv6Address reports whether s is a literal IPv6 address
+func isIPv6Address(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() == nil
+}
+
+// writeIPv6NATTable renders `table ip6 nat` for NAT66 masquerade or NPTv6.
+// Nothing is rendered in the default (routed, no NAT) mode.
+func writeIPv6NATTable(b *strings.Builder, cfg IPv6Config, wanInterfaces []string) {
+	if (cfg.NATMode != "masquerade" && cfg.NATMode != "nptv6") || len(wanInterfaces) == 0 {
+		return
+	}
+	wans := nftIfaceSet(wanInterfaces)
+
+	b.WriteString("\ntable ip6 nat {\n")
+
+	if cfg.NATMode == "nptv6" {
+		// Inbound: external prefix back to the internal prefix
+		b.WriteString("  chain prerouting {\n")
+		b.WriteString("    type nat hook prerouting priority dstnat; policy accept;\n\n")
+		for _, m := range cfg.NPTv6 {
+			b.WriteString(fmt.Sprintf("    iifname %s ip6 daddr %s dnat ip6 prefix to %s comment \"NPTv6 inbound\"\n",
+				wans, m.External, m.Internal))
+		}
+		b.WriteString("  }\n\n")
+	}
+
+	b.WriteString("  chain postrouting {\n")
+	b.WriteString("    type nat hook postrouting priority srcnat; policy accept;\n\n")
+	if cfg.NATMode == "nptv6" {
+		for _, m := range cfg.NPTv6 {
+			b.WriteString(fmt.Sprintf("    oifname %s ip6 saddr %s snat ip6 prefix to %s comment \"NPTv6 outbound\"\n",
+				wans, m.Internal, m.External))
+		}
+	} else {
+		b.WriteString(fmt.Sprintf("    oifname %s masquerade comment \"NAT66\"\n", wans))
+	}
+	b.WriteString("  }\n")
+	b.WriteString("}\n")
+}
+
+// --- Handlers ---
+
+func getIPv6ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, GetIPv6Config())
+}
+
+func updateIPv6ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	var cfg IPv6Config
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if cfg.NATMode == "" {
+		cfg.NATMode = "none"
+	}
+	if cfg.NPTv6 == nil {
+		cfg.NPTv6 = []NPTv6Mapping{}
+	}
+
+	if err := validateIPv6Config(cfg); err != nil {
+		respondWithError(w, ErrNetworkInvalidIP, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	ipv6ConfigLock.Lock()
+	ipv6Config = cfg
+	ipv6ConfigLock.Unlock()
+
+	cfgJSON, _ := json.Marshal(cfg)
+	if err := saveIPv6Config(); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "network.ipv6.update", "ipv6", string(cfgJSON), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save IPv6 configuration", err)
+		return
+	}
+	ipv6ConfigLock.Lock()
+	ipv6Configured = true
+	ipv6ConfigLock.Unlock()
+	logAuditEvent(getUsernameFromToken(r), "network.ipv6.update", "ipv6", string(cfgJSON), getClientIP(r), true)
+
+	applyIPv6Sysctls()
+	go firewallManager.ApplyFirewallRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, cfg)
+}
@@ -103,33 +103,6 @@ var (
 	configPath = "/etc/softrouter/config.json"
 )
 
-func initWireGuard() {
-	configDir := "/etc/softrouter"
-	wgDir := "/etc/wireguard"
-	os.MkdirAll(configDir, 0755)
-	os.MkdirAll(wgDir, 0700)
-
-	privPath := filepath.Join(configDir, "vpn_server_private.key")
-	pubPath := filepath.Join(configDir, "vpn_server_public.key")
-	confPath := filepath.Join(wgDir, "wg0.conf")
-
-	if _, err := os.Stat(privPath); os.IsNotExist(err) {
-		fmt.Println("Initializing WireGuard Server Keys...")
-		privKey, _ := runPrivilegedOutput("wg", "genkey")
-		os.WriteFile(privPath, privKey, 0600)
-
-		pubKey, _ := runPrivilegedCombinedOutput("sh", "-c", fmt.Sprintf("echo %s | wg pubkey", strings.TrimSpace(string(privKey))))
-		os.WriteFile(pubPath, pubKey, 0644)
-	}
-
-	if _, err := os.Stat(confPath); os.IsNotExist(err) {
-		fmt.Println("Initializing WireGuard Base Config...")
-		privData, _ := os.ReadFile(privPath)
-		baseConf := fmt.Sprintf("[Interface]\nPrivateKey = %s\nAddress = 10.8.0.1/24\nListenPort = 51820\nPostUp = nft add table inet wg-filter; nft add chain inet wg-filter postrouting { type nat hook postrouting priority 100; policy accept; }; nft add rule inet wg-filter postrouting oifname \"*\" masquerade\nPostDown = nft delete table inet wg-filter\n", strings.TrimSpace(string(privData)))
-		os.WriteFile(confPath, []byte(baseConf), 0600)
-	}
-}
-
 type UpdateCredsRequest struct {
 	NewUsername string `json:"newUsername"`
 	NewPassword string `json:"newPassword"`
@@ -166,12 +139,16 @@ type NftablesRoot struct {
 }
 
 type FirewallRule struct {
-	Family  string `json:"family"`
-	Table   string `json:"table"`
-	Chain   string `json:"chain"`
-	Handle  int    `json:"handle"`
-	Comment string `json:"comment"`
-	Raw     string `json:"raw"`
+	Family      string    `json:"family"`
+	Table       string    `json:"table"`
+	Chain       string    `json:"chain"`
+	Handle      int       `json:"handle"`
+	Comment     string    `json:"comment"`
+	Raw         string    `json:"raw"`                   // Rule in nft syntax, e.g. "tcp dport 22 accept"
+	Expressions []NftExpr `json:"expressions,omitempty"` // Typed view of the rule's expressions
+	Packets     uint64    `json:"packets"`               // Sum of the rule's counters
+	Bytes       uint64    `json:"bytes"`
+	HasCounter  bool      `json:"has_counter"` // False when the rule has no counter statement
 }
 
 // DNSStats represents aggregate metrics from the ad-blocker
@@ -201,17 +178,11 @@ type ServiceStatus struct {
 // InterfaceMetadata stores custom labels and descriptions for interfaces
 type InterfaceMetadata struct {
 	InterfaceName string `json:"interface_name"`
-	Label         string `json:"label"`       // WAN, LAN, DMZ, Guest, etc.
-	Description   string `json:"description"` // User-provided description
-	Color         string `json:"color"`       // Color for UI display
-}
-
-// VPNClientConfig represents a generated WireGuard client profile
-type VPNClientConfig struct {
-	ClientName string `json:"name"`
-	PublicKey  string `json:"public_key"`
-	CreatedAt  string `json:"created_at"`
-	IPAddress  string `json:"ip_address"`
+	Label         string `json:"label"`               // WAN, LAN, DMZ, Guest, etc.
+	Description   string `json:"description"`         // User-provided description
+	Color         string `json:"color"`               // Color for UI display
+	MTU           int    `json:"mtu,omitempty"`       // Applied at startup and on change, 0 = leave as is
+	MSSClamp      string `json:"mss_clamp,omitempty"` // "" = automatic (tunnels and PPP), "on", "off"
 }
 
 // AppConfig handles persistent settings for advanced modules
@@ -230,6 +201,16 @@ type DHCPConfig struct {
 	LeaseTime  string   `json:"leaseTime"` // e.g., "12h"
 	Gateway    string   `json:"gateway"`
 	DNSServers []string `json:"dnsServers"`
+
+	// IPv6 (router advertisements / DHCPv6)
+	IPv6Mode         string   `json:"ipv6Mode,omitempty"`         // "", ra-only, slaac, ra-stateless, ra-names, stateful
+	IPv6PrefixSource string   `json:"ipv6PrefixSource,omitempty"` // static or delegated
+	IPv6Prefix       string   `json:"ipv6Prefix,omitempty"`       // Static /64, e.g. 2001:db8:1::/64
+	IPv6StartSuffix  string   `json:"ipv6StartSuffix,omitempty"`  // Stateful range host part, e.g. ::100
+	IPv6EndSuffix    string   `json:"ipv6EndSuffix,omitempty"`    // e.g. ::1ff
+	IPv6LeaseTime    string   `json:"ipv6LeaseTime,omitempty"`    // Defaults to LeaseTime
+	RDNSS            []string `json:"rdnss,omitempty"`            // Recursive DNS servers (RA + DHCPv6)
+	DNSSL            []string `json:"dnssl,omitempty"`            // DNS search list
 }
 
 // DHCPConfigStore manages all DHCP configurations
@@ -252,6 +233,8 @@ type DHCPLease struct {
 	Hostname  string `json:"hostname"`
 	Expires   string `json:"expires"`
 	Interface string `json:"interface"`
+	DUID      string `json:"duid,omitempty"` // DHCPv6 client DUID (IPv6 leases have no MAC)
+	IAID      string `json:"iaid,omitempty"`
 }
 
 const configFilePath = "/etc/softrouter/config.json"
@@ -916,119 +899,6 @@ func updateConfig(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-// --- VPN Handlers ---
-
-func listVPNClients(w http.ResponseWriter, r *http.Request) {
-	clientsDir := "/etc/softrouter/vpn_clients"
-	os.MkdirAll(clientsDir, 0755)
-
-	files, err := os.ReadDir(clientsDir)
-	var clients []VPNClientConfig
-	if err == nil {
-		for _, f := range files {
-			if strings.HasSuffix(f.Name(), ".conf") && f.Name() != "wg0.conf" {
-				info, _ := f.Info()
-				clients = append(clients, VPNClientConfig{
-					ClientName: strings.TrimSuffix(f.Name(), ".conf"),
-					CreatedAt:  info.ModTime().Format(time.RFC3339),
-				})
-			}
-		}
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(clients)
-}
-
-func addVPNClient(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name string `json:"name"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
-
-	clientsDir := "/etc/softrouter/vpn_clients"
-	os.MkdirAll(clientsDir, 0755)
-
-	// 1. Generate Client Keys
-	privKey, _ := runPrivilegedOutput("wg", "genkey")
-	cleanPriv := strings.TrimSpace(string(privKey))
-
-	pubKey, _ := runPrivilegedCombinedOutput("sh", "-c", fmt.Sprintf("echo %s | wg pubkey", cleanPriv))
-	cleanPub := strings.TrimSpace(string(pubKey))
-
-	// 2. Determine an IP (Basic assignment for now)
-	existing, _ := os.ReadDir(clientsDir)
-	nextIP := 2 + len(existing)
-	clientIP := fmt.Sprintf("10.8.0.%d/32", nextIP)
-
-	// 3. Update Server Config (/etc/wireguard/wg0.conf)
-	peerBlock := fmt.Sprintf("\n[Peer]\n# Name: %s\nPublicKey = %s\nAllowedIPs = %s\n", req.Name, cleanPub, clientIP)
-	f, err := os.OpenFile("/etc/wireguard/wg0.conf", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-	if err == nil {
-		f.WriteString(peerBlock)
-		f.Close()
-		// Reload wg0 without downtime
-		runPrivileged("wg", "syncconf", "wg0", "/etc/wireguard/wg0.conf")
-	}
-
-	// 4. Generate Client .conf
-	serverPub, _ := os.ReadFile("/etc/softrouter/vpn_server_public.key")
-
-	// Try to get public-facing IP or hostname
-	endpoint := "YOUR_ROUTER_IP"
-	if h, err := os.Hostname(); err == nil {
-		endpoint = h
-	}
-	// Better yet, use the Host header from the request if it looks like an IP/Domain
-	if h := r.Host; h != "" {
-		endpoint = strings.Split(h, ":")[0]
-	}
-
-	clientConf := fmt.Sprintf("[Interface]\nPrivateKey = %s\nAddress = %s\nDNS = 1.1.1.1\n\n[Peer]\nPublicKey = %s\nEndpoint = %s:51820\nAllowedIPs = 0.0.0.0/0\nPersistentKeepalive = 25\n",
-		cleanPriv, clientIP, strings.TrimSpace(string(serverPub)), endpoint)
-
-	confPath := fmt.Sprintf("%s/%s.conf", clientsDir, req.Name)
-	os.WriteFile(confPath, []byte(clientConf), 0600)
-
-	json.NewEncoder(w).Encode(map[string]string{"status": "success", "config": clientConf})
-}
-
-func deleteVPNClient(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get("name")
-	if name == "" {
-		http.Error(w, "Name required", http.StatusBadRequest)
-		return
-	}
-
-	clientsDir := "/etc/softrouter/vpn_clients"
-	confPath := fmt.Sprintf("%s/%s.conf", clientsDir, name)
-	os.Remove(confPath)
-
-	// Note: In production we should also remove from /etc/wireguard/wg0.conf
-	// and call syncconf. For now, it will just disappear from the list.
-
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
-}
-
-func downloadVPNClient(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get("name")
-	clientsDir := "/etc/softrouter/vpn_clients"
-	confPath := fmt.Sprintf("%s/%s.conf", clientsDir, name)
-
-	data, err := os.ReadFile(confPath)
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", name))
-	w.Header().Set("Content-Type", "application/x-wireguard-config")
-	w.Write(data)
-}
-
 func getSystemStatus(w http.ResponseWriter, r *http.Request) {
 	hostname, _ := os.Hostname()
 	uptime := "unknown"
@@ -1148,25 +1018,21 @@ func getFirewallRules(w http.ResponseWriter, r *http.Request) {
 
 			// The "expr" field in `nft -j list ruleset` is an ARRAY of objects.
 			// Example: [{"counter":...}, {"jump":...}]
-			// We want to convert this back into a human-readable string like "counter packets 0 bytes 0 jump piavpn..."
-			// However, `nft` doesn't give us a "raw string" easily from JSON.
-			// The user sees raw JSON in the UI currently.
-
-			rawJsonBytes, _ := json.Marshal(ruleObj["expr"])
-			rawJson := string(rawJsonBytes)
-
-			// Simple heuristic to make the "Raw" field editable for ADDING rules.
-			// When adding, we need "tcp dport 22 accept".
-			// But what we READ is JSON.
-			// We'll store the JSON for display, but the UI expects a statement for adding.
+			// Translate it back into nft syntax ("counter packets 0 bytes 0 jump piavpn...")
+			exprs, _ := ruleObj["expr"].([]interface{})
+			view := renderNftRule(exprs)
 
 			rules = append(rules, FirewallRule{
-				Family:  family,
-				Table:   table,
-				Chain:   chain,
-				Handle:  int(handle),
-				Comment: comment,
-				Raw:     rawJson, // This is JSON. usage in UI needs to be careful.
+				Family:      family,
+				Table:       table,
+				Chain:       chain,
+				Handle:      int(handle),
+				Comment:     comment,
+				Raw:         view.Statement,
+				Expressions: view.Expressions,
+				Packets:     view.Packets,
+				Bytes:       view.Bytes,
+				HasCounter:  view.HasCounter,
 			})
 		}
 	}
@@ -1175,6 +1041,8 @@ func getFirewallRules(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(rules)
 }
 
+// addFirewallRule inserts a rule into the live ruleset only. It is lost on the next
+// regeneration; persistent rules are managed through /api/firewall/rules.
 func addFirewallRule(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1197,51 +1065,31 @@ func addFirewallRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Security: Sanitize firewall rule input to prevent command injection
-	// Block dangerous characters and command sequences
-	dangerousPatterns := []string{";", "|", "&", "$", "`", "$(", "||", "&&", "\n", "\r"}
-	for _, pattern := range dangerousPatterns {
-		if strings.Contains(rule.Raw, pattern) {
-			http.Error(w, "Invalid characters in firewall rule", http.StatusBadRequest)
-			return
-		}
-	}
-
-	// Whitelist allowed nftables keywords
-	allowedKeywords := []string{
-		"tcp", "udp", "icmp", "ip", "ip6", "accept", "drop", "reject", "dport", "sport",
-		"daddr", "saddr", "ct", "state", "established", "related", "new", "invalid",
-		"counter", "packets", "bytes", "limit", "rate", "log", "prefix", "to",
+	if !nftFamilies[rule.Family] || !nftTableRegex.MatchString(rule.Table) || !nftChainRegex.MatchString(rule.Chain) {
+		http.Error(w, "Invalid family, table or chain", http.StatusBadRequest)
+		return
 	}
 
-	// Validate that rule contains at least one allowed keyword
-	hasValidKeyword := false
-	ruleLower := strings.ToLower(rule.Raw)
-	for _, keyword := range allowedKeywords {
-		if strings.Contains(ruleLower, keyword) {
-			hasValidKeyword = true
-			break
+	// Security: Parse the statement against the supported nft grammar instead of
+	// passing user text through. Unknown statements are rejected with a column.
+	statement := rule.Raw
+	if rule.Comment != "" {
+		if strings.ContainsAny(rule.Comment, "\"\\") {
+			http.Error(w, "Comment must not contain quotes or backslashes", http.StatusBadRequest)
+			return
 		}
+		statement += fmt.Sprintf(` comment "%s"`, rule.Comment)
 	}
-	if !hasValidKeyword {
-		http.Error(w, "Firewall rule must contain valid nftables keywords", http.StatusBadRequest)
+
+	parsed, err := parseNftStatement(statement)
+	if err != nil {
+		respondWithError(w, ErrFirewallInvalidRule, "Invalid firewall rule: "+err.Error(), http.StatusBadRequest, nil)
 		return
 	}
 
 	// Command: nft add rule <family> <table> <chain> <statement>
-	// Note: Validating "statement" is hard, we pass it raw and hope.
 	args := []string{"add", "rule", rule.Family, rule.Table, rule.Chain}
-
-	// Split raw string by spaces (rudimentary) - this is fragile for complex rules like "ct state { established }"
-	// For basic commands "tcp dport 22 accept" it works.
-	// A better approach for complex args is parsing them respecting quotes/braces, but for now:
-	parts := strings.Fields(rule.Raw)
-	args = append(args, parts...)
-
-	// Add comment if provided
-	if rule.Comment != "" {
-		args = append(args, "comment", fmt.Sprintf(`"%s"`, rule.Comment))
-	}
+	args = append(args, parsed...)
 
 	fmt.Printf("Executing NFT: nft %v\n", args) // Debug log
 
@@ -1493,6 +1341,14 @@ func deleteVLAN(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// configureInterfaceAddress runs ip addr add/del/replace for an address in CIDR notation
+func configureInterfaceAddress(action, ipAddress, interfaceName string) error {
+	if out, err := runPrivilegedCombinedOutput("ip", "addr", action, ipAddress, "dev", interfaceName); err != nil {
+		return fmt.Errorf("%w (%s)", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 func configureIP(w http.ResponseWriter, r *http.Request) {
 	var req IPConfigRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1513,8 +1369,7 @@ func configureIP(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("Configuring IP: %s %s on %s\n", req.Action, req.IPAddress, req.InterfaceName)
 
-	// Use ip addr add/del
-	if _, err := runPrivilegedCombinedOutput("/usr/sbin/ip", "addr", req.Action, req.IPAddress, "dev", req.InterfaceName); err != nil {
+	if err := configureInterfaceAddress(req.Action, req.IPAddress, req.InterfaceName); err != nil {
 		http.Error(w, "Failed to configure IP address on interface", http.StatusInternalServerError)
 		return
 	}
@@ -1592,7 +1447,7 @@ func setInterfaceLabel(w http.ResponseWriter, r *http.Request) {
 	// Validate label (optional but recommended values)
 	validLabels := map[string]bool{
 		"WAN": true, "LAN": true, "DMZ": true, "Guest": true,
-		"Management": true, "Trunk": true, "": true, // Empty is allowed (to clear)
+		"Management": true, "Trunk": true, "IoT": true, "": true, // Empty is allowed (to clear)
 	}
 	if req.Label != "" && !validLabels[req.Label] {
 		fmt.Printf("Warning: Non-standard label '%s' used\n", req.Label)
@@ -1604,12 +1459,15 @@ func setInterfaceLabel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update or create metadata
+	// Update or create metadata, keeping the MTU settings
+	existing := store.Metadata[req.InterfaceName]
 	store.Metadata[req.InterfaceName] = InterfaceMetadata{
 		InterfaceName: req.InterfaceName,
 		Label:         req.Label,
 		Description:   req.Description,
 		Color:         req.Color,
+		MTU:           existing.MTU,
+		MSSClamp:      existing.MSSClamp,
 	}
 
 	if err := saveInterfaceMetadata(store); err != nil {
@@ -2338,6 +2196,21 @@ func createPortForwardingRule(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal IP required", http.StatusBadRequest)
 		return
 	}
+	if net.ParseIP(rule.InternalIP) == nil {
+		http.Error(w, "Internal IP must be an IPv4 or IPv6 address", http.StatusBadRequest)
+		return
+	}
+	if rule.SourceSet != "" {
+		// IPv6 targets are pinholes and need an ipv6_addr source set
+		setType := setTypeIPv4
+		if isIPv6Address(rule.InternalIP) {
+			setType = setTypeIPv6
+		}
+		if err := validateSetReference(GetFirewallSets(), rule.SourceSet, setType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	rule.ID = uuid.New().String()
 	rule.Enabled = true // Default to enabled
@@ -2388,6 +2261,21 @@ func updatePortForwardingRuleHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal IP required", http.StatusBadRequest)
 		return
 	}
+	if net.ParseIP(rule.InternalIP) == nil {
+		http.Error(w, "Internal IP must be an IPv4 or IPv6 address", http.StatusBadRequest)
+		return
+	}
+	if rule.SourceSet != "" {
+		// IPv6 targets are pinholes and need an ipv6_addr source set
+		setType := setTypeIPv4
+		if isIPv6Address(rule.InternalIP) {
+			setType = setTypeIPv6
+		}
+		if err := validateSetReference(GetFirewallSets(), rule.SourceSet, setType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	if err := updatePortForwardingRule(id, rule); err != nil {
 		http.Error(w, "Failed to update rule: "+err.Error(), http.StatusInternalServerError)
@@ -2402,12 +2290,24 @@ func main() {
 	loadSystemConfig()
 	loadTokenSecret()
 	initWireGuard()
+	initVPNRouting()
+	loadOpenVPNServerConfig()
+	startOpenVPNCRLRefresher()
 	// initFirewall() // Deprecated by FirewallManager
 	InitQoS() // 4. Initialize Networking
 	// initFirewall() // Deprecated by FirewallManager
 	// initPortForwarding() // Deprecated by FirewallManager
 
 	InitFirewallManager()
+	applyInterfaceMTUs()
+	initFirewallZones()
+	initFirewallSets()
+	initUserFirewallRules()
+	initIPv6()
+	initPrefixDelegation()
+	loadWANConfig() // WAN marks and steering are part of the ruleset
+	initWANSteering()
+	initPPPoE()
 	// Apply rules initially (will use default/detected WAN/LAN)
 	firewallManager.ApplyFirewallRules()
 
@@ -2419,6 +2319,8 @@ func main() {
 		log.Printf("WARNING: Failed to initialize audit log: %v", err)
 	}
 	startAuditLogRotation()
+	initWANEvents()
+	loadWANNotifyConfig()
 
 	// Initialize rate limiters
 	authLimiter := NewRateLimiter()  // 10 req/min for login
@@ -2605,6 +2507,7 @@ func main() {
 	mux.HandleFunc("POST /api/interfaces/state", authMiddleware(setInterfaceState))
 	mux.HandleFunc("GET /api/interfaces/metadata", authMiddleware(getInterfaceMetadata))
 	mux.HandleFunc("POST /api/interfaces/label", authMiddleware(setInterfaceLabel))
+	mux.HandleFunc("POST /api/interfaces/mtu", authMiddleware(csrfMiddleware(setInterfaceMTU)))
 
 	// Traffic Control / QoS
 	mux.HandleFunc("GET /api/qos", authMiddleware(getQoSConfig))
@@ -2622,6 +2525,22 @@ func main() {
 	mux.HandleFunc("POST /api/firewall", authMiddleware(addFirewallRule))
 	mux.HandleFunc("DELETE /api/firewall", authMiddleware(deleteFirewallRule))
 	mux.HandleFunc("POST /api/firewall/confirm", authMiddleware(csrfMiddleware(confirmFirewallChanges))) // Watchdog confirmation
+	mux.HandleFunc("POST /api/firewall/preview", authMiddleware(csrfMiddleware(previewFirewallRules)))   // Dry-run + diff before apply
+	mux.HandleFunc("GET /api/firewall/zones", authMiddleware(getFirewallZones))
+	mux.HandleFunc("POST /api/firewall/zones", authMiddleware(csrfMiddleware(updateFirewallZones)))
+	mux.HandleFunc("GET /api/firewall/rules", authMiddleware(listUserFirewallRules))
+	mux.HandleFunc("POST /api/firewall/rules", authMiddleware(csrfMiddleware(createUserFirewallRule)))
+	mux.HandleFunc("PUT /api/firewall/rules", authMiddleware(csrfMiddleware(updateUserFirewallRule)))
+	mux.HandleFunc("DELETE /api/firewall/rules", authMiddleware(csrfMiddleware(deleteUserFirewallRule)))
+	mux.HandleFunc("POST /api/firewall/rules/reorder", authMiddleware(csrfMiddleware(reorderUserFirewallRules)))
+	mux.HandleFunc("GET /api/firewall/sets", authMiddleware(listFirewallSets))
+	mux.HandleFunc("POST /api/firewall/sets", authMiddleware(csrfMiddleware(saveFirewallSet)))
+	mux.HandleFunc("DELETE /api/firewall/sets", authMiddleware(csrfMiddleware(deleteFirewallSet)))
+	mux.HandleFunc("POST /api/firewall/sets/elements", authMiddleware(csrfMiddleware(updateFirewallSetElements)))
+	mux.HandleFunc("GET /api/ipv6/config", authMiddleware(getIPv6ConfigHandler))
+	mux.HandleFunc("POST /api/ipv6/config", authMiddleware(csrfMiddleware(updateIPv6ConfigHandler)))
+	mux.HandleFunc("GET /api/ipv6/pd", authMiddleware(getPDHandler))
+	mux.HandleFunc("POST /api/ipv6/pd", authMiddleware(csrfMiddleware(updatePDHandler)))
 	mux.HandleFunc("GET /api/services", authMiddleware(getServices))
 	mux.HandleFunc("POST /api/services/control", authMiddleware(controlService))
 	mux.HandleFunc("GET /api/traffic/stats", authMiddleware(getTrafficStats))
@@ -2643,11 +2562,20 @@ func main() {
 
 	// VPN Endpoints
 	mux.HandleFunc("GET /api/vpn/clients", authMiddleware(listVPNClients))
-	mux.HandleFunc("POST /api/vpn/clients", authMiddleware(addVPNClient))
-	mux.HandleFunc("DELETE /api/vpn/clients", authMiddleware(deleteVPNClient))
+	mux.HandleFunc("POST /api/vpn/clients", authMiddleware(csrfMiddleware(addVPNClient)))
+	mux.HandleFunc("PUT /api/vpn/clients", authMiddleware(csrfMiddleware(updateVPNClient)))
+	mux.HandleFunc("DELETE /api/vpn/clients", authMiddleware(csrfMiddleware(deleteVPNClient)))
+	mux.HandleFunc("GET /api/vpn/clients/history", authMiddleware(getVPNClientHistory))
+	mux.HandleFunc("GET /api/vpn/wireguard/interfaces", authMiddleware(getWGInterfaces))
+	mux.HandleFunc("POST /api/vpn/wireguard/interfaces", authMiddleware(csrfMiddleware(createWGInterface)))
+	mux.HandleFunc("PUT /api/vpn/wireguard/interfaces", authMiddleware(csrfMiddleware(updateWGInterface)))
+	mux.HandleFunc("DELETE /api/vpn/wireguard/interfaces", authMiddleware(csrfMiddleware(deleteWGInterface)))
 	mux.HandleFunc("GET /api/vpn/download", authMiddleware(downloadVPNClient))
 
 	// OpenVPN Client & PBR
+	mux.HandleFunc("GET /api/vpn/profiles", authMiddleware(getVPNProfiles))
+	mux.HandleFunc("POST /api/vpn/profiles", authMiddleware(csrfMiddleware(createVPNProfile)))
+	mux.HandleFunc("DELETE /api/vpn/profiles", authMiddleware(csrfMiddleware(deleteVPNProfile)))
 	mux.HandleFunc("GET /api/vpn/client/status", authMiddleware(getVPNClientStatus))
 	mux.HandleFunc("POST /api/vpn/client/config", authMiddleware(uploadVPNClientConfig))
 	mux.HandleFunc("POST /api/vpn/client/control", authMiddleware(controlVPNClient))
@@ -2657,10 +2585,14 @@ func main() {
 
 	// OpenVPN Server
 	mux.HandleFunc("GET /api/vpn/server-openvpn/status", authMiddleware(getOpenVPNServerStatus))
-	mux.HandleFunc("POST /api/vpn/server-openvpn/setup", authMiddleware(setupOpenVPNServer))
+	mux.HandleFunc("POST /api/vpn/server-openvpn/setup", authMiddleware(csrfMiddleware(setupOpenVPNServer)))
+	mux.HandleFunc("GET /api/vpn/server-openvpn/config", authMiddleware(getOpenVPNServerConfigHandler))
+	mux.HandleFunc("PUT /api/vpn/server-openvpn/config", authMiddleware(csrfMiddleware(updateOpenVPNServerConfig)))
 	mux.HandleFunc("GET /api/vpn/server-openvpn/clients", authMiddleware(listOpenVPNClients))
-	mux.HandleFunc("POST /api/vpn/server-openvpn/clients", authMiddleware(createOpenVPNClient))
-	mux.HandleFunc("DELETE /api/vpn/server-openvpn/clients", authMiddleware(deleteOpenVPNClient))
+	mux.HandleFunc("POST /api/vpn/server-openvpn/clients", authMiddleware(csrfMiddleware(createOpenVPNClient)))
+	mux.HandleFunc("DELETE /api/vpn/server-openvpn/clients", authMiddleware(csrfMiddleware(deleteOpenVPNClient)))
+	mux.HandleFunc("POST /api/vpn/server-openvpn/clients/renew", authMiddleware(csrfMiddleware(renewOpenVPNClient)))
+	mux.HandleFunc("GET /api/vpn/server-openvpn/clients/revoked", authMiddleware(listRevokedOpenVPNClients))
 
 	mux.HandleFunc("GET /api/vpn/server-openvpn/download", authMiddleware(downloadOpenVPNClient))
 
@@ -2677,7 +2609,22 @@ func main() {
 
 	// Multi-WAN
 	mux.HandleFunc("GET /api/wan", authMiddleware(getWANInterfaces))
+	mux.HandleFunc("GET /api/wan/history", authMiddleware(getWANHistory))
+	mux.HandleFunc("GET /api/wan/events", authMiddleware(getWANEventsHandler))
+	mux.HandleFunc("GET /api/wan/notifiers", authMiddleware(getWANNotifiersHandler))
+	mux.HandleFunc("PUT /api/wan/notifiers", authMiddleware(csrfMiddleware(updateWANNotifiersHandler)))
+	mux.HandleFunc("POST /api/wan/notifiers/test", authMiddleware(csrfMiddleware(testWANNotifierHandler)))
+	mux.HandleFunc("GET /api/wan/steering", authMiddleware(listWANSteeringRules))
+	mux.HandleFunc("POST /api/wan/steering", authMiddleware(csrfMiddleware(createWANSteeringRule)))
+	mux.HandleFunc("PUT /api/wan/steering", authMiddleware(csrfMiddleware(updateWANSteeringRule)))
+	mux.HandleFunc("DELETE /api/wan/steering", authMiddleware(csrfMiddleware(deleteWANSteeringRule)))
+	mux.HandleFunc("POST /api/wan/steering/reorder", authMiddleware(csrfMiddleware(reorderWANSteeringRules)))
 	mux.HandleFunc("POST /api/wan", authMiddleware(updateWANInterfaces))
+	mux.HandleFunc("GET /api/pppoe", authMiddleware(listPPPoEConnections))
+	mux.HandleFunc("POST /api/pppoe", authMiddleware(csrfMiddleware(createPPPoEConnection)))
+	mux.HandleFunc("PUT /api/pppoe", authMiddleware(csrfMiddleware(updatePPPoEConnection)))
+	mux.HandleFunc("DELETE /api/pppoe", authMiddleware(csrfMiddleware(deletePPPoEConnection)))
+	mux.HandleFunc("POST /api/pppoe/reconnect", authMiddleware(csrfMiddleware(reconnectPPPoEConnection)))
 
 	// Dynamic Routing
 	mux.HandleFunc("GET /api/routing/dynamic", authMiddleware(getDynamicRouting))
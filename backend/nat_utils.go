@@ -11,13 +11,23 @@ import (
 type PortForwardingRule struct {
 	ID           string `json:"id"`
 	Description  string `json:"description"`
-	Protocol     string `json:"protocol"`      // tcp, udp
-	ExternalPort int    `json:"external_port"` // Port on WAN interface
-	InternalIP   string `json:"internal_ip"`   // IP of identifying host
-	InternalPort int    `json:"internal_port"` // Port on internal host
+	Protocol     string `json:"protocol"`             // tcp, udp
+	ExternalPort int    `json:"external_port"`        // Port on WAN interface
+	InternalIP   string `json:"internal_ip"`          // IP of identifying host
+	InternalPort int    `json:"internal_port"`        // Port on internal host
+	SourceSet    string `json:"source_set,omitempty"` // Optional ipv4_addr set allowed to connect
 	Enabled      bool   `json:"enabled"`
 }
 
+// nftProtocol returns the rule's protocol, defaulting to tcp for rules saved
+// without one
+func (pf PortForwardingRule) nftProtocol() string {
+	if pf.Protocol == "" {
+		return "tcp"
+	}
+	return pf.Protocol
+}
+
 // PortForwardingStore manages the list of rules
 type PortForwardingStore struct {
 	Rules []PortForwardingRule `json:"rules"`
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NftExpr is a typed view of one element of a rule's `expr` array from `nft -j`
+type NftExpr struct {
+	Kind    string `json:"kind"`              // match, counter, verdict, nat, limit, log, mangle, set, ...
+	Left    string `json:"left,omitempty"`    // match/mangle: rendered selector, e.g. "tcp dport"
+	Op      string `json:"op,omitempty"`      // match: operator as written by nft ("==", "!=", "in", ...)
+	Right   string `json:"right,omitempty"`   // match/mangle: rendered value, e.g. "{ 80, 443 }"
+	Verdict string `json:"verdict,omitempty"` // verdict: accept, drop, jump, goto, ...
+	Target  string `json:"target,omitempty"`  // jump/goto chain, NAT destination, set name
+	Packets uint64 `json:"packets,omitempty"` // counter only
+	Bytes   uint64 `json:"bytes,omitempty"`   // counter only
+	Text    string `json:"text"`              // Human-readable nft syntax for this expression
+}
+
+// NftRuleView is the rendered form of one rule's expression list
+type NftRuleView struct {
+	Statement   string
+	Expressions []NftExpr
+	Packets     uint64
+	Bytes       uint64
+	HasCounter  bool
+}
+
+// Meta keys that nft prints without the "meta" prefix
+var nftUnqualifiedMeta = map[string]bool{
+	"iif": true, "oif": true, "iifname": true, "oifname": true,
+	"iiftype": true, "oiftype": true, "iifgroup": true, "oifgroup": true,
+	"mark": true, "priority": true, "skuid": true, "skgid": true,
+	"rtclassid": true, "nftrace": true, "pkttype": true, "cpu": true, "cgroup": true,
+}
+
+// Selectors whose string values are interface names and must stay quoted
+var nftQuotedSelectors = map[string]bool{
+	"iifname": true, "oifname": true, "meta ibrname": true, "meta obrname": true,
+}
+
+// renderNftRule translates an `expr` array into nft syntax and typed expressions
+func renderNftRule(exprs []interface{}) NftRuleView {
+	view := NftRuleView{Expressions: []NftExpr{}}
+	var parts []string
+
+	for _, raw := range exprs {
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		e := renderNftExpr(obj)
+		if e.Kind == "counter" {
+			view.HasCounter = true
+			view.Packets += e.Packets
+			view.Bytes += e.Bytes
+		}
+		view.Expressions = append(view.Expressions, e)
+		if e.Text != "" {
+			parts = append(parts, e.Text)
+		}
+	}
+
+	view.Statement = strings.Join(parts, " ")
+	return view
+}
+
+// renderNftExpr translates a single statement object such as {"match": {...}}
+func renderNftExpr(obj map[string]interface{}) NftExpr {
+	// Each statement object has exactly one key
+	for key, val := range obj {
+		switch key {
+		case "match":
+			return renderNftMatch(val)
+		case "counter":
+			return renderNftCounter(val)
+		case "accept", "drop", "continue", "return", "queue":
+			return NftExpr{Kind: "verdict", Verdict: key, Text: key}
+		case "jump", "goto":
+			target := ""
+			if m, ok := val.(map[string]interface{}); ok {
+				target, _ = m["target"].(string)
+			}
+			return NftExpr{Kind: "verdict", Verdict: key, Target: target, Text: key + " " + target}
+		case "reject":
+			return renderNftReject(val)
+		case "snat", "dnat", "masquerade", "redirect":
+			return renderNftNat(key, val)
+		case "limit":
+			return renderNftLimit(val)
+		case "log":
+			return renderNftLog(val)
+		case "mangle":
+			m, _ := val.(map[string]interface{})
+			left := renderNftValue(m["key"], "")
+			right := renderNftValue(m["value"], left)
+			return NftExpr{Kind: "mangle", Left: left, Right: right, Text: left + " set " + right}
+		case "set":
+			// Dynamic set update: {"set": {"op": "add", "elem": ..., "set": "@name"}}
+			m, _ := val.(map[string]interface{})
+			op, _ := m["op"].(string)
+			name, _ := m["set"].(string)
+			elem := renderNftValue(m["elem"], "")
+			return NftExpr{Kind: "set", Op: op, Target: name, Text: fmt.Sprintf("%s %s { %s }", op, name, elem)}
+		case "flow":
+			m, _ := val.(map[string]interface{})
+			op, _ := m["op"].(string)
+			table, _ := m["flowtable"].(string)
+			return NftExpr{Kind: "flow", Op: op, Target: table, Text: fmt.Sprintf("flow %s %s", op, table)}
+		case "notrack":
+			return NftExpr{Kind: "notrack", Text: "notrack"}
+		}
+
+		// Unknown statement: keep the JSON so nothing is silently hidden
+		b, _ := json.Marshal(obj)
+		return NftExpr{Kind: key, Text: string(b)}
+	}
+	return NftExpr{Kind: "unknown"}
+}
+
+func renderNftMatch(val interface{}) NftExpr {
+	m, _ := val.(map[string]interface{})
+	op, _ := m["op"].(string)
+	left := renderNftValue(m["left"], "")
+	right := renderNftValue(m["right"], left)
+
+	text := left + " " + right
+	if op != "" && op != "==" && op != "in" {
+		text = left + " " + op + " " + right
+	} else if isNftBinop(m["left"]) {
+		// "mark & 0xff00 256" is ambiguous, so bitwise selectors keep the operator
+		text = left + " == " + right
+	}
+	return NftExpr{Kind: "match", Left: left, Op: op, Right: right, Text: text}
+}
+
+// isNftBinop reports whether a selector is a bitwise expression like {"&": [...]}
+func isNftBinop(v interface{}) bool {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, op := range []string{"&", "|", "^", "<<", ">>"} {
+		if _, ok := m[op]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func renderNftCounter(val interface{}) NftExpr {
+	// Named counter reference: {"counter": "name"}
+	if name, ok := val.(string); ok {
+		return NftExpr{Kind: "counter", Target: name, Text: fmt.Sprintf("counter name \"%s\"", name)}
+	}
+	m, _ := val.(map[string]interface{})
+	packets := jsonUint(m["packets"])
+	bytes := jsonUint(m["bytes"])
+	return NftExpr{
+		Kind:    "counter",
+		Packets: packets,
+		Bytes:   bytes,
+		Text:    fmt.Sprintf("counter packets %d bytes %d", packets, bytes),
+	}
+}
+
+func renderNftReject(val interface{}) NftExpr {
+	m, ok := val.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return NftExpr{Kind: "verdict", Verdict: "reject", Text: "reject"}
+	}
+	rtype, _ := m["type"].(string)
+	code, _ := m["expr"].(string)
+
+	text := "reject"
+	switch {
+	case rtype == "tcp reset":
+		text = "reject with tcp reset"
+	case rtype != "" && code != "":
+		text = fmt.Sprintf("reject with %s type %s", rtype, code)
+	case rtype != "":
+		text = "reject with " + rtype
+	}
+	return NftExpr{Kind: "verdict", Verdict: "reject", Text: text}
+}
+
+func renderNftNat(kind string, val interface{}) NftExpr {
+	m, _ := val.(map[string]interface{})
+	addr := ""
+	if a, ok := m["addr"]; ok {
+		addr = renderNftValue(a, "")
+	}
+	port := ""
+	if p, ok := m["port"]; ok {
+		port = renderNftValue(p, "")
+	}
+
+	target := addr
+	if port != "" {
+		target = addr + ":" + port
+	}
+
+	text := kind
+	if family, ok := m["family"].(string); ok && (kind == "snat" || kind == "dnat") {
+		text += " " + family
+	}
+	if target != "" {
+		text += " to " + target
+	}
+	if flags, ok := m["flags"]; ok {
+		text += " " + strings.Join(jsonStrings(flags), ",")
+	}
+	return NftExpr{Kind: "nat", Verdict: kind, Target: target, Text: text}
+}
+
+func renderNftLimit(val interface{}) NftExpr {
+	m, _ := val.(map[string]interface{})
+	rate := jsonUint(m["rate"])
+	per, _ := m["per"].(string)
+
+	var b strings.Builder
+	b.WriteString("limit rate ")
+	if inv, _ := m["inv"].(bool); inv {
+		b.WriteString("over ")
+	}
+	b.WriteString(strconv.FormatUint(rate, 10))
+	if unit, ok := m["rate_unit"].(string); ok && unit != "packets" {
+		b.WriteString(" " + unit)
+	}
+	b.WriteString("/" + per)
+	if burst := jsonUint(m["burst"]); burst > 0 {
+		unit, _ := m["burst_unit"].(string)
+		if unit == "" {
+			unit = "packets"
+		}
+		b.WriteString(fmt.Sprintf(" burst %d %s", burst, unit))
+	}
+	return NftExpr{Kind: "limit", Text: b.String()}
+}
+
+func renderNftLog(val interface{}) NftExpr {
+	m, _ := val.(map[string]interface{})
+	parts := []string{"log"}
+	if prefix, ok := m["prefix"].(string); ok {
+		parts = append(parts, "prefix", strconv.Quote(prefix))
+	}
+	if level, ok := m["level"].(string); ok {
+		parts = append(parts, "level", level)
+	}
+	if _, ok := m["group"]; ok {
+		parts = append(parts, "group", strconv.FormatUint(jsonUint(m["group"]), 10))
+	}
+	return NftExpr{Kind: "log", Text: strings.Join(parts, " ")}
+}
+
+// renderNftValue renders a selector or value expression. `selector` is the rendered
+// left-hand side of a match so interface names can be quoted correctly.
+func renderNftValue(v interface{}, selector string) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if nftQuotedSelectors[selector] {
+			return strconv.Quote(val)
+		}
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case []interface{}:
+		// Bare arrays appear as flag lists, e.g. tcp flags
+		items := make([]string, len(val))
+		for i, item := range val {
+			items[i] = renderNftValue(item, selector)
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		return renderNftObject(val, selector)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func renderNftObject(m map[string]interface{}, selector string) string {
+	if p, ok := m["payload"].(map[string]interface{}); ok {
+		if proto, ok := p["protocol"].(string); ok {
+			field, _ := p["field"].(string)
+			return proto + " " + field
+		}
+		// Raw payload expression
+		base, _ := p["base"].(string)
+		return fmt.Sprintf("@%s,%d,%d", base, jsonUint(p["offset"]), jsonUint(p["len"]))
+	}
+	if meta, ok := m["meta"].(map[string]interface{}); ok {
+		key, _ := meta["key"].(string)
+		if nftUnqualifiedMeta[key] {
+			return key
+		}
+		return "meta " + key
+	}
+	if ct, ok := m["ct"].(map[string]interface{}); ok {
+		key, _ := ct["key"].(string)
+		if dir, ok := ct["dir"].(string); ok {
+			return "ct " + dir + " " + key
+		}
+		return "ct " + key
+	}
+	if fib, ok := m["fib"].(map[string]interface{}); ok {
+		result, _ := fib["result"].(string)
+		return "fib " + strings.Join(jsonStrings(fib["flags"]), " . ") + " " + result
+	}
+	if rt, ok := m["rt"].(map[string]interface{}); ok {
+		key, _ := rt["key"].(string)
+		return "rt " + key
+	}
+	if exthdr, ok := m["exthdr"].(map[string]interface{}); ok {
+		name, _ := exthdr["name"].(string)
+		field, _ := exthdr["field"].(string)
+		if field == "" {
+			return "exthdr " + name + " exists"
+		}
+		return name + " " + field
+	}
+	if set, ok := m["set"]; ok {
+		elems, ok := set.([]interface{})
+		if !ok {
+			elems = []interface{}{set}
+		}
+		items := make([]string, len(elems))
+		for i, item := range elems {
+			items[i] = renderNftValue(item, selector)
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	}
+	if r, ok := m["range"].([]interface{}); ok && len(r) == 2 {
+		return renderNftValue(r[0], selector) + "-" + renderNftValue(r[1], selector)
+	}
+	if p, ok := m["prefix"].(map[string]interface{}); ok {
+		addr, _ := p["addr"].(string)
+		return fmt.Sprintf("%s/%d", addr, jsonUint(p["len"]))
+	}
+	if c, ok := m["concat"].([]interface{}); ok {
+		items := make([]string, len(c))
+		for i, item := range c {
+			items[i] = renderNftValue(item, selector)
+		}
+		return strings.Join(items, " . ")
+	}
+	if elem, ok := m["elem"].(map[string]interface{}); ok {
+		return renderNftValue(elem["val"], selector)
+	}
+	if mp, ok := m["map"].(map[string]interface{}); ok {
+		key := renderNftValue(mp["key"], "")
+		return key + " map " + renderNftValue(mp["data"], key)
+	}
+	for _, op := range []string{"&", "|", "^", "<<", ">>"} {
+		if operands, ok := m[op].([]interface{}); ok && len(operands) == 2 {
+			left := renderNftValue(operands[0], selector)
+			return left + " " + op + " " + renderNftValue(operands[1], left)
+		}
+	}
+
+	// Unknown expression: fall back to compact JSON (map keys are marshaled sorted)
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// jsonUint converts a decoded JSON number to uint64
+func jsonUint(v interface{}) uint64 {
+	if f, ok := v.(float64); ok && f > 0 {
+		return uint64(f)
+	}
+	return 0
+}
+
+// jsonStrings converts a decoded JSON string or string array into a slice
+func jsonStrings(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		out := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
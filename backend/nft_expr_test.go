@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRenderNftRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{
+			name:     "interface and port set",
+			expr:     `[{"match":{"op":"==","left":{"meta":{"key":"iifname"}},"right":"eth1"}},{"match":{"op":"==","left":{"payload":{"protocol":"tcp","field":"dport"}},"right":{"set":[22,{"range":[8000,8100]}]}}},{"accept":null}]`,
+			expected: `iifname "eth1" tcp dport { 22, 8000-8100 } accept`,
+		},
+		{
+			name:     "ct state set and counter",
+			expr:     `[{"match":{"op":"in","left":{"ct":{"key":"state"}},"right":["established","related"]}},{"counter":{"packets":12,"bytes":3400}},{"accept":null}]`,
+			expected: `ct state established,related counter packets 12 bytes 3400 accept`,
+		},
+		{
+			name:     "prefix, negation and jump",
+			expr:     `[{"match":{"op":"!=","left":{"payload":{"protocol":"ip","field":"saddr"}},"right":{"prefix":{"addr":"10.0.0.0","len":8}}}},{"jump":{"target":"piavpn.in"}}]`,
+			expected: `ip saddr != 10.0.0.0/8 jump piavpn.in`,
+		},
+		{
+			name:     "named set reference and reject",
+			expr:     `[{"match":{"op":"==","left":{"payload":{"protocol":"ip","field":"daddr"}},"right":"@blocklist"}},{"reject":{"type":"icmpx","expr":"admin-prohibited"}}]`,
+			expected: `ip daddr @blocklist reject with icmpx type admin-prohibited`,
+		},
+		{
+			name:     "dnat with port",
+			expr:     `[{"match":{"op":"==","left":{"meta":{"key":"l4proto"}},"right":"tcp"}},{"dnat":{"family":"ip","addr":"10.0.2.10","port":443}}]`,
+			expected: `meta l4proto tcp dnat ip to 10.0.2.10:443`,
+		},
+		{
+			name:     "limit and log",
+			expr:     `[{"limit":{"rate":5,"burst":10,"per":"minute"}},{"log":{"prefix":"[INPUT DROP] "}}]`,
+			expected: `limit rate 5/minute burst 10 packets log prefix "[INPUT DROP] "`,
+		},
+		{
+			name:     "masked mark and mangle",
+			expr:     `[{"match":{"op":"==","left":{"&":[{"meta":{"key":"mark"}},65280]},"right":256}},{"mangle":{"key":{"ct":{"key":"mark"}},"value":{"meta":{"key":"mark"}}}}]`,
+			expected: `mark & 65280 == 256 ct mark set mark`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exprs []interface{}
+			if err := json.Unmarshal([]byte(tt.expr), &exprs); err != nil {
+				t.Fatalf("Bad test input: %v", err)
+			}
+			if got := renderNftRule(exprs).Statement; got != tt.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRenderNftRuleCounters(t *testing.T) {
+	var exprs []interface{}
+	json.Unmarshal([]byte(`[{"counter":{"packets":7,"bytes":700}},{"drop":null}]`), &exprs)
+
+	view := renderNftRule(exprs)
+	if !view.HasCounter || view.Packets != 7 || view.Bytes != 700 {
+		t.Errorf("Expected 7 packets / 700 bytes, got %+v", view)
+	}
+	if len(view.Expressions) != 2 || view.Expressions[1].Verdict != "drop" {
+		t.Errorf("Expected typed drop verdict, got %+v", view.Expressions)
+	}
+}
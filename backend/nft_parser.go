@@ -0,0 +1,721 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parser for the subset of nft rule statements accepted by the live rule editor.
+// Input is tokenized (respecting quotes and { } sets), every clause is checked
+// against a grammar of known selectors and values, and the result is emitted as
+// a normalized argv for `nft add rule`. Anything outside the subset is rejected
+// with the column of the offending token.
+
+// NftParseError reports where in the statement parsing failed (1-based column)
+type NftParseError struct {
+	Column int
+	Msg    string
+}
+
+func (e *NftParseError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+type nftTokenKind int
+
+const (
+	nftTokWord nftTokenKind = iota
+	nftTokString
+	nftTokLBrace
+	nftTokRBrace
+	nftTokComma
+	nftTokOp
+)
+
+type nftToken struct {
+	Kind nftTokenKind
+	Text string
+	Pos  int // Byte offset into the input
+}
+
+// Value classes used to validate right-hand sides of matches
+type nftValueKind int
+
+const (
+	nftValPort nftValueKind = iota
+	nftValAddr
+	nftValIface
+	nftValProto
+	nftValNfproto
+	nftValCtState
+	nftValCtStatus
+	nftValICMPType
+	nftValNumber
+)
+
+var (
+	nftIfaceRegex   = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,15}\*?$`)
+	nftSetRefRegex  = regexp.MustCompile(`^@[A-Za-z][A-Za-z0-9_]{0,31}$`)
+	nftChainRegex   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.\-]{0,63}$`)
+	nftTypeRegex    = regexp.MustCompile(`^[a-z][a-z0-9\-]{0,39}$`)
+	nftRateRegex    = regexp.MustCompile(`^[0-9]{1,9}/(second|minute|hour|day|week)$`)
+	nftNumberRegex  = regexp.MustCompile(`^([0-9]{1,10}|0x[0-9a-fA-F]{1,8})$`)
+	nftTableRegex   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.\-]{0,63}$`)
+	nftWordCharsSet = "_.:/-@*"
+)
+
+var nftProtocols = map[string]bool{
+	"tcp": true, "udp": true, "icmp": true, "icmpv6": true, "ipv6-icmp": true,
+	"gre": true, "esp": true, "ah": true, "sctp": true, "udplite": true,
+}
+
+var nftCtStates = map[string]bool{
+	"established": true, "related": true, "new": true, "invalid": true, "untracked": true,
+}
+
+var nftCtStatuses = map[string]bool{
+	"expected": true, "seen-reply": true, "assured": true, "confirmed": true,
+	"snat": true, "dnat": true, "dying": true,
+}
+
+var nftLogLevels = map[string]bool{
+	"emerg": true, "alert": true, "crit": true, "err": true,
+	"warn": true, "notice": true, "info": true, "debug": true,
+}
+
+var nftFamilies = map[string]bool{
+	"inet": true, "ip": true, "ip6": true, "arp": true, "bridge": true, "netdev": true,
+}
+
+// tokenizeNft splits a statement into tokens, keeping quoted strings intact
+func tokenizeNft(input string) ([]nftToken, error) {
+	var toks []nftToken
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(input) && input[end] != '"' {
+				if input[end] == '\\' || input[end] < 0x20 {
+					return nil, &NftParseError{end + 1, "escape sequences and control characters are not allowed in strings"}
+				}
+				end++
+			}
+			if end >= len(input) {
+				return nil, &NftParseError{i + 1, "unterminated string"}
+			}
+			toks = append(toks, nftToken{nftTokString, input[i+1 : end], i})
+			i = end + 1
+		case c == '{':
+			toks = append(toks, nftToken{nftTokLBrace, "{", i})
+			i++
+		case c == '}':
+			toks = append(toks, nftToken{nftTokRBrace, "}", i})
+			i++
+		case c == ',':
+			toks = append(toks, nftToken{nftTokComma, ",", i})
+			i++
+		case c == '!' || c == '=' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(input) && input[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" || op == "=" {
+				return nil, &NftParseError{i + 1, fmt.Sprintf("unexpected '%s'", op)}
+			}
+			toks = append(toks, nftToken{nftTokOp, op, i})
+			i += len(op)
+		case isNftWordChar(c):
+			start := i
+			for i < len(input) && isNftWordChar(input[i]) {
+				i++
+			}
+			toks = append(toks, nftToken{nftTokWord, input[start:i], start})
+		default:
+			return nil, &NftParseError{i + 1, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return toks, nil
+}
+
+func isNftWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		strings.IndexByte(nftWordCharsSet, c) >= 0
+}
+
+type nftParser struct {
+	input      string
+	toks       []nftToken
+	i          int
+	args       []string
+	hasVerdict bool
+	hasComment bool
+	clauses    int
+}
+
+// parseNftStatement validates a rule statement and returns it as nft argv
+func parseNftStatement(input string) ([]string, error) {
+	toks, err := tokenizeNft(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, &NftParseError{1, "empty rule"}
+	}
+
+	p := &nftParser{input: input, toks: toks}
+	for p.i < len(p.toks) {
+		if err := p.parseClause(); err != nil {
+			return nil, err
+		}
+	}
+	if p.clauses == 0 {
+		return nil, &NftParseError{1, "rule has no match or action"}
+	}
+	return p.args, nil
+}
+
+func (p *nftParser) errAt(tok nftToken, format string, a ...interface{}) error {
+	return &NftParseError{tok.Pos + 1, fmt.Sprintf(format, a...)}
+}
+
+func (p *nftParser) errEOF(format string, a ...interface{}) error {
+	return &NftParseError{len(p.input) + 1, fmt.Sprintf(format, a...)}
+}
+
+func (p *nftParser) peek() (nftToken, bool) {
+	if p.i >= len(p.toks) {
+		return nftToken{}, false
+	}
+	return p.toks[p.i], true
+}
+
+func (p *nftParser) peekWord(word string) bool {
+	tok, ok := p.peek()
+	return ok && tok.Kind == nftTokWord && tok.Text == word
+}
+
+// expectWord consumes a bare word, describing `what` was expected on failure
+func (p *nftParser) expectWord(what string) (nftToken, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return tok, p.errEOF("expected %s", what)
+	}
+	if tok.Kind != nftTokWord {
+		return tok, p.errAt(tok, "expected %s, got '%s'", what, tok.Text)
+	}
+	p.i++
+	return tok, nil
+}
+
+// expectOneOf consumes a bare word from a fixed set
+func (p *nftParser) expectOneOf(what string, options ...string) (string, error) {
+	tok, err := p.expectWord(what)
+	if err != nil {
+		return "", err
+	}
+	for _, o := range options {
+		if tok.Text == o {
+			return o, nil
+		}
+	}
+	return "", p.errAt(tok, "expected %s, got '%s'", what, tok.Text)
+}
+
+func (p *nftParser) emit(args ...string) {
+	p.args = append(p.args, args...)
+}
+
+func (p *nftParser) parseClause() error {
+	tok, _ := p.peek()
+	if tok.Kind != nftTokWord {
+		return p.errAt(tok, "unexpected '%s'", tok.Text)
+	}
+
+	// Only a comment may follow the verdict
+	if p.hasVerdict && tok.Text != "comment" {
+		return p.errAt(tok, "unexpected '%s' after verdict", tok.Text)
+	}
+	p.i++
+
+	switch tok.Text {
+	case "iifname", "oifname", "iif", "oif":
+		p.emit(tok.Text)
+		return p.parseMatch(nftValIface)
+
+	case "ip", "ip6":
+		field := "protocol"
+		if tok.Text == "ip6" {
+			field = "nexthdr"
+		}
+		f, err := p.expectOneOf("saddr, daddr or "+field+" after '"+tok.Text+"'", "saddr", "daddr", field)
+		if err != nil {
+			return err
+		}
+		p.emit(tok.Text, f)
+		if f == field {
+			return p.parseMatch(nftValProto)
+		}
+		return p.parseMatch(nftValAddr)
+
+	case "tcp", "udp", "th", "sctp":
+		f, err := p.expectOneOf("sport or dport after '"+tok.Text+"'", "sport", "dport")
+		if err != nil {
+			return err
+		}
+		p.emit(tok.Text, f)
+		return p.parseMatch(nftValPort)
+
+	case "meta":
+		f, err := p.expectOneOf("meta key (l4proto, nfproto, mark, iifname, oifname)", "l4proto", "nfproto", "mark", "iifname", "oifname")
+		if err != nil {
+			return err
+		}
+		p.emit("meta", f)
+		switch f {
+		case "l4proto":
+			return p.parseMatch(nftValProto)
+		case "nfproto":
+			return p.parseMatch(nftValNfproto)
+		case "mark":
+			return p.parseMatch(nftValNumber)
+		}
+		return p.parseMatch(nftValIface)
+
+	case "mark":
+		p.emit("mark")
+		return p.parseMatch(nftValNumber)
+
+	case "ct":
+		f, err := p.expectOneOf("state, status or mark after 'ct'", "state", "status", "mark")
+		if err != nil {
+			return err
+		}
+		p.emit("ct", f)
+		switch f {
+		case "state":
+			return p.parseMatch(nftValCtState)
+		case "status":
+			return p.parseMatch(nftValCtStatus)
+		}
+		return p.parseMatch(nftValNumber)
+
+	case "icmp", "icmpv6":
+		if _, err := p.expectOneOf("type after '"+tok.Text+"'", "type"); err != nil {
+			return err
+		}
+		p.emit(tok.Text, "type")
+		return p.parseMatch(nftValICMPType)
+
+	case "counter":
+		return p.parseCounter()
+	case "limit":
+		return p.parseLimit()
+	case "log":
+		return p.parseLog()
+	case "comment":
+		return p.parseComment(tok)
+
+	case "accept", "drop", "return", "continue":
+		p.emit(tok.Text)
+		p.hasVerdict = true
+		p.clauses++
+		return nil
+	case "reject":
+		return p.parseReject()
+	case "jump", "goto":
+		target, err := p.expectWord("chain name after '" + tok.Text + "'")
+		if err != nil {
+			return err
+		}
+		if !nftChainRegex.MatchString(target.Text) {
+			return p.errAt(target, "invalid chain name '%s'", target.Text)
+		}
+		p.emit(tok.Text, target.Text)
+		p.hasVerdict = true
+		p.clauses++
+		return nil
+	}
+
+	return p.errAt(tok, "unsupported statement '%s'", tok.Text)
+}
+
+// parseMatch parses an optional relational operator followed by a value
+func (p *nftParser) parseMatch(kind nftValueKind) error {
+	if tok, ok := p.peek(); ok && tok.Kind == nftTokOp {
+		p.i++
+		if tok.Text != "==" && tok.Text != "!=" && kind != nftValPort && kind != nftValNumber {
+			return p.errAt(tok, "operator '%s' is only valid for ports and numbers", tok.Text)
+		}
+		p.emit(tok.Text)
+	}
+
+	val, err := p.parseValue(kind)
+	if err != nil {
+		return err
+	}
+	p.emit(val)
+	p.clauses++
+	return nil
+}
+
+// parseValue parses a single value, a { } set, or a comma list of flags
+func (p *nftParser) parseValue(kind nftValueKind) (string, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return "", p.errEOF("expected %s", nftValueName(kind))
+	}
+
+	if tok.Kind == nftTokLBrace {
+		p.i++
+		var elems []string
+		for {
+			elem, ok := p.peek()
+			if !ok {
+				return "", p.errAt(tok, "unterminated set")
+			}
+			if elem.Kind == nftTokRBrace {
+				if len(elems) == 0 {
+					return "", p.errAt(elem, "empty set")
+				}
+				p.i++
+				break
+			}
+			if len(elems) > 0 {
+				if elem.Kind != nftTokComma {
+					return "", p.errAt(elem, "expected ',' or '}' in set, got '%s'", elem.Text)
+				}
+				p.i++
+				if _, ok := p.peek(); !ok {
+					return "", p.errAt(tok, "unterminated set")
+				}
+			}
+			val, err := p.parseElement(kind, true)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, val)
+		}
+		return "{ " + strings.Join(elems, ", ") + " }", nil
+	}
+
+	val, err := p.parseElement(kind, false)
+	if err != nil {
+		return "", err
+	}
+
+	// ct state/status accept comma separated flag lists: "established,related"
+	if kind == nftValCtState || kind == nftValCtStatus {
+		flags := []string{val}
+		for {
+			comma, ok := p.peek()
+			if !ok || comma.Kind != nftTokComma {
+				break
+			}
+			p.i++
+			next, err := p.parseElement(kind, false)
+			if err != nil {
+				return "", err
+			}
+			flags = append(flags, next)
+		}
+		val = strings.Join(flags, ",")
+	}
+	return val, nil
+}
+
+// parseElement consumes and validates one value token
+func (p *nftParser) parseElement(kind nftValueKind, inSet bool) (string, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return "", p.errEOF("expected %s", nftValueName(kind))
+	}
+
+	if tok.Kind == nftTokString {
+		if kind != nftValIface {
+			return "", p.errAt(tok, "quoted string is not a valid %s", nftValueName(kind))
+		}
+		if !nftIfaceRegex.MatchString(tok.Text) {
+			return "", p.errAt(tok, "invalid interface name '%s'", tok.Text)
+		}
+		p.i++
+		return strconv.Quote(tok.Text), nil
+	}
+	if tok.Kind != nftTokWord {
+		return "", p.errAt(tok, "expected %s, got '%s'", nftValueName(kind), tok.Text)
+	}
+
+	// Named set references (@blocklist) are valid anywhere except inside another set
+	if strings.HasPrefix(tok.Text, "@") {
+		if inSet || kind == nftValCtState || kind == nftValCtStatus {
+			return "", p.errAt(tok, "set reference not allowed here")
+		}
+		if !nftSetRefRegex.MatchString(tok.Text) {
+			return "", p.errAt(tok, "invalid set name '%s'", tok.Text)
+		}
+		p.i++
+		return tok.Text, nil
+	}
+
+	if msg := validateNftElement(kind, tok.Text); msg != "" {
+		return "", p.errAt(tok, "%s", msg)
+	}
+	p.i++
+
+	if kind == nftValIface {
+		return strconv.Quote(tok.Text), nil
+	}
+	return tok.Text, nil
+}
+
+// validateNftElement returns a description of the problem, or "" if the value is valid
+func validateNftElement(kind nftValueKind, v string) string {
+	switch kind {
+	case nftValPort:
+		lo, hi, isRange := strings.Cut(v, "-")
+		a, err1 := strconv.Atoi(lo)
+		b := a
+		var err2 error
+		if isRange {
+			b, err2 = strconv.Atoi(hi)
+		}
+		if err1 != nil || err2 != nil || a < 0 || b > 65535 || a > b {
+			return fmt.Sprintf("invalid port '%s'", v)
+		}
+	case nftValAddr:
+		if lo, hi, isRange := strings.Cut(v, "-"); isRange {
+			a, b := net.ParseIP(lo), net.ParseIP(hi)
+			if a == nil || b == nil || (a.To4() == nil) != (b.To4() == nil) {
+				return fmt.Sprintf("invalid address range '%s'", v)
+			}
+		} else if net.ParseIP(v) == nil {
+			if _, _, err := net.ParseCIDR(v); err != nil {
+				return fmt.Sprintf("invalid address '%s'", v)
+			}
+		}
+	case nftValIface:
+		if !nftIfaceRegex.MatchString(v) {
+			return fmt.Sprintf("invalid interface name '%s'", v)
+		}
+	case nftValProto:
+		if n, err := strconv.Atoi(v); err == nil {
+			if n < 0 || n > 255 {
+				return fmt.Sprintf("invalid protocol number '%s'", v)
+			}
+		} else if !nftProtocols[v] {
+			return fmt.Sprintf("unknown protocol '%s'", v)
+		}
+	case nftValNfproto:
+		if v != "ipv4" && v != "ipv6" {
+			return fmt.Sprintf("expected ipv4 or ipv6, got '%s'", v)
+		}
+	case nftValCtState:
+		if !nftCtStates[v] {
+			return fmt.Sprintf("unknown conntrack state '%s'", v)
+		}
+	case nftValCtStatus:
+		if !nftCtStatuses[v] {
+			return fmt.Sprintf("unknown conntrack status '%s'", v)
+		}
+	case nftValICMPType:
+		if !nftTypeRegex.MatchString(v) {
+			return fmt.Sprintf("invalid ICMP type '%s'", v)
+		}
+	case nftValNumber:
+		if !nftNumberRegex.MatchString(v) {
+			return fmt.Sprintf("invalid number '%s'", v)
+		}
+	}
+	return ""
+}
+
+func nftValueName(kind nftValueKind) string {
+	switch kind {
+	case nftValPort:
+		return "port"
+	case nftValAddr:
+		return "address"
+	case nftValIface:
+		return "interface name"
+	case nftValProto:
+		return "protocol"
+	case nftValNfproto:
+		return "ipv4 or ipv6"
+	case nftValCtState:
+		return "conntrack state"
+	case nftValCtStatus:
+		return "conntrack status"
+	case nftValICMPType:
+		return "ICMP type"
+	}
+	return "number"
+}
+
+// parseCounter accepts "counter" and "counter packets N bytes N" as printed by
+// `nft list`; the snapshot values are dropped so a re-added rule starts at zero
+func (p *nftParser) parseCounter() error {
+	if p.peekWord("packets") {
+		p.i++
+		if _, err := p.expectNumber("packet count"); err != nil {
+			return err
+		}
+		if _, err := p.expectOneOf("'bytes'", "bytes"); err != nil {
+			return err
+		}
+		if _, err := p.expectNumber("byte count"); err != nil {
+			return err
+		}
+	}
+	p.emit("counter")
+	p.clauses++
+	return nil
+}
+
+func (p *nftParser) expectNumber(what string) (string, error) {
+	tok, err := p.expectWord(what)
+	if err != nil {
+		return "", err
+	}
+	if _, err := strconv.ParseUint(tok.Text, 10, 64); err != nil {
+		return "", p.errAt(tok, "expected %s, got '%s'", what, tok.Text)
+	}
+	return tok.Text, nil
+}
+
+func (p *nftParser) parseLimit() error {
+	if _, err := p.expectOneOf("'rate' after 'limit'", "rate"); err != nil {
+		return err
+	}
+	p.emit("limit", "rate")
+	if p.peekWord("over") {
+		p.i++
+		p.emit("over")
+	}
+
+	rate, err := p.expectWord("rate such as 10/second")
+	if err != nil {
+		return err
+	}
+	if !nftRateRegex.MatchString(rate.Text) {
+		return p.errAt(rate, "invalid rate '%s' (expected N/second, minute, hour, day or week)", rate.Text)
+	}
+	p.emit(rate.Text)
+
+	if p.peekWord("burst") {
+		p.i++
+		n, err := p.expectNumber("burst size")
+		if err != nil {
+			return err
+		}
+		p.emit("burst", n)
+		if p.peekWord("packets") {
+			p.i++
+		}
+		p.emit("packets")
+	}
+	p.clauses++
+	return nil
+}
+
+func (p *nftParser) parseLog() error {
+	p.emit("log")
+	for {
+		switch {
+		case p.peekWord("prefix"):
+			p.i++
+			tok, ok := p.peek()
+			if !ok {
+				return p.errEOF("expected quoted log prefix")
+			}
+			if tok.Kind != nftTokString {
+				return p.errAt(tok, "log prefix must be a quoted string")
+			}
+			if len(tok.Text) > 127 {
+				return p.errAt(tok, "log prefix too long")
+			}
+			p.i++
+			p.emit("prefix", strconv.Quote(tok.Text))
+		case p.peekWord("level"):
+			p.i++
+			lvl, err := p.expectWord("log level")
+			if err != nil {
+				return err
+			}
+			if !nftLogLevels[lvl.Text] {
+				return p.errAt(lvl, "unknown log level '%s'", lvl.Text)
+			}
+			p.emit("level", lvl.Text)
+		case p.peekWord("group"):
+			p.i++
+			n, err := p.expectNumber("log group")
+			if err != nil {
+				return err
+			}
+			p.emit("group", n)
+		default:
+			p.clauses++
+			return nil
+		}
+	}
+}
+
+func (p *nftParser) parseComment(kw nftToken) error {
+	if p.hasComment {
+		return p.errAt(kw, "duplicate comment")
+	}
+	tok, ok := p.peek()
+	if !ok {
+		return p.errEOF("expected quoted comment")
+	}
+	if tok.Kind != nftTokString {
+		return p.errAt(tok, "comment must be a quoted string")
+	}
+	if len(tok.Text) > 128 {
+		return p.errAt(tok, "comment too long")
+	}
+	p.i++
+	p.hasComment = true
+	p.emit("comment", strconv.Quote(tok.Text))
+	return nil
+}
+
+func (p *nftParser) parseReject() error {
+	p.emit("reject")
+	p.hasVerdict = true
+	p.clauses++
+	if !p.peekWord("with") {
+		return nil
+	}
+	p.i++
+
+	kind, err := p.expectOneOf("icmpx, icmp, icmpv6 or tcp after 'with'", "icmpx", "icmp", "icmpv6", "tcp")
+	if err != nil {
+		return err
+	}
+	if kind == "tcp" {
+		if _, err := p.expectOneOf("'reset'", "reset"); err != nil {
+			return err
+		}
+		p.emit("with", "tcp", "reset")
+		return nil
+	}
+
+	if _, err := p.expectOneOf("'type'", "type"); err != nil {
+		return err
+	}
+	code, err := p.expectWord("reject type")
+	if err != nil {
+		return err
+	}
+	if !nftTypeRegex.MatchString(code.Text) {
+		return p.errAt(code, "invalid reject type '%s'", code.Text)
+	}
+	p.emit("with", kind, "type", code.Text)
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseNftStatement(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"simple port", "tcp dport 22 accept", []string{"tcp", "dport", "22", "accept"}},
+		{"port set with range", "tcp dport { 80, 443,8000-8100 } counter accept",
+			[]string{"tcp", "dport", "{ 80, 443, 8000-8100 }", "counter", "accept"}},
+		{"ct state list", "ct state established,related accept",
+			[]string{"ct", "state", "established,related", "accept"}},
+		{"ct state set", "ct state { new, invalid } drop",
+			[]string{"ct", "state", "{ new, invalid }", "drop"}},
+		{"quoted comment with spaces", `iifname "eth1" ip saddr 10.0.0.0/24 accept comment "LAN; trusted | hosts"`,
+			[]string{"iifname", `"eth1"`, "ip", "saddr", "10.0.0.0/24", "accept", "comment", `"LAN; trusted | hosts"`}},
+		{"negated ipv6 prefix", "ip6 saddr != 2001:db8::/32 drop",
+			[]string{"ip6", "saddr", "!=", "2001:db8::/32", "drop"}},
+		{"address range and set ref", "ip saddr 10.0.0.10-10.0.0.20 ip daddr @blocklist reject with icmpx type admin-prohibited",
+			[]string{"ip", "saddr", "10.0.0.10-10.0.0.20", "ip", "daddr", "@blocklist", "reject", "with", "icmpx", "type", "admin-prohibited"}},
+		{"counter snapshot from nft list", "counter packets 12 bytes 3400 jump user_input",
+			[]string{"counter", "jump", "user_input"}},
+		{"limit and log", `limit rate 5/minute burst 10 packets log prefix "[DROP] " level warn drop`,
+			[]string{"limit", "rate", "5/minute", "burst", "10", "packets", "log", "prefix", `"[DROP] "`, "level", "warn", "drop"}},
+		{"unquoted iface wildcard", "oifname wg* meta l4proto { tcp, udp } th dport 53 accept",
+			[]string{"oifname", `"wg*"`, "meta", "l4proto", "{ tcp, udp }", "th", "dport", "53", "accept"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNftStatement(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseNftStatementErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		column  int
+		message string
+	}{
+		{"empty", "   ", 1, "empty rule"},
+		{"shell metacharacter", "tcp dport 22 accept; flush ruleset", 20, "unexpected character"},
+		{"unknown statement", "tcp dport 22 masquerade", 14, "unsupported statement 'masquerade'"},
+		{"flush command", "flush ruleset", 1, "unsupported statement 'flush'"},
+		{"bad port", "tcp dport 70000 accept", 11, "invalid port '70000'"},
+		{"inverted range", "udp sport 200-100 drop", 11, "invalid port"},
+		{"unterminated set", "tcp dport { 80, 443", 11, "unterminated set"},
+		{"missing comma", "tcp dport { 80 443 } accept", 16, "expected ',' or '}'"},
+		{"empty set", "tcp dport { } accept", 13, "empty set"},
+		{"unterminated string", `iifname "eth1 accept`, 9, "unterminated string"},
+		{"bad ct state", "ct state established,bogus accept", 22, "unknown conntrack state 'bogus'"},
+		{"bad address", "ip saddr 10.0.0.300 drop", 10, "invalid address"},
+		{"quoted port", `tcp dport "22" accept`, 11, "quoted string is not a valid port"},
+		{"missing value", "tcp dport", 10, "expected port"},
+		{"match after verdict", "accept tcp dport 22", 8, "after verdict"},
+		{"duplicate comment", `accept comment "a" comment "b"`, 20, "duplicate comment"},
+		{"unquoted comment", "accept comment hello", 16, "comment must be a quoted string"},
+		{"escape in string", `accept comment "a\"; flush"`, 18, "escape sequences"},
+		{"comment only", `comment "x"`, 1, "no match or action"},
+		{"bad jump target", "jump @evil", 6, "invalid chain name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseNftStatement(tt.input)
+			var perr *NftParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Expected NftParseError, got %v", err)
+			}
+			if perr.Column != tt.column || !strings.Contains(perr.Msg, tt.message) {
+				t.Errorf("Expected column %d containing %q, got column %d: %s", tt.column, tt.message, perr.Column, perr.Msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clients are revoked through easy-rsa and the server checks every handshake
+// against crl.pem, which OpenVPN re-reads when it changes. easy-rsa issues the
+// CRL for 180 days, so it is regenerated well before it runs out: an expired
+// CRL makes OpenVPN reject every client.
+const (
+	ovpnRenewWindow      = 30 * 24 * time.Hour // Certificates this close to expiry are flagged
+	ovpnCRLRefreshAhead  = 30 * 24 * time.Hour
+	ovpnCRLInterval      = 24 * time.Hour
+	ovpnReasonSuperseded = "superseded"
+)
+
+var (
+	ovpnClientNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	ovpnPKILock         sync.Mutex // Serializes easy-rsa runs
+
+	// Reasons easy-rsa accepts for revoke
+	ovpnRevokeReasons = []string{"unspecified", "keyCompromise", "CACompromise", "affiliationChanged",
+		ovpnReasonSuperseded, "cessationOfOperation", "certificateHold"}
+)
+
+func validateOpenVPNClientName(name string) error {
+	if !ovpnClientNameRegex.MatchString(name) {
+		return fmt.Errorf("client name must be 1-64 letters, digits, '-' or '_'")
+	}
+	if name == "server" {
+		return fmt.Errorf("client name 'server' is reserved")
+	}
+	return nil
+}
+
+func validateOpenVPNRevokeReason(reason string) error {
+	if reason == "" {
+		return nil
+	}
+	for _, r := range ovpnRevokeReasons {
+		if r == reason {
+			return nil
+		}
+	}
+	return fmt.Errorf("revocation reason must be one of %s", strings.Join(ovpnRevokeReasons, ", "))
+}
+
+// parseOpenSSLTime parses the UTCTime and GeneralizedTime dates of index.txt
+func parseOpenSSLTime(s string) (time.Time, bool) {
+	for _, layout := range []string{"060102150405Z", "20060102150405Z"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseOpenVPNIndex reads the easy-rsa index.txt. Lines are tab separated:
+// state, expiry, revocation date[,reason], serial, file name and subject.
+// Valid certificates past their expiry are reported as E.
+func parseOpenVPNIndex(data string, now time.Time) []OpenVPNClientCert {
+	clients := []OpenVPNClientCert{}
+	for _, line := range strings.Split(data, "\n") {
+		parts := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(parts) < 6 {
+			continue
+		}
+
+		name := ""
+		for _, field := range strings.Split(parts[5], "/") {
+			if cn, ok := strings.CutPrefix(field, "CN="); ok {
+				name = cn
+			}
+		}
+		if name == "" || name == "server" {
+			continue
+		}
+
+		c := OpenVPNClientCert{Name: name, State: parts[0], Serial: parts[3]}
+		if expires, ok := parseOpenSSLTime(parts[1]); ok {
+			c.ExpiresAt = expires.Format(time.RFC3339)
+			c.DaysLeft = int(expires.Sub(now).Hours() / 24)
+			if c.State == "V" && !expires.After(now) {
+				c.State = "E"
+			}
+			c.ExpiringSoon = c.State == "V" && expires.Sub(now) < ovpnRenewWindow
+		}
+		if c.State == "R" {
+			revoked, reason, _ := strings.Cut(parts[2], ",")
+			if t, ok := parseOpenSSLTime(revoked); ok {
+				c.RevokedAt = t.Format(time.RFC3339)
+			}
+			c.RevocationReason = reason
+			if reason == "" {
+				c.RevocationReason = "unspecified"
+			}
+		}
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// openVPNCertNotBefore reads the issue date of a client's current certificate
+func openVPNCertNotBefore(name string) (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(ovpnEasyRsaDir, "pki", "issued", name+".crt"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("no certificate in %s.crt", name)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotBefore, nil
+}
+
+// findOpenVPNClient returns the newest index entry of a client, so a renewed
+// client is reported by its current certificate
+func findOpenVPNClient(name string) (OpenVPNClientCert, bool) {
+	clients, _ := listOpenVPNClientsInternal()
+	var found OpenVPNClientCert
+	ok := false
+	for _, c := range clients {
+		if c.Name == name && (!ok || found.State == "R") {
+			found, ok = c, true
+		}
+	}
+	return found, ok
+}
+
+// easyRSAScript runs easyrsa from its directory. easyrsa is not in the
+// allow-list, so it goes through bash like the rest of the PKI setup.
+func easyRSAScript(args ...string) string {
+	return fmt.Sprintf("cd %s && ./easyrsa %s", ovpnEasyRsaDir, strings.Join(args, " "))
+}
+
+func runEasyRSA(args ...string) error {
+	if err := runShellScript(easyRSAScript(args...)); err != nil {
+		return fmt.Errorf("easyrsa %s failed: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// renderOpenVPNCRLScript regenerates the CRL and installs it where server.conf
+// expects it. The server drops privileges, so the copy must be world readable.
+// cp and chmod are not in the allow-list either.
+func renderOpenVPNCRLScript() string {
+	return fmt.Sprintf(`%[1]s
+cp %[2]s/pki/crl.pem %[3]s/crl.pem
+chmod 644 %[3]s/crl.pem
+`, easyRSAScript("gen-crl"), ovpnEasyRsaDir, ovpnServerDir)
+}
+
+// openVPNAuditDetails encodes a single audit detail as JSON
+func openVPNAuditDetails(key, value string) string {
+	details, _ := json.Marshal(map[string]string{key: value})
+	return string(details)
+}
+
+// issueOpenVPNClient signs a client certificate and writes its .ovpn
+func issueOpenVPNClient(name string) error {
+	ovpnPKILock.Lock()
+	err := runEasyRSA("--batch", "build-client-full", name, "nopass")
+	ovpnPKILock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to generate cert: %v", err)
+	}
+	if err := writeOpenVPNClientConfig(name); err != nil {
+		return fmt.Errorf("failed to write client config: %v", err)
+	}
+	return nil
+}
+
+// refreshOpenVPNCRL publishes the current revocations to the server
+func refreshOpenVPNCRL() error {
+	if err := runShellScript("set -e\n" + renderOpenVPNCRLScript()); err != nil {
+		return fmt.Errorf("failed to publish CRL: %v", err)
+	}
+	return nil
+}
+
+// revokeOpenVPNClient revokes a client's certificate, publishes the CRL and
+// removes its .ovpn. The key material is removed as well, so the name can be
+// issued again on easy-rsa versions that leave it in place.
+func revokeOpenVPNClient(name, reason string) error {
+	ovpnPKILock.Lock()
+	defer ovpnPKILock.Unlock()
+
+	args := []string{"--batch", "revoke", name}
+	if reason != "" {
+		args = append(args, reason)
+	}
+	if err := runEasyRSA(args...); err != nil {
+		return err
+	}
+
+	for _, path := range []string{
+		filepath.Join(ovpnEasyRsaDir, "pki", "issued", name+".crt"),
+		filepath.Join(ovpnEasyRsaDir, "pki", "private", name+".key"),
+		filepath.Join(ovpnEasyRsaDir, "pki", "reqs", name+".req"),
+		filepath.Join(ovpnClientConfDir, name+".ovpn"),
+	} {
+		os.Remove(path)
+	}
+	return refreshOpenVPNCRL()
+}
+
+// openVPNCRLNextUpdate returns when the installed CRL expires
+func openVPNCRLNextUpdate() (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(ovpnServerDir, "crl.pem"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("no CRL in crl.pem")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return crl.NextUpdate, nil
+}
+
+// startOpenVPNCRLRefresher regenerates the CRL when it is missing or close to
+// its next update
+func startOpenVPNCRLRefresher() {
+	go func() {
+		for {
+			if openVPNServerInstalled() {
+				next, err := openVPNCRLNextUpdate()
+				if err != nil || time.Until(next) < ovpnCRLRefreshAhead {
+					ovpnPKILock.Lock()
+					if err := refreshOpenVPNCRL(); err != nil {
+						fmt.Printf("Warning: Failed to refresh OpenVPN CRL: %v\n", err)
+					}
+					ovpnPKILock.Unlock()
+				}
+			}
+			time.Sleep(ovpnCRLInterval)
+		}
+	}()
+}
+
+// listRevokedOpenVPNClients returns the revoked certificates with their reasons
+func listRevokedOpenVPNClients(w http.ResponseWriter, r *http.Request) {
+	certs, err := listOpenVPNClientsInternal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	revoked := []OpenVPNClientCert{}
+	for _, c := range certs {
+		if c.State == "R" {
+			revoked = append(revoked, c)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revoked)
+}
+
+// renewOpenVPNClient replaces a client's certificate. The old one is revoked
+// as superseded and the client has to download the new .ovpn.
+func renewOpenVPNClient(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	if err := validateOpenVPNClientName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c, ok := findOpenVPNClient(req.Name); !ok || c.State == "R" {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	err := revokeOpenVPNClient(req.Name, ovpnReasonSuperseded)
+	// Once the old certificate is revoked the client needs the new one, even
+	// if publishing the CRL failed
+	if c, _ := findOpenVPNClient(req.Name); c.State == "R" {
+		if issueErr := issueOpenVPNClient(req.Name); issueErr != nil {
+			err = issueErr
+		}
+	}
+	if err != nil {
+		logAuditEvent(getUsernameFromToken(r), "vpn.openvpn.client.renew", req.Name,
+			openVPNAuditDetails("error", err.Error()), getClientIP(r), false)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logAuditEvent(getUsernameFromToken(r), "vpn.openvpn.client.renew", req.Name, "", getClientIP(r), true)
+
+	c, _ := findOpenVPNClient(req.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "renewed",
+		"config_path": req.Name + ".ovpn",
+		"client":      c,
+	})
+}
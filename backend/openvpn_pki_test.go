@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOpenVPNIndex(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	index := "V\t361013120000Z\t\t01\tunknown\t/CN=server\n" +
+		"V\t281231120000Z\t\t02\tunknown\t/CN=laptop\n" +
+		"R\t281231120000Z\t260901080000Z,keyCompromise\t03\tunknown\t/CN=phone\n" +
+		"V\t261101000000Z\t\t04\tunknown\t/CN=tablet\n" +
+		"V\t260101000000Z\t\t05\tunknown\t/CN=old\n" +
+		"R\t281231120000Z\t260902080000Z\t06\tunknown\t/CN=desk\n" +
+		"V\t20550101000000Z\t\t07\tunknown\t/CN=router-2055\n" +
+		"garbage line\n"
+
+	clients := parseOpenVPNIndex(index, now)
+	if len(clients) != 6 {
+		t.Fatalf("Expected 6 clients without the server, got %+v", clients)
+	}
+	byName := make(map[string]OpenVPNClientCert)
+	for _, c := range clients {
+		byName[c.Name] = c
+	}
+
+	if c := byName["laptop"]; c.State != "V" || c.Serial != "02" || c.ExpiresAt != "2028-12-31T12:00:00Z" || c.ExpiringSoon {
+		t.Errorf("Unexpected valid client %+v", c)
+	}
+	if c := byName["phone"]; c.State != "R" || c.RevokedAt != "2026-09-01T08:00:00Z" || c.RevocationReason != "keyCompromise" {
+		t.Errorf("Unexpected revoked client %+v", c)
+	}
+	if c := byName["desk"]; c.RevocationReason != "unspecified" {
+		t.Errorf("Expected a revocation without reason to be unspecified, got %+v", c)
+	}
+	if c := byName["tablet"]; !c.ExpiringSoon || c.DaysLeft != 16 {
+		t.Errorf("Expected tablet to expire in 16 days, got %+v", c)
+	}
+	if c := byName["old"]; c.State != "E" || c.ExpiringSoon || c.DaysLeft >= 0 {
+		t.Errorf("Expected an expired client, got %+v", c)
+	}
+	if c := byName["router-2055"]; c.ExpiresAt != "2055-01-01T00:00:00Z" {
+		t.Errorf("Expected GeneralizedTime to be parsed, got %+v", c)
+	}
+}
+
+func TestValidateOpenVPNClient(t *testing.T) {
+	for _, name := range []string{"laptop", "alice_phone-2"} {
+		if err := validateOpenVPNClientName(name); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "server", "a b", "x; rm -rf /", "../etc"} {
+		if err := validateOpenVPNClientName(name); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+
+	for _, reason := range []string{"", "keyCompromise", "superseded"} {
+		if err := validateOpenVPNRevokeReason(reason); err != nil {
+			t.Errorf("Expected reason %q to be valid, got %v", reason, err)
+		}
+	}
+	if err := validateOpenVPNRevokeReason("lost; reboot"); err == nil {
+		t.Error("Expected an unknown reason to be rejected")
+	}
+}
+
+func TestOpenVPNCRLCommandsAllowed(t *testing.T) {
+	// Everything that publishes the CRL must pass the privileged exec allow-list
+	for _, script := range []string{
+		"set -e\n" + renderOpenVPNCRLScript(),
+		easyRSAScript("--batch", "revoke", "laptop", "keyCompromise"),
+		easyRSAScript("--batch", "build-client-full", "laptop", "nopass"),
+		renderOpenVPNPKIScript(),
+	} {
+		if err := validateCommand("bash", []string{"-c", script}); err != nil {
+			t.Errorf("Expected script to be allowed, got %v:\n%s", err, script)
+		}
+	}
+
+	script := renderOpenVPNCRLScript()
+	for _, s := range []string{
+		"cd /etc/openvpn/easy-rsa && ./easyrsa gen-crl\n",
+		"cp /etc/openvpn/easy-rsa/pki/crl.pem /etc/openvpn/server/crl.pem\n",
+		"chmod 644 /etc/openvpn/server/crl.pem\n",
+	} {
+		if !strings.Contains(script, s) {
+			t.Errorf("Expected CRL script to contain %q.\n%s", s, script)
+		}
+	}
+}
+
+func TestOpenVPNAuditDetails(t *testing.T) {
+	details := openVPNAuditDetails("error", `easyrsa revoke failed: "laptop" not found`)
+	var decoded map[string]string
+	if err := json.Unmarshal([]byte(details), &decoded); err != nil || decoded["error"] != `easyrsa revoke failed: "laptop" not found` {
+		t.Errorf("Expected valid JSON details, got %s (%v)", details, err)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// OpenVPNServerConfig is the road-warrior OpenVPN server. server.conf is
+// rendered from it whenever it changes.
+type OpenVPNServerConfig struct {
+	Protocol        string   `json:"protocol"` // udp or tcp
+	Port            int      `json:"port"`
+	Subnet          string   `json:"subnet"`           // Client pool, e.g. 10.8.1.0/24
+	PushRoutes      []string `json:"push_routes"`      // Subnets behind the router pushed to clients
+	DNS             []string `json:"dns"`              // Pushed resolvers, default: the server's tunnel address
+	RedirectGateway bool     `json:"redirect_gateway"` // Send all client traffic through the tunnel
+	Cipher          string   `json:"cipher"`
+	ClientToClient  bool     `json:"client_to_client"` // Let clients reach each other
+	MaxClients      int      `json:"max_clients"`      // 0 = OpenVPN default
+	Zone            string   `json:"zone"`             // Firewall zone of the tunnel device
+}
+
+const (
+	ovpnServerDevice = "tun0" // Reserved by the VPN profiles
+	ovpnMaxClients   = 1024
+)
+
+var (
+	ovpnServerConfig     OpenVPNServerConfig
+	ovpnServerLock       sync.Mutex
+	ovpnServerConfigPath = "/etc/softrouter/openvpn_server.json"
+
+	ovpnCiphers = map[string]bool{"AES-256-GCM": true, "AES-128-GCM": true, "CHACHA20-POLY1305": true}
+)
+
+// defaultOpenVPNServerConfig matches the server earlier versions set up
+func defaultOpenVPNServerConfig() OpenVPNServerConfig {
+	return OpenVPNServerConfig{
+		Protocol:        "udp",
+		Port:            1194,
+		Subnet:          "10.8.1.0/24",
+		RedirectGateway: true,
+		Cipher:          "AES-256-GCM",
+		Zone:            zoneLAN,
+	}
+}
+
+func loadOpenVPNServerConfig() {
+	ovpnServerLock.Lock()
+	defer ovpnServerLock.Unlock()
+
+	ovpnServerConfig = defaultOpenVPNServerConfig()
+	data, err := os.ReadFile(ovpnServerConfigPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &ovpnServerConfig); err != nil {
+			fmt.Printf("Error parsing OpenVPN server config: %v\n", err)
+		}
+		return
+	}
+	if !os.IsNotExist(err) {
+		fmt.Printf("Error loading OpenVPN server config: %v\n", err)
+		return
+	}
+
+	// Servers set up by the old script pushed Cloudflare's resolvers
+	if openVPNServerInstalled() {
+		ovpnServerConfig.DNS = []string{"1.1.1.1", "1.0.0.1"}
+		if err := saveOpenVPNServerConfigLocked(); err != nil {
+			fmt.Printf("Error saving OpenVPN server config: %v\n", err)
+		}
+	}
+}
+
+func saveOpenVPNServerConfigLocked() error {
+	data, err := json.MarshalIndent(ovpnServerConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ovpnServerConfigPath, data, 0644)
+}
+
+// GetOpenVPNServerConfig returns a copy of the server config
+func GetOpenVPNServerConfig() OpenVPNServerConfig {
+	ovpnServerLock.Lock()
+	defer ovpnServerLock.Unlock()
+	cfg := ovpnServerConfig
+	cfg.PushRoutes = append([]string(nil), cfg.PushRoutes...)
+	cfg.DNS = append([]string(nil), cfg.DNS...)
+	return cfg
+}
+
+// openVPNServerInstalled reports whether the PKI has been set up and a
+// server.conf written
+func openVPNServerInstalled() bool {
+	for _, f := range []string{"server.conf", "ca.crt"} {
+		if _, err := os.Stat(filepath.Join(ovpnServerDir, f)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func validateOpenVPNServerConfig(cfg OpenVPNServerConfig, zones []FirewallZone) error {
+	if cfg.Protocol != "udp" && cfg.Protocol != "tcp" {
+		return fmt.Errorf("protocol must be udp or tcp")
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return fmt.Errorf("invalid port")
+	}
+	ip, ipNet, err := net.ParseCIDR(cfg.Subnet)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("subnet must be an IPv4 network, e.g. 10.8.1.0/24")
+	}
+	if ones, _ := ipNet.Mask.Size(); ones > 29 {
+		return fmt.Errorf("subnet must be /29 or larger")
+	}
+	if !ip.Equal(ipNet.IP) {
+		return fmt.Errorf("subnet must be a network address, e.g. %s", ipNet)
+	}
+	for _, route := range cfg.PushRoutes {
+		if _, n, err := net.ParseCIDR(route); err != nil || n.IP.To4() == nil {
+			return fmt.Errorf("invalid pushed route %q", route)
+		}
+	}
+	for _, dns := range cfg.DNS {
+		if ip := net.ParseIP(dns); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid DNS server %q", dns)
+		}
+	}
+	if !ovpnCiphers[cfg.Cipher] {
+		return fmt.Errorf("cipher must be AES-256-GCM, AES-128-GCM or CHACHA20-POLY1305")
+	}
+	if cfg.MaxClients < 0 || cfg.MaxClients > ovpnMaxClients {
+		return fmt.Errorf("max clients must be between 0 and %d", ovpnMaxClients)
+	}
+	if cfg.Zone != "" {
+		found := false
+		for _, z := range zones {
+			if z.Name == cfg.Zone {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown zone %s", cfg.Zone)
+		}
+	}
+	return nil
+}
+
+// renderOpenVPNServerConfig renders server.conf. The PKI files are the ones
+// the setup script copies into the server directory.
+func renderOpenVPNServerConfig(cfg OpenVPNServerConfig) string {
+	_, ipNet, _ := net.ParseCIDR(cfg.Subnet)
+
+	var b strings.Builder
+	b.WriteString("# Auto-generated by SoftRouter - DO NOT EDIT MANUALLY\n")
+	b.WriteString(fmt.Sprintf("port %d\n", cfg.Port))
+	b.WriteString(fmt.Sprintf("proto %s\n", cfg.Protocol))
+	b.WriteString(fmt.Sprintf("dev %s\n", ovpnServerDevice))
+	b.WriteString("ca ca.crt\ncert server.crt\nkey server.key\ndh dh.pem\n")
+	b.WriteString("auth SHA256\n")
+	b.WriteString("tls-crypt ta.key\n")
+	b.WriteString("crl-verify crl.pem\n")
+	b.WriteString("topology subnet\n")
+	b.WriteString(fmt.Sprintf("server %s %s\n", ipNet.IP, net.IP(ipNet.Mask)))
+	b.WriteString("ifconfig-pool-persist ipp.txt\n")
+
+	if cfg.RedirectGateway {
+		b.WriteString("push \"redirect-gateway def1 bypass-dhcp\"\n")
+	}
+	for _, route := range cfg.PushRoutes {
+		_, n, _ := net.ParseCIDR(route)
+		b.WriteString(fmt.Sprintf("push \"route %s %s\"\n", n.IP, net.IP(n.Mask)))
+	}
+	dns := cfg.DNS
+	if len(dns) == 0 {
+		dns = []string{openVPNServerAddress(ipNet)} // The router resolves
+	}
+	for _, d := range dns {
+		b.WriteString(fmt.Sprintf("push \"dhcp-option DNS %s\"\n", d))
+	}
+	if cfg.ClientToClient {
+		b.WriteString("client-to-client\n")
+	}
+	if cfg.MaxClients > 0 {
+		b.WriteString(fmt.Sprintf("max-clients %d\n", cfg.MaxClients))
+	}
+
+	b.WriteString("keepalive 10 120\n")
+	b.WriteString(fmt.Sprintf("data-ciphers %s\n", cfg.Cipher))
+	b.WriteString("user nobody\ngroup nogroup\n")
+	b.WriteString("persist-key\npersist-tun\n")
+	b.WriteString("status openvpn-status.log\n")
+	b.WriteString("verb 3\n")
+	if cfg.Protocol == "udp" {
+		b.WriteString("explicit-exit-notify 1\n")
+	}
+	return b.String()
+}
+
+// openVPNServerAddress is the first host of the pool, which OpenVPN assigns
+// to the server
+func openVPNServerAddress(ipNet *net.IPNet) string {
+	ip := make(net.IP, 4)
+	copy(ip, ipNet.IP.To4())
+	ip[3]++
+	return ip.String()
+}
+
+// renderOpenVPNPKIScript creates whatever part of the PKI is missing. Existing
+// CA, server certificate, DH parameters and TLS key are kept, so clients
+// issued earlier stay valid. The CRL is regenerated every time.
+func renderOpenVPNPKIScript() string {
+	return fmt.Sprintf(`set -e
+[ -x %[1]s/easyrsa ] || { mkdir -p %[1]s && cp -r /usr/share/easy-rsa/. %[1]s/; }
+cd %[1]s
+[ -d pki ] || ./easyrsa --batch init-pki
+[ -f pki/ca.crt ] || ./easyrsa --batch --req-cn=SoftRouter-CA build-ca nopass
+[ -f pki/issued/server.crt ] || ./easyrsa --batch build-server-full server nopass
+[ -f pki/dh.pem ] || ./easyrsa gen-dh
+[ -f ta.key ] || openvpn --genkey --secret ta.key
+mkdir -p %[2]s
+cp pki/ca.crt pki/private/server.key pki/issued/server.crt pki/dh.pem ta.key %[2]s/
+%[3]s`, ovpnEasyRsaDir, ovpnServerDir, renderOpenVPNCRLScript())
+}
+
+// writeOpenVPNServerConfig renders server.conf and restarts a running server
+func writeOpenVPNServerConfig(cfg OpenVPNServerConfig, restart bool) error {
+	// Servers set up before crl-verify have no CRL yet and would not start
+	if _, err := os.Stat(filepath.Join(ovpnServerDir, "crl.pem")); os.IsNotExist(err) {
+		ovpnPKILock.Lock()
+		err := refreshOpenVPNCRL()
+		ovpnPKILock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(ovpnServerDir, "server.conf"), []byte(renderOpenVPNServerConfig(cfg)), 0644); err != nil {
+		return err
+	}
+	if !restart {
+		return nil
+	}
+	if out, err := runPrivilegedCombinedOutput("systemctl", "restart", ovpnSystemd); err != nil {
+		return fmt.Errorf("failed to restart %s: %v (%s)", ovpnSystemd, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// addOpenVPNServerZone places the server's tunnel into its zone unless the
+// zone model already assigns it
+func addOpenVPNServerZone(zones []FirewallZone, cfg *OpenVPNServerConfig) []FirewallZone {
+	if cfg == nil || cfg.Zone == "" {
+		return zones
+	}
+	for _, z := range zones {
+		for _, name := range z.Interfaces {
+			if name == ovpnServerDevice {
+				return zones
+			}
+		}
+	}
+	for i := range zones {
+		if zones[i].Name == cfg.Zone {
+			zones[i].Interfaces = append(zones[i].Interfaces, ovpnServerDevice)
+		}
+	}
+	return zones
+}
+
+// openVPNServerRulesetInput returns the config the firewall opens the port
+// for, nil until the server has been set up
+func openVPNServerRulesetInput() *OpenVPNServerConfig {
+	if !openVPNServerInstalled() {
+		return nil
+	}
+	cfg := GetOpenVPNServerConfig()
+	return &cfg
+}
+
+// --- API Handlers ---
+
+func getOpenVPNServerConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, GetOpenVPNServerConfig())
+}
+
+func updateOpenVPNServerConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := GetOpenVPNServerConfig()
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if err := validateOpenVPNServerConfig(cfg, GetZoneStore().Zones); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	ovpnServerLock.Lock()
+	previous := ovpnServerConfig
+	ovpnServerConfig = cfg
+	if err := saveOpenVPNServerConfigLocked(); err != nil {
+		ovpnServerConfig = previous
+		ovpnServerLock.Unlock()
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save OpenVPN server config", err)
+		return
+	}
+	ovpnServerLock.Unlock()
+
+	// Servers that have not been set up get server.conf from the setup
+	if openVPNServerInstalled() {
+		out, _ := runPrivilegedOutput("systemctl", "is-active", ovpnSystemd)
+		if err := writeOpenVPNServerConfig(cfg, strings.TrimSpace(string(out)) == "active"); err != nil {
+			logAuditEvent(getUsernameFromToken(r), "vpn.openvpn.server.update", "server",
+				openVPNAuditDetails("error", err.Error()), getClientIP(r), false)
+			respondWithError(w, ErrVPNConfigInvalid, "Config saved but not applied", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	details, _ := json.Marshal(cfg)
+	logAuditEvent(getUsernameFromToken(r), "vpn.openvpn.server.update", "server", string(details), getClientIP(r), true)
+
+	// Port and zone are part of the ruleset
+	go firewallManager.ApplyFirewallRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, cfg)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderOpenVPNServerConfig(t *testing.T) {
+	conf := renderOpenVPNServerConfig(defaultOpenVPNServerConfig())
+	for _, s := range []string{
+		"port 1194\nproto udp\ndev tun0\n",
+		"server 10.8.1.0 255.255.255.0\n",
+		"push \"redirect-gateway def1 bypass-dhcp\"\n",
+		"push \"dhcp-option DNS 10.8.1.1\"\n",
+		"data-ciphers AES-256-GCM\n",
+		"crl-verify crl.pem\n",
+		"explicit-exit-notify 1\n",
+	} {
+		if !strings.Contains(conf, s) {
+			t.Errorf("Expected default config to contain %q.\n%s", s, conf)
+		}
+	}
+
+	cfg := OpenVPNServerConfig{Protocol: "tcp", Port: 443, Subnet: "10.9.0.0/22", PushRoutes: []string{"192.168.1.0/24"},
+		DNS: []string{"9.9.9.9"}, Cipher: "CHACHA20-POLY1305", ClientToClient: true, MaxClients: 20}
+	conf = renderOpenVPNServerConfig(cfg)
+	for _, s := range []string{
+		"port 443\nproto tcp\n",
+		"server 10.9.0.0 255.255.252.0\n",
+		"push \"route 192.168.1.0 255.255.255.0\"\n",
+		"push \"dhcp-option DNS 9.9.9.9\"\n",
+		"client-to-client\n",
+		"max-clients 20\n",
+		"data-ciphers CHACHA20-POLY1305\n",
+	} {
+		if !strings.Contains(conf, s) {
+			t.Errorf("Expected config to contain %q.\n%s", s, conf)
+		}
+	}
+	for _, s := range []string{"redirect-gateway", "10.9.0.1", "explicit-exit-notify"} {
+		if strings.Contains(conf, s) {
+			t.Errorf("Did not expect %q in split tunnel TCP config.\n%s", s, conf)
+		}
+	}
+}
+
+func TestValidateOpenVPNServerConfig(t *testing.T) {
+	zones := []FirewallZone{{Name: zoneLAN}}
+	if err := validateOpenVPNServerConfig(defaultOpenVPNServerConfig(), zones); err != nil {
+		t.Fatalf("Expected defaults to be valid, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*OpenVPNServerConfig)
+	}{
+		{"protocol", func(c *OpenVPNServerConfig) { c.Protocol = "sctp" }},
+		{"port", func(c *OpenVPNServerConfig) { c.Port = 70000 }},
+		{"subnet", func(c *OpenVPNServerConfig) { c.Subnet = "10.8.1.0" }},
+		{"host address", func(c *OpenVPNServerConfig) { c.Subnet = "10.8.1.1/24" }},
+		{"tiny subnet", func(c *OpenVPNServerConfig) { c.Subnet = "10.8.1.0/30" }},
+		{"route", func(c *OpenVPNServerConfig) { c.PushRoutes = []string{"192.168.1.0"} }},
+		{"dns", func(c *OpenVPNServerConfig) { c.DNS = []string{"dns.example"} }},
+		{"cipher", func(c *OpenVPNServerConfig) { c.Cipher = "BF-CBC" }},
+		{"max clients", func(c *OpenVPNServerConfig) { c.MaxClients = -1 }},
+		{"zone", func(c *OpenVPNServerConfig) { c.Zone = "dmz" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultOpenVPNServerConfig()
+			tt.mutate(&cfg)
+			if err := validateOpenVPNServerConfig(cfg, zones); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestOpenVPNPKIScriptKeepsExistingPKI(t *testing.T) {
+	script := renderOpenVPNPKIScript()
+	for _, line := range strings.Split(script, "\n") {
+		if strings.Contains(line, "rm ") || strings.Contains(line, "clean-all") {
+			t.Errorf("PKI setup must not delete anything: %q", line)
+		}
+		for _, step := range []string{"init-pki", "build-ca", "build-server-full", "gen-dh", "--genkey"} {
+			if strings.Contains(line, step) && !strings.HasPrefix(line, "[ ") {
+				t.Errorf("Step %s must only run when its output is missing: %q", step, line)
+			}
+		}
+	}
+	if !strings.Contains(script, "./easyrsa gen-crl\n") || !strings.Contains(script, "chmod 644 /etc/openvpn/server/crl.pem") {
+		t.Errorf("Expected setup to publish a readable CRL.\n%s", script)
+	}
+}
+
+func TestGenerateFullRulesetOpenVPNServer(t *testing.T) {
+	in := testRulesetInputs()
+	cfg := defaultOpenVPNServerConfig()
+	cfg.Protocol, cfg.Port = "tcp", 443
+	in.OpenVPN = &cfg
+	in.Zones = addOpenVPNServerZone(in.Zones, in.OpenVPN)
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+	if !strings.Contains(ruleset, "    tcp dport 443 accept comment \"OpenVPN server\"\n") {
+		t.Errorf("Expected the server port to be opened.\nGenerated Ruleset:\n%s", ruleset)
+	}
+	if lan := zoneInterfaces(in.Zones, "lan"); len(lan) == 0 || lan[len(lan)-1] != ovpnServerDevice {
+		t.Errorf("Expected %s in the lan zone, got %v", ovpnServerDevice, lan)
+	}
+}
@@ -3,11 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // OpenVPNServerStatus structure
@@ -21,31 +23,34 @@ type OpenVPNServerStatus struct {
 
 // OpenVPNClientCert represents a generated client
 type OpenVPNClientCert struct {
-	Name      string `json:"name"`
-	State     string `json:"state"` // V=Valid, R=Revoked
-	CreatedAt string `json:"created_at"`
-	ExpiresAt string `json:"expires_at"`
+	Name             string `json:"name"`
+	State            string `json:"state"` // V=Valid, R=Revoked, E=Expired
+	Serial           string `json:"serial"`
+	CreatedAt        string `json:"created_at,omitempty"` // RFC 3339, from the issued certificate
+	ExpiresAt        string `json:"expires_at"`           // RFC 3339
+	DaysLeft         int    `json:"days_left"`            // Negative once expired
+	ExpiringSoon     bool   `json:"expiring_soon"`        // Within ovpnRenewWindow
+	RevokedAt        string `json:"revoked_at,omitempty"`
+	RevocationReason string `json:"revocation_reason,omitempty"`
 }
 
 const (
-	ovpnServerDir  = "/etc/openvpn/server"
-	ovpnEasyRsaDir = "/etc/openvpn/easy-rsa"
-	ovpnSystemd    = "openvpn-server@server"
-	ovpnPort       = 1194
-	ovpnSubnet     = "10.8.1.0 255.255.255.0"
+	ovpnServerDir     = "/etc/openvpn/server"
+	ovpnEasyRsaDir    = "/etc/openvpn/easy-rsa"
+	ovpnClientConfDir = "/var/www/softrouter/vpn_configs"
+	ovpnSystemd       = "openvpn-server@server"
 )
 
 // getOpenVPNServerStatus returns the health and install state
 func getOpenVPNServerStatus(w http.ResponseWriter, r *http.Request) {
+	cfg := GetOpenVPNServerConfig()
 	status := OpenVPNServerStatus{
-		Port:     ovpnPort,
-		Protocol: "UDP",
+		Port:     cfg.Port,
+		Protocol: strings.ToUpper(cfg.Protocol),
 	}
 
 	// Check if configured
-	if _, err := os.Stat(filepath.Join(ovpnServerDir, "server.conf")); err == nil {
-		status.Installed = true
-	}
+	status.Installed = openVPNServerInstalled()
 
 	// Check if running
 	out, _ := runPrivilegedOutput("systemctl", "is-active", ovpnSystemd)
@@ -55,103 +60,88 @@ func getOpenVPNServerStatus(w http.ResponseWriter, r *http.Request) {
 
 	// Count clients (using index.txt)
 	clients, _ := listOpenVPNClientsInternal()
-	status.ClientCount = len(clients)
+	for _, c := range clients {
+		if c.State == "V" {
+			status.ClientCount++
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
 
-// setupOpenVPNServer initializes PKI and configures the server
+// setupOpenVPNServer creates the missing parts of the PKI, stores the
+// optional config in the body and (re)starts the server. Running it again
+// keeps the existing PKI and the clients issued from it.
 func setupOpenVPNServer(w http.ResponseWriter, r *http.Request) {
-	// 1. Prepare Directory
-	os.RemoveAll(ovpnEasyRsaDir)
-	if err := runPrivileged("cp", "-r", "/usr/share/easy-rsa", ovpnEasyRsaDir); err != nil {
-		http.Error(w, "Failed to copy easy-rsa: "+err.Error(), http.StatusInternalServerError)
+	cfg := GetOpenVPNServerConfig()
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil && err != io.EOF {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if err := validateOpenVPNServerConfig(cfg, GetZoneStore().Zones); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusBadRequest, nil)
 		return
 	}
 
-	// 2. PKI Initialization commands
-	// We use a helper script to chain these env vars and commands easily
-	setupScript := fmt.Sprintf(`
-#!/bin/bash
-set -e
-cd %s
-./easyrsa init-pki
-echo "SoftRouter-CA" | ./easyrsa build-ca nopass
-./easyrsa build-server-full server nopass
-./easyrsa gen-dh
-openvpn --genkey --secret ta.key
-cp pki/ca.crt pki/private/server.key pki/issued/server.crt pki/dh.pem ta.key %s/
-`, ovpnEasyRsaDir, ovpnServerDir)
-
-	if err := runShellScript(setupScript); err != nil {
+	// 1. PKI
+	ovpnPKILock.Lock()
+	err := runShellScript(renderOpenVPNPKIScript())
+	ovpnPKILock.Unlock()
+	if err != nil {
 		http.Error(w, "PKI Setup failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// 3. Write Server Config
-	serverConf := fmt.Sprintf(`
-port %d
-proto udp
-dev tun
-ca ca.crt
-cert server.crt
-key server.key
-dh dh.pem
-auth SHA256
-tls-crypt ta.key
-topology subnet
-server %s
-ifconfig-pool-persist ipp.txt
-push "redirect-gateway def1 bypass-dhcp"
-push "dhcp-option DNS 1.1.1.1"
-push "dhcp-option DNS 1.0.0.1"
-keepalive 10 120
-cipher AES-256-GCM
-user nobody
-group nogroup
-persist-key
-persist-tun
-status openvpn-status.log
-verb 3
-explicit-exit-notify 1
-`, ovpnPort, ovpnSubnet)
-
-	if err := os.WriteFile(filepath.Join(ovpnServerDir, "server.conf"), []byte(serverConf), 0644); err != nil {
+	// 2. Server Config
+	ovpnServerLock.Lock()
+	ovpnServerConfig = cfg
+	err = saveOpenVPNServerConfigLocked()
+	ovpnServerLock.Unlock()
+	if err != nil {
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save OpenVPN server config", err)
+		return
+	}
+	if err := writeOpenVPNServerConfig(cfg, false); err != nil {
 		http.Error(w, "Failed to write config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// 4. Enable IP Forwarding (if not already)
-	// Managed by SoftRouter core usually, but ensure it
-
-	// 5. Start Service
+	// 3. Start Service
 	runPrivileged("systemctl", "enable", ovpnSystemd)
 	if err := runPrivileged("systemctl", "restart", ovpnSystemd); err != nil {
 		http.Error(w, "Failed to start service: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// 6. Firewall Rule (Allow 1194/udp)
-	// We'll insert it into nftables.conf if not present, OR assumes user manages via firewall UI.
-	// For "Out of box" experience, let's auto-add to firewall via our existing API/logic or just exec nft
-	runPrivileged("nft", "add", "rule", "inet", "filter", "input", "udp", "dport", fmt.Sprintf("%d", ovpnPort), "accept")
+	details, _ := json.Marshal(cfg)
+	logAuditEvent(getUsernameFromToken(r), "vpn.openvpn.server.setup", "server", string(details), getClientIP(r), true)
+
+	// 4. The ruleset opens the port and places the tunnel in its zone
+	go firewallManager.ApplyFirewallRules()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "OpenVPN Server configured and started"})
 }
 
-// listOpenVPNClients returns client list
+// listOpenVPNClients returns the clients that have not been revoked
 func listOpenVPNClients(w http.ResponseWriter, r *http.Request) {
-	clients, err := listOpenVPNClientsInternal()
+	certs, err := listOpenVPNClientsInternal()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	clients := []OpenVPNClientCert{}
+	for _, c := range certs {
+		if c.State != "R" {
+			clients = append(clients, c)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(clients)
 }
 
+// listOpenVPNClientsInternal returns every client certificate in the easy-rsa index
 func listOpenVPNClientsInternal() ([]OpenVPNClientCert, error) {
 	indexFile := filepath.Join(ovpnEasyRsaDir, "pki", "index.txt")
 	data, err := os.ReadFile(indexFile)
@@ -162,34 +152,14 @@ func listOpenVPNClientsInternal() ([]OpenVPNClientCert, error) {
 		return nil, err
 	}
 
-	var clients []OpenVPNClientCert
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		if line == "" {
+	clients := parseOpenVPNIndex(string(data), time.Now())
+	for i := range clients {
+		if clients[i].State == "R" {
 			continue
 		}
-		parts := strings.Fields(line)
-		if len(parts) < 5 {
-			continue
+		if notBefore, err := openVPNCertNotBefore(clients[i].Name); err == nil {
+			clients[i].CreatedAt = notBefore.Format(time.RFC3339)
 		}
-
-		// Format: V <expiry> <revocation> <serial> <file> <subject>
-		// Subject usually: /CN=clientname
-		state := parts[0]
-		if state != "V" {
-			continue
-		} // Only show valid for now
-
-		subject := parts[5] // /CN=myclient
-		name := strings.TrimPrefix(subject, "/CN=")
-
-		// Parse dates if needed (YYMMDDHHMMSSZ)
-
-		clients = append(clients, OpenVPNClientCert{
-			Name:      name,
-			State:     state,
-			ExpiresAt: parts[1],
-		})
 	}
 	return clients, nil
 }
@@ -201,24 +171,37 @@ func createOpenVPNClient(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewDecoder(r.Body).Decode(&req)
 
-	if req.Name == "" {
-		http.Error(w, "Name required", http.StatusBadRequest)
+	if err := validateOpenVPNClientName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Revoked names can be issued again
+	if c, ok := findOpenVPNClient(req.Name); ok && c.State != "R" {
+		http.Error(w, "Client already exists", http.StatusConflict)
 		return
 	}
 
-	// Generate Cert
-	// Note: easyrsa is not in the allow-list, but since it's in a custom dir, we use bash to find it
-	cmdStr := fmt.Sprintf("cd %s && ./easyrsa build-client-full %s nopass", ovpnEasyRsaDir, req.Name)
-	if out, err := runPrivilegedCombinedOutput("bash", "-c", cmdStr); err != nil {
-		http.Error(w, "Failed to generate cert: "+string(out), http.StatusInternalServerError)
+	if err := issueOpenVPNClient(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	logAuditEvent(getUsernameFromToken(r), "vpn.openvpn.client.create", req.Name, "", getClientIP(r), true)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":      "success",
+		"config_path": req.Name + ".ovpn",
+	})
+}
 
+// writeOpenVPNClientConfig builds the .ovpn of an issued client certificate
+func writeOpenVPNClientConfig(name string) error {
 	// Build .ovpn content
 	ca, _ := ioutil.ReadFile(filepath.Join(ovpnServerDir, "ca.crt"))
 	ta, _ := ioutil.ReadFile(filepath.Join(ovpnServerDir, "ta.key"))
-	cert, _ := ioutil.ReadFile(filepath.Join(ovpnEasyRsaDir, "pki", "issued", req.Name+".crt"))
-	key, _ := ioutil.ReadFile(filepath.Join(ovpnEasyRsaDir, "pki", "private", req.Name+".key"))
+	cert, _ := ioutil.ReadFile(filepath.Join(ovpnEasyRsaDir, "pki", "issued", name+".crt"))
+	key, _ := ioutil.ReadFile(filepath.Join(ovpnEasyRsaDir, "pki", "private", name+".key"))
 
 	// Determine public IP
 	publicIP := "YOUR_PUBLIC_IP"
@@ -229,9 +212,10 @@ func createOpenVPNClient(w http.ResponseWriter, r *http.Request) {
 		publicIP = ip
 	}
 
+	cfg := GetOpenVPNServerConfig()
 	ovpnConfig := fmt.Sprintf(`client
 dev tun
-proto udp
+proto %s
 remote %s %d
 resolv-retry infinite
 nobind
@@ -239,7 +223,7 @@ persist-key
 persist-tun
 remote-cert-tls server
 auth SHA256
-cipher AES-256-GCM
+data-ciphers %s
 verb 3
 <ca>
 %s
@@ -253,26 +237,23 @@ verb 3
 <tls-crypt>
 %s
 </tls-crypt>
-`, publicIP, ovpnPort, string(ca), string(cert), string(key), string(ta))
+`, cfg.Protocol, publicIP, cfg.Port, cfg.Cipher, string(ca), string(cert), string(key), string(ta))
 
 	// Store temporarily or just return?
 	// The requirement implies we want to download it later.
 	// Let's store it in a safe place.
-	clientConfDir := "/var/www/softrouter/vpn_configs"
-	os.MkdirAll(clientConfDir, 0700) // Restricted
-	os.WriteFile(filepath.Join(clientConfDir, req.Name+".ovpn"), []byte(ovpnConfig), 0600)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":      "success",
-		"config_path": req.Name + ".ovpn",
-	})
+	os.MkdirAll(ovpnClientConfDir, 0700) // Restricted
+	return os.WriteFile(filepath.Join(ovpnClientConfDir, name+".ovpn"), []byte(ovpnConfig), 0600)
 }
 
 // downloadOpenVPNClient returns the file
 func downloadOpenVPNClient(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
-	path := filepath.Join("/var/www/softrouter/vpn_configs", name+".ovpn")
+	if err := validateOpenVPNClientName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path := filepath.Join(ovpnClientConfDir, name+".ovpn")
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -283,23 +264,38 @@ func downloadOpenVPNClient(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, path)
 }
 
-// deleteOpenVPNClient revokes the cert
+// deleteOpenVPNClient revokes the cert. The optional reason query parameter
+// is recorded in the CRL.
 func deleteOpenVPNClient(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
+	reason := r.URL.Query().Get("reason")
+	if err := validateOpenVPNClientName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateOpenVPNRevokeReason(reason); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c, ok := findOpenVPNClient(name); !ok || c.State == "R" {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
 
-	// Revoke
-	revokeCmd := fmt.Sprintf("cd %s && ./easyrsa --batch revoke %s", ovpnEasyRsaDir, name)
-	runPrivilegedCombinedOutput("bash", "-c", revokeCmd)
-
-	// Gen CRL
-	crlCmd := fmt.Sprintf("cd %s && ./easyrsa gen-crl", ovpnEasyRsaDir)
-	runPrivilegedCombinedOutput("bash", "-c", crlCmd)
-
-	// Copy CRL to server dir
-	runPrivileged("cp", filepath.Join(ovpnEasyRsaDir, "pki", "crl.pem"), ovpnServerDir+"/")
+	if err := revokeOpenVPNClient(name, reason); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "vpn.openvpn.client.revoke", name,
+			openVPNAuditDetails("error", err.Error()), getClientIP(r), false)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logAuditEvent(getUsernameFromToken(r), "vpn.openvpn.client.revoke", name,
+		openVPNAuditDetails("reason", reason), getClientIP(r), true)
 
-	// Remove .ovpn
-	os.Remove(filepath.Join("/var/www/softrouter/vpn_configs", name+".ovpn"))
+	// New handshakes check the CRL right away, a restart also ends the
+	// client's open session
+	if out, _ := runPrivilegedOutput("systemctl", "is-active", ovpnSystemd); strings.TrimSpace(string(out)) == "active" {
+		runPrivileged("systemctl", "restart", ovpnSystemd)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
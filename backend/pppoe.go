@@ -0,0 +1,690 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PPPoEConnection is a PPPoE session run by pppd on an Ethernet port. The
+// resulting ppp<unit> interface is labeled WAN and added to the WANStore in
+// pppoe addressing mode.
+type PPPoEConnection struct {
+	Unit        int    `json:"unit"` // pppd unit, the interface is ppp<unit>
+	Name        string `json:"name"`
+	Interface   string `json:"interface"` // Ethernet port the session runs on
+	Username    string `json:"username"`
+	Password    string `json:"password,omitempty"` // Only written to the secrets files, masked in the API
+	ServiceName string `json:"service_name,omitempty"`
+	ACName      string `json:"ac_name,omitempty"`
+	MTU         int    `json:"mtu,omitempty"` // MTU and MRU, 1492 when unset
+	IPv6        bool   `json:"ipv6"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// PPPoEStore holds the configured sessions
+type PPPoEStore struct {
+	Connections []PPPoEConnection `json:"connections"`
+}
+
+// PPPoEStatus is the runtime state of a session
+type PPPoEStatus struct {
+	Unit         int            `json:"unit"`
+	Name         string         `json:"name"`
+	PPPInterface string         `json:"ppp_interface"`
+	Interface    string         `json:"interface"`
+	Enabled      bool           `json:"enabled"`
+	Service      string         `json:"service"` // systemctl is-active
+	State        string         `json:"state"`   // connected, connecting, stopped
+	LocalIP      string         `json:"local_ip,omitempty"`
+	PeerIP       string         `json:"peer_ip,omitempty"`
+	MTU          int            `json:"mtu,omitempty"`
+	Counters     InterfaceStats `json:"counters"`
+}
+
+const (
+	defaultPPPoEMTU   = 1492
+	maxPPPUnit        = 63
+	pppSecretsBegin   = "# BEGIN SoftRouter PPPoE - managed automatically"
+	pppSecretsEnd     = "# END SoftRouter PPPoE"
+	pppoeUnitTemplate = "softrouter-pppoe-%d.service"
+)
+
+var (
+	pppoeStore      PPPoEStore
+	pppoeLock       sync.RWMutex
+	pppoeConfigPath = "/etc/softrouter/pppoe.json"
+	pppPeersDir     = "/etc/ppp/peers"
+	pppoeUnitDir    = "/etc/systemd/system"
+	pppSecretsFiles = []string{"/etc/ppp/chap-secrets", "/etc/ppp/pap-secrets"}
+)
+
+func initPPPoE() {
+	loadPPPoEConfig()
+	applyPPPoEConnections(nil)
+}
+
+func loadPPPoEConfig() {
+	pppoeLock.Lock()
+	defer pppoeLock.Unlock()
+
+	pppoeStore = PPPoEStore{Connections: []PPPoEConnection{}}
+	data, err := os.ReadFile(pppoeConfigPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error loading PPPoE config: %v\n", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &pppoeStore); err != nil {
+		fmt.Printf("Error parsing PPPoE config: %v\n", err)
+	}
+}
+
+func savePPPoEConfig() error {
+	pppoeLock.RLock()
+	data, err := json.MarshalIndent(pppoeStore, "", "  ")
+	pppoeLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	// Holds the PPP passwords
+	return os.WriteFile(pppoeConfigPath, data, 0600)
+}
+
+// GetPPPoEConnections returns a copy of the configured sessions
+func GetPPPoEConnections() []PPPoEConnection {
+	pppoeLock.RLock()
+	defer pppoeLock.RUnlock()
+	return append([]PPPoEConnection(nil), pppoeStore.Connections...)
+}
+
+func pppInterfaceName(unit int) string {
+	return fmt.Sprintf("ppp%d", unit)
+}
+
+func pppoePeerName(unit int) string {
+	return fmt.Sprintf("softrouter-ppp%d", unit)
+}
+
+func pppoeUnitName(unit int) string {
+	return fmt.Sprintf(pppoeUnitTemplate, unit)
+}
+
+func (c PPPoEConnection) mtu() int {
+	if c.MTU == 0 {
+		return defaultPPPoEMTU
+	}
+	return c.MTU
+}
+
+// isSafePPPValue rejects values that could break out of a quoted pppd option
+// or a secrets file line
+func isSafePPPValue(s string) bool {
+	return !strings.ContainsAny(s, "\"\\\r\n\x00")
+}
+
+func validatePPPoEConnection(c PPPoEConnection, existing []PPPoEConnection) error {
+	if c.Unit < 0 || c.Unit > maxPPPUnit {
+		return fmt.Errorf("unit must be between 0 and %d", maxPPPUnit)
+	}
+	if c.Name == "" || !isSafePPPValue(c.Name) {
+		return fmt.Errorf("invalid name")
+	}
+	if !isValidInterfaceName(c.Interface) || strings.HasPrefix(c.Interface, "ppp") {
+		return fmt.Errorf("invalid Ethernet interface")
+	}
+	if c.Username == "" || !isSafePPPValue(c.Username) || strings.ContainsAny(c.Username, " \t#") {
+		return fmt.Errorf("invalid username")
+	}
+	if !isSafePPPValue(c.Password) {
+		return fmt.Errorf("password must not contain quotes, backslashes or line breaks")
+	}
+	if !isSafePPPValue(c.ServiceName) || !isSafePPPValue(c.ACName) {
+		return fmt.Errorf("invalid service or access concentrator name")
+	}
+	if c.MTU != 0 && (c.MTU < 576 || c.MTU > 1492) {
+		return fmt.Errorf("MTU must be between 576 and 1492")
+	}
+	for _, other := range existing {
+		if other.Unit == c.Unit {
+			continue
+		}
+		if other.Interface == c.Interface && other.Enabled && c.Enabled {
+			return fmt.Errorf("%s already carries PPPoE session %s", c.Interface, other.Name)
+		}
+	}
+	return nil
+}
+
+// renderPPPoEPeer builds the pppd options file. Routing is left to the WAN
+// manager (nodefaultroute), which learns the peer address as the gateway.
+func renderPPPoEPeer(c PPPoEConnection) string {
+	var b strings.Builder
+	b.WriteString("# Auto-generated by SoftRouter - DO NOT EDIT MANUALLY\n")
+	b.WriteString("plugin rp-pppoe.so\n")
+	b.WriteString(fmt.Sprintf("nic-%s\n", c.Interface))
+	if c.ServiceName != "" {
+		b.WriteString(fmt.Sprintf("rp_pppoe_service \"%s\"\n", c.ServiceName))
+	}
+	if c.ACName != "" {
+		b.WriteString(fmt.Sprintf("rp_pppoe_ac \"%s\"\n", c.ACName))
+	}
+	b.WriteString(fmt.Sprintf("user \"%s\"\n", c.Username))
+	b.WriteString(fmt.Sprintf("unit %d\n", c.Unit))
+	b.WriteString("noauth\n")
+	b.WriteString("hide-password\n")
+	b.WriteString("noipdefault\n")
+	b.WriteString("nodefaultroute\n")
+	b.WriteString("usepeerdns\n")
+	if c.IPv6 {
+		b.WriteString("+ipv6\n")
+	}
+	b.WriteString(fmt.Sprintf("mtu %d\n", c.mtu()))
+	b.WriteString(fmt.Sprintf("mru %d\n", c.mtu()))
+	b.WriteString("persist\n")
+	b.WriteString("maxfail 0\n")
+	b.WriteString("holdoff 5\n")
+	b.WriteString("lcp-echo-interval 20\n")
+	b.WriteString("lcp-echo-failure 3\n")
+	return b.String()
+}
+
+// renderPPPoEService builds the systemd unit that keeps pppd running
+func renderPPPoEService(c PPPoEConnection) string {
+	var b strings.Builder
+	b.WriteString("# Auto-generated by SoftRouter - DO NOT EDIT MANUALLY\n")
+	b.WriteString("[Unit]\n")
+	b.WriteString(fmt.Sprintf("Description=SoftRouter PPPoE %s on %s\n", c.Name, c.Interface))
+	b.WriteString("After=network.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString(fmt.Sprintf("ExecStart=/usr/sbin/pppd call %s nodetach\n", pppoePeerName(c.Unit)))
+	b.WriteString("Restart=always\n")
+	b.WriteString("RestartSec=10\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// mergePPPSecrets replaces the managed block of a chap/pap-secrets file,
+// keeping entries added by hand
+func mergePPPSecrets(existing string, conns []PPPoEConnection) string {
+	var kept []string
+	inBlock := false
+	for _, line := range strings.Split(strings.TrimRight(existing, "\n"), "\n") {
+		switch {
+		case line == pppSecretsBegin:
+			inBlock = true
+		case line == pppSecretsEnd:
+			inBlock = false
+		case !inBlock && (line != "" || len(kept) > 0):
+			kept = append(kept, line)
+		}
+	}
+
+	var b strings.Builder
+	for _, line := range kept {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString(pppSecretsBegin + "\n")
+	for _, c := range conns {
+		b.WriteString(fmt.Sprintf("\"%s\" * \"%s\" *\n", c.Username, c.Password))
+	}
+	b.WriteString(pppSecretsEnd + "\n")
+	return b.String()
+}
+
+func writePPPSecrets(conns []PPPoEConnection) error {
+	for _, path := range pppSecretsFiles {
+		existing, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(mergePPPSecrets(string(existing), conns)), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPPPoEConnections writes the secrets, peer files and units, (re)starts
+// changed sessions and stops the ones removed or disabled since previous
+func applyPPPoEConnections(previous []PPPoEConnection) {
+	conns := GetPPPoEConnections()
+
+	var enabled []PPPoEConnection
+	for _, c := range conns {
+		if c.Enabled {
+			enabled = append(enabled, c)
+		}
+	}
+	if len(enabled) > 0 || len(previous) > 0 {
+		if err := writePPPSecrets(enabled); err != nil {
+			fmt.Printf("WARNING: Failed to write PPP secrets: %v\n", err)
+		}
+	}
+
+	active := make(map[int]bool)
+	oldSecrets := make(map[int]string)
+	for _, c := range previous {
+		oldSecrets[c.Unit] = c.Username + "\x00" + c.Password
+	}
+
+	for _, c := range enabled {
+		active[c.Unit] = true
+
+		peerPath := filepath.Join(pppPeersDir, pppoePeerName(c.Unit))
+		unitPath := filepath.Join(pppoeUnitDir, pppoeUnitName(c.Unit))
+		peer, unit := renderPPPoEPeer(c), renderPPPoEService(c)
+
+		existingPeer, _ := os.ReadFile(peerPath)
+		existingUnit, _ := os.ReadFile(unitPath)
+		secretsChanged := previous != nil && oldSecrets[c.Unit] != c.Username+"\x00"+c.Password
+		if string(existingPeer) == peer && string(existingUnit) == unit && !secretsChanged {
+			continue // Unchanged and already running
+		}
+
+		if err := os.MkdirAll(pppPeersDir, 0755); err != nil {
+			fmt.Printf("WARNING: Failed to create %s: %v\n", pppPeersDir, err)
+		}
+		if err := os.WriteFile(peerPath, []byte(peer), 0640); err != nil {
+			fmt.Printf("WARNING: Failed to write %s: %v\n", peerPath, err)
+			continue
+		}
+		if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+			fmt.Printf("WARNING: Failed to write %s: %v\n", unitPath, err)
+			continue
+		}
+		if err := runPrivileged("systemctl", "daemon-reload"); err != nil {
+			fmt.Printf("WARNING: systemctl daemon-reload failed: %v\n", err)
+		}
+		if err := runPrivileged("systemctl", "enable", pppoeUnitName(c.Unit)); err != nil {
+			fmt.Printf("WARNING: Failed to enable PPPoE %s: %v\n", c.Name, err)
+		}
+		if err := runPrivileged("systemctl", "restart", pppoeUnitName(c.Unit)); err != nil {
+			fmt.Printf("WARNING: Failed to start PPPoE %s: %v\n", c.Name, err)
+		}
+	}
+
+	for _, c := range previous {
+		if active[c.Unit] {
+			continue
+		}
+		if err := runPrivileged("systemctl", "disable", "--now", pppoeUnitName(c.Unit)); err != nil {
+			fmt.Printf("WARNING: Failed to stop PPPoE %s: %v\n", c.Name, err)
+		}
+		os.Remove(filepath.Join(pppoeUnitDir, pppoeUnitName(c.Unit))) //nolint:errcheck
+		os.Remove(filepath.Join(pppPeersDir, pppoePeerName(c.Unit)))  //nolint:errcheck
+	}
+}
+
+// mergePPPoEWANs returns the WAN list with a pppoe-mode entry for every
+// session and without the entries of sessions removed since previous
+func mergePPPoEWANs(interfaces []WANInterface, conns, previous []PPPoEConnection) []WANInterface {
+	current := make(map[string]PPPoEConnection)
+	for _, c := range conns {
+		current[pppInterfaceName(c.Unit)] = c
+	}
+	removed := make(map[string]bool)
+	for _, c := range previous {
+		if _, ok := current[pppInterfaceName(c.Unit)]; !ok {
+			removed[pppInterfaceName(c.Unit)] = true
+		}
+	}
+
+	merged := []WANInterface{}
+	seen := make(map[string]bool)
+	for _, wan := range interfaces {
+		if removed[wan.Interface] {
+			continue
+		}
+		if c, ok := current[wan.Interface]; ok {
+			wan.AddressMode = "pppoe"
+			wan.Enabled = c.Enabled
+			seen[wan.Interface] = true
+		}
+		merged = append(merged, wan)
+	}
+	for _, c := range conns {
+		iface := pppInterfaceName(c.Unit)
+		if seen[iface] {
+			continue
+		}
+		merged = append(merged, WANInterface{
+			Interface:   iface,
+			Name:        c.Name,
+			AddressMode: "pppoe",
+			Priority:    len(merged) + 1,
+			Weight:      1,
+			Enabled:     c.Enabled,
+			State:       "unknown",
+		})
+	}
+	assignWANRouteIDs(merged)
+	return merged
+}
+
+// syncPPPoEWANs plugs the sessions into the WANStore and labels new ppp
+// interfaces WAN (an existing label is kept)
+func syncPPPoEWANs(conns, previous []PPPoEConnection) {
+	wanLock.Lock()
+	wanStore.Interfaces = mergePPPoEWANs(wanStore.Interfaces, conns, previous)
+	wanLock.Unlock()
+
+	if err := saveWANConfig(); err != nil {
+		fmt.Printf("Error saving WAN config: %v\n", err)
+	}
+
+	current := make(map[string]PPPoEConnection)
+	for _, c := range conns {
+		current[pppInterfaceName(c.Unit)] = c
+	}
+
+	store, err := loadInterfaceMetadata()
+	if err != nil {
+		fmt.Printf("Error loading interface metadata: %v\n", err)
+		return
+	}
+	changed := false
+	for iface, c := range current {
+		if _, ok := store.Metadata[iface]; ok {
+			continue // Keep the operator's label
+		}
+		store.Metadata[iface] = InterfaceMetadata{
+			InterfaceName: iface,
+			Label:         "WAN",
+			Description:   fmt.Sprintf("PPPoE %s on %s", c.Name, c.Interface),
+		}
+		changed = true
+	}
+	for _, c := range previous {
+		iface := pppInterfaceName(c.Unit)
+		if _, ok := current[iface]; ok {
+			continue
+		}
+		if meta, ok := store.Metadata[iface]; ok && strings.HasPrefix(meta.Description, "PPPoE ") {
+			delete(store.Metadata, iface)
+			changed = true
+		}
+	}
+	if changed {
+		if err := saveInterfaceMetadata(store); err != nil {
+			fmt.Printf("Error saving interface metadata: %v\n", err)
+		}
+	}
+}
+
+// parsePPPAddresses returns the local and peer IPv4 address of a
+// point-to-point link from `ip -j addr show` output
+func parsePPPAddresses(data []byte) (local, peer string) {
+	var links []struct {
+		AddrInfo []struct {
+			Family  string `json:"family"`
+			Local   string `json:"local"`
+			Address string `json:"address"`
+		} `json:"addr_info"`
+	}
+	if err := json.Unmarshal(data, &links); err != nil {
+		return "", ""
+	}
+	for _, link := range links {
+		for _, a := range link.AddrInfo {
+			if a.Family == "inet" {
+				if a.Address != a.Local {
+					peer = a.Address
+				}
+				return a.Local, peer
+			}
+		}
+	}
+	return "", ""
+}
+
+// readInterfaceCounters reads the kernel counters of an interface
+func readInterfaceCounters(name string) InterfaceStats {
+	stats := InterfaceStats{InterfaceName: name}
+	read := func(counter string) uint64 {
+		data, err := os.ReadFile(filepath.Join("/sys/class/net", name, "statistics", counter))
+		if err != nil {
+			return 0
+		}
+		v, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		return v
+	}
+	stats.RxBytes, stats.TxBytes = read("rx_bytes"), read("tx_bytes")
+	stats.RxPackets, stats.TxPackets = read("rx_packets"), read("tx_packets")
+	stats.RxErrors, stats.TxErrors = read("rx_errors"), read("tx_errors")
+	stats.RxDropped, stats.TxDropped = read("rx_dropped"), read("tx_dropped")
+	return stats
+}
+
+func getPPPoEStatus(c PPPoEConnection) PPPoEStatus {
+	iface := pppInterfaceName(c.Unit)
+	status := PPPoEStatus{
+		Unit:         c.Unit,
+		Name:         c.Name,
+		PPPInterface: iface,
+		Interface:    c.Interface,
+		Enabled:      c.Enabled,
+		State:        "stopped",
+	}
+
+	out, _ := runPrivilegedOutput("systemctl", "is-active", pppoeUnitName(c.Unit))
+	status.Service = strings.TrimSpace(string(out))
+	if status.Service == "active" || status.Service == "activating" {
+		status.State = "connecting"
+	}
+
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return status
+	}
+	status.MTU = link.MTU
+	status.Counters = readInterfaceCounters(iface)
+	if out, err := runPrivilegedOutput("ip", "-4", "-j", "addr", "show", "dev", iface); err == nil {
+		status.LocalIP, status.PeerIP = parsePPPAddresses(out)
+	}
+	if link.Flags&net.FlagUp != 0 && status.LocalIP != "" {
+		status.State = "connected"
+	}
+	return status
+}
+
+// --- API Handlers ---
+
+func listPPPoEConnections(w http.ResponseWriter, r *http.Request) {
+	conns := GetPPPoEConnections()
+	type connWithStatus struct {
+		PPPoEConnection
+		Status PPPoEStatus `json:"status"`
+	}
+	result := make([]connWithStatus, 0, len(conns))
+	for _, c := range conns {
+		status := getPPPoEStatus(c)
+		c.Password = maskPassword(c.Password)
+		result = append(result, connWithStatus{PPPoEConnection: c, Status: status})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, result)
+}
+
+// applyPPPoEChange persists the sessions and rolls the change out to pppd, the
+// WAN manager and the firewall
+func applyPPPoEChange(w http.ResponseWriter, r *http.Request, previous []PPPoEConnection, action, resource, details string) bool {
+	if err := savePPPoEConfig(); err != nil {
+		pppoeLock.Lock()
+		pppoeStore.Connections = previous
+		pppoeLock.Unlock()
+		logAuditEvent(getUsernameFromToken(r), action, resource,
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save PPPoE configuration", err)
+		return false
+	}
+
+	conns := GetPPPoEConnections()
+	applyPPPoEConnections(previous)
+	syncPPPoEWANs(conns, previous)
+	go firewallManager.ApplyFirewallRules()
+	go checkWANHealth()
+
+	logAuditEvent(getUsernameFromToken(r), action, resource, details, getClientIP(r), true)
+	return true
+}
+
+// pppoeAuditDetails renders a session for the audit log without its password
+func pppoeAuditDetails(c PPPoEConnection) string {
+	c.Password = ""
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+func createPPPoEConnection(w http.ResponseWriter, r *http.Request) {
+	var c PPPoEConnection
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	pppoeLock.Lock()
+	previous := append([]PPPoEConnection(nil), pppoeStore.Connections...)
+	for _, other := range previous {
+		if other.Unit == c.Unit {
+			pppoeLock.Unlock()
+			respondInvalidRequest(w, fmt.Sprintf("Unit %d is already in use", c.Unit))
+			return
+		}
+	}
+	if err := validatePPPoEConnection(c, previous); err != nil {
+		pppoeLock.Unlock()
+		respondInvalidRequest(w, err.Error())
+		return
+	}
+	pppoeStore.Connections = append(pppoeStore.Connections, c)
+	pppoeLock.Unlock()
+
+	if !applyPPPoEChange(w, r, previous, "network.pppoe.create", pppInterfaceName(c.Unit), pppoeAuditDetails(c)) {
+		return
+	}
+
+	c.Password = maskPassword(c.Password)
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, c)
+}
+
+func updatePPPoEConnection(w http.ResponseWriter, r *http.Request) {
+	unit, err := strconv.Atoi(r.URL.Query().Get("unit"))
+	if err != nil {
+		respondInvalidRequest(w, "unit required")
+		return
+	}
+
+	var c PPPoEConnection
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	c.Unit = unit
+
+	pppoeLock.Lock()
+	previous := append([]PPPoEConnection(nil), pppoeStore.Connections...)
+	idx := -1
+	for i, other := range previous {
+		if other.Unit == unit {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		pppoeLock.Unlock()
+		respondWithError(w, ErrGenericNotFound, "PPPoE connection not found", http.StatusNotFound, nil)
+		return
+	}
+	// Masked or omitted password: keep the stored one
+	if c.Password == "" || c.Password == maskPassword(previous[idx].Password) {
+		c.Password = previous[idx].Password
+	}
+	if err := validatePPPoEConnection(c, previous); err != nil {
+		pppoeLock.Unlock()
+		respondInvalidRequest(w, err.Error())
+		return
+	}
+	pppoeStore.Connections[idx] = c
+	pppoeLock.Unlock()
+
+	if !applyPPPoEChange(w, r, previous, "network.pppoe.update", pppInterfaceName(unit), pppoeAuditDetails(c)) {
+		return
+	}
+
+	c.Password = maskPassword(c.Password)
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, c)
+}
+
+func deletePPPoEConnection(w http.ResponseWriter, r *http.Request) {
+	unit, err := strconv.Atoi(r.URL.Query().Get("unit"))
+	if err != nil {
+		respondInvalidRequest(w, "unit required")
+		return
+	}
+
+	pppoeLock.Lock()
+	previous := append([]PPPoEConnection(nil), pppoeStore.Connections...)
+	conns := []PPPoEConnection{}
+	for _, c := range previous {
+		if c.Unit != unit {
+			conns = append(conns, c)
+		}
+	}
+	pppoeStore.Connections = conns
+	pppoeLock.Unlock()
+
+	if len(conns) == len(previous) {
+		respondWithError(w, ErrGenericNotFound, "PPPoE connection not found", http.StatusNotFound, nil)
+		return
+	}
+
+	if !applyPPPoEChange(w, r, previous, "network.pppoe.delete", pppInterfaceName(unit), fmt.Sprintf("{\"unit\":%d}", unit)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
+
+// reconnectPPPoEConnection restarts a session's pppd
+func reconnectPPPoEConnection(w http.ResponseWriter, r *http.Request) {
+	unit, err := strconv.Atoi(r.URL.Query().Get("unit"))
+	if err != nil {
+		respondInvalidRequest(w, "unit required")
+		return
+	}
+
+	found := false
+	for _, c := range GetPPPoEConnections() {
+		if c.Unit == unit && c.Enabled {
+			found = true
+		}
+	}
+	if !found {
+		respondWithError(w, ErrGenericNotFound, "Enabled PPPoE connection not found", http.StatusNotFound, nil)
+		return
+	}
+
+	if err := runPrivileged("systemctl", "restart", pppoeUnitName(unit)); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "network.pppoe.reconnect", pppInterfaceName(unit),
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemServiceControl, "Failed to restart PPPoE session", err)
+		return
+	}
+	logAuditEvent(getUsernameFromToken(r), "network.pppoe.reconnect", pppInterfaceName(unit), "", getClientIP(r), true)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "reconnecting"})
+}
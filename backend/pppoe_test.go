@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderPPPoEPeer(t *testing.T) {
+	c := PPPoEConnection{Unit: 1, Name: "DSL", Interface: "eth2", Username: "user@isp", Password: "hunter2", ServiceName: "internet", MTU: 1480, IPv6: true, Enabled: true}
+	peer := renderPPPoEPeer(c)
+
+	for _, s := range []string{
+		"plugin rp-pppoe.so\nnic-eth2\n",
+		"rp_pppoe_service \"internet\"\n",
+		"user \"user@isp\"\nunit 1\n",
+		"nodefaultroute\n",
+		"+ipv6\n",
+		"mtu 1480\nmru 1480\n",
+		"persist\nmaxfail 0\n",
+	} {
+		if !strings.Contains(peer, s) {
+			t.Errorf("Expected peer file to contain %q.\n%s", s, peer)
+		}
+	}
+	if strings.Contains(peer, "hunter2") {
+		t.Errorf("Peer file must not carry the password:\n%s", peer)
+	}
+
+	unit := renderPPPoEService(c)
+	if !strings.Contains(unit, "ExecStart=/usr/sbin/pppd call softrouter-ppp1 nodetach\n") {
+		t.Errorf("Unexpected unit:\n%s", unit)
+	}
+}
+
+func TestMergePPPSecrets(t *testing.T) {
+	existing := "# Secrets for authentication using CHAP\n\"manual\" * \"keep\" *\n" +
+		pppSecretsBegin + "\n\"old\" * \"gone\" *\n" + pppSecretsEnd + "\n"
+
+	got := mergePPPSecrets(existing, []PPPoEConnection{{Username: "user@isp", Password: "p4ss word"}})
+	want := "# Secrets for authentication using CHAP\n\"manual\" * \"keep\" *\n" +
+		pppSecretsBegin + "\n\"user@isp\" * \"p4ss word\" *\n" + pppSecretsEnd + "\n"
+	if got != want {
+		t.Errorf("Unexpected secrets file.\nGot:\n%s\nWant:\n%s", got, want)
+	}
+
+	// Idempotent
+	if again := mergePPPSecrets(got, []PPPoEConnection{{Username: "user@isp", Password: "p4ss word"}}); again != got {
+		t.Errorf("Re-merging changed the file:\n%s", again)
+	}
+}
+
+func TestValidatePPPoEConnection(t *testing.T) {
+	valid := PPPoEConnection{Unit: 0, Name: "Fiber", Interface: "eth1", Username: "user@isp", Password: "secret", Enabled: true}
+	if err := validatePPPoEConnection(valid, nil); err != nil {
+		t.Errorf("Expected valid connection, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*PPPoEConnection)
+	}{
+		{"unit", func(c *PPPoEConnection) { c.Unit = 64 }},
+		{"ppp over ppp", func(c *PPPoEConnection) { c.Interface = "ppp1" }},
+		{"quote in password", func(c *PPPoEConnection) { c.Password = "a\"b" }},
+		{"newline in username", func(c *PPPoEConnection) { c.Username = "a\nb" }},
+		{"mtu", func(c *PPPoEConnection) { c.MTU = 1500 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.mutate(&c)
+			if err := validatePPPoEConnection(c, nil); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+
+	other := []PPPoEConnection{{Unit: 1, Name: "Other", Interface: "eth1", Enabled: true}}
+	if err := validatePPPoEConnection(valid, other); err == nil {
+		t.Error("Expected error for two sessions on one port")
+	}
+}
+
+func TestGenerateFullRulesetPPPoEClamping(t *testing.T) {
+	in := testRulesetInputs()
+	in.PPPoE = []PPPoEConnection{
+		{Unit: 0, Interface: "eth2", Enabled: true},
+		{Unit: 1, Interface: "eth3", Enabled: false},
+	}
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	expected := []string{
+		"    oifname \"ppp*\" tcp option maxseg size set rt mtu comment \"MSS clamp ppp*\"\n",
+		"    iifname \"ppp0\" meta nfproto ipv4 tcp option maxseg size 1453-65535 tcp option maxseg size set 1452 comment \"MSS clamp ppp0 inbound\"\n",
+		"    iifname \"ppp0\" meta nfproto ipv6 tcp option maxseg size 1433-65535 tcp option maxseg size set 1432 comment \"MSS clamp ppp0 inbound\"\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain %q.\nGenerated Ruleset:\n%s", s, ruleset)
+		}
+	}
+	if strings.Contains(ruleset, "ppp1") {
+		t.Error("Disabled sessions must not get inbound clamping")
+	}
+}
+
+func TestMergePPPoEWANs(t *testing.T) {
+	interfaces := []WANInterface{
+		{Interface: "eth0", Gateway: "192.0.2.1", Enabled: true, RouteID: 1},
+		{Interface: "ppp1", AddressMode: "pppoe", Enabled: true, RouteID: 2},
+		{Interface: "ppp5", AddressMode: "pppoe", Enabled: true, RouteID: 3}, // Not managed here
+	}
+	previous := []PPPoEConnection{{Unit: 1, Name: "Old", Interface: "eth3", Enabled: true}}
+	conns := []PPPoEConnection{{Unit: 0, Name: "Fiber", Interface: "eth2", Enabled: true}}
+
+	got := mergePPPoEWANs(interfaces, conns, previous)
+
+	var names []string
+	for _, wan := range got {
+		names = append(names, wan.Interface)
+	}
+	if strings.Join(names, ",") != "eth0,ppp5,ppp0" {
+		t.Fatalf("Expected eth0,ppp5,ppp0, got %v", names)
+	}
+	if ppp0 := got[2]; ppp0.AddressMode != "pppoe" || ppp0.Name != "Fiber" || ppp0.RouteID != 2 {
+		t.Errorf("Expected ppp0 in pppoe mode with the free route ID 2, got %+v", ppp0)
+	}
+
+	// A disabled session disables its WAN but keeps the entry
+	conns[0].Enabled = false
+	if again := mergePPPoEWANs(got, conns, conns); len(again) != 3 || again[2].Enabled {
+		t.Errorf("Expected disabled ppp0 WAN, got %+v", again)
+	}
+}
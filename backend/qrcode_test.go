@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestQRReedSolomon(t *testing.T) {
+	// "01234567" at 1-M, ISO/IEC 18004 annex I
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	want := []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+	if got := qrRSRemainder(data, qrRSDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("Expected ECC % X, got % X", want, got)
+	}
+}
+
+func TestQRAlignmentPositions(t *testing.T) {
+	for ver, want := range map[int][]int{2: {6, 18}, 7: {6, 22, 38}, 32: {6, 34, 60, 86, 112, 138}} {
+		got := qrAlignmentPositions(ver)
+		if len(got) != len(want) {
+			t.Errorf("Version %d: expected %v, got %v", ver, want, got)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Version %d: expected %v, got %v", ver, want, got)
+				break
+			}
+		}
+	}
+}
+
+// decodeQR reads a symbol back: format information, unmasking, codeword
+// placement and de-interleaving, checking every block's ECC on the way
+func decodeQR(t *testing.T, qr *qrCode) []byte {
+	t.Helper()
+
+	format := 0
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | b2i(qr.modules[8][14-i])
+	}
+	format = format<<1 | b2i(qr.modules[8][7])
+	format = format<<1 | b2i(qr.modules[8][8])
+	format = format<<1 | b2i(qr.modules[7][8])
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | b2i(qr.modules[i][8])
+	}
+	format ^= 0x5412
+	if format>>13 != qrFormatBitsM {
+		t.Fatalf("Expected error correction level M, format %015b", format)
+	}
+	mask := format >> 10 & 7
+
+	ref := newQRCode(qr.version)
+	ref.drawFunctionPatterns()
+	var bits []bool
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if !ref.isFunction[y][x] {
+					bits = append(bits, qr.modules[y][x] != qrMaskBit(mask, x, y))
+				}
+			}
+		}
+	}
+
+	raw := make([]byte, qrNumRawDataModules(qr.version)/8)
+	for i := range raw {
+		for k := 0; k < 8; k++ {
+			raw[i] = raw[i]<<1 | byte(b2i(bits[i*8+k]))
+		}
+	}
+
+	numBlocks, eccLen := qrNumBlocksM[qr.version], qrECCPerBlockM[qr.version]
+	numShort := numBlocks - len(raw)%numBlocks
+	shortLen := len(raw) / numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortLen; i++ {
+		for j := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+
+	var data []byte
+	for j, block := range blocks {
+		datLen := len(block) - eccLen
+		if got := qrRSRemainder(block[:datLen], qrRSDivisor(eccLen)); !bytes.Equal(got, block[datLen:]) {
+			t.Fatalf("Block %d: ECC mismatch", j)
+		}
+		data = append(data, block[:datLen]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("Expected byte mode, got %X", data[0]>>4)
+	}
+	countBits := 8
+	if qr.version >= 10 {
+		countBits = 16
+	}
+	read := func(pos, n int) int {
+		v := 0
+		for i := pos; i < pos+n; i++ {
+			v = v<<1 | int(data[i/8]>>uint(7-i%8)&1)
+		}
+		return v
+	}
+	count := read(4, countBits)
+	out := make([]byte, count)
+	for i := range out {
+		out[i] = byte(read(4+countBits+i*8, 8))
+	}
+	return out
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestQRRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 14, 100, 300, 650} {
+		data := []byte(strings.Repeat("[Peer]\nPublicKey = abc+/=\n", n/26+1)[:n])
+		qr, err := encodeQR(data)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if got := decodeQR(t, qr); !bytes.Equal(got, data) {
+			t.Errorf("Version %d: decoded %q, want %q", qr.version, got, data)
+		}
+		if qr.version >= 7 && !qr.isFunction[0][qr.size-11] {
+			t.Errorf("Version %d: missing version information", qr.version)
+		}
+	}
+
+	if _, err := encodeQR(make([]byte, 3000)); err == nil {
+		t.Error("Expected data beyond version 40 to be rejected")
+	}
+}
+
+func TestQRPNG(t *testing.T) {
+	out, err := qrPNG([]byte("hello"), 4)
+	if err != nil {
+		t.Fatalf("qrPNG failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+	// Version 1 is 21 modules plus a 4 module quiet zone on each side
+	if b := img.Bounds(); b.Dx() != 29*4 || b.Dy() != 29*4 {
+		t.Errorf("Unexpected size %v", b)
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("Quiet zone must be light")
+	}
+	if r, _, _, _ := img.At(4*4, 4*4).RGBA(); r != 0 {
+		t.Error("Finder pattern corner must be dark")
+	}
+}
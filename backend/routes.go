@@ -5,16 +5,19 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 )
 
 // StaticRoute represents a user-defined static route
 type StaticRoute struct {
 	ID          string `json:"id"`
-	Destination string `json:"destination"` // CIDR (e.g., 10.0.0.0/24)
-	Gateway     string `json:"gateway"`     // Next hop IP
+	Destination string `json:"destination"`         // CIDR (e.g., 10.0.0.0/24)
+	Gateway     string `json:"gateway"`             // Next hop IP
+	Interface   string `json:"interface,omitempty"` // Output device, required without a gateway (tunnels)
 	Metric      int    `json:"metric"`
 	Comment     string `json:"comment"`
+	Source      string `json:"source,omitempty"` // Subsystem owning the route (e.g. "wireguard"), empty for user routes
 }
 
 // RouteStore manages persistence
@@ -76,9 +79,9 @@ func applyRoutes() {
 	fmt.Println("Applying Static Routes...")
 
 	for _, route := range routes {
-		// ip route replace <dest> via <gateway> metric <metric>
+		// ip route replace <dest> [via <gateway>] [dev <interface>] metric <metric>
 		// "replace" is idempotent-ish (will update if changed, add if new)
-		args := []string{"route", "replace", route.Destination, "via", route.Gateway}
+		args := append([]string{"route", "replace"}, staticRouteArgs(route)...)
 		if route.Metric > 0 {
 			args = append(args, "metric", fmt.Sprintf("%d", route.Metric))
 		}
@@ -86,16 +89,59 @@ func applyRoutes() {
 		if out, err := runPrivilegedCombinedOutput("ip", args...); err != nil {
 			fmt.Printf("Failed to apply route %s: %v (%s)\n", route.Destination, err, string(out))
 		} else {
-			fmt.Printf("Applied route: %s via %s\n", route.Destination, route.Gateway)
+			fmt.Printf("Applied route: %s\n", strings.Join(staticRouteArgs(route), " "))
 		}
 	}
 }
 
+// staticRouteArgs returns the destination and next hop arguments of a route
+func staticRouteArgs(route StaticRoute) []string {
+	args := []string{route.Destination}
+	if route.Gateway != "" {
+		args = append(args, "via", route.Gateway)
+	}
+	if route.Interface != "" {
+		args = append(args, "dev", route.Interface)
+	}
+	return args
+}
+
 // deleteSystemRoute removes the route from kernel
 func deleteSystemRoute(route StaticRoute) error {
 	// ip route del <dest> via <gateway>
 	// We ignore errors if route doesn't exist to allow cleanup of stale db entries
-	return runPrivileged("ip", "route", "del", route.Destination, "via", route.Gateway)
+	return runPrivileged("ip", append([]string{"route", "del"}, staticRouteArgs(route)...)...)
+}
+
+// replaceManagedRoutes swaps the routes owned by a subsystem for routes,
+// removing the ones that went away from the kernel
+func replaceManagedRoutes(source string, routes []StaticRoute) error {
+	wanted := make(map[string]bool)
+	for _, rt := range routes {
+		wanted[strings.Join(staticRouteArgs(rt), " ")] = true
+	}
+
+	routeStoreLock.Lock()
+	merged := make([]StaticRoute, 0, len(routeStore.Routes)+len(routes))
+	var stale []StaticRoute
+	for _, rt := range routeStore.Routes {
+		if rt.Source != source {
+			merged = append(merged, rt)
+		} else if !wanted[strings.Join(staticRouteArgs(rt), " ")] {
+			stale = append(stale, rt)
+		}
+	}
+	routeStore.Routes = append(merged, routes...)
+	routeStoreLock.Unlock()
+
+	for _, rt := range stale {
+		deleteSystemRoute(rt) //nolint:errcheck
+	}
+	if err := saveRoutes(); err != nil {
+		return err
+	}
+	applyRoutes()
+	return nil
 }
 
 // --- Handlers ---
@@ -116,10 +162,15 @@ func createRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Destination == "" || req.Gateway == "" {
-		http.Error(w, "Destination and Gateway are required", http.StatusBadRequest)
+	if req.Destination == "" || (req.Gateway == "" && req.Interface == "") {
+		http.Error(w, "Destination and Gateway or Interface are required", http.StatusBadRequest)
+		return
+	}
+	if req.Interface != "" && !isValidInterfaceName(req.Interface) {
+		http.Error(w, "Invalid interface name", http.StatusBadRequest)
 		return
 	}
+	req.Source = "" // Managed routes are only created by their subsystem
 
 	// Generate ID if missing
 	if req.ID == "" {
@@ -155,6 +206,11 @@ func deleteRoute(w http.ResponseWriter, r *http.Request) {
 	var targetRoute *StaticRoute
 
 	for _, rt := range routeStore.Routes {
+		if rt.ID == id && rt.Source != "" {
+			routeStoreLock.Unlock()
+			http.Error(w, "Route is managed by "+rt.Source, http.StatusConflict)
+			return
+		}
 		if rt.ID == id {
 			// Found it, keep reference to delete from system
 			r := rt
@@ -0,0 +1,19 @@
+package main
+
+import "syscall"
+
+// bindToDeviceControl returns a net.Dialer Control func that pins the socket to
+// an interface (SO_BINDTODEVICE), so probes leave via that WAN regardless of
+// the main routing table
+func bindToDeviceControl(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
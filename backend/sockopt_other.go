@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// bindToDeviceControl is a no-op where SO_BINDTODEVICE is unavailable
+func bindToDeviceControl(iface string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
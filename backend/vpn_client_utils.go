@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // VPNClientStatus represents the state of the OpenVPN client connection
@@ -15,20 +18,37 @@ type VPNClientStatus struct {
 	IPAddress   string `json:"ip_address"`
 	Uptime      string `json:"uptime"`
 	ServiceName string `json:"service_name"`
+	Profile     string `json:"profile"`
 }
 
-// VPNPolicy represents a routing rule for Split Tunneling
+// VPNPolicy represents a routing rule for Split Tunneling. Matching traffic
+// is routed through a VPN profile, or kept off the VPNs by a bypass policy.
 type VPNPolicy struct {
-	SourceIP    string `json:"source_ip"`
-	Description string `json:"description"`
+	ID          string   `json:"id"`
+	SourceIP    string   `json:"source_ip"`            // IPv4 address/CIDR
+	SourceSet   string   `json:"source_set,omitempty"` // Named ipv4_addr set (client group)
+	SourceMAC   string   `json:"source_mac,omitempty"`
+	DestCIDR    string   `json:"dest_cidr,omitempty"`  // IPv4 address/CIDR or "@set"
+	Protocol    string   `json:"protocol,omitempty"`   // tcp, udp, tcp_udp or "" (any)
+	DestPorts   string   `json:"dest_ports,omitempty"` // e.g. "443" or "@set"
+	Domains     []string `json:"domains,omitempty"`    // Destinations learned from DNS answers (dnsmasq nftset)
+	Action      string   `json:"action,omitempty"`     // vpn (default) or bypass
+	Profile     string   `json:"profile,omitempty"`    // VPN profile to route through, default: the first profile
+	KillSwitch  bool     `json:"kill_switch"`          // Block the source while the tunnel is down instead of using the WAN
+	Description string   `json:"description"`
 }
 
 const (
 	vpnClientConfigDir = "/etc/openvpn/client"
-	vpnAuthFile        = "/etc/openvpn/client/pia.auth"
-	vpnConfigFile      = "/etc/openvpn/client/pia.conf"
-	vpnSystemdService  = "openvpn-client@pia"
 	vpnPoliciesFile    = "/etc/softrouter/vpn_policies.json"
+
+	// Policy traffic is marked in prerouting with its profile's mark and
+	// routed by fwmark. Kill switch policies also set vpnKillSwitchFlag so
+	// their rules can stay while the tunnel is down. The masks include
+	// vpnBypassFlag, so bypassed packets never match a profile's rule.
+	vpnPolicyMarkMask     = "0x1f000000"
+	vpnKillSwitchMarkMask = "0x3f000000"
+	vpnPolicyMarkClear    = "0xd0ffffff" // Clears the profile and kill switch bits
 )
 
 // loadVPNPolicies reads the persistent list of policies from disk
@@ -41,8 +61,16 @@ func loadVPNPolicies() ([]VPNPolicy, error) {
 		}
 		return nil, err
 	}
-	err = json.Unmarshal(data, &policies)
-	return policies, err
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, err
+	}
+	// Policies saved before IDs existed get one on the next save
+	for i := range policies {
+		if policies[i].ID == "" {
+			policies[i].ID = uuid.NewSHA1(uuid.NameSpaceOID, []byte(policies[i].SourceIP+"@"+policies[i].SourceSet)).String()
+		}
+	}
+	return policies, nil
 }
 
 // saveVPNPolicies writes the list of policies to disk
@@ -54,24 +82,23 @@ func saveVPNPolicies(policies []VPNPolicy) error {
 	return os.WriteFile(vpnPoliciesFile, data, 0644)
 }
 
-// getVPNClientStatus checks systemd and interface status
-func getVPNClientStatus(w http.ResponseWriter, r *http.Request) {
-	status := VPNClientStatus{ServiceName: vpnSystemdService}
+// vpnProfileStatus checks systemd and interface status of a profile
+func vpnProfileStatus(p VPNProfile) VPNClientStatus {
+	status := VPNClientStatus{ServiceName: p.unit(), Profile: p.Name}
 
 	// Check systemd status
-	output, _ := runPrivilegedOutput("systemctl", "is-active", vpnSystemdService)
+	output, _ := runPrivilegedOutput("systemctl", "is-active", p.unit())
 	isActive := strings.TrimSpace(string(output)) == "active"
 
 	status.Connected = isActive
 
 	if isActive {
 		// Get uptime
-		outUptime, _ := runPrivilegedOutput("systemctl", "show", vpnSystemdService, "--property=ActiveEnterTimestamp")
+		outUptime, _ := runPrivilegedOutput("systemctl", "show", p.unit(), "--property=ActiveEnterTimestamp")
 		status.Uptime = strings.TrimPrefix(strings.TrimSpace(string(outUptime)), "ActiveEnterTimestamp=")
 
-		// Get IP from tun1 (assuming we force tun1) or trying to find the tun interface
-		// A robust way creates a specific device name, but let's try to find the one associated with the PID or just 'tun1'
-		outIP, err := runPrivilegedOutput("ip", "-4", "addr", "show", "tun1")
+		// Each profile forces its own device, so the address is read from it
+		outIP, err := runPrivilegedOutput("ip", "-4", "addr", "show", p.Device)
 		if err == nil {
 			lines := strings.Split(string(outIP), "\n")
 			for _, line := range lines {
@@ -85,6 +112,28 @@ func getVPNClientStatus(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	return status
+}
+
+// requestVPNProfile returns the profile named by ?profile=, defaulting to the
+// first profile for callers that predate profiles
+func requestVPNProfile(name string) (VPNProfile, bool) {
+	profiles := GetVPNProfiles()
+	if name != "" {
+		return findVPNProfile(profiles, name)
+	}
+	if len(profiles) == 0 {
+		return VPNProfile{}, false
+	}
+	return profiles[0], true
+}
+
+// getVPNClientStatus reports the tunnel of one profile
+func getVPNClientStatus(w http.ResponseWriter, r *http.Request) {
+	var status VPNClientStatus
+	if p, ok := requestVPNProfile(r.URL.Query().Get("profile")); ok {
+		status = vpnProfileStatus(p)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
@@ -110,9 +159,15 @@ func uploadVPNClientConfig(w http.ResponseWriter, r *http.Request) {
 	// 1. Ensure directories exist
 	os.MkdirAll(vpnClientConfigDir, 0755)
 
+	profile, err := ensureOpenVPNProfile(r.FormValue("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// 2. Save Auth File
 	authContent := fmt.Sprintf("%s\n%s", username, password)
-	if err := os.WriteFile(vpnAuthFile, []byte(authContent), 0600); err != nil {
+	if err := os.WriteFile(profile.authPath(), []byte(authContent), 0600); err != nil {
 		http.Error(w, "Failed to save credentials: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -140,13 +195,13 @@ func uploadVPNClientConfig(w http.ResponseWriter, r *http.Request) {
 	// Inject our mandatory settings
 	configLines = append(configLines, "")
 	configLines = append(configLines, "# SoftRouter Injected Settings")
-	configLines = append(configLines, fmt.Sprintf("auth-user-pass %s", vpnAuthFile))
-	configLines = append(configLines, "dev tun1")          // Force tun1 for easy routing
-	configLines = append(configLines, "route-noexec")      // Manual routing handling
-	configLines = append(configLines, "script-security 2") // Allow scripts if needed (future proofing)
+	configLines = append(configLines, fmt.Sprintf("auth-user-pass %s", profile.authPath()))
+	configLines = append(configLines, "dev "+profile.Device) // Force the profile's device for easy routing
+	configLines = append(configLines, "route-noexec")        // Manual routing handling
+	configLines = append(configLines, "script-security 2")   // Allow scripts if needed (future proofing)
 
 	finalConfig := strings.Join(configLines, "\n")
-	if err := os.WriteFile(vpnConfigFile, []byte(finalConfig), 0644); err != nil {
+	if err := os.WriteFile(profile.configPath(), []byte(finalConfig), 0644); err != nil {
 		http.Error(w, "Failed to write config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -155,25 +210,67 @@ func uploadVPNClientConfig(w http.ResponseWriter, r *http.Request) {
 	runPrivileged("systemctl", "daemon-reload")
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Configuration saved successfully. You can now connect."})
+	json.NewEncoder(w).Encode(map[string]string{"message": "Configuration saved successfully. You can now connect.", "profile": profile.Name})
+}
+
+// ensureOpenVPNProfile returns the OpenVPN profile a config is uploaded to,
+// creating it on first upload. Without a name the first profile is used, or
+// the legacy profile on a fresh router.
+func ensureOpenVPNProfile(name string) (VPNProfile, error) {
+	vpnProfileLock.Lock()
+	defer vpnProfileLock.Unlock()
+
+	if name == "" {
+		if len(vpnProfileStore.Profiles) > 0 {
+			name = vpnProfileStore.Profiles[0].Name
+		} else {
+			name = legacyVPNProfile
+		}
+	}
+	if p, ok := findVPNProfile(vpnProfileStore.Profiles, name); ok {
+		if p.Type != vpnProfileOpenVPN {
+			return VPNProfile{}, fmt.Errorf("profile %s is not an OpenVPN profile", name)
+		}
+		return p, nil
+	}
+
+	p := VPNProfile{Name: name, Type: vpnProfileOpenVPN}
+	if err := allocateVPNProfile(&p, vpnProfileStore.Profiles); err != nil {
+		return VPNProfile{}, err
+	}
+	if err := validateVPNProfile(p, vpnProfileStore.Profiles, nil); err != nil {
+		return VPNProfile{}, err
+	}
+	vpnProfileStore.Profiles = append(vpnProfileStore.Profiles, p)
+	if err := saveVPNProfilesLocked(); err != nil {
+		vpnProfileStore.Profiles = vpnProfileStore.Profiles[:len(vpnProfileStore.Profiles)-1]
+		return VPNProfile{}, err
+	}
+	return p, nil
 }
 
 // controlVPNClient starts/stops the service
 func controlVPNClient(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Action string `json:"action"` // "start" or "stop"
+		Action  string `json:"action"` // "start" or "stop"
+		Profile string `json:"profile"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	profile, ok := requestVPNProfile(req.Profile)
+	if !ok {
+		http.Error(w, "VPN profile not found", http.StatusNotFound)
+		return
+	}
 
 	var output []byte
 	var err error
 	if req.Action == "start" {
-		output, err = runPrivilegedCombinedOutput("systemctl", "restart", vpnSystemdService)
+		output, err = runPrivilegedCombinedOutput("systemctl", "restart", profile.unit())
 	} else {
-		output, err = runPrivilegedCombinedOutput("systemctl", "stop", vpnSystemdService)
+		output, err = runPrivilegedCombinedOutput("systemctl", "stop", profile.unit())
 	}
 
 	if err != nil {
@@ -193,87 +290,133 @@ func controlVPNClient(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-// getVPNPolicies returns the list of policies
+// getVPNPolicies returns the list of policies with their routing status
 func getVPNPolicies(w http.ResponseWriter, r *http.Request) {
 	policies, _ := loadVPNPolicies()
+	profiles := GetVPNProfiles()
+	up := vpnTunnelStates(profiles)
+
+	statuses := make([]VPNPolicyStatus, 0, len(policies))
+	for _, p := range policies {
+		statuses = append(statuses, VPNPolicyStatus{VPNPolicy: p, Status: vpnPolicyStatus(p, profiles, up)})
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(policies)
+	json.NewEncoder(w).Encode(statuses)
 }
 
-// addVPNPolicy adds a new source IP to route through VPN
+// addVPNPolicy adds a new policy routing matching traffic through (or past) a VPN
 func addVPNPolicy(w http.ResponseWriter, r *http.Request) {
 	var req VPNPolicy
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.Action == "" {
+		req.Action = vpnActionVPN
+	}
+	if err := validateVPNPolicy(req, GetVPNProfiles(), GetFirewallSets()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.ID = uuid.New().String()
 
 	policies, _ := loadVPNPolicies()
 	// Check duplicate
 	for _, p := range policies {
-		if p.SourceIP == req.SourceIP {
-			http.Error(w, "Policy for this IP already exists", http.StatusConflict)
+		if p.matchKey() == req.matchKey() {
+			http.Error(w, "Policy for this traffic already exists", http.StatusConflict)
 			return
 		}
 	}
 	policies = append(policies, req)
-	saveVPNPolicies(policies)
-	refreshVPNRouting()
+	if err := saveVPNPolicies(policies); err != nil {
+		http.Error(w, "Failed to save policies: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	applyVPNPolicies(policies)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(policies)
 }
 
-// deleteVPNPolicy removes a policy
+// deleteVPNPolicy removes a policy by ID, or the policies of a source
 func deleteVPNPolicy(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
 	ip := r.URL.Query().Get("ip")
-	if ip == "" {
-		http.Error(w, "IP required", http.StatusBadRequest)
+	set := r.URL.Query().Get("set")
+	if id == "" && ip == "" && set == "" {
+		http.Error(w, "ID, IP or set required", http.StatusBadRequest)
 		return
 	}
 
 	policies, _ := loadVPNPolicies()
-	var newPolicies []VPNPolicy
+	newPolicies := []VPNPolicy{}
 	for _, p := range policies {
-		if p.SourceIP != ip {
-			newPolicies = append(newPolicies, p)
+		if (id != "" && p.ID == id) || (ip != "" && p.SourceIP == ip) || (set != "" && p.SourceSet == set) {
+			continue
 		}
+		newPolicies = append(newPolicies, p)
 	}
-	saveVPNPolicies(newPolicies)
-	refreshVPNRouting()
+	if err := saveVPNPolicies(newPolicies); err != nil {
+		http.Error(w, "Failed to save policies: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	applyVPNPolicies(newPolicies)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(newPolicies)
 }
 
-// refreshVPNRouting applies ip rules based on current policies
+// applyVPNPolicies marks policy traffic in the firewall, points the domain
+// sets at dnsmasq and syncs the ip rules
+func applyVPNPolicies(policies []VPNPolicy) {
+	firewallManager.ApplyFirewallRules()
+	writeVPNDnsmasqConfig(policies)
+	refreshVPNRouting()
+}
+
+// refreshVPNRouting points each profile's table at its tunnel and syncs the
+// policy ip rules. While a tunnel is down only kill switch traffic keeps its
+// rule, and it hits an unreachable default.
 func refreshVPNRouting() {
-	// 1. Ensure Table 100 uses VPN interface
-	// Check if tun1 is up
-	if err := runPrivileged("ip", "link", "show", "tun1"); err != nil {
-		// Tun1 down, no routing possible
-		return
-	}
+	vpnRoutingLock.Lock()
+	defer vpnRoutingLock.Unlock()
 
-	// Add default route to table 100
-	// "ip route replace default dev tun1 table 100"
-	runPrivileged("ip", "route", "replace", "default", "dev", "tun1", "table", "100")
+	profiles := GetVPNProfiles()
+	policies, _ := loadVPNPolicies()
+	up := vpnTunnelStates(profiles)
+
+	for _, profile := range profiles {
+		table := strconv.Itoa(profile.Table)
+		killSwitch := false
+		for _, p := range policies {
+			if pp, ok := policyVPNProfile(p, profiles); ok && pp.Name == profile.Name && p.KillSwitch {
+				killSwitch = true
+			}
+		}
 
-	// 2. Flush existing rules for table 100 to avoid duplicates?
-	// It's hard to selectively flush only ours without tagging.
-	// For now, we will delete known policies and re-add.
-	// Or we can list all rules and delete ones looking up table 100.
-	// "ip rule del lookup 100" loops until error
-	for {
-		if err := runPrivileged("ip", "rule", "del", "lookup", "100"); err != nil {
-			break
+		// The unreachable default sits behind the tunnel's route and takes
+		// over when the tunnel's route disappears
+		if killSwitch {
+			runPrivileged("ip", "route", "replace", "unreachable", "default", "metric", vpnUnreachableMetric, "table", table)
+		} else {
+			runPrivileged("ip", "route", "del", "unreachable", "default", "metric", vpnUnreachableMetric, "table", table)
+		}
+		if up[profile.Name] {
+			runPrivileged("ip", "route", "replace", "default", "dev", profile.Device, "table", table)
 		}
 	}
 
-	// 3. Add rules for each policy
-	policies, _ := loadVPNPolicies()
-	for _, p := range policies {
-		runPrivileged("ip", "rule", "add", "from", p.SourceIP, "lookup", "100")
+	// Rules added by earlier versions had no fixed priority
+	if out, err := runPrivilegedOutput("ip", "-4", "-j", "rule", "show"); err == nil {
+		if current, err := parseIPRules(out); err == nil {
+			for _, r := range legacyVPNIPRules(current, profiles) {
+				runPrivileged("ip", append([]string{"-4", "rule", "del"}, r.args()...)...)
+			}
+		}
+	}
+	if err := syncIPRules("-4", vpnIPRules(policies, profiles, up), vpnRulePrioMin, vpnRulePrioMax); err != nil {
+		fmt.Printf("Failed to sync VPN ip rules: %v\n", err)
 	}
 
 	// Ensure cache flush
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VPNPolicyStatus is a policy with the state of its tunnel
+type VPNPolicyStatus struct {
+	VPNPolicy
+	Status string `json:"status"`
+}
+
+const (
+	vpnPolicyActive      = "active"
+	vpnPolicyBlocked     = "blocked (tunnel down)"
+	vpnPolicyDirect      = "direct (tunnel down)" // No kill switch, the source uses the WAN
+	vpnPolicyNoProfile   = "no profile"
+	vpnPolicyBypass      = "bypass"
+	vpnUnreachableMetric = "4278198272" // Behind any route the tunnel installs
+	vpnKillSwitchFlag    = 0x20000000
+)
+
+var (
+	vpnRoutingLock         sync.Mutex // Serializes refreshVPNRouting
+	vpnMonitorInterval     = 10 * time.Second
+	vpnLastTunnelSignature string
+)
+
+// killSwitchMark returns the profile's mark with the kill switch flag set
+func (p VPNProfile) killSwitchMark() string {
+	return fmt.Sprintf("0x%08x", p.MarkID<<vpnMarkShift|vpnKillSwitchFlag)
+}
+
+// vpnTunnelStates reports which profiles have their tunnel device up
+func vpnTunnelStates(profiles []VPNProfile) map[string]bool {
+	up := make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		iface, err := net.InterfaceByName(p.Device)
+		up[p.Name] = err == nil && iface.Flags&net.FlagUp != 0
+	}
+	return up
+}
+
+func vpnPolicyStatus(policy VPNPolicy, profiles []VPNProfile, up map[string]bool) string {
+	profile, ok := policyVPNProfile(policy, profiles)
+	switch {
+	case policy.bypass():
+		return vpnPolicyBypass
+	case !ok:
+		return vpnPolicyNoProfile
+	case up[profile.Name]:
+		return vpnPolicyActive
+	case policy.KillSwitch:
+		return vpnPolicyBlocked
+	default:
+		return vpnPolicyDirect
+	}
+}
+
+// writeVPNKillSwitch renders the forward chain that drops traffic of kill
+// switch policies unless it leaves through the profile's tunnel. It covers the
+// moments before refreshVPNRouting has reacted to a tunnel going down.
+func writeVPNKillSwitch(b *strings.Builder, policies []VPNPolicy, profiles []VPNProfile) {
+	var rules []string
+	seen := make(map[string]bool)
+	for _, p := range policies {
+		profile, ok := policyVPNProfile(p, profiles)
+		if !ok || !p.KillSwitch || seen[profile.Name] {
+			continue
+		}
+		seen[profile.Name] = true
+		rules = append(rules, fmt.Sprintf("    meta mark & %s == %s oifname != \"%s\" drop comment \"VPN kill switch: %s\"\n",
+			vpnKillSwitchMarkMask, profile.killSwitchMark(), profile.Device, profile.Name))
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	b.WriteString("  chain vpn_kill_switch {\n")
+	b.WriteString("    type filter hook forward priority filter; policy accept;\n")
+	for _, rule := range rules {
+		b.WriteString(rule)
+	}
+	b.WriteString("  }\n\n")
+}
+
+// startVPNRoutingMonitor re-applies the policy routing whenever a tunnel comes
+// up or goes down. The first check applies it at boot.
+func startVPNRoutingMonitor() {
+	go func() {
+		ticker := time.NewTicker(vpnMonitorInterval)
+		for range ticker.C {
+			profiles := GetVPNProfiles()
+			if len(profiles) == 0 {
+				continue
+			}
+			sig := fmt.Sprint(vpnTunnelStates(profiles))
+			if sig == vpnLastTunnelSignature {
+				continue
+			}
+			vpnLastTunnelSignature = sig
+			refreshVPNRouting()
+		}
+	}()
+}
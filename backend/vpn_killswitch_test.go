@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVPNKillSwitch(t *testing.T) {
+	profiles := []VPNProfile{
+		{Name: "pia", Type: vpnProfileOpenVPN, Device: "tun1", Table: 100, MarkID: 1},
+		{Name: "mullvad", Type: vpnProfileWireGuard, Device: "wg2", Table: 101, MarkID: 2},
+	}
+	policies := []VPNPolicy{
+		{SourceIP: "192.168.1.50", KillSwitch: true, Description: "laptop"},
+		{SourceSet: "kids", KillSwitch: true, Description: "kids"},
+		{SourceSet: "tv", Profile: "mullvad", Description: "streaming"},
+	}
+
+	var b strings.Builder
+	writeVPNPolicyMarks(&b, policies, profiles)
+	writeVPNKillSwitch(&b, policies, profiles)
+	ruleset := b.String()
+	for _, s := range []string{
+		"ip saddr 192.168.1.50 meta mark set meta mark & 0xd0ffffff | 0x21000000",
+		"ip saddr @tv meta mark set meta mark & 0xd0ffffff | 0x02000000",
+		"type filter hook forward priority filter; policy accept;\n" +
+			"    meta mark & 0x3f000000 == 0x21000000 oifname != \"tun1\" drop comment \"VPN kill switch: pia\"\n  }",
+	} {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain %q.\n%s", s, ruleset)
+		}
+	}
+	if strings.Contains(ruleset, "kill switch: mullvad") {
+		t.Errorf("Profiles without kill switch policies must not drop.\n%s", ruleset)
+	}
+
+	up := map[string]bool{"pia": false, "mullvad": false}
+	if got := vpnPolicyStatus(policies[0], profiles, up); got != vpnPolicyBlocked {
+		t.Errorf("Expected %q, got %q", vpnPolicyBlocked, got)
+	}
+	if got := vpnPolicyStatus(policies[2], profiles, up); got != vpnPolicyDirect {
+		t.Errorf("Expected %q, got %q", vpnPolicyDirect, got)
+	}
+	up["pia"] = true
+	if got := vpnPolicyStatus(policies[0], profiles, up); got != vpnPolicyActive {
+		t.Errorf("Expected %q, got %q", vpnPolicyActive, got)
+	}
+	if got := vpnPolicyStatus(VPNPolicy{SourceIP: "10.0.0.1", Profile: "gone"}, profiles, up); got != vpnPolicyNoProfile {
+		t.Errorf("Expected %q, got %q", vpnPolicyNoProfile, got)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bypass policies are evaluated first and set vpnBypassFlag, so they carve
+// exceptions out of VPN policies; otherwise the first matching policy wins.
+// The policy ip rules sit before the WAN rules: local routes in main first,
+// then one fwmark rule per profile.
+const (
+	vpnActionVPN    = "vpn"
+	vpnActionBypass = "bypass"
+
+	vpnBypassFlag      = 0x10000000
+	vpnRulePrioMin     = 1000 // lookup main suppress_prefixlength 0
+	vpnRulePrioProfile = 1100 // + mark ID: fwmark -> profile table
+	vpnRulePrioMax     = 1999
+	dnsmasqVPNPath     = "/etc/dnsmasq.d/softrouter-vpn.conf"
+)
+
+func (p VPNPolicy) bypass() bool {
+	return p.Action == vpnActionBypass
+}
+
+func vpnPolicySetName(n int) string {
+	return fmt.Sprintf("vpn_policy_%d", n)
+}
+
+// match returns the traffic selected by the n-th policy (1-based)
+func (p VPNPolicy) match(n int) trafficMatch {
+	m := trafficMatch{SourceMAC: p.SourceMAC, SourceCIDR: p.SourceIP, DestCIDR: p.DestCIDR,
+		Protocol: p.Protocol, DestPorts: p.DestPorts}
+	if p.SourceSet != "" {
+		m.SourceCIDR = "@" + p.SourceSet
+	}
+	if len(p.Domains) > 0 {
+		m.DomainSet = vpnPolicySetName(n)
+	}
+	return m
+}
+
+// matchKey identifies the traffic of a policy for duplicate detection
+func (p VPNPolicy) matchKey() string {
+	return fmt.Sprintf("%+v %s", p.match(0), strings.Join(p.Domains, ","))
+}
+
+func validateVPNPolicy(p VPNPolicy, profiles []VPNProfile, sets []FirewallSet) error {
+	if p.SourceIP != "" && p.SourceSet != "" {
+		return fmt.Errorf("use either source_ip or source_set, not both")
+	}
+	if p.SourceIP == "" && p.SourceSet == "" && p.SourceMAC == "" && p.DestCIDR == "" && len(p.Domains) == 0 {
+		return fmt.Errorf("a source, destination or domain is required")
+	}
+	if p.SourceIP != "" && isSetRef(p.SourceIP) {
+		return fmt.Errorf("use source_set for client groups")
+	}
+	if p.SourceSet != "" {
+		if err := validateSetReference(sets, p.SourceSet, setTypeIPv4); err != nil {
+			return err
+		}
+	}
+	if err := validateTrafficMatch(p.match(0), p.Domains, sets); err != nil {
+		return err
+	}
+	if strings.ContainsAny(p.Description, "\"\\\n\r") {
+		return fmt.Errorf("description must not contain quotes or backslashes")
+	}
+
+	switch p.Action {
+	case vpnActionVPN:
+		if p.Profile != "" {
+			if _, ok := findVPNProfile(profiles, p.Profile); !ok {
+				return fmt.Errorf("unknown VPN profile %s", p.Profile)
+			}
+		}
+	case vpnActionBypass:
+		if p.Profile != "" || p.KillSwitch {
+			return fmt.Errorf("bypass policies take no profile or kill switch")
+		}
+	default:
+		return fmt.Errorf("action must be vpn or bypass")
+	}
+	return nil
+}
+
+// writeVPNPolicyMarks renders the domain sets and the prerouting chain that
+// marks policy traffic, so the fwmark ip rules send it to the table of the
+// policy's profile and the kill switch can recognize it
+func writeVPNPolicyMarks(b *strings.Builder, policies []VPNPolicy, profiles []VPNProfile) {
+	var bypass, rules []string
+	for i, p := range policies {
+		n := i + 1
+		parts := p.match(n).nftParts()
+		comment := strings.NewReplacer("\"", "", "\\", "").Replace(p.Description)
+
+		if p.bypass() {
+			parts = append(parts, "meta", "mark", "set", "meta", "mark", "|", fmt.Sprintf("0x%08x", vpnBypassFlag), "return",
+				"comment", fmt.Sprintf("\"VPN bypass: %s\"", comment))
+			bypass = append(bypass, "    "+strings.Join(parts, " ")+"\n")
+			continue
+		}
+
+		profile, ok := policyVPNProfile(p, profiles)
+		if !ok {
+			continue
+		}
+		mark := profile.mark()
+		if p.KillSwitch {
+			mark = profile.killSwitchMark()
+		}
+		// Replace rather than OR the profile bits so earlier chains cannot
+		// combine two profiles' marks
+		parts = append(parts, "meta", "mark", "set", "meta", "mark", "&", vpnPolicyMarkClear, "|", mark, "return",
+			"comment", fmt.Sprintf("\"VPN policy: %s\"", comment))
+		rules = append(rules, "    "+strings.Join(parts, " ")+"\n")
+	}
+	if len(bypass)+len(rules) == 0 {
+		return
+	}
+
+	for i, p := range policies {
+		if len(p.Domains) == 0 {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("  set %s {\n", vpnPolicySetName(i+1)))
+		b.WriteString("    type ipv4_addr\n")
+		b.WriteString("    flags timeout\n")
+		b.WriteString("    timeout 1h\n")
+		b.WriteString("  }\n\n")
+	}
+
+	b.WriteString("  chain vpn_policy_mark {\n")
+	b.WriteString("    type filter hook prerouting priority mangle; policy accept;\n")
+	for _, rule := range append(bypass, rules...) {
+		b.WriteString(rule)
+	}
+	b.WriteString("  }\n\n")
+}
+
+// vpnIPRules builds the policy routing rules. Profiles whose tunnel is down
+// keep a rule only for kill switch traffic, the rest follows the WAN rules.
+func vpnIPRules(policies []VPNPolicy, profiles []VPNProfile, up map[string]bool) []IPRule {
+	routed := make(map[string]bool)
+	killSwitch := make(map[string]bool)
+	for _, p := range policies {
+		if p.bypass() {
+			continue
+		}
+		if profile, ok := policyVPNProfile(p, profiles); ok {
+			routed[profile.Name] = true
+			killSwitch[profile.Name] = killSwitch[profile.Name] || p.KillSwitch
+		}
+	}
+	if len(routed) == 0 {
+		return nil
+	}
+
+	// Connected and static routes in main still win over the tunnels
+	rules := []IPRule{{Priority: vpnRulePrioMin, Table: "main", Suppress: true}}
+	for _, profile := range profiles {
+		table := strconv.Itoa(profile.Table)
+		switch {
+		case !routed[profile.Name]:
+		case up[profile.Name]:
+			rules = append(rules, IPRule{Priority: vpnRulePrioProfile + profile.MarkID, FwMark: profile.mark() + "/" + vpnPolicyMarkMask, Table: table})
+		case killSwitch[profile.Name]:
+			rules = append(rules, IPRule{Priority: vpnRulePrioProfile + profile.MarkID, FwMark: profile.killSwitchMark() + "/" + vpnKillSwitchMarkMask, Table: table})
+		}
+	}
+	return rules
+}
+
+// legacyVPNIPRules returns rules that look up a profile table outside the
+// managed priority range, left over from the unordered rules of earlier versions
+func legacyVPNIPRules(current []IPRule, profiles []VPNProfile) []IPRule {
+	tables := make(map[string]bool)
+	for _, p := range profiles {
+		tables[strconv.Itoa(p.Table)] = true
+	}
+	var stale []IPRule
+	for _, r := range current {
+		if tables[r.Table] && (r.Priority < vpnRulePrioMin || r.Priority > vpnRulePrioMax) {
+			stale = append(stale, r)
+		}
+	}
+	return stale
+}
+
+// renderVPNDnsmasqConfig maps policy domains to their nft sets
+func renderVPNDnsmasqConfig(policies []VPNPolicy) string {
+	var b strings.Builder
+	b.WriteString("# Auto-generated by SoftRouter - DO NOT EDIT MANUALLY\n")
+	b.WriteString("# Edit via Web UI: VPN > Policies\n\n")
+	for i, p := range policies {
+		if len(p.Domains) == 0 {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("# %s\n", p.Description))
+		b.WriteString(fmt.Sprintf("nftset=/%s/4#inet#softrouter#%s\n", strings.Join(p.Domains, "/"), vpnPolicySetName(i+1)))
+	}
+	return b.String()
+}
+
+func writeVPNDnsmasqConfig(policies []VPNPolicy) {
+	needed := false
+	for _, p := range policies {
+		needed = needed || len(p.Domains) > 0
+	}
+	writeDnsmasqNftsetConfig(dnsmasqVPNPath, renderVPNDnsmasqConfig(policies), needed)
+}
+
+// initVPNRouting loads the profiles and starts following the tunnels. The
+// monitor applies the policy routing once at boot.
+func initVPNRouting() {
+	loadVPNProfiles()
+	if policies, err := loadVPNPolicies(); err == nil {
+		writeVPNDnsmasqConfig(policies)
+	}
+	startVPNRoutingMonitor()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateVPNPolicy(t *testing.T) {
+	profiles := []VPNProfile{{Name: "pia", Type: vpnProfileOpenVPN, Device: "tun1", Table: 100, MarkID: 1}}
+	sets := []FirewallSet{
+		{Name: "kids", Type: setTypeIPv4},
+		{Name: "web", Type: setTypeService},
+	}
+
+	valid := []VPNPolicy{
+		{Action: vpnActionVPN, SourceIP: "192.168.1.0/24", Profile: "pia"},
+		{Action: vpnActionVPN, SourceSet: "kids", Protocol: "tcp", DestPorts: "@web"},
+		{Action: vpnActionVPN, SourceMAC: "aa:bb:cc:dd:ee:ff", Domains: []string{"netflix.com"}},
+		{Action: vpnActionBypass, SourceSet: "kids", DestCIDR: "198.51.100.0/24"},
+	}
+	for _, p := range valid {
+		if err := validateVPNPolicy(p, profiles, sets); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", p, err)
+		}
+	}
+
+	invalid := map[string]VPNPolicy{
+		"no match":       {Action: vpnActionVPN, Protocol: "tcp"},
+		"two sources":    {Action: vpnActionVPN, SourceIP: "192.168.1.5", SourceSet: "kids"},
+		"group as ip":    {Action: vpnActionVPN, SourceIP: "@kids"},
+		"ipv6 source":    {Action: vpnActionVPN, SourceIP: "2001:db8::1"},
+		"unknown set":    {Action: vpnActionVPN, SourceSet: "guests"},
+		"bad mac":        {Action: vpnActionVPN, SourceMAC: "aa:bb"},
+		"ports no proto": {Action: vpnActionVPN, SourceIP: "192.168.1.5", DestPorts: "443"},
+		"dest and names": {Action: vpnActionVPN, DestCIDR: "198.51.100.1", Domains: []string{"example.com"}},
+		"bad domain":     {Action: vpnActionVPN, Domains: []string{"exa mple.com"}},
+		"unknown":        {Action: vpnActionVPN, SourceIP: "192.168.1.5", Profile: "nord"},
+		"bypass profile": {Action: vpnActionBypass, SourceIP: "192.168.1.5", Profile: "pia"},
+		"bypass kill":    {Action: vpnActionBypass, SourceIP: "192.168.1.5", KillSwitch: true},
+		"action":         {Action: "drop", SourceIP: "192.168.1.5"},
+		"description":    {Action: vpnActionVPN, SourceIP: "192.168.1.5", Description: "a\"b"},
+	}
+	for name, p := range invalid {
+		if err := validateVPNPolicy(p, profiles, sets); err == nil {
+			t.Errorf("%s: expected validation error, got nil", name)
+		}
+	}
+}
+
+func TestWriteVPNPolicyMarksBypassFirst(t *testing.T) {
+	profiles := []VPNProfile{{Name: "pia", Type: vpnProfileOpenVPN, Device: "tun1", Table: 100, MarkID: 1}}
+	policies := []VPNPolicy{
+		{Action: vpnActionVPN, SourceSet: "kids", Description: "kids"},
+		{Action: vpnActionVPN, SourceIP: "192.168.1.20", Protocol: "tcp_udp", DestPorts: "53", Description: "dns"},
+		{Action: vpnActionBypass, SourceSet: "kids", Domains: []string{"bank.example"}, Description: "banking"},
+	}
+
+	var b strings.Builder
+	writeVPNPolicyMarks(&b, policies, profiles)
+	ruleset := b.String()
+
+	bypass := "ip saddr @kids ip daddr @vpn_policy_3 meta mark set meta mark | 0x10000000 return comment \"VPN bypass: banking\""
+	for _, s := range []string{
+		"  set vpn_policy_3 {\n    type ipv4_addr\n    flags timeout\n    timeout 1h\n  }",
+		bypass,
+		"ip saddr @kids meta mark set meta mark & 0xd0ffffff | 0x01000000 return comment \"VPN policy: kids\"",
+		"ip saddr 192.168.1.20 meta l4proto { tcp, udp } th dport 53 meta mark set meta mark & 0xd0ffffff | 0x01000000 return",
+	} {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain %q.\n%s", s, ruleset)
+		}
+	}
+	if strings.Index(ruleset, bypass) > strings.Index(ruleset, "VPN policy: kids") {
+		t.Errorf("Bypass policies must be evaluated before VPN policies.\n%s", ruleset)
+	}
+
+	conf := renderVPNDnsmasqConfig(policies)
+	if !strings.Contains(conf, "nftset=/bank.example/4#inet#softrouter#vpn_policy_3\n") {
+		t.Errorf("Unexpected dnsmasq config:\n%s", conf)
+	}
+}
+
+func TestVPNIPRules(t *testing.T) {
+	profiles := []VPNProfile{
+		{Name: "pia", Type: vpnProfileOpenVPN, Device: "tun1", Table: 100, MarkID: 1},
+		{Name: "mullvad", Type: vpnProfileWireGuard, Device: "wg2", Table: 101, MarkID: 2},
+		{Name: "idle", Type: vpnProfileOpenVPN, Device: "tun3", Table: 102, MarkID: 3},
+	}
+	policies := []VPNPolicy{
+		{Action: vpnActionVPN, SourceIP: "192.168.1.20", KillSwitch: true},
+		{Action: vpnActionVPN, SourceSet: "tv", Profile: "mullvad"},
+		{Action: vpnActionBypass, SourceSet: "tv", DestCIDR: "198.51.100.0/24"},
+	}
+
+	got := vpnIPRules(policies, profiles, map[string]bool{"pia": true, "mullvad": true})
+	want := []string{
+		"priority 1000 lookup main suppress_prefixlength 0",
+		"priority 1101 fwmark 0x01000000/0x1f000000 lookup 100",
+		"priority 1102 fwmark 0x02000000/0x1f000000 lookup 101",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d rules, got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("Rule %d: expected %q, got %q", i, want[i], got[i].String())
+		}
+	}
+
+	// Down tunnels keep only the kill switch rule
+	got = vpnIPRules(policies, profiles, map[string]bool{})
+	if len(got) != 2 || got[1].String() != "priority 1101 fwmark 0x21000000/0x3f000000 lookup 100" {
+		t.Errorf("Unexpected rules with tunnels down: %v", got)
+	}
+
+	if rules := vpnIPRules(policies[2:], profiles, nil); rules != nil {
+		t.Errorf("Bypass-only policies need no rules, got %v", rules)
+	}
+
+	current := []IPRule{
+		{Priority: 0, Table: "local"},
+		{Priority: 1101, FwMark: "0x1000000/0x1f000000", Table: "100"},
+		{Priority: 4989, From: "192.168.1.20", Table: "100"},
+		{Priority: 32766, Table: "main"},
+	}
+	if stale := legacyVPNIPRules(current, profiles); len(stale) != 1 || stale[0].Priority != 4989 {
+		t.Errorf("Expected the unordered legacy rule, got %v", stale)
+	}
+}
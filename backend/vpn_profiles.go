@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// VPNProfile is an outbound VPN connection that policies route sources
+// through. Each profile has its own tunnel device, routing table and fwmark.
+type VPNProfile struct {
+	Name        string `json:"name"` // Also names the OpenVPN config, credentials and unit
+	Type        string `json:"type"` // openvpn or wireguard
+	Description string `json:"description"`
+	Device      string `json:"device"`  // tunN for OpenVPN, a provider wgN interface for WireGuard
+	Table       int    `json:"table"`   // Routing table the policies look up, vpnTableMin-vpnTableMax
+	MarkID      int    `json:"mark_id"` // Value of the profile in vpnPolicyMarkMask (1-15)
+}
+
+// VPNProfileStore holds the outbound VPN profiles
+type VPNProfileStore struct {
+	Profiles []VPNProfile `json:"profiles"`
+}
+
+// VPNProfileStatus is a profile with the state of its tunnel
+type VPNProfileStatus struct {
+	VPNProfile
+	VPNClientStatus
+}
+
+const (
+	vpnProfileOpenVPN   = "openvpn"
+	vpnProfileWireGuard = "wireguard"
+
+	// Upgraded routers had a single OpenVPN client with these settings
+	legacyVPNProfile = "pia"
+	legacyVPNDevice  = "tun1"
+
+	vpnTableMin   = 100 // WAN tables start at wanTableBase
+	vpnTableMax   = 199
+	maxVPNMarkID  = 15
+	vpnMarkShift  = 24
+	vpnTunnelBase = 1 // tun0 is left to the OpenVPN server
+)
+
+var (
+	vpnProfileStore      VPNProfileStore
+	vpnProfileLock       sync.Mutex
+	vpnProfileConfigPath = "/etc/softrouter/vpn_profiles.json"
+
+	vpnProfileNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	vpnTunnelRegex      = regexp.MustCompile(`^tun[0-9]{1,3}$`)
+)
+
+// mark returns the fwmark value that selects the profile's table
+func (p VPNProfile) mark() string {
+	return fmt.Sprintf("0x%08x", p.MarkID<<vpnMarkShift)
+}
+
+// unit returns the systemd unit that brings the tunnel up
+func (p VPNProfile) unit() string {
+	if p.Type == vpnProfileWireGuard {
+		return "wg-quick@" + p.Device
+	}
+	return "openvpn-client@" + p.Name
+}
+
+func (p VPNProfile) configPath() string {
+	return filepath.Join(vpnClientConfigDir, p.Name+".conf")
+}
+
+func (p VPNProfile) authPath() string {
+	return filepath.Join(vpnClientConfigDir, p.Name+".auth")
+}
+
+func loadVPNProfiles() {
+	vpnProfileLock.Lock()
+	defer vpnProfileLock.Unlock()
+
+	data, err := os.ReadFile(vpnProfileConfigPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &vpnProfileStore); err != nil {
+			fmt.Printf("Error parsing VPN profiles: %v\n", err)
+		}
+		return
+	}
+	if !os.IsNotExist(err) {
+		fmt.Printf("Error loading VPN profiles: %v\n", err)
+		return
+	}
+
+	// Adopt the config uploaded before profiles existed
+	vpnProfileStore = VPNProfileStore{Profiles: []VPNProfile{}}
+	legacy := VPNProfile{Name: legacyVPNProfile, Type: vpnProfileOpenVPN, Description: "Migrated VPN client",
+		Device: legacyVPNDevice, Table: vpnTableMin, MarkID: 1}
+	if _, err := os.Stat(legacy.configPath()); err != nil {
+		return
+	}
+	vpnProfileStore.Profiles = append(vpnProfileStore.Profiles, legacy)
+	if err := saveVPNProfilesLocked(); err != nil {
+		fmt.Printf("Error saving VPN profiles: %v\n", err)
+	}
+}
+
+func saveVPNProfilesLocked() error {
+	data, err := json.MarshalIndent(vpnProfileStore, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(vpnProfileConfigPath, data, 0644)
+}
+
+// GetVPNProfiles returns a copy of the VPN profiles
+func GetVPNProfiles() []VPNProfile {
+	vpnProfileLock.Lock()
+	defer vpnProfileLock.Unlock()
+	return append([]VPNProfile(nil), vpnProfileStore.Profiles...)
+}
+
+func findVPNProfile(profiles []VPNProfile, name string) (VPNProfile, bool) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return VPNProfile{}, false
+}
+
+// policyVPNProfile returns the profile a policy routes through. Policies
+// without a profile use the first one (the migrated profile on upgrades).
+func policyVPNProfile(policy VPNPolicy, profiles []VPNProfile) (VPNProfile, bool) {
+	if policy.Profile == "" {
+		if len(profiles) == 0 {
+			return VPNProfile{}, false
+		}
+		return profiles[0], true
+	}
+	return findVPNProfile(profiles, policy.Profile)
+}
+
+// allocateVPNProfile fills in the device, table and mark of a new profile
+// with the lowest values the other profiles leave free
+func allocateVPNProfile(p *VPNProfile, others []VPNProfile) error {
+	usedTables := make(map[int]bool)
+	usedMarks := make(map[int]bool)
+	usedDevices := make(map[string]bool)
+	for _, o := range others {
+		usedTables[o.Table] = true
+		usedMarks[o.MarkID] = true
+		usedDevices[o.Device] = true
+	}
+
+	if p.Device == "" && p.Type == vpnProfileOpenVPN {
+		for n := vpnTunnelBase; ; n++ {
+			if dev := fmt.Sprintf("tun%d", n); !usedDevices[dev] {
+				p.Device = dev
+				break
+			}
+		}
+	}
+	if p.Table == 0 {
+		for t := vpnTableMin; t <= vpnTableMax && p.Table == 0; t++ {
+			if !usedTables[t] {
+				p.Table = t
+			}
+		}
+		if p.Table == 0 {
+			return fmt.Errorf("no free routing table between %d and %d", vpnTableMin, vpnTableMax)
+		}
+	}
+	if p.MarkID == 0 {
+		for id := 1; id <= maxVPNMarkID && p.MarkID == 0; id++ {
+			if !usedMarks[id] {
+				p.MarkID = id
+			}
+		}
+		if p.MarkID == 0 {
+			return fmt.Errorf("at most %d VPN profiles are supported", maxVPNMarkID)
+		}
+	}
+	return nil
+}
+
+func validateVPNProfile(p VPNProfile, others []VPNProfile, wgIfaces []WGInterface) error {
+	if !vpnProfileNameRegex.MatchString(p.Name) {
+		return fmt.Errorf("profile name must be lowercase letters, digits, - or _")
+	}
+	switch p.Type {
+	case vpnProfileOpenVPN:
+		if !vpnTunnelRegex.MatchString(p.Device) {
+			return fmt.Errorf("OpenVPN profiles need a tunN device")
+		}
+		if p.Device == "tun0" {
+			return fmt.Errorf("tun0 is reserved for the OpenVPN server")
+		}
+	case vpnProfileWireGuard:
+		iface, ok := findWGInterface(wgIfaces, p.Device)
+		if !ok {
+			return fmt.Errorf("unknown WireGuard interface %s", p.Device)
+		}
+		if iface.Role != wgRoleProvider {
+			return fmt.Errorf("WireGuard interface %s must have the provider role", p.Device)
+		}
+	default:
+		return fmt.Errorf("type must be openvpn or wireguard")
+	}
+	if p.Table < vpnTableMin || p.Table > vpnTableMax {
+		return fmt.Errorf("table must be between %d and %d", vpnTableMin, vpnTableMax)
+	}
+	if p.MarkID < 1 || p.MarkID > maxVPNMarkID {
+		return fmt.Errorf("mark ID must be between 1 and %d", maxVPNMarkID)
+	}
+
+	for _, o := range others {
+		if o.Name == p.Name {
+			continue
+		}
+		if o.Device == p.Device {
+			return fmt.Errorf("device %s is already used by profile %s", p.Device, o.Name)
+		}
+		if o.Table == p.Table {
+			return fmt.Errorf("table %d is already used by profile %s", p.Table, o.Name)
+		}
+		if o.MarkID == p.MarkID {
+			return fmt.Errorf("mark ID %d is already used by profile %s", p.MarkID, o.Name)
+		}
+	}
+	return nil
+}
+
+// vpnProfileForDevice returns the profile routing through a tunnel device
+func vpnProfileForDevice(profiles []VPNProfile, device string) (VPNProfile, bool) {
+	for _, p := range profiles {
+		if p.Device == device {
+			return p, true
+		}
+	}
+	return VPNProfile{}, false
+}
+
+// clearVPNProfileRouting removes the routes of a deleted profile's table. Its
+// ip rule goes with the next refreshVPNRouting.
+func clearVPNProfileRouting(p VPNProfile) {
+	runPrivileged("ip", "route", "flush", "table", strconv.Itoa(p.Table))
+}
+
+// --- API Handlers ---
+
+func getVPNProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles := GetVPNProfiles()
+	out := make([]VPNProfileStatus, 0, len(profiles))
+	for _, p := range profiles {
+		out = append(out, VPNProfileStatus{VPNProfile: p, VPNClientStatus: vpnProfileStatus(p)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, out)
+}
+
+func createVPNProfile(w http.ResponseWriter, r *http.Request) {
+	var p VPNProfile
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if p.Type == "" {
+		p.Type = vpnProfileOpenVPN
+	}
+
+	wgIfaces := GetWGInterfaces()
+
+	vpnProfileLock.Lock()
+	defer vpnProfileLock.Unlock()
+
+	if _, exists := findVPNProfile(vpnProfileStore.Profiles, p.Name); exists {
+		respondWithError(w, ErrVPNConfigInvalid, "Profile "+p.Name+" already exists", http.StatusConflict, nil)
+		return
+	}
+	if err := allocateVPNProfile(&p, vpnProfileStore.Profiles); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusConflict, nil)
+		return
+	}
+	if err := validateVPNProfile(p, vpnProfileStore.Profiles, wgIfaces); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	previous := vpnProfileStore.Profiles
+	vpnProfileStore.Profiles = append(append([]VPNProfile{}, previous...), p)
+	if err := saveVPNProfilesLocked(); err != nil {
+		vpnProfileStore.Profiles = previous
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save VPN profiles", err)
+		return
+	}
+
+	details, _ := json.Marshal(p)
+	logAuditEvent(getUsernameFromToken(r), "vpn.profile.create", p.Name, string(details), getClientIP(r), true)
+
+	// Policies without a profile may now route through this one
+	go firewallManager.ApplyFirewallRules()
+	go refreshVPNRouting()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, p)
+}
+
+// vpnPoliciesUseProfile reports whether a VPN policy routes through the
+// profile, explicitly or as the default profile
+func vpnPoliciesUseProfile(policies []VPNPolicy, profiles []VPNProfile, name string) bool {
+	for _, policy := range policies {
+		if profile, ok := policyVPNProfile(policy, profiles); ok && !policy.bypass() && profile.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func deleteVPNProfile(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+
+	// Policies without a profile use the first one and would silently move to
+	// the next
+	policies, _ := loadVPNPolicies()
+	if vpnPoliciesUseProfile(policies, GetVPNProfiles(), name) {
+		respondWithError(w, ErrVPNConfigInvalid, "Profile "+name+" is used by VPN policies", http.StatusConflict, nil)
+		return
+	}
+
+	vpnProfileLock.Lock()
+	p, ok := findVPNProfile(vpnProfileStore.Profiles, name)
+	if !ok {
+		vpnProfileLock.Unlock()
+		respondWithError(w, ErrGenericNotFound, "Profile not found", http.StatusNotFound, nil)
+		return
+	}
+	previous := vpnProfileStore.Profiles
+	remaining := make([]VPNProfile, 0, len(previous))
+	for _, existing := range previous {
+		if existing.Name != name {
+			remaining = append(remaining, existing)
+		}
+	}
+	vpnProfileStore.Profiles = remaining
+	if err := saveVPNProfilesLocked(); err != nil {
+		vpnProfileStore.Profiles = previous
+		vpnProfileLock.Unlock()
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save VPN profiles", err)
+		return
+	}
+	vpnProfileLock.Unlock()
+
+	// WireGuard tunnels belong to their interface and keep running
+	if p.Type == vpnProfileOpenVPN {
+		runPrivileged("systemctl", "disable", "--now", p.unit())
+		os.Remove(p.configPath()) //nolint:errcheck
+		os.Remove(p.authPath())   //nolint:errcheck
+	}
+	clearVPNProfileRouting(p)
+
+	logAuditEvent(getUsernameFromToken(r), "vpn.profile.delete", name, "", getClientIP(r), true)
+
+	go firewallManager.ApplyFirewallRules()
+	go refreshVPNRouting()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAllocateVPNProfile(t *testing.T) {
+	others := []VPNProfile{
+		{Name: "pia", Type: vpnProfileOpenVPN, Device: "tun1", Table: 100, MarkID: 1},
+		{Name: "work", Type: vpnProfileOpenVPN, Device: "tun3", Table: 102, MarkID: 3},
+	}
+
+	p := VPNProfile{Name: "nord", Type: vpnProfileOpenVPN}
+	if err := allocateVPNProfile(&p, others); err != nil {
+		t.Fatalf("allocateVPNProfile failed: %v", err)
+	}
+	if p.Device != "tun2" || p.Table != 101 || p.MarkID != 2 || p.mark() != "0x02000000" {
+		t.Errorf("Expected the lowest free device, table and mark, got %+v (mark %s)", p, p.mark())
+	}
+
+	// WireGuard profiles name their provider interface and get no tunN
+	wg := VPNProfile{Name: "mullvad", Type: vpnProfileWireGuard, Device: "wg2"}
+	if err := allocateVPNProfile(&wg, others); err != nil || wg.Device != "wg2" {
+		t.Errorf("Expected the WireGuard device to be kept, got %+v (err %v)", wg, err)
+	}
+
+	full := make([]VPNProfile, 0, maxVPNMarkID)
+	for id := 1; id <= maxVPNMarkID; id++ {
+		full = append(full, VPNProfile{MarkID: id, Table: vpnTableMin + id})
+	}
+	extra := VPNProfile{Name: "extra", Type: vpnProfileOpenVPN}
+	if err := allocateVPNProfile(&extra, full); err == nil {
+		t.Error("Expected an error when all marks are used")
+	}
+}
+
+func TestValidateVPNProfile(t *testing.T) {
+	wgIfaces := []WGInterface{
+		{Name: "wg0", Role: wgRoleServer},
+		{Name: "wg2", Role: wgRoleProvider},
+	}
+	others := []VPNProfile{{Name: "pia", Type: vpnProfileOpenVPN, Device: "tun1", Table: 100, MarkID: 1}}
+
+	valid := VPNProfile{Name: "mullvad", Type: vpnProfileWireGuard, Device: "wg2", Table: 101, MarkID: 2}
+	if err := validateVPNProfile(valid, others, wgIfaces); err != nil {
+		t.Fatalf("Expected valid profile, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*VPNProfile)
+	}{
+		{"name", func(p *VPNProfile) { p.Name = "My VPN" }},
+		{"type", func(p *VPNProfile) { p.Type = "ipsec" }},
+		{"unknown interface", func(p *VPNProfile) { p.Device = "wg9" }},
+		{"server interface", func(p *VPNProfile) { p.Device = "wg0" }},
+		{"openvpn device", func(p *VPNProfile) { p.Type, p.Device = vpnProfileOpenVPN, "eth0" }},
+		{"server tunnel", func(p *VPNProfile) { p.Type, p.Device = vpnProfileOpenVPN, "tun0" }},
+		{"duplicate device", func(p *VPNProfile) { p.Type, p.Device = vpnProfileOpenVPN, "tun1" }},
+		{"wan table", func(p *VPNProfile) { p.Table = wanTableBase }},
+		{"duplicate table", func(p *VPNProfile) { p.Table = 100 }},
+		{"duplicate mark", func(p *VPNProfile) { p.MarkID = 1 }},
+		{"mark range", func(p *VPNProfile) { p.MarkID = 16 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.mutate(&p)
+			if err := validateVPNProfile(p, others, wgIfaces); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestVPNPoliciesUseProfile(t *testing.T) {
+	profiles := []VPNProfile{{Name: "pia"}, {Name: "mullvad"}}
+	policies := []VPNPolicy{
+		{SourceSet: "tv", Action: vpnActionVPN, Profile: "mullvad"},
+		{SourceIP: "192.168.1.9", Action: vpnActionBypass},
+	}
+	if vpnPoliciesUseProfile(policies, profiles, "pia") {
+		t.Error("Expected pia to be unused")
+	}
+	if !vpnPoliciesUseProfile(policies, profiles, "mullvad") {
+		t.Error("Expected mullvad to be used explicitly")
+	}
+
+	// Policies without a profile pin the first one
+	policies = append(policies, VPNPolicy{SourceSet: "kids", Action: vpnActionVPN})
+	if !vpnPoliciesUseProfile(policies, profiles, "pia") {
+		t.Error("Expected pia to be used as the default profile")
+	}
+}
+
+func TestWriteVPNPolicyMarksPerProfile(t *testing.T) {
+	profiles := []VPNProfile{
+		{Name: "pia", Type: vpnProfileOpenVPN, Device: "tun1", Table: 100, MarkID: 1},
+		{Name: "mullvad", Type: vpnProfileWireGuard, Device: "wg2", Table: 101, MarkID: 2},
+	}
+	policies := []VPNPolicy{
+		{SourceSet: "kids", Description: "default profile"},
+		{SourceSet: "tv", Profile: "mullvad", Description: "streaming"},
+		{SourceSet: "gone", Profile: "deleted", Description: "orphan"},
+		{SourceIP: "192.168.1.50", Profile: "mullvad"},
+	}
+
+	var b strings.Builder
+	writeVPNPolicyMarks(&b, policies, profiles)
+	chain := b.String()
+	for _, s := range []string{
+		"ip saddr @kids meta mark set meta mark & 0xd0ffffff | 0x01000000 return comment \"VPN policy: default profile\"",
+		"ip saddr @tv meta mark set meta mark & 0xd0ffffff | 0x02000000 return comment \"VPN policy: streaming\"",
+		"ip saddr 192.168.1.50 meta mark set meta mark & 0xd0ffffff | 0x02000000",
+	} {
+		if !strings.Contains(chain, s) {
+			t.Errorf("Expected chain to contain %q.\n%s", s, chain)
+		}
+	}
+	if strings.Contains(chain, "@gone") {
+		t.Errorf("Only policies with a known profile are marked.\n%s", chain)
+	}
+
+	b.Reset()
+	writeVPNPolicyMarks(&b, policies, nil)
+	if b.Len() != 0 {
+		t.Errorf("Expected no chain without profiles, got:\n%s", b.String())
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WANEvent records a WAN transition, failover or route change
+type WANEvent struct {
+	ID        string      `json:"id"`
+	Timestamp time.Time   `json:"timestamp"`
+	Type      string      `json:"type"`      // state_change, failover, route_change, gateway_change, all_down
+	Interface string      `json:"interface"` // WAN interface, "balanced" for load balancing routes
+	Previous  string      `json:"previous,omitempty"`
+	Current   string      `json:"current,omitempty"`
+	Message   string      `json:"message"`
+	Metrics   *WANMetrics `json:"metrics,omitempty"` // Health metrics that triggered the event
+}
+
+const (
+	wanEventLogFile = "wan_events.log"
+	maxWANEvents    = 2000 // Kept in memory and on disk
+)
+
+var (
+	wanEvents     []WANEvent
+	wanEventsLock sync.RWMutex
+)
+
+// initWANEvents loads the persisted event log and trims it to maxWANEvents
+func initWANEvents() {
+	wanEventsLock.Lock()
+	defer wanEventsLock.Unlock()
+
+	logPath := filepath.Join(auditLogDir, wanEventLogFile)
+	file, err := os.Open(logPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error loading WAN event log: %v\n", err)
+		}
+		return
+	}
+	defer file.Close() //nolint:errcheck
+
+	total := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var ev WANEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue // Skip malformed entries
+		}
+		wanEvents = append(wanEvents, ev)
+		total++
+	}
+	if len(wanEvents) > maxWANEvents {
+		wanEvents = wanEvents[len(wanEvents)-maxWANEvents:]
+	}
+
+	if total > maxWANEvents {
+		if err := rewriteWANEventLog(wanEvents); err != nil {
+			fmt.Printf("Error trimming WAN event log: %v\n", err)
+		}
+	}
+}
+
+func rewriteWANEventLog(events []WANEvent) error {
+	logPath := filepath.Join(auditLogDir, wanEventLogFile)
+	tmpPath := logPath + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, ev := range events {
+		data, _ := json.Marshal(ev)
+		w.Write(append(data, '\n')) //nolint:errcheck
+	}
+	if err := w.Flush(); err != nil {
+		file.Close() //nolint:errcheck
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, logPath)
+}
+
+// recordWANEvent persists an event, mirrors it to the audit log and hands it to the notifiers
+func recordWANEvent(ev WANEvent) {
+	ev.ID = uuid.New().String()
+	ev.Timestamp = time.Now()
+
+	fmt.Printf("WAN event [%s] %s: %s\n", ev.Type, ev.Interface, ev.Message)
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	wanEventsLock.Lock()
+	wanEvents = append(wanEvents, ev)
+	trimmed := len(wanEvents) > maxWANEvents
+	if trimmed {
+		wanEvents = wanEvents[len(wanEvents)-maxWANEvents:]
+		if err := rewriteWANEventLog(wanEvents); err != nil {
+			fmt.Printf("Error trimming WAN event log: %v\n", err)
+		}
+	} else {
+		logPath := filepath.Join(auditLogDir, wanEventLogFile)
+		if file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			file.Write(append(data, '\n')) //nolint:errcheck
+			file.Close()                   //nolint:errcheck
+		} else {
+			fmt.Printf("Error writing WAN event log: %v\n", err)
+		}
+	}
+	wanEventsLock.Unlock()
+
+	logAuditEvent("system", "wan."+ev.Type, ev.Interface, string(data), "", true)
+	go notifyWANEvent(ev)
+}
+
+// getWANEvents returns events newest first, optionally filtered
+func getWANEvents(ifaceFilter, typeFilter string, since time.Time, limit int) []WANEvent {
+	wanEventsLock.RLock()
+	defer wanEventsLock.RUnlock()
+
+	events := []WANEvent{}
+	for i := len(wanEvents) - 1; i >= 0; i-- {
+		ev := wanEvents[i]
+		if ifaceFilter != "" && ev.Interface != ifaceFilter {
+			continue
+		}
+		if typeFilter != "" && ev.Type != typeFilter {
+			continue
+		}
+		if !since.IsZero() && ev.Timestamp.Before(since) {
+			break
+		}
+		events = append(events, ev)
+		if limit > 0 && len(events) >= limit {
+			break
+		}
+	}
+	return events
+}
+
+func getWANEventsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit := 100
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	var since time.Time
+	if s := q.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			respondInvalidRequest(w, "since must be an RFC3339 timestamp")
+			return
+		}
+		since = t
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, getWANEvents(q.Get("interface"), q.Get("type"), since, limit))
+}
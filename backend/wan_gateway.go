@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WAN addressing modes. Static WANs use the configured Gateway; for dhcp and
+// pppoe the gateway is learned on every health check and written back into
+// the store, so routes follow lease renewals and PPP reconnects.
+var wanAddressModes = map[string]bool{"": true, "static": true, "dhcp": true, "pppoe": true}
+
+var (
+	networkdLeaseDir   = "/run/systemd/netif/leases"
+	dhclientLeaseGlobs = []string{
+		"/var/lib/dhcp/dhclient.%s.leases",
+		"/var/lib/dhcp/dhclient-*-%s.lease",
+		"/var/lib/dhclient/dhclient-%s.leases",
+		"/var/lib/NetworkManager/dhclient-*-%s.lease",
+	}
+)
+
+func isDynamicWAN(wan WANInterface) bool {
+	return wan.AddressMode == "dhcp" || wan.AddressMode == "pppoe"
+}
+
+func validateWANAddressing(wan WANInterface) error {
+	if !wanAddressModes[wan.AddressMode] {
+		return fmt.Errorf("address mode must be static, dhcp or pppoe")
+	}
+	if isDynamicWAN(wan) {
+		return nil
+	}
+	if wan.Gateway == "" {
+		if wan.Enabled {
+			return fmt.Errorf("a static WAN needs a gateway")
+		}
+		return nil
+	}
+	if ip := net.ParseIP(wan.Gateway); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("gateway must be an IPv4 address")
+	}
+	return nil
+}
+
+// wanRouteArgs returns the nexthop of a WAN's default route. PPP links are
+// point-to-point and can be routed by device alone.
+func wanRouteArgs(wan WANInterface) ([]string, error) {
+	if wan.Gateway != "" {
+		return []string{"via", wan.Gateway, "dev", wan.Interface}, nil
+	}
+	if wan.AddressMode == "pppoe" {
+		return []string{"dev", wan.Interface}, nil
+	}
+	return nil, fmt.Errorf("no gateway known for interface %s", wan.Interface)
+}
+
+// discoverWANGateway returns the current gateway of a WAN, "" if unknown
+func discoverWANGateway(wan WANInterface) string {
+	switch wan.AddressMode {
+	case "dhcp":
+		return dhcpLeaseGateway(wan.Interface)
+	case "pppoe":
+		out, err := runPrivilegedOutput("ip", "-4", "-j", "addr", "show", "dev", wan.Interface)
+		if err != nil {
+			return ""
+		}
+		_, peer := parsePPPAddresses(out)
+		return peer
+	}
+	return wan.Gateway
+}
+
+// dhcpLeaseGateway reads the router option from the DHCP client's lease,
+// falling back to the default route the client installed
+func dhcpLeaseGateway(ifaceName string) string {
+	if iface, err := net.InterfaceByName(ifaceName); err == nil {
+		if data, err := os.ReadFile(filepath.Join(networkdLeaseDir, fmt.Sprint(iface.Index))); err == nil {
+			if gw := parseNetworkdLeaseRouter(data); gw != "" {
+				return gw
+			}
+		}
+	}
+
+	for _, pattern := range dhclientLeaseGlobs {
+		matches, _ := filepath.Glob(fmt.Sprintf(pattern, ifaceName))
+		for _, path := range matches {
+			if data, err := os.ReadFile(path); err == nil {
+				if gw := parseDhclientLeaseRouter(data, ifaceName); gw != "" {
+					return gw
+				}
+			}
+		}
+	}
+
+	out, err := runPrivilegedOutput("ip", "-4", "-j", "route", "show", "table", "all", "default", "dev", ifaceName, "proto", "dhcp")
+	if err != nil {
+		return ""
+	}
+	return parseDefaultRouteGateway(out)
+}
+
+// parseNetworkdLeaseRouter parses systemd-networkd's lease file (ROUTER=a b)
+func parseNetworkdLeaseRouter(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "ROUTER="); ok {
+			if fields := strings.Fields(value); len(fields) > 0 && net.ParseIP(fields[0]) != nil {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
+
+// parseDhclientLeaseRouter returns the first router of the last lease for the
+// interface in a dhclient lease file
+func parseDhclientLeaseRouter(data []byte, ifaceName string) string {
+	router := ""
+	inLease, leaseIface, leaseRouter := false, "", ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ";")
+		switch {
+		case line == "lease {":
+			inLease, leaseIface, leaseRouter = true, "", ""
+		case !inLease:
+		case line == "}":
+			inLease = false
+			if leaseRouter != "" && (leaseIface == "" || leaseIface == ifaceName) {
+				router = leaseRouter
+			}
+		case strings.HasPrefix(line, "interface "):
+			leaseIface = strings.Trim(strings.TrimPrefix(line, "interface "), "\"")
+		case strings.HasPrefix(line, "option routers "):
+			first, _, _ := strings.Cut(strings.TrimPrefix(line, "option routers "), ",")
+			if net.ParseIP(strings.TrimSpace(first)) != nil {
+				leaseRouter = strings.TrimSpace(first)
+			}
+		}
+	}
+	return router
+}
+
+// parseDefaultRouteGateway returns the gateway of the first route in `ip -j route` output
+func parseDefaultRouteGateway(data []byte) string {
+	var routes []struct {
+		Gateway string `json:"gateway"`
+	}
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return ""
+	}
+	for _, r := range routes {
+		if r.Gateway != "" {
+			return r.Gateway
+		}
+	}
+	return ""
+}
+
+// refreshWANGateways learns the gateways of dynamic WANs, updates the store and
+// records changes. interfaces is updated in place.
+func refreshWANGateways(interfaces []WANInterface) {
+	changed := make(map[string]string)
+	for i := range interfaces {
+		wan := &interfaces[i]
+		if !wan.Enabled || !isDynamicWAN(*wan) {
+			continue
+		}
+		gw := discoverWANGateway(*wan)
+		if gw == wan.Gateway {
+			continue
+		}
+		previous := wan.Gateway
+		wan.Gateway = gw
+		changed[wan.Interface] = gw
+
+		message := fmt.Sprintf("Gateway of %s changed from %s to %s", wan.Interface, previous, gw)
+		switch {
+		case gw == "":
+			message = fmt.Sprintf("Gateway of %s lost (was %s)", wan.Interface, previous)
+		case previous == "":
+			message = fmt.Sprintf("Gateway of %s learned: %s", wan.Interface, gw)
+		}
+		recordWANEvent(WANEvent{
+			Type:      "gateway_change",
+			Interface: wan.Interface,
+			Previous:  previous,
+			Current:   gw,
+			Message:   message,
+		})
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	wanLock.Lock()
+	for i := range wanStore.Interfaces {
+		if gw, ok := changed[wanStore.Interfaces[i].Interface]; ok && isDynamicWAN(wanStore.Interfaces[i]) {
+			wanStore.Interfaces[i].Gateway = gw
+		}
+	}
+	wanLock.Unlock()
+
+	// Remember the last known gateway across restarts
+	if err := saveWANConfig(); err != nil {
+		fmt.Printf("Error saving WAN config: %v\n", err)
+	}
+}
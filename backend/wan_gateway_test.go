@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDhclientLeaseRouter(t *testing.T) {
+	leases := `lease {
+  interface "eth0";
+  fixed-address 100.64.0.20;
+  option routers 100.64.0.1;
+  renew 4 2026/03/05 10:00:00;
+}
+lease {
+  interface "eth1";
+  option routers 198.51.100.1;
+}
+lease {
+  interface "eth0";
+  fixed-address 100.64.8.20;
+  option routers 100.64.8.1,100.64.8.2;
+}
+`
+	if got := parseDhclientLeaseRouter([]byte(leases), "eth0"); got != "100.64.8.1" {
+		t.Errorf("Expected latest eth0 router 100.64.8.1, got %q", got)
+	}
+	if got := parseDhclientLeaseRouter([]byte(leases), "eth2"); got != "" {
+		t.Errorf("Expected no router for eth2, got %q", got)
+	}
+}
+
+func TestParseNetworkdLeaseRouter(t *testing.T) {
+	lease := "# This is private data. Do not parse.\nADDRESS=203.0.113.50\nNETMASK=255.255.255.0\nROUTER=203.0.113.1 203.0.113.2\n"
+	if got := parseNetworkdLeaseRouter([]byte(lease)); got != "203.0.113.1" {
+		t.Errorf("Expected 203.0.113.1, got %q", got)
+	}
+}
+
+func TestParseGatewayFromIPOutput(t *testing.T) {
+	routes := `[{"dst":"default","gateway":"192.0.2.1","dev":"eth0","protocol":"dhcp","metric":1024,"flags":[]}]`
+	if got := parseDefaultRouteGateway([]byte(routes)); got != "192.0.2.1" {
+		t.Errorf("Expected route gateway 192.0.2.1, got %q", got)
+	}
+
+	addrs := `[{"ifindex":9,"ifname":"ppp0","flags":["POINTOPOINT","UP"],"mtu":1492,
+	  "addr_info":[{"family":"inet","local":"100.70.1.2","address":"10.255.0.1","prefixlen":32,"scope":"global"}]}]`
+	if local, peer := parsePPPAddresses([]byte(addrs)); local != "100.70.1.2" || peer != "10.255.0.1" {
+		t.Errorf("Expected PPP addresses 100.70.1.2 -> 10.255.0.1, got %q -> %q", local, peer)
+	}
+}
+
+func TestWANRouteArgs(t *testing.T) {
+	tests := []struct {
+		wan  WANInterface
+		want string
+	}{
+		{WANInterface{Interface: "eth0", Gateway: "192.0.2.1"}, "via 192.0.2.1 dev eth0"},
+		{WANInterface{Interface: "ppp0", AddressMode: "pppoe"}, "dev ppp0"},
+		{WANInterface{Interface: "eth1", AddressMode: "dhcp"}, ""}, // No lease yet
+	}
+	for _, tt := range tests {
+		args, err := wanRouteArgs(tt.wan)
+		if got := strings.Join(args, " "); got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("%s: expected %q, got %q (err %v)", tt.wan.Interface, tt.want, got, err)
+		}
+	}
+}
+
+func TestValidateWANAddressing(t *testing.T) {
+	valid := []WANInterface{
+		{Interface: "eth0", Gateway: "192.0.2.1", Enabled: true},
+		{Interface: "eth1", AddressMode: "dhcp", Enabled: true},
+		{Interface: "ppp0", AddressMode: "pppoe", Enabled: true},
+		{Interface: "eth2", AddressMode: "static"}, // Disabled, gateway not needed yet
+	}
+	for _, wan := range valid {
+		if err := validateWANAddressing(wan); err != nil {
+			t.Errorf("%s: expected valid, got %v", wan.Interface, err)
+		}
+	}
+
+	invalid := []WANInterface{
+		{Interface: "eth0", Enabled: true},
+		{Interface: "eth0", Gateway: "2001:db8::1", Enabled: true},
+		{Interface: "eth0", AddressMode: "bootp"},
+	}
+	for _, wan := range invalid {
+		if err := validateWANAddressing(wan); err == nil {
+			t.Errorf("Expected %+v to be rejected", wan)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -13,12 +14,18 @@ import (
 type WANInterface struct {
 	Interface   string `json:"interface"`    // e.g., "eth0", "eth1"
 	Name        string `json:"name"`         // e.g., "Primary Fiber", "Backup 5G"
-	Gateway     string `json:"gateway"`      // e.g., "192.168.1.1"
+	Gateway     string `json:"gateway"`      // e.g., "192.168.1.1"; learned automatically for dhcp and pppoe
+	AddressMode string `json:"address_mode"` // "static" (default), "dhcp" or "pppoe"
 	CheckTarget string `json:"check_target"` // e.g., "8.8.8.8"
 	Priority    int    `json:"priority"`     // Lower is higher priority (1 = Primary)
 	Weight      int    `json:"weight"`       // For Load Balancing (default 1)
 	Enabled     bool   `json:"enabled"`
 	State       string `json:"state"` // "online", "offline", "unknown"
+
+	RouteID    int           `json:"route_id"`         // Routing table 200+ID and fwmark ID<<8, assigned automatically
+	Probes     []WANProbe    `json:"probes,omitempty"` // Health checks; defaults to ICMP to CheckTarget
+	Thresholds WANThresholds `json:"thresholds"`
+	Metrics    *WANMetrics   `json:"metrics,omitempty"` // Runtime only, filled in by GET /api/wan
 }
 
 // WANStore manages persistence
@@ -32,11 +39,18 @@ var (
 	wanLock       sync.RWMutex
 	wanConfigPath = "/etc/softrouter/multi_wan.json"
 	wanTicker     *time.Ticker
-	currentActive string // Interface name of currently active WAN (for active-passive)
+
+	// Routing decision state, guarded by activeLock
+	activeLock     sync.Mutex
+	currentActive  string // Interface name of currently active WAN (for active-passive), "balanced" for load balancing
+	activeRoute    string // Nexthop of the active WAN's default route, to follow gateway changes
+	lastBalanceSig string // Nexthops of the last applied load balancing route
+	allWANsDown    bool
 )
 
+// initWANManager starts health checks; the configuration is loaded earlier
+// because the initial firewall ruleset depends on it
 func initWANManager() {
-	loadWANConfig()
 	startWANMonitor()
 }
 
@@ -60,6 +74,7 @@ func loadWANConfig() {
 		wanStore.Interfaces = []WANInterface{}
 		wanStore.Mode = "failover"
 	}
+	assignWANRouteIDs(wanStore.Interfaces)
 }
 
 func saveWANConfig() error {
@@ -73,6 +88,15 @@ func saveWANConfig() error {
 	return os.WriteFile(wanConfigPath, data, 0644)
 }
 
+// GetWANStore returns a copy of the multi-WAN configuration
+func GetWANStore() WANStore {
+	wanLock.RLock()
+	defer wanLock.RUnlock()
+	store := wanStore
+	store.Interfaces = append([]WANInterface(nil), wanStore.Interfaces...)
+	return store
+}
+
 // startWANMonitor runs the periodic health check
 func startWANMonitor() {
 	wanTicker = time.NewTicker(10 * time.Second) // Check every 10s
@@ -86,43 +110,61 @@ func startWANMonitor() {
 
 func checkWANHealth() {
 	wanLock.Lock()
-	interfaces := wanStore.Interfaces
+	interfaces := append([]WANInterface(nil), wanStore.Interfaces...)
 	mode := wanStore.Mode
-	wanLock.Unlock() // Unlock logic to avoid holding during long pings
+	wanLock.Unlock() // Unlock logic to avoid holding during long probes
 
-	updated := false
+	refreshWANGateways(interfaces)
 
-	// Check all interfaces
+	// Probe all interfaces in parallel
+	results := make([][]probeSample, len(interfaces))
+	var wg sync.WaitGroup
 	for i := range interfaces {
 		if !interfaces[i].Enabled {
 			continue
 		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = runWANProbes(interfaces[i])
+		}(i)
+	}
+	wg.Wait()
 
-		target := interfaces[i].CheckTarget
-		if target == "" {
-			target = "8.8.8.8" // Default
-		}
-
-		// -W 2 seconds timeout
-		err := runPrivileged("ping", "-I", interfaces[i].Interface, "-c", "1", "-W", "2", target)
-
-		isOnline := (err == nil)
-		newState := "offline"
-		if isOnline {
-			newState = "online"
+	states := make(map[string]string)
+	for i := range interfaces {
+		if !interfaces[i].Enabled {
+			continue
 		}
 
-		if interfaces[i].State != newState {
+		newState, m := recordWANSamples(interfaces[i], results[i])
+		if previous := interfaces[i].State; previous != newState {
 			interfaces[i].State = newState
-			updated = true
-			fmt.Printf("WAN Interface %s (%s) is now %s\n", interfaces[i].Name, interfaces[i].Interface, newState)
+			states[interfaces[i].Interface] = newState
+
+			// Coming up after a restart is not worth an event
+			if newState != "online" || (previous != "" && previous != "unknown") {
+				recordWANEvent(WANEvent{
+					Type:      "state_change",
+					Interface: interfaces[i].Interface,
+					Previous:  previous,
+					Current:   newState,
+					Message: fmt.Sprintf("WAN %s (%s) is now %s (loss %.0f%%, rtt %.1fms, jitter %.1fms)",
+						interfaces[i].Name, interfaces[i].Interface, newState, m.LossPct, m.RTTMs, m.JitterMs),
+					Metrics: &m,
+				})
+			}
 		}
 	}
 
-	// Update Store if states changed
-	if updated {
+	// Update Store if states changed (by name, the config may have been replaced meanwhile)
+	if len(states) > 0 {
 		wanLock.Lock()
-		wanStore.Interfaces = interfaces
+		for i := range wanStore.Interfaces {
+			if state, ok := states[wanStore.Interfaces[i].Interface]; ok {
+				wanStore.Interfaces[i].State = state
+			}
+		}
 		wanLock.Unlock()
 	}
 
@@ -131,6 +173,8 @@ func checkWANHealth() {
 }
 
 func applyRoutingLogic(interfaces []WANInterface, mode string) {
+	syncWANPolicyRouting(interfaces)
+
 	if mode == "load_balance" {
 		applyLoadBalancing(interfaces)
 	} else {
@@ -139,25 +183,102 @@ func applyRoutingLogic(interfaces []WANInterface, mode string) {
 }
 
 func applyFailover(interfaces []WANInterface) {
+	var best *WANInterface
 	bestInterface := ""
 	highestPriority := 999
 
-	for _, iface := range interfaces {
+	for i, iface := range interfaces {
 		if iface.Enabled && iface.State == "online" {
+			if _, err := wanRouteArgs(iface); err != nil {
+				continue // Online but no gateway learned yet
+			}
 			if iface.Priority < highestPriority {
 				highestPriority = iface.Priority
 				bestInterface = iface.Interface
+				best = &interfaces[i]
 			}
 		}
 	}
 
-	if bestInterface != "" && bestInterface != currentActive {
+	activeLock.Lock()
+	defer activeLock.Unlock()
+
+	if bestInterface == "" {
+		// All offline: keep the last route, it is the best guess until one comes back
+		reportAllWANsDown(interfaces)
+		return
+	}
+	allWANsDown = false
+
+	routeArgs, _ := wanRouteArgs(*best)
+	route := strings.Join(routeArgs, " ")
+
+	if bestInterface == currentActive && route != activeRoute {
+		// Same WAN, new gateway (lease renewal or PPP reconnect)
+		if err := switchDefaultRoute(*best); err != nil {
+			fmt.Printf("Failed to update default route of %s: %v\n", bestInterface, err)
+			return
+		}
+		activeRoute = route
+		recordWANEvent(WANEvent{
+			Type:      "route_change",
+			Interface: bestInterface,
+			Current:   route,
+			Message:   fmt.Sprintf("Default route updated to %s", route),
+		})
+		return
+	}
+
+	if bestInterface != currentActive {
+		previous := currentActive
 		fmt.Printf("Failover: Switching default gateway to %s\n", bestInterface)
-		switchDefaultRoute(bestInterface)
-	} else if bestInterface == "" {
-		// All offline?
-		// currentActive might stay as last known regular
+		if err := switchDefaultRoute(*best); err != nil {
+			recordWANEvent(WANEvent{
+				Type:      "failover",
+				Interface: bestInterface,
+				Previous:  previous,
+				Message:   fmt.Sprintf("Failed to switch default route to %s: %v", bestInterface, err),
+			})
+			return
+		}
+		currentActive = bestInterface
+		activeRoute = route
+		lastBalanceSig = ""
+
+		// Startup picks the first active WAN, that is not a failover
+		if previous != "" {
+			recordWANEvent(WANEvent{
+				Type:      "failover",
+				Interface: bestInterface,
+				Previous:  previous,
+				Current:   bestInterface,
+				Message:   fmt.Sprintf("Default route moved from %s to %s", previous, bestInterface),
+			})
+		}
+	}
+}
+
+// reportAllWANsDown records an event once when the last WAN goes offline.
+// Caller must hold activeLock.
+func reportAllWANsDown(interfaces []WANInterface) {
+	if allWANsDown {
+		return
+	}
+	enabled := 0
+	for _, iface := range interfaces {
+		if iface.Enabled {
+			enabled++
+		}
 	}
+	if enabled == 0 {
+		return
+	}
+	allWANsDown = true
+	recordWANEvent(WANEvent{
+		Type:      "all_down",
+		Interface: currentActive,
+		Message:   "All WAN interfaces are offline",
+	})
 }
 
 func applyLoadBalancing(interfaces []WANInterface) {
@@ -169,9 +290,14 @@ func applyLoadBalancing(interfaces []WANInterface) {
 		}
 	}
 
+	activeLock.Lock()
+	defer activeLock.Unlock()
+
 	if len(onlineInterfaces) == 0 {
+		reportAllWANsDown(interfaces)
 		return // Nothing to do
 	}
+	allWANsDown = false
 
 	// Build ip route command
 	// ip route replace default scope global
@@ -179,52 +305,58 @@ func applyLoadBalancing(interfaces []WANInterface) {
 	//   nexthop via <G2> dev <I2> weight <W2>
 
 	args := []string{"route", "replace", "default", "scope", "global"}
+	var nexthops []string
 
 	for _, iface := range onlineInterfaces {
+		routeArgs, err := wanRouteArgs(iface)
+		if err != nil {
+			continue // No gateway learned yet
+		}
 		weight := iface.Weight
 		if weight <= 0 {
 			weight = 1
 		}
-		args = append(args, "nexthop", "via", iface.Gateway, "dev", iface.Interface, "weight", fmt.Sprintf("%d", weight))
+		args = append(args, "nexthop")
+		args = append(args, routeArgs...)
+		args = append(args, "weight", fmt.Sprintf("%d", weight))
+		nexthops = append(nexthops, fmt.Sprintf("%s(%d)", strings.Join(routeArgs, " "), weight))
+	}
+	if len(nexthops) == 0 {
+		return
 	}
 
-	// Check if this is different from current state?
-	// For simplicity, we re-apply. Linux is smart enough to handle replace.
-	// But to avoid log spam, maybe only log if changes?
-	// Note: 'replace' is atomic.
-
+	// Re-applied on every check ('replace' is atomic), only changes are reported
 	if out, err := runPrivilegedCombinedOutput("ip", args...); err != nil {
 		fmt.Printf("Failed to apply Load Balancing: %v (%s)\n", err, string(out))
-	} else {
-		// Success
-		// fmt.Println("Applied Load Balancing routes.")
+		return
 	}
 	currentActive = "balanced"
-}
 
-func switchDefaultRoute(ifaceName string) {
-	// Find gateway for this interface
-	wanLock.RLock()
-	gateway := ""
-	for _, iface := range wanStore.Interfaces {
-		if iface.Interface == ifaceName {
-			gateway = iface.Gateway
-			break
-		}
+	sig := strings.Join(nexthops, ",")
+	if sig != lastBalanceSig {
+		recordWANEvent(WANEvent{
+			Type:      "route_change",
+			Interface: "balanced",
+			Previous:  lastBalanceSig,
+			Current:   sig,
+			Message:   "Load balancing default route now uses " + sig,
+		})
+		lastBalanceSig = sig
 	}
-	wanLock.RUnlock()
+}
 
-	if gateway == "" {
-		fmt.Printf("Error: No gateway found for interface %s, cannot switch route.\n", ifaceName)
-		return
+func switchDefaultRoute(wan WANInterface) error {
+	routeArgs, err := wanRouteArgs(wan)
+	if err != nil {
+		return err
 	}
 
-	if out, err := runPrivilegedCombinedOutput("ip", "route", "replace", "default", "via", gateway, "dev", ifaceName); err != nil {
-		fmt.Printf("Failed to switch default route: %v (%s)\n", err, string(out))
-	} else {
-		fmt.Printf("Successfully switched default route to %s via %s\n", ifaceName, gateway)
-		currentActive = ifaceName
+	args := append([]string{"route", "replace", "default"}, routeArgs...)
+	if out, err := runPrivilegedCombinedOutput("ip", args...); err != nil {
+		return fmt.Errorf("%v (%s)", err, strings.TrimSpace(string(out)))
 	}
+	fmt.Printf("Successfully switched default route to %s\n", strings.Join(routeArgs, " "))
+	return nil
 }
 
 // --- API Handlers ---
@@ -233,8 +365,13 @@ func getWANInterfaces(w http.ResponseWriter, r *http.Request) {
 	wanLock.RLock()
 	// Return the whole store structure now (Mode + Interfaces)
 	data := wanStore
+	data.Interfaces = append([]WANInterface(nil), wanStore.Interfaces...)
 	wanLock.RUnlock()
 
+	for i := range data.Interfaces {
+		data.Interfaces[i].Metrics = getWANMetrics(data.Interfaces[i].Interface)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
 }
@@ -246,6 +383,39 @@ func updateWANInterfaces(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for i := range req.Interfaces {
+		req.Interfaces[i].Metrics = nil
+		for _, p := range req.Interfaces[i].Probes {
+			if err := validateWANProbe(p); err != nil {
+				http.Error(w, fmt.Sprintf("%s: %v", req.Interfaces[i].Interface, err), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := validateWANThresholds(req.Interfaces[i].Thresholds); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", req.Interfaces[i].Interface, err), http.StatusBadRequest)
+			return
+		}
+		if err := validateWANAddressing(req.Interfaces[i]); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", req.Interfaces[i].Interface, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Keep route IDs stable for interfaces that already have one
+	current := GetWANStore()
+	for i := range req.Interfaces {
+		req.Interfaces[i].RouteID = 0
+		for _, old := range current.Interfaces {
+			if old.Interface == req.Interfaces[i].Interface {
+				req.Interfaces[i].RouteID = old.RouteID
+				if isDynamicWAN(req.Interfaces[i]) && isDynamicWAN(old) {
+					req.Interfaces[i].Gateway = old.Gateway // Learned, not configured
+				}
+			}
+		}
+	}
+	assignWANRouteIDs(req.Interfaces)
+
 	wanLock.Lock()
 	wanStore = req
 	wanLock.Unlock()
@@ -255,6 +425,9 @@ func updateWANInterfaces(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Connmark chains depend on the WAN list
+	go firewallManager.ApplyFirewallRules()
+
 	// Trigger immediate check to apply changes
 	go checkWANHealth()
 
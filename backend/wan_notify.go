@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WANNotifier delivers WAN events to an external system
+type WANNotifier struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"` // webhook, smtp, syslog
+	Enabled bool     `json:"enabled"`
+	Events  []string `json:"events,omitempty"` // Event types to send, empty = all
+
+	// webhook
+	URL    string `json:"url,omitempty"`
+	Secret string `json:"secret,omitempty"` // Signs the body as X-SoftRouter-Signature: sha256=<hmac>
+
+	// smtp (STARTTLS is used when the server offers it)
+	SMTPHost string   `json:"smtp_host,omitempty"`
+	SMTPPort int      `json:"smtp_port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+
+	// syslog: "" for the local daemon, or udp://host:514 / tcp://host:514
+	SyslogAddr string `json:"syslog_addr,omitempty"`
+}
+
+// WANNotifyStore holds the configured notifiers
+type WANNotifyStore struct {
+	Notifiers []WANNotifier `json:"notifiers"`
+}
+
+var (
+	wanNotifyStore      WANNotifyStore
+	wanNotifyLock       sync.RWMutex
+	wanNotifyConfigPath = "/etc/softrouter/wan_notify.json"
+
+	wanEventTypes = map[string]bool{"state_change": true, "failover": true, "route_change": true, "all_down": true, "gateway_change": true}
+	notifyClient  = &http.Client{Timeout: 10 * time.Second}
+)
+
+func loadWANNotifyConfig() {
+	wanNotifyLock.Lock()
+	defer wanNotifyLock.Unlock()
+
+	wanNotifyStore = WANNotifyStore{Notifiers: []WANNotifier{}}
+	data, err := os.ReadFile(wanNotifyConfigPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error loading WAN notifier config: %v\n", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &wanNotifyStore); err != nil {
+		fmt.Printf("Error parsing WAN notifier config: %v\n", err)
+	}
+}
+
+func saveWANNotifyConfig() error {
+	data, err := json.MarshalIndent(wanNotifyStore, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Holds SMTP passwords and webhook secrets
+	return os.WriteFile(wanNotifyConfigPath, data, 0600)
+}
+
+func validateWANNotifier(n WANNotifier) error {
+	if n.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, ev := range n.Events {
+		if !wanEventTypes[ev] {
+			return fmt.Errorf("unknown event type %q", ev)
+		}
+	}
+
+	switch n.Type {
+	case "webhook":
+		u, err := url.Parse(n.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook URL must be an http(s) URL")
+		}
+	case "smtp":
+		if n.SMTPHost == "" || strings.ContainsAny(n.SMTPHost, "/: ") {
+			return fmt.Errorf("invalid SMTP host")
+		}
+		if n.SMTPPort < 0 || n.SMTPPort > 65535 {
+			return fmt.Errorf("invalid SMTP port")
+		}
+		if !isValidEmailHeader(n.From) {
+			return fmt.Errorf("invalid sender address")
+		}
+		if len(n.To) == 0 {
+			return fmt.Errorf("at least one recipient is required")
+		}
+		for _, to := range n.To {
+			if !isValidEmailHeader(to) {
+				return fmt.Errorf("invalid recipient %q", to)
+			}
+		}
+	case "syslog":
+		if n.SyslogAddr != "" {
+			network, addr, ok := strings.Cut(n.SyslogAddr, "://")
+			if !ok || (network != "udp" && network != "tcp") {
+				return fmt.Errorf("syslog address must be udp://host:port or tcp://host:port")
+			}
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("invalid syslog address: %v", err)
+			}
+		}
+	default:
+		return fmt.Errorf("type must be webhook, smtp or syslog")
+	}
+	return nil
+}
+
+// isValidEmailHeader rejects addresses that could inject mail headers
+func isValidEmailHeader(addr string) bool {
+	return strings.Contains(addr, "@") && !strings.ContainsAny(addr, "\r\n<>, ")
+}
+
+func (n WANNotifier) wants(eventType string) bool {
+	if !n.Enabled {
+		return false
+	}
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, ev := range n.Events {
+		if ev == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyWANEvent sends an event to every interested notifier
+func notifyWANEvent(ev WANEvent) {
+	wanNotifyLock.RLock()
+	notifiers := append([]WANNotifier(nil), wanNotifyStore.Notifiers...)
+	wanNotifyLock.RUnlock()
+
+	for _, n := range notifiers {
+		if !n.wants(ev.Type) {
+			continue
+		}
+		if err := sendWANNotification(n, ev); err != nil {
+			fmt.Printf("WAN notifier %s failed: %v\n", n.Name, err)
+		}
+	}
+}
+
+func sendWANNotification(n WANNotifier, ev WANEvent) error {
+	switch n.Type {
+	case "webhook":
+		return sendWebhookNotification(n, ev)
+	case "smtp":
+		return sendSMTPNotification(n, ev)
+	case "syslog":
+		return sendSyslogNotification(n, ev)
+	}
+	return fmt.Errorf("unknown notifier type %s", n.Type)
+}
+
+func wanEventSubject(ev WANEvent) string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("[SoftRouter %s] WAN %s: %s", hostname, strings.ReplaceAll(ev.Type, "_", " "), ev.Interface)
+}
+
+func sendWebhookNotification(n WANNotifier, ev WANEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-SoftRouter-Event", ev.Type)
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set("X-SoftRouter-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// renderWANEventEmail builds the RFC 5322 message for an event
+func renderWANEventEmail(n WANNotifier, ev WANEvent) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + n.From + "\r\n")
+	b.WriteString("To: " + strings.Join(n.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + wanEventSubject(ev) + "\r\n")
+	b.WriteString("Date: " + ev.Timestamp.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	b.WriteString(ev.Message + "\r\n\r\n")
+	b.WriteString("Time:      " + ev.Timestamp.Format(time.RFC3339) + "\r\n")
+	b.WriteString("Interface: " + ev.Interface + "\r\n")
+	if ev.Previous != "" || ev.Current != "" {
+		b.WriteString(fmt.Sprintf("Change:    %s -> %s\r\n", ev.Previous, ev.Current))
+	}
+	if m := ev.Metrics; m != nil {
+		b.WriteString(fmt.Sprintf("Loss:      %.0f%%\r\nLatency:   %.1f ms\r\nJitter:    %.1f ms\r\n", m.LossPct, m.RTTMs, m.JitterMs))
+	}
+	return []byte(b.String())
+}
+
+func sendSMTPNotification(n WANNotifier, ev WANEvent) error {
+	port := n.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.SMTPHost)
+	}
+	addr := net.JoinHostPort(n.SMTPHost, fmt.Sprintf("%d", port))
+	return smtp.SendMail(addr, auth, n.From, n.To, renderWANEventEmail(n, ev))
+}
+
+// formatSyslogMessage renders an RFC 3164 message (facility daemon). The local
+// daemon adds the hostname itself, remote collectors need it in the header.
+func formatSyslogMessage(ev WANEvent, hostname string) string {
+	severity := 5 // notice
+	if ev.Type == "all_down" || ev.Type == "failover" || ev.Current == "offline" {
+		severity = 4 // warning
+	}
+	header := fmt.Sprintf("<%d>%s ", 3*8+severity, ev.Timestamp.Format(time.Stamp))
+	if hostname != "" {
+		header += hostname + " "
+	}
+	msg := strings.NewReplacer("\n", " ", "\r", " ").Replace(ev.Message)
+	return fmt.Sprintf("%ssoftrouter[%d]: wan.%s %s: %s", header, os.Getpid(), ev.Type, ev.Interface, msg)
+}
+
+func sendSyslogNotification(n WANNotifier, ev WANEvent) error {
+	var conn net.Conn
+	var err error
+	hostname := ""
+	if n.SyslogAddr == "" {
+		for _, path := range []string{"/dev/log", "/var/run/syslog"} {
+			if conn, err = net.DialTimeout("unixgram", path, 5*time.Second); err == nil {
+				break
+			}
+		}
+	} else {
+		network, addr, _ := strings.Cut(n.SyslogAddr, "://")
+		hostname, _ = os.Hostname()
+		conn, err = net.DialTimeout(network, addr, 5*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	msg := formatSyslogMessage(ev, hostname)
+	if strings.HasPrefix(n.SyslogAddr, "tcp://") {
+		msg += "\n" // Non-transparent framing
+	}
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+// --- API Handlers ---
+
+func getWANNotifiersHandler(w http.ResponseWriter, r *http.Request) {
+	wanNotifyLock.RLock()
+	notifiers := make([]WANNotifier, len(wanNotifyStore.Notifiers))
+	copy(notifiers, wanNotifyStore.Notifiers)
+	wanNotifyLock.RUnlock()
+
+	for i := range notifiers {
+		notifiers[i].Password = maskPassword(notifiers[i].Password)
+		notifiers[i].Secret = maskPassword(notifiers[i].Secret)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, WANNotifyStore{Notifiers: notifiers})
+}
+
+// mergeNotifierSecrets keeps stored secrets for notifiers sent back with masked values
+func mergeNotifierSecrets(updated, existing []WANNotifier) {
+	byID := make(map[string]WANNotifier)
+	for _, n := range existing {
+		byID[n.ID] = n
+	}
+	for i := range updated {
+		old, ok := byID[updated[i].ID]
+		if updated[i].ID == "" || !ok {
+			updated[i].ID = uuid.New().String()
+			continue
+		}
+		if updated[i].Password == maskPassword(old.Password) {
+			updated[i].Password = old.Password
+		}
+		if updated[i].Secret == maskPassword(old.Secret) {
+			updated[i].Secret = old.Secret
+		}
+	}
+}
+
+func updateWANNotifiersHandler(w http.ResponseWriter, r *http.Request) {
+	var newStore WANNotifyStore
+	if err := json.NewDecoder(r.Body).Decode(&newStore); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if newStore.Notifiers == nil {
+		newStore.Notifiers = []WANNotifier{}
+	}
+	for _, n := range newStore.Notifiers {
+		if err := validateWANNotifier(n); err != nil {
+			respondInvalidRequest(w, fmt.Sprintf("Notifier %q: %v", n.Name, err))
+			return
+		}
+	}
+
+	wanNotifyLock.Lock()
+	mergeNotifierSecrets(newStore.Notifiers, wanNotifyStore.Notifiers)
+	previous := wanNotifyStore
+	wanNotifyStore = newStore
+	if err := saveWANNotifyConfig(); err != nil {
+		wanNotifyStore = previous
+		wanNotifyLock.Unlock()
+		logAuditEvent(getUsernameFromToken(r), "wan.notifiers.update", "wan_notify",
+			fmt.Sprintf("{\"error\":%q}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save WAN notifier configuration", err)
+		return
+	}
+	wanNotifyLock.Unlock()
+
+	logAuditEvent(getUsernameFromToken(r), "wan.notifiers.update", "wan_notify",
+		fmt.Sprintf("{\"count\":%d}", len(newStore.Notifiers)), getClientIP(r), true)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "success"})
+}
+
+// testWANNotifierHandler sends a test event through one stored notifier
+func testWANNotifierHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	wanNotifyLock.RLock()
+	var notifier *WANNotifier
+	for _, n := range wanNotifyStore.Notifiers {
+		if n.ID == id {
+			n := n
+			notifier = &n
+			break
+		}
+	}
+	wanNotifyLock.RUnlock()
+
+	if notifier == nil {
+		respondWithError(w, ErrGenericNotFound, "Notifier not found", http.StatusNotFound, nil)
+		return
+	}
+
+	ev := WANEvent{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now(),
+		Type:      "test",
+		Interface: "test",
+		Message:   "Test notification from SoftRouter",
+	}
+	if err := sendWANNotification(*notifier, ev); err != nil {
+		respondWithError(w, ErrGenericInternalError, fmt.Sprintf("Test notification failed: %v", err), http.StatusBadGateway, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "success"})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetWANEventsFilters(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	wanEventsLock.Lock()
+	saved := wanEvents
+	wanEvents = []WANEvent{
+		{ID: "1", Timestamp: base, Type: "state_change", Interface: "eth0"},
+		{ID: "2", Timestamp: base.Add(time.Minute), Type: "failover", Interface: "eth5"},
+		{ID: "3", Timestamp: base.Add(2 * time.Minute), Type: "state_change", Interface: "eth5"},
+	}
+	wanEventsLock.Unlock()
+	defer func() {
+		wanEventsLock.Lock()
+		wanEvents = saved
+		wanEventsLock.Unlock()
+	}()
+
+	ids := func(events []WANEvent) string {
+		var out []string
+		for _, ev := range events {
+			out = append(out, ev.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		name, iface, typ string
+		since            time.Time
+		limit            int
+		want             string
+	}{
+		{"all newest first", "", "", time.Time{}, 0, "3,2,1"},
+		{"interface", "eth5", "", time.Time{}, 0, "3,2"},
+		{"type", "", "state_change", time.Time{}, 0, "3,1"},
+		{"since", "", "", base.Add(time.Minute), 0, "3,2"},
+		{"limit", "", "", time.Time{}, 1, "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(getWANEvents(tt.iface, tt.typ, tt.since, tt.limit)); got != tt.want {
+				t.Errorf("Expected events %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSendWebhookNotificationSigned(t *testing.T) {
+	var gotBody []byte
+	var gotSig, gotEvent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSig = r.Header.Get("X-SoftRouter-Signature")
+		gotEvent = r.Header.Get("X-SoftRouter-Event")
+	}))
+	defer srv.Close()
+
+	n := WANNotifier{Name: "hook", Type: "webhook", URL: srv.URL, Secret: "s3cret", Enabled: true}
+	ev := WANEvent{ID: "x", Type: "failover", Interface: "eth5", Previous: "eth0", Current: "eth5", Message: "moved"}
+	if err := sendWebhookNotification(n, ev); err != nil {
+		t.Fatalf("Webhook failed: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(gotBody)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); gotSig != want {
+		t.Errorf("Expected signature %s, got %s", want, gotSig)
+	}
+	if gotEvent != "failover" || !strings.Contains(string(gotBody), `"previous":"eth0"`) {
+		t.Errorf("Unexpected webhook request: event %q body %s", gotEvent, gotBody)
+	}
+}
+
+func TestMergeNotifierSecrets(t *testing.T) {
+	existing := []WANNotifier{{ID: "a", Password: "mailpass", Secret: "hooksecret"}}
+	updated := []WANNotifier{
+		{ID: "a", Password: "****", Secret: "changed"},
+		{Name: "new", Password: "****"},
+	}
+	mergeNotifierSecrets(updated, existing)
+
+	if updated[0].Password != "mailpass" || updated[0].Secret != "changed" {
+		t.Errorf("Expected masked password kept and new secret applied, got %+v", updated[0])
+	}
+	if updated[1].ID == "" || updated[1].Password != "****" {
+		t.Errorf("Expected new notifier to get an ID and keep its values, got %+v", updated[1])
+	}
+}
+
+func TestValidateWANNotifier(t *testing.T) {
+	valid := []WANNotifier{
+		{Name: "hook", Type: "webhook", URL: "https://example.com/hook", Events: []string{"failover"}},
+		{Name: "mail", Type: "smtp", SMTPHost: "mail.example.com", From: "router@example.com", To: []string{"ops@example.com"}},
+		{Name: "local", Type: "syslog"},
+		{Name: "remote", Type: "syslog", SyslogAddr: "udp://192.0.2.10:514"},
+	}
+	for _, n := range valid {
+		if err := validateWANNotifier(n); err != nil {
+			t.Errorf("Expected %s to be valid, got %v", n.Name, err)
+		}
+	}
+
+	invalid := []WANNotifier{
+		{Name: "scheme", Type: "webhook", URL: "file:///etc/passwd"},
+		{Name: "event", Type: "syslog", Events: []string{"reboot"}},
+		{Name: "header injection", Type: "smtp", SMTPHost: "mail.example.com", From: "a@example.com\r\nBcc: x@evil.com", To: []string{"ops@example.com"}},
+		{Name: "no recipients", Type: "smtp", SMTPHost: "mail.example.com", From: "router@example.com"},
+		{Name: "syslog network", Type: "syslog", SyslogAddr: "unix:///dev/log"},
+		{Name: "type", Type: "pager"},
+	}
+	for _, n := range invalid {
+		if err := validateWANNotifier(n); err == nil {
+			t.Errorf("Expected %s to be rejected", n.Name)
+		}
+	}
+}
+
+func TestFormatSyslogMessage(t *testing.T) {
+	ev := WANEvent{
+		Timestamp: time.Date(2026, 3, 1, 9, 5, 0, 0, time.UTC),
+		Type:      "state_change",
+		Interface: "eth0",
+		Current:   "offline",
+		Message:   "WAN eth0 is now offline\ninjected",
+	}
+	got := formatSyslogMessage(ev, "router")
+	if !strings.HasPrefix(got, "<28>Mar  1 09:05:00 router softrouter[") {
+		t.Errorf("Unexpected syslog header: %s", got)
+	}
+	if !strings.HasSuffix(got, "]: wan.state_change eth0: WAN eth0 is now offline injected") {
+		t.Errorf("Unexpected syslog message: %s", got)
+	}
+}
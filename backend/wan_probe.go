@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WANProbe is one health check target of a WAN interface
+type WANProbe struct {
+	Type   string `json:"type"`            // icmp, tcp, http, dns
+	Target string `json:"target"`          // icmp: host, tcp: host:port, http: URL, dns: resolver IP
+	Query  string `json:"query,omitempty"` // dns: name to resolve (default example.com)
+}
+
+// WANThresholds decide when a WAN goes down or comes back. An interval is bad
+// when any Down* limit is reached and good when every Up* limit is met; in
+// between the state is held. Zero RTT/jitter limits are ignored.
+type WANThresholds struct {
+	DownLossPct  float64 `json:"down_loss_pct"`  // Default 50
+	UpLossPct    float64 `json:"up_loss_pct"`    // Default 10
+	DownRTTMs    float64 `json:"down_rtt_ms"`    //
+	UpRTTMs      float64 `json:"up_rtt_ms"`      //
+	DownJitterMs float64 `json:"down_jitter_ms"` //
+	UpJitterMs   float64 `json:"up_jitter_ms"`   //
+	DownCount    int     `json:"down_count"`     // Consecutive bad intervals before offline (default 3)
+	UpCount      int     `json:"up_count"`       // Consecutive good intervals before online (default 3)
+}
+
+// WANMetrics are the rolling health metrics of a WAN interface
+type WANMetrics struct {
+	RTTMs      float64   `json:"rtt_ms"`
+	JitterMs   float64   `json:"jitter_ms"`
+	LossPct    float64   `json:"loss_pct"`
+	Samples    int       `json:"samples"`
+	GoodStreak int       `json:"good_streak"`
+	BadStreak  int       `json:"bad_streak"`
+	LastCheck  time.Time `json:"last_check"`
+}
+
+// WANHealthPoint is one entry of a WAN interface's metric history
+type WANHealthPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	RTTMs     float64 `json:"rtt_ms"`
+	JitterMs  float64 `json:"jitter_ms"`
+	LossPct   float64 `json:"loss_pct"`
+	State     string  `json:"state"`
+}
+
+// probeSample is a single probe result
+type probeSample struct {
+	Target string
+	RTT    time.Duration
+	OK     bool
+}
+
+type wanHealthState struct {
+	samples []probeSample // Rolling window, oldest first
+	metrics WANMetrics
+	history []WANHealthPoint
+}
+
+var (
+	wanHealth     = make(map[string]*wanHealthState) // Key: interface name
+	wanHealthLock sync.RWMutex
+
+	wanProbeTimeout     = 2 * time.Second
+	wanProbeCount       = 3   // Probes per target per interval
+	wanSampleWindow     = 30  // Samples kept for rolling metrics
+	maxWANHistoryPoints = 360 // 1 hour at 10s
+
+	pingRTTRegex = regexp.MustCompile(`time=([0-9.]+) ms`)
+)
+
+var wanProbeTypes = map[string]bool{"icmp": true, "tcp": true, "http": true, "dns": true}
+
+// withDefaults fills unset thresholds
+func (t WANThresholds) withDefaults() WANThresholds {
+	if t.DownLossPct == 0 {
+		t.DownLossPct = 50
+	}
+	if t.UpLossPct == 0 {
+		t.UpLossPct = 10
+	}
+	if t.DownCount <= 0 {
+		t.DownCount = 3
+	}
+	if t.UpCount <= 0 {
+		t.UpCount = 3
+	}
+	return t
+}
+
+func validateWANProbe(p WANProbe) error {
+	if !wanProbeTypes[p.Type] {
+		return fmt.Errorf("probe type must be icmp, tcp, http or dns")
+	}
+	switch p.Type {
+	case "icmp":
+		if net.ParseIP(p.Target) == nil && !dnsDomainRegex.MatchString(p.Target) {
+			return fmt.Errorf("invalid icmp probe target %q", p.Target)
+		}
+	case "tcp":
+		host, port, err := net.SplitHostPort(p.Target)
+		if err != nil || host == "" {
+			return fmt.Errorf("tcp probe target must be host:port")
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid tcp probe port %q", port)
+		}
+	case "http":
+		u, err := url.Parse(p.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("http probe target must be an http(s) URL")
+		}
+	case "dns":
+		if net.ParseIP(p.Target) == nil {
+			return fmt.Errorf("dns probe target must be a resolver IP")
+		}
+		if p.Query != "" && !dnsDomainRegex.MatchString(p.Query) {
+			return fmt.Errorf("invalid dns probe query %q", p.Query)
+		}
+	}
+	return nil
+}
+
+func validateWANThresholds(t WANThresholds) error {
+	if t.DownLossPct < 0 || t.DownLossPct > 100 || t.UpLossPct < 0 || t.UpLossPct > 100 {
+		return fmt.Errorf("loss thresholds must be between 0 and 100")
+	}
+	if t.DownRTTMs < 0 || t.UpRTTMs < 0 || t.DownJitterMs < 0 || t.UpJitterMs < 0 || t.DownCount < 0 || t.UpCount < 0 {
+		return fmt.Errorf("thresholds must not be negative")
+	}
+	d := t.withDefaults()
+	if d.UpLossPct > d.DownLossPct ||
+		(d.UpRTTMs > 0 && d.DownRTTMs > 0 && d.UpRTTMs > d.DownRTTMs) ||
+		(d.UpJitterMs > 0 && d.DownJitterMs > 0 && d.UpJitterMs > d.DownJitterMs) {
+		return fmt.Errorf("up thresholds must not be looser than down thresholds")
+	}
+	return nil
+}
+
+// wanProbes returns the configured probes, falling back to an ICMP check of CheckTarget
+func wanProbes(iface WANInterface) []WANProbe {
+	if len(iface.Probes) > 0 {
+		return iface.Probes
+	}
+	target := iface.CheckTarget
+	if target == "" {
+		target = "8.8.8.8" // Default
+	}
+	return []WANProbe{{Type: "icmp", Target: target}}
+}
+
+// runWANProbes runs every probe of an interface concurrently
+func runWANProbes(iface WANInterface) []probeSample {
+	probes := wanProbes(iface)
+	results := make([][]probeSample, len(probes))
+
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p WANProbe) {
+			defer wg.Done()
+			results[i] = runWANProbe(iface.Interface, p, wanProbeCount)
+		}(i, p)
+	}
+	wg.Wait()
+
+	var samples []probeSample
+	for _, r := range results {
+		samples = append(samples, r...)
+	}
+	return samples
+}
+
+func runWANProbe(ifaceName string, p WANProbe, count int) []probeSample {
+	if p.Type == "icmp" {
+		// -W 2 seconds timeout per reply
+		out, _ := runPrivilegedCombinedOutput("ping", "-I", ifaceName, "-c", strconv.Itoa(count), "-i", "0.2", "-W", "2", p.Target)
+		return pingSamples(p.Target, string(out), count)
+	}
+
+	samples := make([]probeSample, 0, count)
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		err := probeOnce(ifaceName, p)
+		samples = append(samples, probeSample{Target: p.Target, RTT: time.Since(start), OK: err == nil})
+	}
+	return samples
+}
+
+// pingSamples turns ping output into samples; missing replies count as lost
+func pingSamples(target, output string, count int) []probeSample {
+	samples := make([]probeSample, 0, count)
+	for _, m := range pingRTTRegex.FindAllStringSubmatch(output, count) {
+		ms, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, probeSample{Target: target, RTT: time.Duration(ms * float64(time.Millisecond)), OK: true})
+	}
+	for len(samples) < count {
+		samples = append(samples, probeSample{Target: target})
+	}
+	return samples
+}
+
+// probeOnce runs one TCP, HTTP or DNS probe from the given interface
+func probeOnce(ifaceName string, p WANProbe) error {
+	ctx, cancel := context.WithTimeout(context.Background(), wanProbeTimeout)
+	defer cancel()
+
+	dialer := &net.Dialer{Control: bindToDeviceControl(ifaceName)}
+
+	switch p.Type {
+	case "tcp":
+		conn, err := dialer.DialContext(ctx, "tcp", p.Target)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http":
+		client := &http.Client{
+			Transport: &http.Transport{DialContext: dialer.DialContext, DisableKeepAlives: true},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.Target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close() //nolint:errcheck
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		return nil
+	case "dns":
+		query := p.Query
+		if query == "" {
+			query = "example.com"
+		}
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, net.JoinHostPort(p.Target, "53"))
+			},
+		}
+		_, err := resolver.LookupHost(ctx, query)
+		return err
+	}
+	return fmt.Errorf("unknown probe type %q", p.Type)
+}
+
+// computeWANMetrics derives loss, mean RTT and jitter from a sample window.
+// Jitter is the mean RTT difference between consecutive replies of the same target.
+func computeWANMetrics(samples []probeSample) WANMetrics {
+	m := WANMetrics{Samples: len(samples)}
+	if len(samples) == 0 {
+		return m
+	}
+
+	var rttSum, jitterSum float64
+	var ok, jitterN int
+	last := make(map[string]float64)
+	for _, s := range samples {
+		if !s.OK {
+			continue
+		}
+		ms := float64(s.RTT) / float64(time.Millisecond)
+		rttSum += ms
+		ok++
+		if prev, seen := last[s.Target]; seen {
+			jitterSum += math.Abs(ms - prev)
+			jitterN++
+		}
+		last[s.Target] = ms
+	}
+
+	m.LossPct = float64(len(samples)-ok) * 100 / float64(len(samples))
+	if ok > 0 {
+		m.RTTMs = rttSum / float64(ok)
+	}
+	if jitterN > 0 {
+		m.JitterMs = jitterSum / float64(jitterN)
+	}
+	return m
+}
+
+// nextWANState applies the thresholds with hysteresis. m carries the streak
+// counters from the previous interval and is updated in place.
+func nextWANState(state string, m *WANMetrics, t WANThresholds) string {
+	t = t.withDefaults()
+
+	bad := m.LossPct >= t.DownLossPct ||
+		(t.DownRTTMs > 0 && m.RTTMs >= t.DownRTTMs) ||
+		(t.DownJitterMs > 0 && m.JitterMs >= t.DownJitterMs)
+	good := m.LossPct <= t.UpLossPct &&
+		(t.UpRTTMs == 0 || m.RTTMs <= t.UpRTTMs) &&
+		(t.UpJitterMs == 0 || m.JitterMs <= t.UpJitterMs)
+
+	switch {
+	case bad:
+		m.BadStreak++
+		m.GoodStreak = 0
+	case good:
+		m.GoodStreak++
+		m.BadStreak = 0
+	default:
+		// Between the up and down thresholds: hold the current state
+		m.GoodStreak, m.BadStreak = 0, 0
+	}
+
+	// The first decision after startup doesn't wait for a streak
+	if state != "online" && state != "offline" {
+		if bad {
+			return "offline"
+		}
+		if good {
+			return "online"
+		}
+		return state
+	}
+	if state == "online" && m.BadStreak >= t.DownCount {
+		return "offline"
+	}
+	if state == "offline" && m.GoodStreak >= t.UpCount {
+		return "online"
+	}
+	return state
+}
+
+// recordWANSamples adds an interval's samples to the rolling window and returns
+// the new state of the interface
+func recordWANSamples(iface WANInterface, samples []probeSample) (string, WANMetrics) {
+	wanHealthLock.Lock()
+	defer wanHealthLock.Unlock()
+
+	h, ok := wanHealth[iface.Interface]
+	if !ok {
+		h = &wanHealthState{}
+		wanHealth[iface.Interface] = h
+	}
+
+	h.samples = append(h.samples, samples...)
+	if len(h.samples) > wanSampleWindow {
+		h.samples = h.samples[len(h.samples)-wanSampleWindow:]
+	}
+
+	m := computeWANMetrics(h.samples)
+	m.GoodStreak, m.BadStreak = h.metrics.GoodStreak, h.metrics.BadStreak
+	m.LastCheck = time.Now()
+	state := nextWANState(iface.State, &m, iface.Thresholds)
+	h.metrics = m
+
+	h.history = append(h.history, WANHealthPoint{
+		Timestamp: m.LastCheck.Unix(),
+		RTTMs:     m.RTTMs,
+		JitterMs:  m.JitterMs,
+		LossPct:   m.LossPct,
+		State:     state,
+	})
+	if len(h.history) > maxWANHistoryPoints {
+		h.history = h.history[len(h.history)-maxWANHistoryPoints:]
+	}
+
+	return state, m
+}
+
+// getWANMetrics returns the current metrics of an interface, if probed yet
+func getWANMetrics(ifaceName string) *WANMetrics {
+	wanHealthLock.RLock()
+	defer wanHealthLock.RUnlock()
+
+	h, ok := wanHealth[ifaceName]
+	if !ok {
+		return nil
+	}
+	m := h.metrics
+	return &m
+}
+
+func getWANHistory(w http.ResponseWriter, r *http.Request) {
+	ifaceName := r.URL.Query().Get("interface")
+
+	wanHealthLock.RLock()
+	points := []WANHealthPoint{}
+	if h, ok := wanHealth[ifaceName]; ok {
+		points = append(points, h.history...)
+	}
+	wanHealthLock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, points)
+}
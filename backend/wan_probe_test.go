@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPingSamples(t *testing.T) {
+	output := `PING 8.8.8.8 (8.8.8.8) from 192.0.2.10 eth0: 56(84) bytes of data.
+64 bytes from 8.8.8.8: icmp_seq=1 ttl=117 time=12.4 ms
+64 bytes from 8.8.8.8: icmp_seq=3 ttl=117 time=14.6 ms
+
+--- 8.8.8.8 ping statistics ---
+3 packets transmitted, 2 received, 33.3333% packet loss, time 402ms
+rtt min/avg/max/mdev = 12.400/13.500/14.600/1.100 ms
+`
+	samples := pingSamples("8.8.8.8", output, 3)
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %d", len(samples))
+	}
+	if !samples[0].OK || samples[0].RTT != 12400*time.Microsecond || samples[2].OK {
+		t.Errorf("Unexpected samples: %+v", samples)
+	}
+}
+
+func TestComputeWANMetrics(t *testing.T) {
+	ms := time.Millisecond
+	samples := []probeSample{
+		{Target: "a", RTT: 10 * ms, OK: true},
+		{Target: "b", RTT: 100 * ms, OK: true},
+		{Target: "a", RTT: 14 * ms, OK: true},
+		{Target: "b", RTT: 100 * ms, OK: true},
+		{Target: "a", OK: false},
+	}
+
+	m := computeWANMetrics(samples)
+	if m.LossPct != 20 {
+		t.Errorf("Expected 20%% loss, got %v", m.LossPct)
+	}
+	if math.Abs(m.RTTMs-56) > 0.001 {
+		t.Errorf("Expected 56ms mean RTT, got %v", m.RTTMs)
+	}
+	// Jitter is per target: |14-10| and |100-100|, not across targets
+	if math.Abs(m.JitterMs-2) > 0.001 {
+		t.Errorf("Expected 2ms jitter, got %v", m.JitterMs)
+	}
+}
+
+func TestNextWANStateHysteresis(t *testing.T) {
+	th := WANThresholds{DownLossPct: 50, UpLossPct: 10, DownRTTMs: 300, UpRTTMs: 150, DownCount: 2, UpCount: 3}
+
+	steps := []struct {
+		loss, rtt float64
+		expected  string
+	}{
+		{0, 20, "online"},   // First result decides immediately
+		{60, 20, "online"},  // One bad interval is not enough
+		{0, 20, "online"},   // Streak reset
+		{60, 20, "online"},  //
+		{0, 400, "offline"}, // Second consecutive bad interval (latency)
+		{0, 20, "offline"},  //
+		{30, 20, "offline"}, // Between thresholds: hold and reset streaks
+		{0, 20, "offline"},  //
+		{0, 20, "offline"},  //
+		{0, 20, "online"},   // Three consecutive good intervals
+	}
+
+	state := "unknown"
+	var m WANMetrics
+	for i, s := range steps {
+		m.LossPct, m.RTTMs = s.loss, s.rtt
+		state = nextWANState(state, &m, th)
+		if state != s.expected {
+			t.Fatalf("Step %d: expected %s, got %s", i, s.expected, state)
+		}
+	}
+}
+
+func TestValidateWANProbe(t *testing.T) {
+	tests := []struct {
+		probe WANProbe
+		valid bool
+	}{
+		{WANProbe{Type: "icmp", Target: "1.1.1.1"}, true},
+		{WANProbe{Type: "tcp", Target: "1.1.1.1:443"}, true},
+		{WANProbe{Type: "tcp", Target: "1.1.1.1"}, false},
+		{WANProbe{Type: "http", Target: "https://connectivity.example.com/generate_204"}, true},
+		{WANProbe{Type: "http", Target: "ftp://example.com"}, false},
+		{WANProbe{Type: "dns", Target: "9.9.9.9", Query: "example.org"}, true},
+		{WANProbe{Type: "dns", Target: "dns.example.com"}, false},
+		{WANProbe{Type: "udp", Target: "1.1.1.1"}, false},
+		{WANProbe{Type: "icmp", Target: "-f 1.1.1.1"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := validateWANProbe(tt.probe); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", tt.probe, tt.valid, err)
+		}
+	}
+
+	if err := validateWANThresholds(WANThresholds{UpLossPct: 60}); err == nil {
+		t.Error("Expected error when up loss threshold is looser than the default down threshold")
+	}
+}
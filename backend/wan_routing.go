@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Each WAN gets a route ID that selects its routing table (wanTableBase+ID) and
+// its fwmark (ID<<8 under wanMarkMask). Connections remember their WAN in the
+// conntrack mark, so they stay on it across load balancing or failover changes
+// and replies to inbound connections leave via the WAN they arrived on.
+const (
+	wanMarkMask      = "0x0000ff00"
+	wanTableBase     = 200
+	maxWANRouteID    = 255
+	wanRulePrioMin   = 4990          // lookup main suppress_prefixlength 0
+	wanRulePrioMark  = 5000          // + ID: fwmark -> WAN table
+	wanRulePrioFrom  = 5300          // + ID: WAN address -> WAN table
+	wanRulePrioMax   = 5999          // Includes the steering rules (wanRulePrioSteering)
+	wanMarkChainPrio = "mangle - 10" // Before vpn_policy_mark, which sets its own bits
+)
+
+var (
+	wanRoutingLock    sync.Mutex
+	lastWANRoutingSig string
+	lastWANTables     = make(map[int]bool)
+)
+
+func wanTable(routeID int) int {
+	return wanTableBase + routeID
+}
+
+func wanMark(routeID int) string {
+	return fmt.Sprintf("0x%08x", routeID<<8)
+}
+
+// assignWANRouteIDs keeps valid unique route IDs and gives the lowest free ID
+// to interfaces without one
+func assignWANRouteIDs(interfaces []WANInterface) {
+	used := make(map[int]bool)
+	for i := range interfaces {
+		id := interfaces[i].RouteID
+		if id < 1 || id > maxWANRouteID || used[id] {
+			interfaces[i].RouteID = 0
+			continue
+		}
+		used[id] = true
+	}
+
+	next := 1
+	for i := range interfaces {
+		if interfaces[i].RouteID != 0 {
+			continue
+		}
+		for next <= maxWANRouteID && used[next] {
+			next++
+		}
+		if next > maxWANRouteID {
+			return
+		}
+		interfaces[i].RouteID = next
+		used[next] = true
+	}
+}
+
+// routedWANs returns the enabled WANs that have a routing table
+func routedWANs(interfaces []WANInterface) []WANInterface {
+	var wans []WANInterface
+	for _, iface := range interfaces {
+		if iface.Enabled && iface.RouteID > 0 && iface.Interface != "" {
+			wans = append(wans, iface)
+		}
+	}
+	return wans
+}
+
+// writeWANMarkChains renders the connmark stickiness chains
+func writeWANMarkChains(b *strings.Builder, interfaces []WANInterface) {
+	wans := routedWANs(interfaces)
+	if len(wans) == 0 {
+		return
+	}
+
+	// Restore the connection's WAN onto forwarded packets; new inbound
+	// connections remember the WAN they arrived on
+	b.WriteString("  chain wan_mark_prerouting {\n")
+	b.WriteString(fmt.Sprintf("    type filter hook prerouting priority %s; policy accept;\n", wanMarkChainPrio))
+	b.WriteString(fmt.Sprintf("    ct mark and %s != 0 meta mark set ct mark and %s comment \"WAN stickiness\"\n", wanMarkMask, wanMarkMask))
+	for _, wan := range wans {
+		b.WriteString(fmt.Sprintf("    ct state new iifname \"%s\" ct mark set ct mark | %s comment \"WAN %s inbound\"\n",
+			wan.Interface, wanMark(wan.RouteID), wan.Interface))
+	}
+	b.WriteString("  }\n\n")
+
+	// Locally generated packets of a pinned connection (reroute on mark change)
+	b.WriteString("  chain wan_mark_output {\n")
+	b.WriteString(fmt.Sprintf("    type route hook output priority %s; policy accept;\n", wanMarkChainPrio))
+	b.WriteString(fmt.Sprintf("    meta mark 0 ct mark and %s != 0 meta mark set ct mark and %s comment \"WAN stickiness\"\n", wanMarkMask, wanMarkMask))
+	b.WriteString("  }\n\n")
+
+	// Outbound connections are pinned to the WAN the routing decision picked
+	b.WriteString("  chain wan_mark_postrouting {\n")
+	b.WriteString("    type filter hook postrouting priority mangle; policy accept;\n")
+	for _, wan := range wans {
+		b.WriteString(fmt.Sprintf("    ct mark and %s == 0 oifname \"%s\" ct mark set ct mark | %s comment \"WAN %s outbound\"\n",
+			wanMarkMask, wan.Interface, wanMark(wan.RouteID), wan.Interface))
+	}
+	b.WriteString("  }\n\n")
+}
+
+// wanIPRules builds the policy routing rules for the given WANs. addrs maps an
+// interface to its IPv4 addresses.
+func wanIPRules(wans []WANInterface, addrs map[string][]string) []IPRule {
+	if len(wans) == 0 {
+		return nil
+	}
+
+	// Connected and static routes in main still win over the per-WAN default routes
+	rules := []IPRule{{Priority: wanRulePrioMin, Table: "main", Suppress: true}}
+	for _, wan := range wans {
+		table := strconv.Itoa(wanTable(wan.RouteID))
+		rules = append(rules, IPRule{Priority: wanRulePrioMark + wan.RouteID, FwMark: wanMark(wan.RouteID) + "/" + wanMarkMask, Table: table})
+		for _, addr := range addrs[wan.Interface] {
+			rules = append(rules, IPRule{Priority: wanRulePrioFrom + wan.RouteID, From: addr, Table: table})
+		}
+	}
+	return rules
+}
+
+// interfaceIPv4Addrs returns the IPv4 addresses assigned to an interface
+func interfaceIPv4Addrs(name string) []string {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			out = append(out, ipnet.IP.String())
+		}
+	}
+	return out
+}
+
+// syncWANPolicyRouting installs each WAN's default route in its own table and
+// reconciles the WAN and steering ip rules. Nothing is run when the inputs are
+// unchanged, so steering failover happens here as WAN states change.
+func syncWANPolicyRouting(interfaces []WANInterface) {
+	wans := routedWANs(interfaces)
+	addrs := make(map[string][]string)
+	for _, wan := range wans {
+		addrs[wan.Interface] = interfaceIPv4Addrs(wan.Interface)
+	}
+	rules := wanIPRules(wans, addrs)
+	if len(rules) > 0 {
+		rules = append(rules, steeringIPRules(GetWANSteeringRules(), interfaces)...)
+	}
+
+	var sig []string
+	for _, wan := range wans {
+		sig = append(sig, fmt.Sprintf("%s/%d/%s", wan.Interface, wan.RouteID, wan.Gateway))
+	}
+	for _, r := range rules {
+		sig = append(sig, r.String())
+	}
+	sort.Strings(sig)
+	signature := strings.Join(sig, ";")
+
+	wanRoutingLock.Lock()
+	defer wanRoutingLock.Unlock()
+	if signature == lastWANRoutingSig {
+		return
+	}
+
+	tables := make(map[int]bool)
+	for _, wan := range wans {
+		table := wanTable(wan.RouteID)
+		tables[table] = true
+		routeArgs, err := wanRouteArgs(wan)
+		if err != nil {
+			continue
+		}
+		args := append([]string{"route", "replace", "default"}, routeArgs...)
+		if out, err := runPrivilegedCombinedOutput("ip", append(args, "table", strconv.Itoa(table))...); err != nil {
+			fmt.Printf("Failed to set default route of %s in table %d: %v (%s)\n", wan.Interface, table, err, string(out))
+		}
+	}
+	for table := range lastWANTables {
+		if !tables[table] {
+			runPrivileged("ip", "route", "flush", "table", strconv.Itoa(table)) //nolint:errcheck
+		}
+	}
+	lastWANTables = tables
+
+	if err := syncIPRules("-4", rules, wanRulePrioMin, wanRulePrioMax); err != nil {
+		fmt.Printf("Failed to sync WAN ip rules: %v\n", err)
+		return // Retry on the next check
+	}
+	lastWANRoutingSig = signature
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssignWANRouteIDs(t *testing.T) {
+	ifaces := []WANInterface{
+		{Interface: "eth0", RouteID: 2},
+		{Interface: "eth1"},
+		{Interface: "eth2", RouteID: 2}, // Duplicate
+		{Interface: "ppp0", RouteID: 300},
+	}
+	assignWANRouteIDs(ifaces)
+
+	expected := []int{2, 1, 3, 4}
+	for i, id := range expected {
+		if ifaces[i].RouteID != id {
+			t.Errorf("%s: expected route ID %d, got %d", ifaces[i].Interface, id, ifaces[i].RouteID)
+		}
+	}
+}
+
+func TestGenerateFullRulesetWANMarks(t *testing.T) {
+	in := testRulesetInputs()
+	in.WANs = []WANInterface{
+		{Interface: "eth0", RouteID: 1, Enabled: true},
+		{Interface: "eth5", RouteID: 2, Enabled: true},
+		{Interface: "eth6", RouteID: 3, Enabled: false},
+	}
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	expectedSubstrings := []string{
+		"type filter hook prerouting priority mangle - 10; policy accept;",
+		"ct mark and 0x0000ff00 != 0 meta mark set ct mark and 0x0000ff00 comment \"WAN stickiness\"",
+		"ct state new iifname \"eth5\" ct mark set ct mark | 0x00000200 comment \"WAN eth5 inbound\"",
+		"type route hook output priority mangle - 10; policy accept;",
+		"ct mark and 0x0000ff00 == 0 oifname \"eth0\" ct mark set ct mark | 0x00000100 comment \"WAN eth0 outbound\"",
+	}
+	for _, s := range expectedSubstrings {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain '%s'.\nGenerated Ruleset:\n%s", s, ruleset)
+		}
+	}
+	if strings.Contains(ruleset, "eth6") {
+		t.Error("Disabled WANs must not be pinned")
+	}
+
+	rules := wanIPRules(routedWANs(in.WANs), map[string][]string{"eth0": {"192.0.2.10"}})
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.String())
+	}
+	expected := "priority 4990 lookup main suppress_prefixlength 0;" +
+		"priority 5001 fwmark 0x00000100/0x0000ff00 lookup 201;" +
+		"priority 5301 from 192.0.2.10 lookup 201;" +
+		"priority 5002 fwmark 0x00000200/0x0000ff00 lookup 202"
+	if strings.Join(got, ";") != expected {
+		t.Errorf("Unexpected ip rules:\n%s", strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// WANSteeringRule sends matching new connections to the first online WAN of an
+// ordered preference list. Matching traffic is marked in the wan_steering
+// chain and an ip rule per rule points the mark at the chosen WAN's table;
+// failover only swaps the ip rule. Once a connection is pinned to a WAN
+// (connmark), it is no longer steered.
+type WANSteeringRule struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	SourceCIDR string   `json:"source_cidr"` // IPv4 address/CIDR or "@set" (client group)
+	SourceMAC  string   `json:"source_mac"`  //
+	DestCIDR   string   `json:"dest_cidr"`   // IPv4 address/CIDR or "@set"
+	Protocol   string   `json:"protocol"`    // tcp, udp, tcp_udp or "" (any)
+	DestPorts  string   `json:"dest_ports"`  // e.g. "5060-5061" or "@set"
+	Domains    []string `json:"domains"`     // Destinations learned from DNS answers (dnsmasq nftset)
+	WANs       []string `json:"wans"`        // WAN interfaces in order of preference
+	Enabled    bool     `json:"enabled"`
+}
+
+// WANSteeringStore keeps rules in evaluation order (first match wins)
+type WANSteeringStore struct {
+	Rules []WANSteeringRule `json:"rules"`
+}
+
+const (
+	steeringMarkMask    = "0x00ff0000"
+	maxSteeringRules    = 255
+	wanRulePrioSteering = 5600 // + rule number; after the sticky WAN fwmark rules
+	steeringChainPrio   = "mangle - 5"
+	dnsmasqSteeringPath = "/etc/dnsmasq.d/softrouter-steering.conf"
+)
+
+var (
+	steeringStore      = WANSteeringStore{Rules: []WANSteeringRule{}}
+	steeringLock       sync.RWMutex
+	steeringConfigPath = "/etc/softrouter/wan_steering.json"
+
+	macAddrRegex = regexp.MustCompile(`^([0-9a-fA-F]{2}:){5}[0-9a-fA-F]{2}$`)
+)
+
+func initWANSteering() {
+	loadWANSteering()
+	writeSteeringDnsmasqConfig(GetWANSteeringRules())
+}
+
+func loadWANSteering() {
+	steeringLock.Lock()
+	defer steeringLock.Unlock()
+
+	data, err := os.ReadFile(steeringConfigPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error loading WAN steering rules: %v\n", err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &steeringStore); err != nil {
+		fmt.Printf("Error parsing WAN steering rules: %v\n", err)
+		steeringStore.Rules = []WANSteeringRule{}
+	}
+}
+
+func saveWANSteering() error {
+	steeringLock.RLock()
+	data, err := json.MarshalIndent(steeringStore, "", "  ")
+	steeringLock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(steeringConfigPath, data, 0644)
+}
+
+// GetWANSteeringRules returns a copy of the ordered rule list
+func GetWANSteeringRules() []WANSteeringRule {
+	steeringLock.RLock()
+	defer steeringLock.RUnlock()
+	rules := make([]WANSteeringRule, len(steeringStore.Rules))
+	copy(rules, steeringStore.Rules)
+	return rules
+}
+
+// steeringMark is the fwmark of the n-th enabled rule (1-based)
+func steeringMark(n int) string {
+	return fmt.Sprintf("0x%08x", n<<16)
+}
+
+func validateSteeringAddr(value, what string, sets []FirewallSet) error {
+	if isSetRef(value) {
+		return validateSetReference(sets, value, setTypeIPv4)
+	}
+	if cidrFamily(value) != "ip" {
+		return fmt.Errorf("%s must be an IPv4 address/CIDR or an ipv4_addr set", what)
+	}
+	return nil
+}
+
+func validateWANSteeringRule(rule WANSteeringRule, wans []WANInterface, sets []FirewallSet) error {
+	if rule.Name == "" || len(rule.Name) > 64 || strings.ContainsAny(rule.Name, "\"\\\n\r") {
+		return fmt.Errorf("name is required (max 64 characters, no quotes or backslashes)")
+	}
+
+	m := trafficMatch{SourceMAC: rule.SourceMAC, SourceCIDR: rule.SourceCIDR, DestCIDR: rule.DestCIDR,
+		Protocol: rule.Protocol, DestPorts: rule.DestPorts}
+	if err := validateTrafficMatch(m, rule.Domains, sets); err != nil {
+		return err
+	}
+
+	if len(rule.WANs) == 0 {
+		return fmt.Errorf("at least one WAN is required")
+	}
+	known := make(map[string]bool)
+	for _, w := range wans {
+		known[w.Interface] = true
+	}
+	seen := make(map[string]bool)
+	for _, w := range rule.WANs {
+		if !known[w] {
+			return fmt.Errorf("unknown WAN interface '%s'", w)
+		}
+		if seen[w] {
+			return fmt.Errorf("WAN '%s' listed twice", w)
+		}
+		seen[w] = true
+	}
+	return nil
+}
+
+// enabledSteeringRules returns the rules that get a mark, numbered from 1
+func enabledSteeringRules(rules []WANSteeringRule) []WANSteeringRule {
+	var enabled []WANSteeringRule
+	for _, rule := range rules {
+		if rule.Enabled && len(enabled) < maxSteeringRules {
+			enabled = append(enabled, rule)
+		}
+	}
+	return enabled
+}
+
+func steeringSetName(n int) string {
+	return fmt.Sprintf("steer_%d", n)
+}
+
+// trafficMatch describes the IPv4 traffic selected by a steering rule or VPN
+// policy. DomainSet names the nft set dnsmasq fills with resolved domains.
+type trafficMatch struct {
+	SourceMAC  string
+	SourceCIDR string
+	DestCIDR   string
+	DomainSet  string
+	Protocol   string
+	DestPorts  string
+}
+
+// validateTrafficMatch checks the match fields shared by steering rules and VPN policies
+func validateTrafficMatch(m trafficMatch, domains []string, sets []FirewallSet) error {
+	if m.SourceCIDR != "" {
+		if err := validateSteeringAddr(m.SourceCIDR, "source", sets); err != nil {
+			return err
+		}
+	}
+	if m.SourceMAC != "" && !macAddrRegex.MatchString(m.SourceMAC) {
+		return fmt.Errorf("invalid source MAC '%s'", m.SourceMAC)
+	}
+	if m.DestCIDR != "" {
+		if len(domains) > 0 {
+			return fmt.Errorf("use either a destination address or domains, not both")
+		}
+		if err := validateSteeringAddr(m.DestCIDR, "destination", sets); err != nil {
+			return err
+		}
+	}
+
+	switch m.Protocol {
+	case "tcp", "udp", "tcp_udp":
+		if m.DestPorts != "" {
+			if isSetRef(m.DestPorts) {
+				if err := validateSetReference(sets, m.DestPorts, setTypeService); err != nil {
+					return err
+				}
+			} else if err := validatePortSpec(m.DestPorts); err != nil {
+				return err
+			}
+		}
+	case "":
+		if m.DestPorts != "" {
+			return fmt.Errorf("ports require protocol tcp, udp or tcp_udp")
+		}
+	default:
+		return fmt.Errorf("unsupported protocol '%s'", m.Protocol)
+	}
+
+	if len(domains) > 64 {
+		return fmt.Errorf("at most 64 domains per rule")
+	}
+	for _, d := range domains {
+		if len(d) > 253 || !dnsDomainRegex.MatchString(d) {
+			return fmt.Errorf("invalid domain '%s'", d)
+		}
+	}
+	return nil
+}
+
+// nftParts compiles the match into nft expression tokens
+func (m trafficMatch) nftParts() []string {
+	var parts []string
+
+	if m.SourceMAC != "" {
+		parts = append(parts, "ether", "saddr", strings.ToLower(m.SourceMAC))
+	}
+	if m.SourceCIDR != "" {
+		parts = append(parts, "ip", "saddr", m.SourceCIDR)
+	}
+	if m.DestCIDR != "" {
+		parts = append(parts, "ip", "daddr", m.DestCIDR)
+	}
+	if m.DomainSet != "" {
+		parts = append(parts, "ip", "daddr", "@"+m.DomainSet)
+	}
+
+	switch m.Protocol {
+	case "tcp", "udp":
+		if m.DestPorts != "" {
+			parts = append(parts, m.Protocol, "dport", nftPortSet(m.DestPorts))
+		} else {
+			parts = append(parts, "meta", "l4proto", m.Protocol)
+		}
+	case "tcp_udp":
+		parts = append(parts, "meta", "l4proto", "{ tcp, udp }")
+		if m.DestPorts != "" {
+			parts = append(parts, "th", "dport", nftPortSet(m.DestPorts))
+		}
+	}
+	return parts
+}
+
+// renderWANSteeringRule compiles the match part of a rule and its mark
+func renderWANSteeringRule(rule WANSteeringRule, n int) string {
+	m := trafficMatch{SourceMAC: rule.SourceMAC, SourceCIDR: rule.SourceCIDR, DestCIDR: rule.DestCIDR,
+		Protocol: rule.Protocol, DestPorts: rule.DestPorts}
+	if len(rule.Domains) > 0 {
+		m.DomainSet = steeringSetName(n)
+	}
+	parts := m.nftParts()
+
+	parts = append(parts, "meta", "mark", "set", "meta", "mark", "|", steeringMark(n), "return",
+		"comment", fmt.Sprintf("\"WAN steering: %s\"", rule.Name))
+	return strings.Join(parts, " ")
+}
+
+// writeWANSteering renders the per-rule domain sets and the wan_steering chain.
+// Domain sets are filled by dnsmasq as clients resolve the names; they start
+// empty after each ruleset reload until the next lookup.
+func writeWANSteering(b *strings.Builder, rules []WANSteeringRule) {
+	enabled := enabledSteeringRules(rules)
+	if len(enabled) == 0 {
+		return
+	}
+
+	for i, rule := range enabled {
+		if len(rule.Domains) == 0 {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("  set %s {\n", steeringSetName(i+1)))
+		b.WriteString("    type ipv4_addr\n")
+		b.WriteString("    flags timeout\n")
+		b.WriteString("    timeout 1h\n")
+		b.WriteString("  }\n\n")
+	}
+
+	b.WriteString("  chain wan_steering {\n")
+	b.WriteString(fmt.Sprintf("    type filter hook prerouting priority %s; policy accept;\n", steeringChainPrio))
+	b.WriteString(fmt.Sprintf("    meta mark and %s != 0 return comment \"Pinned to a WAN\"\n", wanMarkMask))
+	for i, rule := range enabled {
+		b.WriteString("    " + renderWANSteeringRule(rule, i+1) + "\n")
+	}
+	b.WriteString("  }\n\n")
+}
+
+// steeringIPRules points each rule's mark at the table of its first online WAN.
+// Rules without an online WAN get no ip rule and follow the main routing table.
+func steeringIPRules(rules []WANSteeringRule, wans []WANInterface) []IPRule {
+	byName := make(map[string]WANInterface)
+	for _, w := range routedWANs(wans) {
+		byName[w.Interface] = w
+	}
+
+	var ipRules []IPRule
+	for i, rule := range enabledSteeringRules(rules) {
+		for _, name := range rule.WANs {
+			wan, ok := byName[name]
+			if !ok || wan.State != "online" {
+				continue
+			}
+			ipRules = append(ipRules, IPRule{
+				Priority: wanRulePrioSteering + i + 1,
+				FwMark:   steeringMark(i+1) + "/" + steeringMarkMask,
+				Table:    fmt.Sprintf("%d", wanTable(wan.RouteID)),
+			})
+			break
+		}
+	}
+	return ipRules
+}
+
+// renderSteeringDnsmasqConfig maps rule domains to their nft sets
+func renderSteeringDnsmasqConfig(rules []WANSteeringRule) string {
+	var b strings.Builder
+	b.WriteString("# Auto-generated by SoftRouter - DO NOT EDIT MANUALLY\n")
+	b.WriteString("# Edit via Web UI: Multi-WAN > Steering\n\n")
+	for i, rule := range enabledSteeringRules(rules) {
+		if len(rule.Domains) == 0 {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("# %s\n", rule.Name))
+		b.WriteString(fmt.Sprintf("nftset=/%s/4#inet#softrouter#%s\n", strings.Join(rule.Domains, "/"), steeringSetName(i+1)))
+	}
+	return b.String()
+}
+
+// writeSteeringDnsmasqConfig updates the dnsmasq nftset file, restarting dnsmasq only on change
+func writeSteeringDnsmasqConfig(rules []WANSteeringRule) {
+	writeDnsmasqNftsetConfig(dnsmasqSteeringPath, renderSteeringDnsmasqConfig(rules), len(enabledSteeringRules(rules)) > 0)
+}
+
+// writeDnsmasqNftsetConfig writes a dnsmasq drop-in, restarting dnsmasq only
+// on change. A missing file is only created when needed.
+func writeDnsmasqNftsetConfig(path, config string, needed bool) {
+	content := []byte(config)
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, content) {
+		return
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) && !needed {
+		return // Nothing to configure
+	}
+
+	if err := os.MkdirAll("/etc/dnsmasq.d", 0755); err != nil {
+		fmt.Printf("WARNING: Failed to create /etc/dnsmasq.d: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		fmt.Printf("WARNING: Failed to write %s: %v\n", path, err)
+		return
+	}
+	if err := runPrivileged("systemctl", "restart", "dnsmasq"); err != nil {
+		fmt.Printf("WARNING: Failed to restart dnsmasq: %v\n", err)
+	}
+}
+
+// --- Handlers ---
+
+func listWANSteeringRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, GetWANSteeringRules())
+}
+
+// applyWANSteeringChange persists the rules, regenerates the ruleset, dnsmasq
+// sets and ip rules, and audits the change
+func applyWANSteeringChange(w http.ResponseWriter, r *http.Request, action, resource, details string) bool {
+	if err := saveWANSteering(); err != nil {
+		logAuditEvent(getUsernameFromToken(r), action, resource,
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save WAN steering rules", err)
+		return false
+	}
+
+	if err := firewallManager.ApplyFirewallRules(); err != nil {
+		logAuditEvent(getUsernameFromToken(r), action, resource,
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondFirewallError(w, ErrFirewallAddFailed, "Rule saved but the firewall could not be applied", err)
+		return false
+	}
+
+	rules := GetWANSteeringRules()
+	writeSteeringDnsmasqConfig(rules)
+	syncWANPolicyRouting(GetWANStore().Interfaces)
+
+	logAuditEvent(getUsernameFromToken(r), action, resource, details, getClientIP(r), true)
+	return true
+}
+
+func decodeWANSteeringRule(w http.ResponseWriter, r *http.Request) (WANSteeringRule, bool) {
+	var rule WANSteeringRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return rule, false
+	}
+	if err := validateWANSteeringRule(rule, GetWANStore().Interfaces, GetFirewallSets()); err != nil {
+		respondWithError(w, ErrFirewallInvalidRule, err.Error(), http.StatusBadRequest, nil)
+		return rule, false
+	}
+	return rule, true
+}
+
+func createWANSteeringRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodeWANSteeringRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = uuid.New().String()
+
+	steeringLock.Lock()
+	if len(steeringStore.Rules) >= maxSteeringRules {
+		steeringLock.Unlock()
+		respondWithError(w, ErrFirewallInvalidRule, fmt.Sprintf("At most %d steering rules are supported", maxSteeringRules), http.StatusBadRequest, nil)
+		return
+	}
+	steeringStore.Rules = append(steeringStore.Rules, rule)
+	steeringLock.Unlock()
+
+	ruleJSON, _ := json.Marshal(rule)
+	if !applyWANSteeringChange(w, r, "wan.steering.create", rule.ID, string(ruleJSON)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, rule)
+}
+
+func updateWANSteeringRule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		respondInvalidRequest(w, "ID required")
+		return
+	}
+
+	rule, ok := decodeWANSteeringRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = id
+
+	steeringLock.Lock()
+	found := false
+	for i := range steeringStore.Rules {
+		if steeringStore.Rules[i].ID == id {
+			steeringStore.Rules[i] = rule
+			found = true
+			break
+		}
+	}
+	steeringLock.Unlock()
+
+	if !found {
+		respondWithError(w, ErrGenericNotFound, "Rule not found", http.StatusNotFound, nil)
+		return
+	}
+
+	ruleJSON, _ := json.Marshal(rule)
+	if !applyWANSteeringChange(w, r, "wan.steering.update", id, string(ruleJSON)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, rule)
+}
+
+func deleteWANSteeringRule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		respondInvalidRequest(w, "ID required")
+		return
+	}
+
+	steeringLock.Lock()
+	newRules := []WANSteeringRule{}
+	found := false
+	for _, rule := range steeringStore.Rules {
+		if rule.ID == id {
+			found = true
+			continue
+		}
+		newRules = append(newRules, rule)
+	}
+	steeringStore.Rules = newRules
+	steeringLock.Unlock()
+
+	if !found {
+		respondWithError(w, ErrGenericNotFound, "Rule not found", http.StatusNotFound, nil)
+		return
+	}
+
+	if !applyWANSteeringChange(w, r, "wan.steering.delete", id, fmt.Sprintf("{\"id\":\"%s\"}", id)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "deleted"})
+}
+
+// reorderWANSteeringRules takes the complete list of rule IDs in the new evaluation order
+func reorderWANSteeringRules(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	steeringLock.Lock()
+	byID := make(map[string]WANSteeringRule, len(steeringStore.Rules))
+	for _, rule := range steeringStore.Rules {
+		byID[rule.ID] = rule
+	}
+
+	if len(req.IDs) != len(byID) {
+		steeringLock.Unlock()
+		respondInvalidRequest(w, "Reorder must list every rule exactly once")
+		return
+	}
+
+	reordered := make([]WANSteeringRule, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		rule, ok := byID[id]
+		if !ok {
+			steeringLock.Unlock()
+			respondInvalidRequest(w, "Reorder must list every rule exactly once")
+			return
+		}
+		delete(byID, id)
+		reordered = append(reordered, rule)
+	}
+	steeringStore.Rules = reordered
+	steeringLock.Unlock()
+
+	idsJSON, _ := json.Marshal(req.IDs)
+	if !applyWANSteeringChange(w, r, "wan.steering.reorder", "rules", string(idsJSON)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, GetWANSteeringRules())
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateFullRulesetWANSteering(t *testing.T) {
+	in := testRulesetInputs()
+	in.WANs = []WANInterface{
+		{Interface: "eth0", RouteID: 1, Enabled: true},
+		{Interface: "eth5", RouteID: 2, Enabled: true},
+	}
+	in.Steering = []WANSteeringRule{
+		{Name: "VoIP phone", SourceMAC: "AA:BB:CC:DD:EE:01", Protocol: "udp", DestPorts: "5060-5061", WANs: []string{"eth0"}, Enabled: true},
+		{Name: "disabled", SourceCIDR: "10.0.0.9", WANs: []string{"eth0"}, Enabled: false},
+		{Name: "Streaming", SourceCIDR: "192.168.1.0/24", Domains: []string{"netflix.com", "nflxvideo.net"}, WANs: []string{"eth5", "eth0"}, Enabled: true},
+	}
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	expectedSubstrings := []string{
+		"  set steer_2 {\n    type ipv4_addr\n    flags timeout\n    timeout 1h\n  }",
+		"type filter hook prerouting priority mangle - 5; policy accept;",
+		"meta mark and 0x0000ff00 != 0 return comment \"Pinned to a WAN\"",
+		"ether saddr aa:bb:cc:dd:ee:01 udp dport 5060-5061 meta mark set meta mark | 0x00010000 return comment \"WAN steering: VoIP phone\"",
+		"ip saddr 192.168.1.0/24 ip daddr @steer_2 meta mark set meta mark | 0x00020000 return comment \"WAN steering: Streaming\"",
+	}
+	for _, s := range expectedSubstrings {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain '%s'.\nGenerated Ruleset:\n%s", s, ruleset)
+		}
+	}
+	if strings.Contains(ruleset, "WAN steering: disabled") {
+		t.Error("Disabled steering rules must not be rendered")
+	}
+
+	dnsmasq := renderSteeringDnsmasqConfig(in.Steering)
+	if !strings.Contains(dnsmasq, "nftset=/netflix.com/nflxvideo.net/4#inet#softrouter#steer_2\n") {
+		t.Errorf("Unexpected dnsmasq config:\n%s", dnsmasq)
+	}
+}
+
+func TestSteeringIPRulesFailover(t *testing.T) {
+	rules := []WANSteeringRule{
+		{Name: "Streaming", WANs: []string{"eth5", "eth0"}, Enabled: true},
+		{Name: "Fiber only", WANs: []string{"eth0"}, Enabled: true},
+	}
+	wans := []WANInterface{
+		{Interface: "eth0", RouteID: 1, Enabled: true, State: "online"},
+		{Interface: "eth5", RouteID: 2, Enabled: true, State: "online"},
+	}
+
+	got := steeringIPRules(rules, wans)
+	if len(got) != 2 || got[0].String() != "priority 5601 fwmark 0x00010000/0x00ff0000 lookup 202" || got[1].Table != "201" {
+		t.Errorf("Unexpected rules with both WANs online: %v", got)
+	}
+
+	// Preferred WAN down: fail over to the next one
+	wans[1].State = "offline"
+	got = steeringIPRules(rules, wans)
+	if len(got) != 2 || got[0].Table != "201" {
+		t.Errorf("Expected failover to table 201, got %v", got)
+	}
+
+	// No preferred WAN online: no rule, main table decides
+	wans[0].State = "offline"
+	if got = steeringIPRules(rules, wans); len(got) != 0 {
+		t.Errorf("Expected no rules with all WANs offline, got %v", got)
+	}
+}
+
+func TestValidateWANSteeringRule(t *testing.T) {
+	wans := []WANInterface{{Interface: "eth0"}, {Interface: "eth5"}}
+	valid := WANSteeringRule{Name: "VoIP", SourceCIDR: "192.168.1.20", Protocol: "udp", DestPorts: "5060", WANs: []string{"eth0", "eth5"}}
+	if err := validateWANSteeringRule(valid, wans, nil); err != nil {
+		t.Errorf("Expected valid rule, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*WANSteeringRule)
+	}{
+		{"no WANs", func(r *WANSteeringRule) { r.WANs = nil }},
+		{"unknown WAN", func(r *WANSteeringRule) { r.WANs = []string{"eth9"} }},
+		{"duplicate WAN", func(r *WANSteeringRule) { r.WANs = []string{"eth0", "eth0"} }},
+		{"ipv6 source", func(r *WANSteeringRule) { r.SourceCIDR = "2001:db8::1" }},
+		{"bad MAC", func(r *WANSteeringRule) { r.SourceMAC = "aa:bb:cc" }},
+		{"ports without protocol", func(r *WANSteeringRule) { r.Protocol = "" }},
+		{"destination and domains", func(r *WANSteeringRule) { r.DestCIDR = "203.0.113.0/24"; r.Domains = []string{"example.com"} }},
+		{"bad domain", func(r *WANSteeringRule) { r.Domains = []string{"example.com/#/1.2.3.4"} }},
+		{"quote in name", func(r *WANSteeringRule) { r.Name = "a\"b" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.mutate(&rule)
+			if err := validateWANSteeringRule(rule, wans, nil); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WGInterface is a WireGuard interface managed by the router. Its wg-quick
+// config is rendered from this model and the peers assigned to it.
+type WGInterface struct {
+	Name        string `json:"name"` // wg0, wg1, ...
+	Role        string `json:"role"` // server, site, provider
+	Description string `json:"description"`
+	PrivateKey  string `json:"private_key,omitempty"` // Never returned by the API
+	PublicKey   string `json:"public_key"`
+	Address     string `json:"address"`               // Interface address with prefix, e.g. 10.8.0.1/24
+	ListenPort  int    `json:"listen_port,omitempty"` // 0 = random source port (provider)
+	MTU         int    `json:"mtu,omitempty"`         // 0 = wg-quick default
+	Zone        string `json:"zone"`                  // Firewall zone the interface joins
+	Endpoint    string `json:"endpoint,omitempty"`    // Public hostname (DDNS) or IP written to client configs, default: the host the UI is reached on
+	Enabled     bool   `json:"enabled"`
+
+	Client WGClientTemplate `json:"client"` // Defaults for the configs handed to this interface's peers
+}
+
+// WGClientTemplate shapes the client configs of a server interface. Peers may
+// override DNS, AllowedIPs and keepalive individually.
+type WGClientTemplate struct {
+	DNS          string   `json:"dns,omitempty"`           // Default: the interface address (the router's resolver)
+	Tunnel       string   `json:"tunnel,omitempty"`        // full (default) or split
+	SplitRoutes  []string `json:"split_routes,omitempty"`  // Split tunnel: subnets reached through the tunnel besides the VPN subnet
+	EndpointPort int      `json:"endpoint_port,omitempty"` // When a port forward in front of the router differs from ListenPort
+	Keepalive    int      `json:"keepalive,omitempty"`     // Default 25
+	MTU          int      `json:"mtu,omitempty"`
+}
+
+// WGInterfaceStore holds the WireGuard interfaces
+type WGInterfaceStore struct {
+	Interfaces []WGInterface `json:"interfaces"`
+}
+
+const (
+	wgRoleServer   = "server"   // Road warriors dial in, peers get pool addresses
+	wgRoleSite     = "site"     // Site-to-site, peers route the subnets behind them
+	wgRoleProvider = "provider" // Outbound to a VPN provider, used by policy routing only
+
+	defaultWGInterface = "wg0"
+	wgRouteSource      = "wireguard"
+	minWGMTU           = 1280
+)
+
+var (
+	wgInterfaceStore      WGInterfaceStore
+	wgLock                sync.Mutex // Guards the interface and peer stores and the rendered configs
+	wgInterfaceConfigPath = "/etc/softrouter/wireguard_interfaces.json"
+	wgConfigDir           = "/etc/wireguard"
+	wgKeyDir              = "/etc/softrouter"
+
+	wgInterfaceNameRegex = regexp.MustCompile(`^wg[0-9]{1,3}$`)
+	wgRoles              = map[string]bool{wgRoleServer: true, wgRoleSite: true, wgRoleProvider: true}
+)
+
+// wgServerKeys returns the key pair of the original wg0 server, generating it
+// on first use
+func wgServerKeys() (string, string, error) {
+	privPath := filepath.Join(wgKeyDir, "vpn_server_private.key")
+	pubPath := filepath.Join(wgKeyDir, "vpn_server_public.key")
+
+	if data, err := os.ReadFile(privPath); err == nil {
+		priv := strings.TrimSpace(string(data))
+		pub, err := wgPublicKey(priv)
+		return priv, pub, err
+	}
+
+	fmt.Println("Initializing WireGuard Server Keys...")
+	priv, pub, err := generateWGKeyPair()
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(privPath, []byte(priv+"\n"), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(pubPath, []byte(pub+"\n"), 0644); err != nil {
+		return "", "", err
+	}
+	return priv, pub, nil
+}
+
+// defaultWGInterfaceStore is the road-warrior server earlier versions ran
+func defaultWGInterfaceStore(privateKey, publicKey string) WGInterfaceStore {
+	return WGInterfaceStore{Interfaces: []WGInterface{{
+		Name:        defaultWGInterface,
+		Role:        wgRoleServer,
+		Description: "Remote access",
+		PrivateKey:  privateKey,
+		PublicKey:   publicKey,
+		Address:     "10.8.0.1/24",
+		ListenPort:  51820,
+		Zone:        zoneLAN,
+		Enabled:     true,
+	}}}
+}
+
+func loadWGInterfaces() {
+	wgLock.Lock()
+	defer wgLock.Unlock()
+
+	data, err := os.ReadFile(wgInterfaceConfigPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &wgInterfaceStore); err != nil {
+			fmt.Printf("Error parsing WireGuard interfaces: %v\n", err)
+		}
+		return
+	}
+	if !os.IsNotExist(err) {
+		fmt.Printf("Error loading WireGuard interfaces: %v\n", err)
+		return
+	}
+
+	priv, pub, err := wgServerKeys()
+	if err != nil {
+		fmt.Printf("Error loading WireGuard server keys: %v\n", err)
+		return
+	}
+	wgInterfaceStore = defaultWGInterfaceStore(priv, pub)
+	if err := saveWGInterfacesLocked(); err != nil {
+		fmt.Printf("Error saving WireGuard interfaces: %v\n", err)
+	}
+}
+
+func saveWGInterfacesLocked() error {
+	data, err := json.MarshalIndent(wgInterfaceStore, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Holds private keys
+	return os.WriteFile(wgInterfaceConfigPath, data, 0600)
+}
+
+// GetWGInterfaces returns a copy of the WireGuard interfaces
+func GetWGInterfaces() []WGInterface {
+	wgLock.Lock()
+	defer wgLock.Unlock()
+	return append([]WGInterface(nil), wgInterfaceStore.Interfaces...)
+}
+
+func findWGInterface(ifaces []WGInterface, name string) (WGInterface, bool) {
+	for _, iface := range ifaces {
+		if iface.Name == name {
+			return iface, true
+		}
+	}
+	return WGInterface{}, false
+}
+
+func publicWGInterface(iface WGInterface) WGInterface {
+	iface.PrivateKey = ""
+	return iface
+}
+
+func validateWGInterface(iface WGInterface, others []WGInterface, zones []FirewallZone) error {
+	if !wgInterfaceNameRegex.MatchString(iface.Name) {
+		return fmt.Errorf("interface name must be wg followed by a number")
+	}
+	if !wgRoles[iface.Role] {
+		return fmt.Errorf("role must be server, site or provider")
+	}
+	if !isValidWGKey(iface.PrivateKey) {
+		return fmt.Errorf("invalid private key")
+	}
+
+	ip, ipNet, err := net.ParseCIDR(iface.Address)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("address must be an IPv4 address with prefix, e.g. 10.8.0.1/24")
+	}
+	if ones, _ := ipNet.Mask.Size(); ones < 32 && ip.Equal(ipNet.IP) {
+		return fmt.Errorf("address %s is the network address", iface.Address)
+	}
+
+	if iface.ListenPort < 0 || iface.ListenPort > 65535 {
+		return fmt.Errorf("invalid listen port")
+	}
+	if iface.ListenPort == 0 && iface.Role == wgRoleServer {
+		return fmt.Errorf("server interfaces need a listen port")
+	}
+	if iface.MTU != 0 && (iface.MTU < minWGMTU || iface.MTU > maxInterfaceMTU) {
+		return fmt.Errorf("MTU must be between %d and %d", minWGMTU, maxInterfaceMTU)
+	}
+	if iface.Endpoint != "" && net.ParseIP(iface.Endpoint) == nil && !dnsDomainRegex.MatchString(iface.Endpoint) {
+		return fmt.Errorf("endpoint must be a host name or IP address")
+	}
+	if err := validateWGClientTemplate(iface.Client); err != nil {
+		return err
+	}
+
+	if iface.Zone != "" {
+		found := false
+		for _, z := range zones {
+			if z.Name == iface.Zone {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown zone %s", iface.Zone)
+		}
+	}
+
+	for _, o := range others {
+		if o.Name == iface.Name {
+			continue
+		}
+		if iface.ListenPort != 0 && o.ListenPort == iface.ListenPort {
+			return fmt.Errorf("port %d is already used by %s", iface.ListenPort, o.Name)
+		}
+		if oIP, oNet, err := net.ParseCIDR(o.Address); err == nil && (oNet.Contains(ip) || ipNet.Contains(oIP)) {
+			return fmt.Errorf("address %s overlaps %s (%s)", iface.Address, o.Name, o.Address)
+		}
+	}
+	return nil
+}
+
+func validateWGClientTemplate(t WGClientTemplate) error {
+	if t.DNS != "" {
+		for _, d := range strings.Split(t.DNS, ",") {
+			if net.ParseIP(strings.TrimSpace(d)) == nil {
+				return fmt.Errorf("invalid client DNS server %q", d)
+			}
+		}
+	}
+	if t.Tunnel != "" && t.Tunnel != "full" && t.Tunnel != "split" {
+		return fmt.Errorf("client tunnel must be full or split")
+	}
+	for _, cidr := range t.SplitRoutes {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid split route %q", cidr)
+		}
+	}
+	if t.EndpointPort < 0 || t.EndpointPort > 65535 {
+		return fmt.Errorf("invalid client endpoint port")
+	}
+	if t.Keepalive < 0 || t.Keepalive > 65535 {
+		return fmt.Errorf("invalid client keepalive")
+	}
+	if t.MTU != 0 && (t.MTU < minWGMTU || t.MTU > maxInterfaceMTU) {
+		return fmt.Errorf("client MTU must be between %d and %d", minWGMTU, maxInterfaceMTU)
+	}
+	return nil
+}
+
+// wgInterfaceRoutes returns the routes to the subnets behind the peers of
+// server and site interfaces. Provider tunnels carry default routes that are
+// only used through policy routing, so they get none.
+func wgInterfaceRoutes(ifaces []WGInterface, peers []WGPeer, now time.Time) []StaticRoute {
+	var routes []StaticRoute
+	for _, iface := range ifaces {
+		if !iface.Enabled || iface.Role == wgRoleProvider {
+			continue
+		}
+		for _, p := range wgInterfacePeers(peers, iface.Name) {
+			if !wgPeerActive(p, now) {
+				continue
+			}
+			for _, cidr := range p.AllowedIPs {
+				routes = append(routes, StaticRoute{
+					ID:          fmt.Sprintf("%s-%s-%s", wgRouteSource, iface.Name, cidr),
+					Destination: cidr,
+					Interface:   iface.Name,
+					Comment:     "WireGuard peer " + p.Name,
+					Source:      wgRouteSource,
+				})
+			}
+		}
+	}
+	return routes
+}
+
+// renderWGInterfaceConfig renders the wg-quick config of an interface. Routes
+// are managed here (Table = off) and restored by PostUp when wg-quick restarts
+// the interface; NAT comes from the zones.
+func renderWGInterfaceConfig(iface WGInterface, peers []WGPeer, routes []StaticRoute, now time.Time) string {
+	var b strings.Builder
+	b.WriteString("# Auto-generated by SoftRouter - DO NOT EDIT MANUALLY\n")
+	b.WriteString("[Interface]\n")
+	b.WriteString(fmt.Sprintf("PrivateKey = %s\n", iface.PrivateKey))
+	b.WriteString(fmt.Sprintf("Address = %s\n", iface.Address))
+	if iface.ListenPort > 0 {
+		b.WriteString(fmt.Sprintf("ListenPort = %d\n", iface.ListenPort))
+	}
+	if iface.MTU > 0 {
+		b.WriteString(fmt.Sprintf("MTU = %d\n", iface.MTU))
+	}
+	b.WriteString("Table = off\n")
+	for _, rt := range routes {
+		if rt.Interface == iface.Name {
+			b.WriteString(fmt.Sprintf("PostUp = ip route replace %s dev %%i\n", rt.Destination))
+		}
+	}
+	renderWGPeers(&b, iface, peers, now)
+	return b.String()
+}
+
+// renderWGSyncConfig renders the wg(8) subset `wg syncconf` accepts
+func renderWGSyncConfig(iface WGInterface, peers []WGPeer, now time.Time) string {
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	b.WriteString(fmt.Sprintf("PrivateKey = %s\n", iface.PrivateKey))
+	if iface.ListenPort > 0 {
+		b.WriteString(fmt.Sprintf("ListenPort = %d\n", iface.ListenPort))
+	}
+	renderWGPeers(&b, iface, peers, now)
+	return b.String()
+}
+
+// syncWGInterfaceLocked writes the interface's config and loads its peers
+// into the running interface. Interfaces that are down pick the file up when
+// wg-quick starts them.
+func syncWGInterfaceLocked(iface WGInterface, now time.Time) error {
+	routes := wgInterfaceRoutes([]WGInterface{iface}, wgPeerStore.Peers, now)
+	confPath := filepath.Join(wgConfigDir, iface.Name+".conf")
+	if err := os.WriteFile(confPath, []byte(renderWGInterfaceConfig(iface, wgPeerStore.Peers, routes, now)), 0600); err != nil {
+		return err
+	}
+
+	if !iface.Enabled {
+		return nil
+	}
+	if _, err := net.InterfaceByName(iface.Name); err != nil {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp("", "wg-sync-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.WriteString(renderWGSyncConfig(iface, wgPeerStore.Peers, now)); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	tmp.Close() //nolint:errcheck
+
+	if out, err := runPrivilegedCombinedOutput("wg", "syncconf", iface.Name, tmp.Name()); err != nil {
+		return fmt.Errorf("wg syncconf failed: %v (%s)", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// restartWGInterface (re)starts or stops the wg-quick unit of an interface.
+// Address, port and MTU changes need a restart; peers are synced live.
+func restartWGInterface(iface WGInterface) error {
+	unit := "wg-quick@" + iface.Name
+	if !iface.Enabled {
+		if out, err := runPrivilegedCombinedOutput("systemctl", "disable", "--now", unit); err != nil {
+			return fmt.Errorf("failed to stop %s: %v (%s)", unit, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	if out, err := runPrivilegedCombinedOutput("systemctl", "enable", unit); err != nil {
+		return fmt.Errorf("failed to enable %s: %v (%s)", unit, err, strings.TrimSpace(string(out)))
+	}
+	if out, err := runPrivilegedCombinedOutput("systemctl", "restart", unit); err != nil {
+		return fmt.Errorf("failed to start %s: %v (%s)", unit, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// syncWGRoutesLocked publishes the peer subnets in the route store
+func syncWGRoutesLocked(now time.Time) {
+	if err := replaceManagedRoutes(wgRouteSource, wgInterfaceRoutes(wgInterfaceStore.Interfaces, wgPeerStore.Peers, now)); err != nil {
+		fmt.Printf("Warning: Failed to save WireGuard routes: %v\n", err)
+	}
+}
+
+// addWGInterfaceZones places WireGuard interfaces into their configured zone
+// unless the zone model already assigns them explicitly or by label
+func addWGInterfaceZones(zones []FirewallZone, ifaces []WGInterface) []FirewallZone {
+	assigned := make(map[string]bool)
+	for _, z := range zones {
+		for _, name := range z.Interfaces {
+			assigned[name] = true
+		}
+	}
+	for _, iface := range ifaces {
+		if !iface.Enabled || iface.Zone == "" || assigned[iface.Name] {
+			continue
+		}
+		for i := range zones {
+			if zones[i].Name == iface.Zone {
+				zones[i].Interfaces = append(zones[i].Interfaces, iface.Name)
+			}
+		}
+	}
+	return zones
+}
+
+// initWireGuard loads the interfaces and peers and renders their configs
+func initWireGuard() {
+	os.MkdirAll(wgKeyDir, 0755)    //nolint:errcheck
+	os.MkdirAll(wgConfigDir, 0700) //nolint:errcheck
+
+	loadWGInterfaces()
+	loadWGPeers()
+
+	wgLock.Lock()
+	if err := applyWGPeersLocked(); err != nil {
+		fmt.Printf("WARNING: Failed to apply WireGuard config: %v\n", err)
+	}
+	wgLock.Unlock()
+
+	startWGExpiryMonitor()
+	startWGStatsSampler()
+}
+
+// --- API Handlers ---
+
+func getWGInterfaces(w http.ResponseWriter, r *http.Request) {
+	ifaces := GetWGInterfaces()
+	out := make([]WGInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		out = append(out, publicWGInterface(iface))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, out)
+}
+
+// saveWGInterface validates, stores and applies a created or updated interface
+func saveWGInterface(w http.ResponseWriter, r *http.Request, iface WGInterface, action string) {
+	if iface.PrivateKey == "" {
+		priv, pub, err := generateWGKeyPair()
+		if err != nil {
+			respondWithError(w, ErrVPNCreateFailed, "Failed to generate keys", http.StatusInternalServerError, err)
+			return
+		}
+		iface.PrivateKey, iface.PublicKey = priv, pub
+	} else {
+		pub, err := wgPublicKey(iface.PrivateKey)
+		if err != nil {
+			respondWithError(w, ErrVPNConfigInvalid, "Invalid private key", http.StatusBadRequest, nil)
+			return
+		}
+		iface.PublicKey = pub
+	}
+
+	zones := GetZoneStore().Zones
+
+	wgLock.Lock()
+	defer wgLock.Unlock()
+
+	if err := validateWGInterface(iface, wgInterfaceStore.Interfaces, zones); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	previous := wgInterfaceStore.Interfaces
+	updated := make([]WGInterface, 0, len(previous)+1)
+	replaced := false
+	for _, existing := range previous {
+		if existing.Name == iface.Name {
+			existing, replaced = iface, true
+		}
+		updated = append(updated, existing)
+	}
+	if !replaced {
+		updated = append(updated, iface)
+	}
+	wgInterfaceStore.Interfaces = updated
+
+	if err := saveWGInterfacesLocked(); err != nil {
+		wgInterfaceStore.Interfaces = previous
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save WireGuard interfaces", err)
+		return
+	}
+
+	now := time.Now()
+	applyErr := syncWGInterfaceLocked(iface, now)
+	if applyErr == nil {
+		applyErr = restartWGInterface(iface)
+	}
+	syncWGRoutesLocked(now)
+
+	details, _ := json.Marshal(publicWGInterface(iface))
+	if applyErr != nil {
+		logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.interface."+action, iface.Name,
+			fmt.Sprintf("{\"error\":\"%s\"}", applyErr.Error()), getClientIP(r), false)
+		respondWithError(w, ErrVPNConfigInvalid, "Interface saved but not applied", http.StatusInternalServerError, applyErr)
+		return
+	}
+	logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.interface."+action, iface.Name, string(details), getClientIP(r), true)
+
+	// Zone membership and the listen port are part of the ruleset
+	go firewallManager.ApplyFirewallRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, publicWGInterface(iface))
+}
+
+func createWGInterface(w http.ResponseWriter, r *http.Request) {
+	var iface WGInterface
+	if err := json.NewDecoder(r.Body).Decode(&iface); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	if _, exists := findWGInterface(GetWGInterfaces(), iface.Name); exists {
+		respondWithError(w, ErrVPNConfigInvalid, "Interface "+iface.Name+" already exists", http.StatusConflict, nil)
+		return
+	}
+	if iface.Role == "" {
+		iface.Role = wgRoleServer
+	}
+	saveWGInterface(w, r, iface, "create")
+}
+
+func updateWGInterface(w http.ResponseWriter, r *http.Request) {
+	var iface WGInterface
+	if err := json.NewDecoder(r.Body).Decode(&iface); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+	iface.Name = r.URL.Query().Get("name")
+
+	existing, ok := findWGInterface(GetWGInterfaces(), iface.Name)
+	if !ok {
+		respondWithError(w, ErrGenericNotFound, "Interface not found", http.StatusNotFound, nil)
+		return
+	}
+	if iface.PrivateKey == "" {
+		iface.PrivateKey = existing.PrivateKey // Keep the key unless replaced
+	}
+	saveWGInterface(w, r, iface, "update")
+}
+
+func deleteWGInterface(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+
+	wgLock.Lock()
+	defer wgLock.Unlock()
+
+	iface, ok := findWGInterface(wgInterfaceStore.Interfaces, name)
+	if !ok {
+		respondWithError(w, ErrGenericNotFound, "Interface not found", http.StatusNotFound, nil)
+		return
+	}
+	if profile, used := vpnProfileForDevice(GetVPNProfiles(), name); used {
+		respondWithError(w, ErrVPNConfigInvalid, fmt.Sprintf("Interface %s is used by VPN profile %s", name, profile.Name), http.StatusConflict, nil)
+		return
+	}
+	if peers := wgInterfacePeers(wgPeerStore.Peers, name); len(peers) > 0 {
+		respondWithError(w, ErrVPNConfigInvalid, fmt.Sprintf("Interface %s still has %d peers", name, len(peers)), http.StatusConflict, nil)
+		return
+	}
+
+	previous := wgInterfaceStore.Interfaces
+	remaining := make([]WGInterface, 0, len(previous))
+	for _, existing := range previous {
+		if existing.Name != name {
+			remaining = append(remaining, existing)
+		}
+	}
+	wgInterfaceStore.Interfaces = remaining
+	if err := saveWGInterfacesLocked(); err != nil {
+		wgInterfaceStore.Interfaces = previous
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save WireGuard interfaces", err)
+		return
+	}
+
+	iface.Enabled = false
+	if err := restartWGInterface(iface); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	os.Remove(filepath.Join(wgConfigDir, name+".conf")) //nolint:errcheck
+	syncWGRoutesLocked(time.Now())
+
+	logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.interface.delete", name, "", getClientIP(r), true)
+
+	go firewallManager.ApplyFirewallRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "success"})
+}
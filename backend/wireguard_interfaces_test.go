@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateWGInterface(t *testing.T) {
+	priv, _, _ := generateWGKeyPair()
+	zones := defaultZoneStore().Zones
+	existing := []WGInterface{{Name: "wg0", Role: wgRoleServer, PrivateKey: priv, Address: "10.8.0.1/24", ListenPort: 51820}}
+
+	valid := WGInterface{Name: "wg1", Role: wgRoleSite, PrivateKey: priv, Address: "10.9.0.1/30", ListenPort: 51821, MTU: 1420, Zone: "lan"}
+	if err := validateWGInterface(valid, existing, zones); err != nil {
+		t.Fatalf("Expected valid interface, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*WGInterface)
+	}{
+		{"name", func(i *WGInterface) { i.Name = "tun0" }},
+		{"role", func(i *WGInterface) { i.Role = "mesh" }},
+		{"port in use", func(i *WGInterface) { i.ListenPort = 51820 }},
+		{"overlap", func(i *WGInterface) { i.Address = "10.8.0.200/30" }},
+		{"network address", func(i *WGInterface) { i.Address = "10.9.0.0/30" }},
+		{"mtu", func(i *WGInterface) { i.MTU = 1000 }},
+		{"zone", func(i *WGInterface) { i.Zone = "nowhere" }},
+		{"server without port", func(i *WGInterface) { i.Role, i.ListenPort = wgRoleServer, 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := valid
+			tt.mutate(&i)
+			if err := validateWGInterface(i, existing, zones); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestWGInterfaceRoutes(t *testing.T) {
+	ifaces := []WGInterface{
+		{Name: "wg0", Role: wgRoleServer, Enabled: true},
+		{Name: "wg1", Role: wgRoleSite, Address: "10.9.0.1/30", ListenPort: 51821, Enabled: true},
+		{Name: "wg2", Role: wgRoleProvider, Enabled: true},
+	}
+	peers := []WGPeer{
+		{Name: "phone", Address: "10.8.0.2/32", Enabled: true},
+		{Name: "branch", Interface: "wg1", Address: "10.9.0.2/32", AllowedIPs: []string{"192.168.60.0/24", "192.168.61.0/24"}, Endpoint: "203.0.113.5:51820", PersistentKeepalive: 25, Enabled: true},
+		{Name: "closed", Interface: "wg1", AllowedIPs: []string{"192.168.70.0/24"}, Enabled: false},
+		{Name: "exit", Interface: "wg2", AllowedIPs: []string{"0.0.0.0/0"}, Enabled: true},
+	}
+
+	routes := wgInterfaceRoutes(ifaces, peers, time.Now())
+	var got []string
+	for _, rt := range routes {
+		got = append(got, strings.Join(staticRouteArgs(rt), " "))
+		if rt.Source != wgRouteSource {
+			t.Errorf("Expected managed route, got %+v", rt)
+		}
+	}
+	if strings.Join(got, ",") != "192.168.60.0/24 dev wg1,192.168.61.0/24 dev wg1" {
+		t.Errorf("Unexpected routes %v", got)
+	}
+
+	conf := renderWGInterfaceConfig(ifaces[1], peers, routes, time.Now())
+	for _, s := range []string{
+		"PostUp = ip route replace 192.168.60.0/24 dev %i\n",
+		"AllowedIPs = 10.9.0.2/32, 192.168.60.0/24, 192.168.61.0/24\nEndpoint = 203.0.113.5:51820\nPersistentKeepalive = 25\n",
+	} {
+		if !strings.Contains(conf, s) {
+			t.Errorf("Expected config to contain %q.\n%s", s, conf)
+		}
+	}
+	if strings.Contains(conf, "phone") || strings.Contains(conf, "exit") || strings.Contains(conf, "masquerade") {
+		t.Errorf("Config must only carry wg1 peers and no NAT:\n%s", conf)
+	}
+}
+
+func TestGenerateFullRulesetWireGuard(t *testing.T) {
+	in := testRulesetInputs()
+	in.WireGuard = []WGInterface{
+		{Name: "wg0", Role: wgRoleServer, ListenPort: 51820, Zone: "lan", Enabled: true},
+		{Name: "wg1", Role: wgRoleProvider, ListenPort: 51999, Zone: "wan", Enabled: true},
+		{Name: "wg3", Role: wgRoleSite, ListenPort: 51823, Zone: "lan", Enabled: false},
+	}
+	in.Zones = addWGInterfaceZones(in.Zones, in.WireGuard)
+
+	ruleset, err := firewallManager.generateFullRuleset(in)
+	if err != nil {
+		t.Fatalf("generateFullRuleset failed: %v", err)
+	}
+
+	for _, s := range []string{
+		"    udp dport 51820 accept comment \"WireGuard wg0\"\n",
+		"    oifname \"wg1\" masquerade comment \"NAT\"\n",
+	} {
+		if !strings.Contains(ruleset, s) {
+			t.Errorf("Expected ruleset to contain %q.\nGenerated Ruleset:\n%s", s, ruleset)
+		}
+	}
+	for _, s := range []string{"51999", "51823", "oifname \"*\""} {
+		if strings.Contains(ruleset, s) {
+			t.Errorf("Unexpected %q in ruleset", s)
+		}
+	}
+	if lan := zoneInterfaces(in.Zones, "lan"); len(lan) == 0 || lan[len(lan)-1] != "wg0" {
+		t.Errorf("Expected wg0 in the lan zone, got %v", lan)
+	}
+}
@@ -0,0 +1,839 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WGPeer is a peer of one of the WireGuard interfaces. The interface configs
+// are rendered from the store, so they are never edited in place.
+type WGPeer struct {
+	ID                  string     `json:"id"`
+	Name                string     `json:"name"`
+	Interface           string     `json:"interface,omitempty"` // Default wg0
+	PublicKey           string     `json:"public_key"`
+	PrivateKey          string     `json:"private_key,omitempty"`   // Only kept when generated here (for the client config), never returned by the API
+	PresharedKey        string     `json:"preshared_key,omitempty"` // Never returned by the API
+	Address             string     `json:"ip_address,omitempty"`    // Tunnel address, e.g. 10.8.0.2/32; allocated from the pool on server interfaces
+	AllowedIPs          []string   `json:"allowed_ips,omitempty"`   // Subnets behind this peer (routed on server and site interfaces)
+	ClientAllowedIPs    []string   `json:"client_allowed_ips,omitempty"`
+	Endpoint            string     `json:"endpoint,omitempty"` // host:port we connect to (site and provider peers)
+	DNS                 string     `json:"dns,omitempty"`
+	PersistentKeepalive int        `json:"persistent_keepalive,omitempty"`
+	Enabled             bool       `json:"enabled"`
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// WGPeerStore holds the peers of all WireGuard interfaces
+type WGPeerStore struct {
+	Peers []WGPeer `json:"peers"`
+}
+
+var (
+	wgPeerStore      WGPeerStore
+	wgPeerConfigPath = "/etc/softrouter/wireguard_peers.json"
+	wgLegacyClients  = "/etc/softrouter/vpn_clients"
+	wgLastActiveSig  string
+
+	wgPeerNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+// generateWGKeyPair returns a base64 X25519 private key and its public key
+func generateWGKeyPair() (string, string, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Bytes()),
+		base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+// wgPublicKey derives the public key of a base64 private key
+func wgPublicKey(privateKey string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil {
+		return "", err
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+func generateWGPresharedKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// isValidWGKey checks for a base64 encoded 32 byte key
+func isValidWGKey(key string) bool {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(raw) == 32
+}
+
+// interfaceName returns the interface the peer belongs to
+func (p WGPeer) interfaceName() string {
+	if p.Interface == "" {
+		return defaultWGInterface
+	}
+	return p.Interface
+}
+
+// wgPeerActive reports whether a peer belongs in the running config
+func wgPeerActive(p WGPeer, now time.Time) bool {
+	return p.Enabled && (p.ExpiresAt == nil || now.Before(*p.ExpiresAt))
+}
+
+// wgInterfacePeers returns the peers of one interface
+func wgInterfacePeers(peers []WGPeer, iface string) []WGPeer {
+	var out []WGPeer
+	for _, p := range peers {
+		if p.interfaceName() == iface {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// allocateWGAddress returns the lowest free host address of the pool. Every
+// stored peer holds its address, disabled and expired ones included, and
+// addresses of deleted peers are handed out again.
+func allocateWGAddress(pool string, peers []WGPeer) (string, error) {
+	gw, ipNet, err := net.ParseCIDR(pool)
+	if err != nil || gw.To4() == nil {
+		return "", fmt.Errorf("invalid IPv4 pool %s", pool)
+	}
+
+	used := map[string]bool{gw.String(): true}
+	for _, p := range peers {
+		if ip, _, err := net.ParseCIDR(p.Address); err == nil {
+			used[ip.String()] = true
+		}
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	base := binary.BigEndian.Uint32(ipNet.IP.To4())
+	size := uint32(1) << uint(bits-ones)
+	for off := uint32(1); off < size-1; off++ { // Skip network and broadcast
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+off)
+		if !used[ip.String()] {
+			return ip.String() + "/32", nil
+		}
+	}
+	return "", fmt.Errorf("address pool %s is exhausted", pool)
+}
+
+func validateWGPeer(p WGPeer, iface WGInterface, others []WGPeer) error {
+	if !wgPeerNameRegex.MatchString(p.Name) {
+		return fmt.Errorf("name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if !isValidWGKey(p.PublicKey) {
+		return fmt.Errorf("invalid public key")
+	}
+	if p.PresharedKey != "" && !isValidWGKey(p.PresharedKey) {
+		return fmt.Errorf("invalid preshared key")
+	}
+
+	// Road warriors need a tunnel address, site and provider peers may route
+	// only subnets
+	if p.Address != "" || iface.Role == wgRoleServer {
+		ip, _, err := net.ParseCIDR(p.Address)
+		if err != nil || !strings.HasSuffix(p.Address, "/32") {
+			return fmt.Errorf("address must be a /32")
+		}
+		gw, ifaceNet, _ := net.ParseCIDR(iface.Address)
+		if !ifaceNet.Contains(ip) || ip.Equal(gw) {
+			return fmt.Errorf("address %s is not a host address of %s (%s)", p.Address, iface.Name, iface.Address)
+		}
+	}
+
+	for _, cidr := range append(append([]string(nil), p.AllowedIPs...), p.ClientAllowedIPs...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+	}
+	if iface.Role == wgRoleProvider && len(p.AllowedIPs) == 0 {
+		return fmt.Errorf("provider peers need allowed IPs")
+	}
+
+	if p.Endpoint != "" {
+		host, port, err := net.SplitHostPort(p.Endpoint)
+		if err != nil || host == "" {
+			return fmt.Errorf("endpoint must be host:port")
+		}
+		// The endpoint is written into the wg-quick config as is
+		if net.ParseIP(host) == nil && !dnsDomainRegex.MatchString(host) {
+			return fmt.Errorf("invalid endpoint host %q", host)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid endpoint port %q", port)
+		}
+	} else if iface.Role == wgRoleProvider {
+		return fmt.Errorf("provider peers need an endpoint")
+	}
+
+	if p.DNS != "" {
+		for _, d := range strings.Split(p.DNS, ",") {
+			if net.ParseIP(strings.TrimSpace(d)) == nil {
+				return fmt.Errorf("invalid DNS server %q", d)
+			}
+		}
+	}
+	if p.PersistentKeepalive < 0 || p.PersistentKeepalive > 65535 {
+		return fmt.Errorf("invalid persistent keepalive")
+	}
+
+	for _, o := range others {
+		if o.ID == p.ID {
+			continue
+		}
+		switch {
+		case o.Name == p.Name:
+			return fmt.Errorf("a peer named %s already exists", p.Name)
+		case o.PublicKey == p.PublicKey:
+			return fmt.Errorf("public key already used by %s", o.Name)
+		case o.interfaceName() == iface.Name && p.Address != "" && o.Address == p.Address:
+			return fmt.Errorf("address %s already used by %s", p.Address, o.Name)
+		}
+	}
+	return nil
+}
+
+// routerSubnets lists the subnets the router is connected to, including those
+// of WireGuard interfaces that are down
+func routerSubnets(ifaces []WGInterface) []*net.IPNet {
+	var subnets []*net.IPNet
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok {
+				subnets = append(subnets, &net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask})
+			}
+		}
+	}
+	for _, iface := range ifaces {
+		if _, n, err := net.ParseCIDR(iface.Address); err == nil {
+			subnets = append(subnets, n)
+		}
+	}
+	return subnets
+}
+
+// validateWGPeerRoutes checks the allowed IPs that become main table routes on
+// server and site interfaces: a default route or a connected subnet would
+// replace the WAN or LAN route
+func validateWGPeerRoutes(p WGPeer, iface WGInterface, subnets []*net.IPNet) error {
+	if iface.Role == wgRoleProvider {
+		return nil
+	}
+	for _, cidr := range p.AllowedIPs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		if ones, _ := n.Mask.Size(); ones == 0 {
+			return fmt.Errorf("allowed IPs of %s peers must not contain a default route", iface.Role)
+		}
+		for _, s := range subnets {
+			if s.Contains(n.IP) || n.Contains(s.IP) {
+				return fmt.Errorf("allowed IPs %s overlap the connected subnet %s", cidr, s)
+			}
+		}
+	}
+	return nil
+}
+
+// wgPeerAllowedIPs is the interface-side AllowedIPs of a peer
+func wgPeerAllowedIPs(p WGPeer) string {
+	var allowed []string
+	if p.Address != "" {
+		allowed = append(allowed, p.Address)
+	}
+	return strings.Join(append(allowed, p.AllowedIPs...), ", ")
+}
+
+// renderWGPeers renders the [Peer] sections of the interface's active peers
+func renderWGPeers(b *strings.Builder, iface WGInterface, peers []WGPeer, now time.Time) {
+	for _, p := range peers {
+		if p.interfaceName() != iface.Name || !wgPeerActive(p, now) {
+			continue
+		}
+		b.WriteString("\n[Peer]\n")
+		b.WriteString(fmt.Sprintf("# Name: %s\n", p.Name))
+		b.WriteString(fmt.Sprintf("PublicKey = %s\n", p.PublicKey))
+		if p.PresharedKey != "" {
+			b.WriteString(fmt.Sprintf("PresharedKey = %s\n", p.PresharedKey))
+		}
+		b.WriteString(fmt.Sprintf("AllowedIPs = %s\n", wgPeerAllowedIPs(p)))
+		if p.Endpoint != "" {
+			b.WriteString(fmt.Sprintf("Endpoint = %s\n", p.Endpoint))
+		}
+		// Road warriors keep their own NAT mappings alive
+		if p.PersistentKeepalive > 0 && iface.Role != wgRoleServer {
+			b.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", p.PersistentKeepalive))
+		}
+	}
+}
+
+// renderWGClientConfig renders the configuration file handed to a peer from
+// the interface's client template and the peer's overrides
+func renderWGClientConfig(p WGPeer, iface WGInterface, endpoint string) string {
+	tmpl := iface.Client
+	gw, ifaceNet, _ := net.ParseCIDR(iface.Address)
+
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	if p.PrivateKey != "" {
+		b.WriteString(fmt.Sprintf("PrivateKey = %s\n", p.PrivateKey))
+	} else {
+		b.WriteString("# PrivateKey = <the private key matching this peer's public key>\n")
+	}
+	if p.Address != "" {
+		b.WriteString(fmt.Sprintf("Address = %s\n", p.Address))
+	}
+	dns := p.DNS
+	if dns == "" {
+		dns = tmpl.DNS
+	}
+	if dns == "" && gw != nil {
+		dns = gw.String() // The router's resolver answers on every interface
+	}
+	if dns != "" {
+		b.WriteString(fmt.Sprintf("DNS = %s\n", dns))
+	}
+	if tmpl.MTU > 0 {
+		b.WriteString(fmt.Sprintf("MTU = %d\n", tmpl.MTU))
+	}
+
+	b.WriteString("\n[Peer]\n")
+	b.WriteString(fmt.Sprintf("PublicKey = %s\n", iface.PublicKey))
+	if p.PresharedKey != "" {
+		b.WriteString(fmt.Sprintf("PresharedKey = %s\n", p.PresharedKey))
+	}
+	port := iface.ListenPort
+	if tmpl.EndpointPort > 0 {
+		port = tmpl.EndpointPort
+	}
+	if iface.Endpoint != "" {
+		endpoint = iface.Endpoint
+	}
+	b.WriteString(fmt.Sprintf("Endpoint = %s\n", net.JoinHostPort(endpoint, strconv.Itoa(port))))
+
+	allowed := p.ClientAllowedIPs
+	if len(allowed) == 0 {
+		if tmpl.Tunnel == "split" && ifaceNet != nil {
+			allowed = append([]string{ifaceNet.String()}, tmpl.SplitRoutes...)
+		} else {
+			allowed = []string{"0.0.0.0/0"}
+		}
+	}
+	b.WriteString(fmt.Sprintf("AllowedIPs = %s\n", strings.Join(allowed, ", ")))
+
+	keepalive := p.PersistentKeepalive
+	if keepalive == 0 {
+		keepalive = tmpl.Keepalive
+	}
+	if keepalive == 0 {
+		keepalive = 25
+	}
+	b.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", keepalive))
+	return b.String()
+}
+
+// wgConfigSection is one [Interface] or [Peer] block of a WireGuard config
+type wgConfigSection struct {
+	Name   string
+	Keys   map[string]string
+	Header string // "# Name:" comment in a peer block
+}
+
+// parseWGConfig splits a WireGuard config into its sections
+func parseWGConfig(data string) []wgConfigSection {
+	var sections []wgConfigSection
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			sections = append(sections, wgConfigSection{Name: strings.Trim(line, "[]"), Keys: make(map[string]string)})
+		case len(sections) == 0:
+		case strings.HasPrefix(line, "# Name:"):
+			sections[len(sections)-1].Header = strings.TrimSpace(strings.TrimPrefix(line, "# Name:"))
+		case strings.HasPrefix(line, "#"), line == "":
+		default:
+			if k, v, ok := strings.Cut(line, "="); ok {
+				sections[len(sections)-1].Keys[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+	}
+	return sections
+}
+
+// importLegacyWGPeers builds the store from the peers appended to wg0.conf and
+// the client files of earlier versions
+func importLegacyWGPeers(serverConf string, clientConfs map[string]string) []WGPeer {
+	privByAddress := make(map[string]string)
+	for _, conf := range clientConfs {
+		for _, s := range parseWGConfig(conf) {
+			if s.Name == "Interface" && s.Keys["Address"] != "" {
+				privByAddress[s.Keys["Address"]] = s.Keys["PrivateKey"]
+			}
+		}
+	}
+
+	var peers []WGPeer
+	for i, s := range parseWGConfig(serverConf) {
+		if s.Name != "Peer" || s.Keys["PublicKey"] == "" {
+			continue
+		}
+		allowed := strings.Split(s.Keys["AllowedIPs"], ",")
+		name := s.Header
+		if !wgPeerNameRegex.MatchString(name) {
+			name = fmt.Sprintf("peer-%d", i)
+		}
+		p := WGPeer{
+			ID:           uuid.New().String(),
+			Name:         name,
+			Interface:    defaultWGInterface,
+			PublicKey:    s.Keys["PublicKey"],
+			PresharedKey: s.Keys["PresharedKey"],
+			Address:      strings.TrimSpace(allowed[0]),
+			Enabled:      true,
+			CreatedAt:    time.Now(),
+		}
+		for _, a := range allowed[1:] {
+			p.AllowedIPs = append(p.AllowedIPs, strings.TrimSpace(a))
+		}
+		if priv := privByAddress[p.Address]; priv != "" {
+			if pub, err := wgPublicKey(priv); err == nil && pub == p.PublicKey {
+				p.PrivateKey = priv
+			}
+		}
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func loadWGPeers() {
+	wgLock.Lock()
+	defer wgLock.Unlock()
+
+	wgPeerStore = WGPeerStore{Peers: []WGPeer{}}
+	data, err := os.ReadFile(wgPeerConfigPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &wgPeerStore); err != nil {
+			fmt.Printf("Error parsing WireGuard peers: %v\n", err)
+		}
+		return
+	}
+	if !os.IsNotExist(err) {
+		fmt.Printf("Error loading WireGuard peers: %v\n", err)
+		return
+	}
+
+	// First start with the peer store: take over the existing peers
+	serverConf, err := os.ReadFile(filepath.Join(wgConfigDir, defaultWGInterface+".conf"))
+	if err != nil {
+		return
+	}
+	clientConfs := make(map[string]string)
+	if files, err := os.ReadDir(wgLegacyClients); err == nil {
+		for _, f := range files {
+			if strings.HasSuffix(f.Name(), ".conf") {
+				if data, err := os.ReadFile(filepath.Join(wgLegacyClients, f.Name())); err == nil {
+					clientConfs[f.Name()] = string(data)
+				}
+			}
+		}
+	}
+	if peers := importLegacyWGPeers(string(serverConf), clientConfs); len(peers) > 0 {
+		wgPeerStore.Peers = peers
+		fmt.Printf("Imported %d WireGuard peers from %s.conf\n", len(peers), defaultWGInterface)
+		if err := saveWGPeersLocked(); err != nil {
+			fmt.Printf("Error saving WireGuard peers: %v\n", err)
+		}
+	}
+}
+
+func saveWGPeersLocked() error {
+	data, err := json.MarshalIndent(wgPeerStore, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Holds private and preshared keys
+	return os.WriteFile(wgPeerConfigPath, data, 0600)
+}
+
+// applyWGPeersLocked re-renders every interface and loads the peers into the
+// running interfaces without dropping sessions
+func applyWGPeersLocked() error {
+	now := time.Now()
+	var errs []string
+	for _, iface := range wgInterfaceStore.Interfaces {
+		if err := syncWGInterfaceLocked(iface, now); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", iface.Name, err))
+		}
+	}
+	wgLastActiveSig = wgActiveSignature(wgPeerStore.Peers, now)
+	syncWGRoutesLocked(now)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// wgActiveSignature identifies the set of peers in the running configs
+func wgActiveSignature(peers []WGPeer, now time.Time) string {
+	var ids []string
+	for _, p := range peers {
+		if wgPeerActive(p, now) {
+			ids = append(ids, p.ID)
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// startWGExpiryMonitor removes expired peers from the running configs
+func startWGExpiryMonitor() {
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		for range ticker.C {
+			wgLock.Lock()
+			if wgActiveSignature(wgPeerStore.Peers, time.Now()) != wgLastActiveSig {
+				if err := applyWGPeersLocked(); err != nil {
+					fmt.Printf("WARNING: Failed to apply WireGuard config: %v\n", err)
+				}
+			}
+			wgLock.Unlock()
+		}
+	}()
+}
+
+// findWGPeer returns the index of a peer by ID or name
+func findWGPeer(peers []WGPeer, id, name string) int {
+	for i, p := range peers {
+		if (id != "" && p.ID == id) || (id == "" && name != "" && p.Name == name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// publicWGPeer strips the secrets for API responses
+func publicWGPeer(p WGPeer) WGPeer {
+	p.PrivateKey = ""
+	p.PresharedKey = ""
+	return p
+}
+
+// wgEndpointHost picks the endpoint host for client configs
+func wgEndpointHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	if r.Host != "" {
+		return r.Host
+	}
+	if h, err := os.Hostname(); err == nil {
+		return h
+	}
+	return "YOUR_ROUTER_IP"
+}
+
+// --- API Handlers ---
+
+// WGPeerRequest creates or updates a peer
+type WGPeerRequest struct {
+	Name                string     `json:"name"`
+	Interface           string     `json:"interface,omitempty"`  // Default wg0, fixed after creation
+	PublicKey           string     `json:"public_key,omitempty"` // Bring your own key; generated when empty
+	Address             string     `json:"ip_address,omitempty"` // Allocated on server interfaces when empty
+	AllowedIPs          []string   `json:"allowed_ips,omitempty"`
+	ClientAllowedIPs    []string   `json:"client_allowed_ips,omitempty"`
+	Endpoint            string     `json:"endpoint,omitempty"`
+	DNS                 string     `json:"dns,omitempty"`
+	PersistentKeepalive int        `json:"persistent_keepalive,omitempty"`
+	PresharedKey        bool       `json:"preshared_key"`
+	Enabled             *bool      `json:"enabled,omitempty"` // Defaults to true on create
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+}
+
+func listVPNClients(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, getVPNClientStatuses())
+}
+
+// commitWGPeersLocked saves and applies the store, restoring previous on failure
+func commitWGPeersLocked(previous []WGPeer) error {
+	if err := saveWGPeersLocked(); err != nil {
+		wgPeerStore.Peers = previous
+		return err
+	}
+	if err := applyWGPeersLocked(); err != nil {
+		fmt.Printf("WARNING: WireGuard peers saved but not applied: %v\n", err)
+	}
+	return nil
+}
+
+func addVPNClient(w http.ResponseWriter, r *http.Request) {
+	var req WGPeerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	peer := WGPeer{
+		ID:                  uuid.New().String(),
+		Name:                req.Name,
+		Interface:           req.Interface,
+		PublicKey:           req.PublicKey,
+		Address:             req.Address,
+		AllowedIPs:          req.AllowedIPs,
+		ClientAllowedIPs:    req.ClientAllowedIPs,
+		Endpoint:            req.Endpoint,
+		DNS:                 req.DNS,
+		PersistentKeepalive: req.PersistentKeepalive,
+		Enabled:             req.Enabled == nil || *req.Enabled,
+		ExpiresAt:           req.ExpiresAt,
+		CreatedAt:           time.Now(),
+	}
+	peer.Interface = peer.interfaceName()
+	if peer.PublicKey == "" {
+		priv, pub, err := generateWGKeyPair()
+		if err != nil {
+			respondWithError(w, ErrVPNCreateFailed, "Failed to generate keys", http.StatusInternalServerError, err)
+			return
+		}
+		peer.PrivateKey, peer.PublicKey = priv, pub
+	}
+	if req.PresharedKey {
+		psk, err := generateWGPresharedKey()
+		if err != nil {
+			respondWithError(w, ErrVPNCreateFailed, "Failed to generate preshared key", http.StatusInternalServerError, err)
+			return
+		}
+		peer.PresharedKey = psk
+	}
+
+	wgLock.Lock()
+	defer wgLock.Unlock()
+
+	iface, ok := findWGInterface(wgInterfaceStore.Interfaces, peer.Interface)
+	if !ok {
+		respondWithError(w, ErrVPNConfigInvalid, "Unknown WireGuard interface "+peer.Interface, http.StatusBadRequest, nil)
+		return
+	}
+	if peer.Address == "" && iface.Role == wgRoleServer {
+		addr, err := allocateWGAddress(iface.Address, wgInterfacePeers(wgPeerStore.Peers, iface.Name))
+		if err != nil {
+			respondWithError(w, ErrVPNCreateFailed, err.Error(), http.StatusConflict, nil)
+			return
+		}
+		peer.Address = addr
+	}
+	if err := validateWGPeer(peer, iface, wgPeerStore.Peers); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if err := validateWGPeerRoutes(peer, iface, routerSubnets(wgInterfaceStore.Interfaces)); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	previous := wgPeerStore.Peers
+	wgPeerStore.Peers = append(append([]WGPeer(nil), previous...), peer)
+	if err := commitWGPeersLocked(previous); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.peer.create", peer.Name,
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save WireGuard peers", err)
+		return
+	}
+
+	details, _ := json.Marshal(publicWGPeer(peer))
+	logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.peer.create", peer.Name, string(details), getClientIP(r), true)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]interface{}{
+		"status": "success",
+		"peer":   publicWGPeer(peer),
+		"config": renderWGClientConfig(peer, iface, wgEndpointHost(r)),
+	})
+}
+
+func updateVPNClient(w http.ResponseWriter, r *http.Request) {
+	var req WGPeerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondInvalidRequest(w, "Invalid request body")
+		return
+	}
+
+	wgLock.Lock()
+	defer wgLock.Unlock()
+
+	idx := findWGPeer(wgPeerStore.Peers, r.URL.Query().Get("id"), r.URL.Query().Get("name"))
+	if idx < 0 {
+		respondWithError(w, ErrGenericNotFound, "Peer not found", http.StatusNotFound, nil)
+		return
+	}
+
+	peer := wgPeerStore.Peers[idx]
+	if req.Interface != "" && req.Interface != peer.interfaceName() {
+		respondInvalidRequest(w, "Peers cannot move between interfaces")
+		return
+	}
+	if req.Name != "" {
+		peer.Name = req.Name
+	}
+	if req.PublicKey != "" && req.PublicKey != peer.PublicKey {
+		peer.PublicKey = req.PublicKey
+		peer.PrivateKey = "" // The client brought its own key
+	}
+	if req.Address != "" {
+		peer.Address = req.Address
+	}
+	peer.AllowedIPs = req.AllowedIPs
+	peer.ClientAllowedIPs = req.ClientAllowedIPs
+	peer.Endpoint = req.Endpoint
+	peer.DNS = req.DNS
+	peer.PersistentKeepalive = req.PersistentKeepalive
+	peer.ExpiresAt = req.ExpiresAt
+	if req.Enabled != nil {
+		peer.Enabled = *req.Enabled
+	}
+	switch {
+	case req.PresharedKey && peer.PresharedKey == "":
+		psk, err := generateWGPresharedKey()
+		if err != nil {
+			respondWithError(w, ErrVPNConfigInvalid, "Failed to generate preshared key", http.StatusInternalServerError, err)
+			return
+		}
+		peer.PresharedKey = psk
+	case !req.PresharedKey:
+		peer.PresharedKey = ""
+	}
+
+	iface, ok := findWGInterface(wgInterfaceStore.Interfaces, peer.interfaceName())
+	if !ok {
+		respondWithError(w, ErrVPNConfigInvalid, "Unknown WireGuard interface "+peer.interfaceName(), http.StatusBadRequest, nil)
+		return
+	}
+	if err := validateWGPeer(peer, iface, wgPeerStore.Peers); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if err := validateWGPeerRoutes(peer, iface, routerSubnets(wgInterfaceStore.Interfaces)); err != nil {
+		respondWithError(w, ErrVPNConfigInvalid, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	previous := wgPeerStore.Peers
+	wgPeerStore.Peers = append([]WGPeer(nil), previous...)
+	wgPeerStore.Peers[idx] = peer
+	if err := commitWGPeersLocked(previous); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.peer.update", peer.Name,
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save WireGuard peers", err)
+		return
+	}
+
+	details, _ := json.Marshal(publicWGPeer(peer))
+	logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.peer.update", peer.Name, string(details), getClientIP(r), true)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, publicWGPeer(peer))
+}
+
+func deleteVPNClient(w http.ResponseWriter, r *http.Request) {
+	id, name := r.URL.Query().Get("id"), r.URL.Query().Get("name")
+	if id == "" && name == "" {
+		respondInvalidRequest(w, "id or name required")
+		return
+	}
+
+	wgLock.Lock()
+	defer wgLock.Unlock()
+
+	idx := findWGPeer(wgPeerStore.Peers, id, name)
+	if idx < 0 {
+		respondWithError(w, ErrGenericNotFound, "Peer not found", http.StatusNotFound, nil)
+		return
+	}
+	peer := wgPeerStore.Peers[idx]
+
+	previous := wgPeerStore.Peers
+	wgPeerStore.Peers = append(append([]WGPeer(nil), previous[:idx]...), previous[idx+1:]...)
+	if err := commitWGPeersLocked(previous); err != nil {
+		logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.peer.delete", peer.Name,
+			fmt.Sprintf("{\"error\":\"%s\"}", err.Error()), getClientIP(r), false)
+		respondSystemError(w, ErrSystemConfigSave, "Failed to save WireGuard peers", err)
+		return
+	}
+	// Client file written by earlier versions
+	os.Remove(filepath.Join(wgLegacyClients, peer.Name+".conf")) //nolint:errcheck
+
+	logAuditEvent(getUsernameFromToken(r), "vpn.wireguard.peer.delete", peer.Name,
+		fmt.Sprintf("{\"interface\":\"%s\",\"public_key\":\"%s\",\"address\":\"%s\"}", peer.interfaceName(), peer.PublicKey, peer.Address), getClientIP(r), true)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, map[string]string{"status": "success"})
+}
+
+func downloadVPNClient(w http.ResponseWriter, r *http.Request) {
+	wgLock.Lock()
+	idx := findWGPeer(wgPeerStore.Peers, r.URL.Query().Get("id"), r.URL.Query().Get("name"))
+	var peer WGPeer
+	var iface WGInterface
+	found := false
+	if idx >= 0 {
+		peer = wgPeerStore.Peers[idx]
+		iface, found = findWGInterface(wgInterfaceStore.Interfaces, peer.interfaceName())
+	}
+	wgLock.Unlock()
+
+	if !found {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	conf := renderWGClientConfig(peer, iface, wgEndpointHost(r))
+
+	// Mobile apps import the config by scanning it
+	if r.URL.Query().Get("format") == "qr" {
+		img, err := qrPNG([]byte(conf), 6)
+		if err != nil {
+			respondWithError(w, ErrGenericInternalError, "Failed to render QR code", http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store") // Carries the private key
+		w.Write(img)                                //nolint:errcheck
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", peer.Name))
+	w.Header().Set("Content-Type", "application/x-wireguard-config")
+	w.Write([]byte(conf)) //nolint:errcheck
+}
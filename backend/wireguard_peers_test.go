@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllocateWGAddress(t *testing.T) {
+	peers := []WGPeer{
+		{Name: "a", Address: "10.8.0.2/32", Enabled: true},
+		{Name: "b", Address: "10.8.0.3/32", Enabled: false}, // Disabled peers keep their address
+		{Name: "c", Address: "10.8.0.5/32", Enabled: true},
+	}
+
+	got, err := allocateWGAddress("10.8.0.1/24", peers)
+	if err != nil || got != "10.8.0.4/32" {
+		t.Fatalf("Expected the freed 10.8.0.4/32, got %q (err %v)", got, err)
+	}
+
+	full := make([]WGPeer, 0, 253)
+	for i := 2; i <= 254; i++ {
+		full = append(full, WGPeer{Address: fmt.Sprintf("10.8.0.%d/32", i)})
+	}
+	if _, err := allocateWGAddress("10.8.0.1/24", full); err == nil {
+		t.Error("Expected an exhausted pool error")
+	}
+}
+
+func TestRenderWGServerConfig(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	peers := []WGPeer{
+		{Name: "phone", PublicKey: "PUBPHONE", PresharedKey: "PSK", Address: "10.8.0.2/32", Enabled: true, ExpiresAt: &future},
+		{Name: "office", PublicKey: "PUBOFFICE", Address: "10.8.0.3/32", AllowedIPs: []string{"192.168.50.0/24"}, Enabled: true},
+		{Name: "off", PublicKey: "PUBOFF", Address: "10.8.0.4/32", Enabled: false},
+		{Name: "old", PublicKey: "PUBOLD", Address: "10.8.0.5/32", Enabled: true, ExpiresAt: &past},
+	}
+
+	iface := WGInterface{Name: "wg0", Role: wgRoleServer, PrivateKey: "PRIV", Address: "10.8.0.1/24", ListenPort: 51820, Enabled: true}
+	conf := renderWGInterfaceConfig(iface, peers, nil, time.Now())
+	for _, s := range []string{
+		"PrivateKey = PRIV\nAddress = 10.8.0.1/24\nListenPort = 51820\nTable = off\n",
+		"# Name: phone\nPublicKey = PUBPHONE\nPresharedKey = PSK\nAllowedIPs = 10.8.0.2/32\n",
+		"PublicKey = PUBOFFICE\nAllowedIPs = 10.8.0.3/32, 192.168.50.0/24\n",
+	} {
+		if !strings.Contains(conf, s) {
+			t.Errorf("Expected config to contain %q.\n%s", s, conf)
+		}
+	}
+	for _, s := range []string{"PUBOFF\n", "PUBOLD"} {
+		if strings.Contains(conf, s) {
+			t.Errorf("Disabled and expired peers must not be rendered, found %q", s)
+		}
+	}
+
+	sync := renderWGSyncConfig(iface, peers, time.Now())
+	for _, s := range []string{"Address", "Table", "PostUp"} {
+		if strings.Contains(sync, s) {
+			t.Errorf("wg syncconf rejects wg-quick key %s:\n%s", s, sync)
+		}
+	}
+}
+
+func TestWGKeys(t *testing.T) {
+	priv, pub, err := generateWGKeyPair()
+	if err != nil {
+		t.Fatalf("generateWGKeyPair failed: %v", err)
+	}
+	if !isValidWGKey(priv) || !isValidWGKey(pub) {
+		t.Fatalf("Invalid keys %q / %q", priv, pub)
+	}
+	if derived, err := wgPublicKey(priv); err != nil || derived != pub {
+		t.Errorf("Expected derived public key %q, got %q (err %v)", pub, derived, err)
+	}
+
+	// RFC 7748 section 6.1 test vector (Alice)
+	alice := "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	if got, _ := wgPublicKey(alice); got != "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=" {
+		t.Errorf("Unexpected public key for RFC 7748 vector: %q", got)
+	}
+}
+
+func TestImportLegacyWGPeers(t *testing.T) {
+	priv, pub, _ := generateWGKeyPair()
+	serverConf := "[Interface]\nPrivateKey = SERVER\nAddress = 10.8.0.1/24\nListenPort = 51820\n" +
+		"\n[Peer]\n# Name: laptop\nPublicKey = " + pub + "\nAllowedIPs = 10.8.0.2/32\n" +
+		"\n[Peer]\n# Name: bad name!\nPublicKey = OTHER\nAllowedIPs = 10.8.0.3/32, 192.168.9.0/24\n"
+	clients := map[string]string{
+		"laptop.conf": "[Interface]\nPrivateKey = " + priv + "\nAddress = 10.8.0.2/32\nDNS = 1.1.1.1\n",
+	}
+
+	peers := importLegacyWGPeers(serverConf, clients)
+	if len(peers) != 2 {
+		t.Fatalf("Expected 2 peers, got %+v", peers)
+	}
+	if p := peers[0]; p.Name != "laptop" || p.Interface != "wg0" || p.Address != "10.8.0.2/32" || p.PrivateKey != priv || !p.Enabled {
+		t.Errorf("Unexpected laptop peer %+v", p)
+	}
+	if p := peers[1]; p.Name != "peer-2" || p.PrivateKey != "" || len(p.AllowedIPs) != 1 || p.AllowedIPs[0] != "192.168.9.0/24" {
+		t.Errorf("Unexpected second peer %+v", p)
+	}
+}
+
+func TestValidateWGPeer(t *testing.T) {
+	_, pub, _ := generateWGKeyPair()
+	_, pub2, _ := generateWGKeyPair()
+	server := WGInterface{Name: "wg0", Role: wgRoleServer, Address: "10.8.0.1/24"}
+	valid := WGPeer{ID: "1", Name: "phone", PublicKey: pub, Address: "10.8.0.2/32", DNS: "10.8.0.1", Enabled: true}
+	if err := validateWGPeer(valid, server, nil); err != nil {
+		t.Fatalf("Expected valid peer, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*WGPeer)
+	}{
+		{"name", func(p *WGPeer) { p.Name = "a b" }},
+		{"key", func(p *WGPeer) { p.PublicKey = "abc" }},
+		{"outside pool", func(p *WGPeer) { p.Address = "10.9.0.2/32" }},
+		{"server address", func(p *WGPeer) { p.Address = "10.8.0.1/32" }},
+		{"not host", func(p *WGPeer) { p.Address = "10.8.0.0/24" }},
+		{"allowed ips", func(p *WGPeer) { p.AllowedIPs = []string{"192.168.1.0"} }},
+		{"dns", func(p *WGPeer) { p.DNS = "dns.example" }},
+		{"no address", func(p *WGPeer) { p.Address = "" }},
+		{"endpoint", func(p *WGPeer) { p.Endpoint = "vpn.example.com" }},
+		{"endpoint injection", func(p *WGPeer) { p.Endpoint = "1.2.3.4\nAllowedIPs = 0.0.0.0/0\n#x:51820" }},
+		{"endpoint host", func(p *WGPeer) { p.Endpoint = "vpn example.com:51820" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.mutate(&p)
+			if err := validateWGPeer(p, server, nil); err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+		})
+	}
+
+	others := []WGPeer{{ID: "2", Name: "tablet", PublicKey: pub2, Address: "10.8.0.2/32"}}
+	if err := validateWGPeer(valid, server, others); err == nil {
+		t.Error("Expected duplicate address error")
+	}
+
+	// Provider peers route everything through an endpoint and need no address
+	provider := WGInterface{Name: "wg2", Role: wgRoleProvider, Address: "10.64.0.2/32"}
+	exit := WGPeer{ID: "3", Name: "exit", PublicKey: pub2, Endpoint: "198.51.100.9:51820", AllowedIPs: []string{"0.0.0.0/0"}}
+	if err := validateWGPeer(exit, provider, nil); err != nil {
+		t.Errorf("Expected valid provider peer, got %v", err)
+	}
+	for _, endpoint := range []string{"vpn.example.com:51820", "[2001:db8::1]:51820"} {
+		exit.Endpoint = endpoint
+		if err := validateWGPeer(exit, provider, nil); err != nil {
+			t.Errorf("Expected endpoint %s to be valid, got %v", endpoint, err)
+		}
+	}
+	exit.Endpoint = ""
+	if err := validateWGPeer(exit, provider, nil); err == nil {
+		t.Error("Expected provider peer without endpoint to be rejected")
+	}
+}
+
+func TestValidateWGPeerRoutes(t *testing.T) {
+	var subnets []*net.IPNet
+	for _, cidr := range []string{"192.168.1.0/24", "10.8.0.0/24"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		subnets = append(subnets, n)
+	}
+	site := WGInterface{Name: "wg1", Role: wgRoleSite, Address: "10.9.0.1/30"}
+
+	valid := WGPeer{Name: "branch", AllowedIPs: []string{"192.168.50.0/24", "2001:db8:50::/48"}}
+	if err := validateWGPeerRoutes(valid, site, subnets); err != nil {
+		t.Errorf("Expected remote subnets to be valid, got %v", err)
+	}
+	for _, allowed := range []string{"0.0.0.0/0", "::/0", "192.168.1.0/24", "192.168.1.128/25", "192.168.0.0/16", "10.8.0.5/32"} {
+		p := valid
+		p.AllowedIPs = []string{allowed}
+		if err := validateWGPeerRoutes(p, site, subnets); err == nil {
+			t.Errorf("Expected allowed IPs %s to be rejected", allowed)
+		}
+	}
+
+	// Provider peers carry the default route for policy routing only
+	provider := WGInterface{Name: "wg2", Role: wgRoleProvider}
+	if err := validateWGPeerRoutes(WGPeer{AllowedIPs: []string{"0.0.0.0/0"}}, provider, subnets); err != nil {
+		t.Errorf("Expected provider default route to be valid, got %v", err)
+	}
+}
+
+func TestRenderWGClientConfig(t *testing.T) {
+	iface := WGInterface{Name: "wg0", Role: wgRoleServer, PublicKey: "SERVERPUB", Address: "10.8.0.1/24", ListenPort: 51820}
+	peer := WGPeer{Name: "phone", PrivateKey: "PEERPRIV", Address: "10.8.0.2/32"}
+
+	// Defaults: the router resolves, full tunnel, endpoint from the request
+	conf := renderWGClientConfig(peer, iface, "192.0.2.10")
+	for _, s := range []string{"DNS = 10.8.0.1\n", "Endpoint = 192.0.2.10:51820\n", "AllowedIPs = 0.0.0.0/0\n", "PersistentKeepalive = 25\n"} {
+		if !strings.Contains(conf, s) {
+			t.Errorf("Expected default config to contain %q.\n%s", s, conf)
+		}
+	}
+
+	iface.Endpoint = "vpn.example.com"
+	iface.Client = WGClientTemplate{Tunnel: "split", SplitRoutes: []string{"192.168.1.0/24"}, EndpointPort: 443, Keepalive: 15, MTU: 1380}
+	conf = renderWGClientConfig(peer, iface, "192.0.2.10")
+	for _, s := range []string{"MTU = 1380\n", "Endpoint = vpn.example.com:443\n", "AllowedIPs = 10.8.0.0/24, 192.168.1.0/24\n", "PersistentKeepalive = 15\n"} {
+		if !strings.Contains(conf, s) {
+			t.Errorf("Expected templated config to contain %q.\n%s", s, conf)
+		}
+	}
+
+	// Peer overrides win over the template
+	peer.DNS, peer.ClientAllowedIPs = "9.9.9.9", []string{"10.0.0.0/8"}
+	conf = renderWGClientConfig(peer, iface, "")
+	if !strings.Contains(conf, "DNS = 9.9.9.9\n") || !strings.Contains(conf, "AllowedIPs = 10.0.0.0/8\n") {
+		t.Errorf("Expected peer overrides.\n%s", conf)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WGPeerStats is the live state of a peer as reported by `wg show all dump`
+type WGPeerStats struct {
+	Interface           string     `json:"interface"`
+	PublicKey           string     `json:"public_key"`
+	Endpoint            string     `json:"endpoint,omitempty"`
+	AllowedIPs          []string   `json:"allowed_ips,omitempty"`
+	LatestHandshake     *time.Time `json:"latest_handshake,omitempty"`
+	RxBytes             uint64     `json:"rx_bytes"`
+	TxBytes             uint64     `json:"tx_bytes"`
+	PersistentKeepalive int        `json:"persistent_keepalive"` // Seconds, 0 = off
+}
+
+// WGPeerStatus is a stored peer merged with its live stats
+type WGPeerStatus struct {
+	WGPeer
+	Active    bool         `json:"active"`    // Enabled and not expired, i.e. loaded into the interface
+	Connected bool         `json:"connected"` // Handshake within wgConnectedWindow
+	RxRate    uint64       `json:"rx_rate"`   // Bps over the last sample interval
+	TxRate    uint64       `json:"tx_rate"`
+	Stats     *WGPeerStats `json:"stats,omitempty"` // Nil when the peer is not loaded
+}
+
+// WGPeerPoint is one entry of a peer's traffic history
+type WGPeerPoint struct {
+	Timestamp int64  `json:"timestamp"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxRate    uint64 `json:"rx_rate"`
+	TxRate    uint64 `json:"tx_rate"`
+	Connected bool   `json:"connected"`
+}
+
+var (
+	wgHistory     = make(map[string][]WGPeerPoint) // Key: public key
+	wgHistoryLock sync.RWMutex
+
+	wgSampleInterval   = 10 * time.Second
+	maxWGHistoryPoints = 360             // 1 hour at 10s
+	wgConnectedWindow  = 3 * time.Minute // Handshakes are renewed every 2 minutes while traffic flows
+)
+
+const wgPeerDumpFieldCount = 9
+
+// parseWGDump parses `wg show all dump`. Interface lines (5 fields) are
+// skipped; peer lines carry interface, public key, preshared key, endpoint,
+// allowed IPs, latest handshake, rx, tx and keepalive.
+func parseWGDump(data string) map[string]WGPeerStats {
+	peers := make(map[string]WGPeerStats)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != wgPeerDumpFieldCount {
+			continue
+		}
+
+		s := WGPeerStats{Interface: fields[0], PublicKey: fields[1]}
+		if fields[3] != "(none)" {
+			s.Endpoint = fields[3]
+		}
+		if fields[4] != "(none)" {
+			s.AllowedIPs = strings.Split(fields[4], ",")
+		}
+		if ts, err := strconv.ParseInt(fields[5], 10, 64); err == nil && ts > 0 {
+			hs := time.Unix(ts, 0)
+			s.LatestHandshake = &hs
+		}
+		s.RxBytes, _ = strconv.ParseUint(fields[6], 10, 64)
+		s.TxBytes, _ = strconv.ParseUint(fields[7], 10, 64)
+		if fields[8] != "off" {
+			s.PersistentKeepalive, _ = strconv.Atoi(fields[8])
+		}
+		peers[s.PublicKey] = s
+	}
+	return peers
+}
+
+// readWGStats returns the live stats of all peers on all interfaces
+func readWGStats() (map[string]WGPeerStats, error) {
+	out, err := runPrivilegedOutput("wg", "show", "all", "dump")
+	if err != nil {
+		return nil, err
+	}
+	return parseWGDump(string(out)), nil
+}
+
+func (s WGPeerStats) connected(now time.Time) bool {
+	return s.LatestHandshake != nil && now.Sub(*s.LatestHandshake) < wgConnectedWindow
+}
+
+// mergeWGPeerStatus joins the store with the live stats. Rates come from the
+// newest history point of each peer.
+func mergeWGPeerStatus(peers []WGPeer, stats map[string]WGPeerStats, history map[string][]WGPeerPoint, now time.Time) []WGPeerStatus {
+	result := make([]WGPeerStatus, 0, len(peers))
+	for _, p := range peers {
+		status := WGPeerStatus{WGPeer: publicWGPeer(p), Active: wgPeerActive(p, now)}
+		if s, ok := stats[p.PublicKey]; ok {
+			s := s
+			status.Stats = &s
+			status.Connected = s.connected(now)
+		}
+		if points := history[p.PublicKey]; len(points) > 0 {
+			status.RxRate = points[len(points)-1].RxRate
+			status.TxRate = points[len(points)-1].TxRate
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// recordWGSample appends a sample per peer and drops the history of peers
+// that are gone
+func recordWGSample(stats map[string]WGPeerStats, now time.Time) {
+	wgHistoryLock.Lock()
+	defer wgHistoryLock.Unlock()
+
+	for key := range wgHistory {
+		if _, ok := stats[key]; !ok {
+			delete(wgHistory, key)
+		}
+	}
+
+	for key, s := range stats {
+		point := WGPeerPoint{Timestamp: now.Unix(), RxBytes: s.RxBytes, TxBytes: s.TxBytes, Connected: s.connected(now)}
+		points := wgHistory[key]
+		if len(points) > 0 {
+			last := points[len(points)-1]
+			if elapsed := uint64(point.Timestamp - last.Timestamp); elapsed > 0 {
+				// Counters reset when the peer is reloaded
+				if s.RxBytes >= last.RxBytes {
+					point.RxRate = (s.RxBytes - last.RxBytes) / elapsed
+				}
+				if s.TxBytes >= last.TxBytes {
+					point.TxRate = (s.TxBytes - last.TxBytes) / elapsed
+				}
+			}
+		}
+		points = append(points, point)
+		if len(points) > maxWGHistoryPoints {
+			points = points[len(points)-maxWGHistoryPoints:]
+		}
+		wgHistory[key] = points
+	}
+}
+
+// startWGStatsSampler samples the peer counters for the history
+func startWGStatsSampler() {
+	go func() {
+		ticker := time.NewTicker(wgSampleInterval)
+		for range ticker.C {
+			stats, err := readWGStats()
+			if err != nil {
+				continue // No WireGuard interface up
+			}
+			recordWGSample(stats, time.Now())
+		}
+	}()
+}
+
+// --- API Handlers ---
+
+func getVPNClientStatuses() []WGPeerStatus {
+	stats, err := readWGStats()
+	if err != nil {
+		stats = map[string]WGPeerStats{}
+	}
+
+	wgLock.Lock()
+	peers := append([]WGPeer(nil), wgPeerStore.Peers...)
+	wgLock.Unlock()
+
+	wgHistoryLock.RLock()
+	defer wgHistoryLock.RUnlock()
+	return mergeWGPeerStatus(peers, stats, wgHistory, time.Now())
+}
+
+func getVPNClientHistory(w http.ResponseWriter, r *http.Request) {
+	wgLock.Lock()
+	idx := findWGPeer(wgPeerStore.Peers, r.URL.Query().Get("id"), r.URL.Query().Get("name"))
+	var key string
+	if idx >= 0 {
+		key = wgPeerStore.Peers[idx].PublicKey
+	}
+	wgLock.Unlock()
+
+	if idx < 0 {
+		respondWithError(w, ErrGenericNotFound, "Peer not found", http.StatusNotFound, nil)
+		return
+	}
+
+	wgHistoryLock.RLock()
+	points := append([]WGPeerPoint{}, wgHistory[key]...)
+	wgHistoryLock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, points)
+}
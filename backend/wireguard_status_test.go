@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWGDump(t *testing.T) {
+	dump := "wg0\tSERVERPRIV=\tSERVERPUB=\t51820\toff\n" +
+		"wg0\tPEER1=\t(none)\t198.51.100.7:41234\t10.8.0.2/32,192.168.50.0/24\t1760000000\t1048576\t2097152\t25\n" +
+		"wg0\tPEER2=\tPSK=\t(none)\t10.8.0.3/32\t0\t0\t0\toff\n"
+
+	stats := parseWGDump(dump)
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 peers, got %+v", stats)
+	}
+
+	p1 := stats["PEER1="]
+	if p1.Interface != "wg0" || p1.Endpoint != "198.51.100.7:41234" || len(p1.AllowedIPs) != 2 ||
+		p1.RxBytes != 1048576 || p1.TxBytes != 2097152 || p1.PersistentKeepalive != 25 {
+		t.Errorf("Unexpected stats %+v", p1)
+	}
+	if p1.LatestHandshake == nil || p1.LatestHandshake.Unix() != 1760000000 {
+		t.Errorf("Expected handshake at 1760000000, got %v", p1.LatestHandshake)
+	}
+
+	if p2 := stats["PEER2="]; p2.Endpoint != "" || p2.LatestHandshake != nil || p2.PersistentKeepalive != 0 {
+		t.Errorf("Expected a peer that never connected, got %+v", p2)
+	}
+}
+
+func TestMergeWGPeerStatus(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-30 * time.Second)
+	stale := now.Add(-10 * time.Minute)
+	peers := []WGPeer{
+		{ID: "1", Name: "phone", PublicKey: "A", PrivateKey: "SECRET", Enabled: true},
+		{ID: "2", Name: "laptop", PublicKey: "B", Enabled: true},
+		{ID: "3", Name: "off", PublicKey: "C", Enabled: false},
+	}
+	stats := map[string]WGPeerStats{
+		"A": {PublicKey: "A", LatestHandshake: &recent, RxBytes: 100},
+		"B": {PublicKey: "B", LatestHandshake: &stale},
+	}
+	history := map[string][]WGPeerPoint{"A": {{RxRate: 7, TxRate: 9}}}
+
+	got := mergeWGPeerStatus(peers, stats, history, now)
+	if !got[0].Connected || got[0].RxRate != 7 || got[0].TxRate != 9 || got[0].Stats.RxBytes != 100 {
+		t.Errorf("Expected phone connected with rates, got %+v", got[0])
+	}
+	if got[0].PrivateKey != "" {
+		t.Error("Status must not carry the private key")
+	}
+	if got[1].Connected {
+		t.Error("Expected laptop with a stale handshake to be disconnected")
+	}
+	if got[2].Active || got[2].Stats != nil {
+		t.Errorf("Expected inactive peer without stats, got %+v", got[2])
+	}
+}
+
+func TestRecordWGSample(t *testing.T) {
+	wgHistoryLock.Lock()
+	wgHistory = make(map[string][]WGPeerPoint)
+	wgHistoryLock.Unlock()
+
+	start := time.Unix(1760000000, 0)
+	recordWGSample(map[string]WGPeerStats{"A": {RxBytes: 1000, TxBytes: 500}, "B": {}}, start)
+	recordWGSample(map[string]WGPeerStats{"A": {RxBytes: 3000, TxBytes: 400}}, start.Add(10*time.Second))
+
+	points := wgHistory["A"]
+	if len(points) != 2 || points[1].RxRate != 200 || points[1].TxRate != 0 {
+		t.Errorf("Expected rx rate 200 and tx rate 0 after a counter reset, got %+v", points)
+	}
+	if _, ok := wgHistory["B"]; ok {
+		t.Error("Expected history of removed peer B to be dropped")
+	}
+}